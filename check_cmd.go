@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"piyazche/config"
+	"piyazche/optimizer"
+	"piyazche/scanner"
+	"piyazche/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var optimizeSave bool
+
+// checkCmd builds the `check` subcommand: one connection through the proxy
+func checkCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check [ip]",
+		Short: "Test a single connection through the proxy",
+		Long: `Connect once through xray to proxy.address, or to the given IP, with the
+fragment settings of --config. This is the only test available for reality.
+
+Exit codes: 0 connected, 1 error, 2 bad flags or config, 3 connection failed.
+
+Example:
+  piyazche check -c config.json
+  piyazche check -c config.json 104.16.1.2 --json`,
+		Args: usageArgs(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCLIConfig()
+			if err != nil {
+				return err
+			}
+			target := cfg.Proxy.Address
+			if len(args) == 1 {
+				target = args[0]
+			}
+			cfg.PrintConfigInfo()
+			if cfg.Fragment.Mode == "auto" {
+				applyAutoFragment(cfg)
+			}
+			return runCheckMode(cfg, target)
+		},
+	}
+	addConfigFlag(cmd)
+	addXrayFlags(cmd)
+	addJSONFlag(cmd)
+	return cmd
+}
+
+// optimizeFragmentCmd builds the `optimize-fragment` subcommand
+func optimizeFragmentCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "optimize-fragment",
+		Short: "Find working fragment settings for the proxy",
+		Long: `Search the fragment packets, length and interval ranges of fragment.auto
+for settings that get through, testing against --test-ip, fragment.auto.testIp
+or an IP of --subnets (proxy.address for reality).
+
+Exit codes: 0 found, 1 error, 2 bad flags or config, 3 nothing works.
+
+Example:
+  piyazche optimize-fragment -c config.json --test-ip 104.27.68.140
+  piyazche optimize-fragment -c config.json --save --json`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCLIConfig()
+			if err != nil {
+				return err
+			}
+			cfg.PrintConfigInfo()
+			best, err := runFragmentOptimizer(cfg)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("\n%s✓ Best Settings%s\n", utils.Green, utils.Reset)
+			fmt.Printf("  %sLength:%s   %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, best.SizeRange.String(), utils.Reset)
+			fmt.Printf("  %sInterval:%s %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, best.IntervalRange.String(), utils.Reset)
+			fmt.Printf("  %sPackets:%s  %s%s%s\n\n", utils.Gray, utils.Reset, utils.Magenta, best.Zone, utils.Reset)
+			fields := fragmentFields(best)
+			if optimizeSave {
+				if err := saveFragmentSettings(configPath, best); err != nil {
+					return err
+				}
+				fmt.Printf("%sSaved to:%s %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, configPath, utils.Reset)
+				fields["saved"] = configPath
+			}
+			emit("done", fields)
+			return nil
+		},
+	}
+	addConfigFlag(cmd)
+	cmd.Flags().StringVarP(&subnetsPath, "subnets", "s", "ipv4.txt", "Path to subnets file or CIDR to pick a test IP from")
+	cmd.Flags().StringVar(&testIP, "test-ip", "", "IP address to use for fragment optimization tests (overrides config)")
+	cmd.Flags().BoolVar(&debug, "debug", false, "Print xray config JSON for first IP")
+	cmd.Flags().BoolVar(&optimizeSave, "save", false, "Write the best settings into the config file as manual fragment settings")
+	addJSONFlag(cmd)
+	return cmd
+}
+
+// runCheckMode tests a single connection to targetIP
+func runCheckMode(cfg *config.Config, targetIP string) error {
+	if targetIP == "" {
+		return usageErrorf("proxy.address is required for check mode")
+	}
+
+	fmt.Printf("%s%sConnection Check%s\n", utils.Bold, utils.Cyan, utils.Reset)
+	fmt.Printf("%s─────────────────────────────────────────%s\n", utils.Gray, utils.Reset)
+	fmt.Printf("  %sTarget:%s %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, targetIP, utils.Reset)
+	fmt.Printf("  %sPort:%s   %s%d%s\n", utils.Gray, utils.Reset, utils.Yellow, cfg.Proxy.Port, utils.Reset)
+	fmt.Printf("  %sMethod:%s %s%s%s\n\n", utils.Gray, utils.Reset, utils.Green, cfg.Proxy.Method, utils.Reset)
+
+	zone := cfg.Fragment.Packets
+	if zone == "" {
+		zone = "tlshello"
+	}
+
+	size := 10
+	interval := 10
+
+	if cfg.Fragment.Mode == "manual" || cfg.Fragment.Mode == "" {
+		var minLen int
+		fmt.Sscanf(cfg.Fragment.Manual.Length, "%d", &minLen)
+		if minLen > 0 {
+			size = minLen
+		}
+
+		var minInt int
+		fmt.Sscanf(cfg.Fragment.Manual.Interval, "%d", &minInt)
+		if minInt > 0 {
+			interval = minInt
+		}
+	}
+
+	fmt.Printf("  %sFragment:%s Size=%s%d%s, Interval=%s%d%sms, Packets=%s%s%s\n\n",
+		utils.Gray, utils.Reset,
+		utils.Green, size, utils.Reset,
+		utils.Green, interval, utils.Reset,
+		utils.Yellow, zone, utils.Reset)
+
+	tester := optimizer.NewFragmentTester(cfg, targetIP)
+	tester.WithDebug(debug)
+
+	fmt.Printf("Testing connection...")
+
+	result := tester.TestSingle(zone, size, interval)
+
+	fields := map[string]interface{}{"ip": targetIP, "success": result.Success}
+	if result.Success {
+		fmt.Printf("\r%s✓ Connection successful%s\n", utils.Green, utils.Reset)
+		fmt.Printf("  %sLatency:%s %s%dms%s\n", utils.Gray, utils.Reset, utils.Yellow, result.Latency.Milliseconds(), utils.Reset)
+		fields["latency_ms"] = result.Latency.Milliseconds()
+	} else {
+		fmt.Printf("\r%s✗ Connection failed%s\n", utils.Red, utils.Reset)
+		if result.Error != "" {
+			fmt.Printf("  %sError:%s %s\n", utils.Gray, utils.Reset, result.Error)
+			fields["error"] = result.Error
+		}
+	}
+	emit("done", fields)
+
+	if !result.Success {
+		return withExit(exitNoResults, errors.New("connection failed"))
+	}
+	return nil
+}
+
+// runFragmentOptimizer runs the fragment optimizer to find optimal settings
+func runFragmentOptimizer(cfg *config.Config) (*optimizer.ZoneResult, error) {
+	var testIP string
+
+	if cfg.Fragment.Auto.TestIP != "" {
+		testIP = cfg.Fragment.Auto.TestIP
+	} else {
+		src, err := scanner.OpenIPSource(subnetsPath, utils.SourceOptions{SampleSize: 5, Shuffle: true})
+		if err != nil {
+			return nil, withExit(exitUsage, fmt.Errorf("failed to load IPs for optimization: %w", err))
+		}
+
+		testIP, _ = src.Next()
+	}
+
+	fmt.Printf("Testing fragment settings using IP: %s\n\n", testIP)
+
+	tester := optimizer.NewFragmentTester(cfg, testIP)
+	tester.WithDebug(debug)
+
+	finderConfig := optimizer.FinderConfig{
+		MaxTriesPerZone:   cfg.Fragment.Auto.MaxTests,
+		SuccessThreshold:  cfg.Fragment.Auto.SuccessThreshold,
+		MinRangeWidth:     5,
+		EnableCorrelation: true,
+	}
+
+	if finderConfig.MaxTriesPerZone <= 0 {
+		finderConfig.MaxTriesPerZone = 20
+	}
+	if finderConfig.SuccessThreshold <= 0 {
+		finderConfig.SuccessThreshold = 0.5
+	}
+
+	opt := optimizer.NewOptimizer(finderConfig, tester.CreateTesterFunc())
+
+	sizeRange := optimizer.Range{
+		Min: cfg.Fragment.Auto.LengthRange.Min,
+		Max: cfg.Fragment.Auto.LengthRange.Max,
+	}
+	intervalRange := optimizer.Range{
+		Min: cfg.Fragment.Auto.IntervalRange.Min,
+		Max: cfg.Fragment.Auto.IntervalRange.Max,
+	}
+
+	if !sizeRange.IsValid() {
+		sizeRange = optimizer.Range{Min: 10, Max: 60}
+	}
+	if !intervalRange.IsValid() {
+		intervalRange = optimizer.Range{Min: 10, Max: 32}
+	}
+
+	results, err := opt.FindOptimalRanges(context.Background(), sizeRange, intervalRange)
+	if err != nil {
+		return nil, err
+	}
+
+	optimizer.PrintSummary(results)
+
+	best := optimizer.GetBestResult(results)
+	if best == nil {
+		return nil, withExit(exitNoResults, fmt.Errorf("no working fragment settings found"))
+	}
+
+	return best, nil
+}
+
+func fragmentFields(r *optimizer.ZoneResult) map[string]interface{} {
+	return map[string]interface{}{
+		"packets":    r.Zone,
+		"length":     r.SizeRange.String(),
+		"interval":   r.IntervalRange.String(),
+		"latency_ms": r.Latency.Milliseconds(),
+		"successes":  r.SuccessCount,
+		"tests":      r.TotalTests,
+	}
+}
+
+// saveFragmentSettings writes r into the config file as manual fragment
+// settings, leaving the rest of the file as it was loaded
+func saveFragmentSettings(path string, r *optimizer.ZoneResult) error {
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	cfg.Fragment.Mode = "manual"
+	cfg.Fragment.Packets = r.Zone
+	cfg.Fragment.Manual.Length = r.SizeRange.String()
+	cfg.Fragment.Manual.Interval = r.IntervalRange.String()
+	return config.SaveConfig(cfg, path)
+}
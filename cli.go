@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"piyazche/config"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes, so scripts can tell a failed run from one that found nothing
+const (
+	exitOK          = 0
+	exitFailure     = 1   // the run itself failed
+	exitUsage       = 2   // bad flags, arguments or config
+	exitNoResults   = 3   // the run finished but nothing passed
+	exitInterrupted = 130 // stopped by Ctrl+C or SIGTERM
+)
+
+// exitError carries the process exit code for an error returned by a command
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func withExit(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return withExit(exitUsage, fmt.Errorf(format, args...))
+}
+
+// exitCodeOf maps an error returned by a command to the process exit code
+func exitCodeOf(err error) int {
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	if err != nil {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// usageArgs makes argument validation errors exit with exitUsage
+func usageArgs(args cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, a []string) error {
+		return withExit(exitUsage, args(cmd, a))
+	}
+}
+
+// ── --json ───────────────────────────────────────────────────────────────────
+
+var (
+	jsonMode bool
+	jsonMu   sync.Mutex
+	jsonEnc  *json.Encoder
+)
+
+// startJSON keeps stdout for one JSON event per line and sends everything
+// meant for humans (banners, progress bars, tables) to stderr
+func startJSON() {
+	jsonEnc = json.NewEncoder(os.Stdout)
+	os.Stdout = os.Stderr
+}
+
+// emit writes one event in --json mode and does nothing otherwise
+func emit(event string, fields map[string]interface{}) {
+	if jsonEnc == nil {
+		return
+	}
+	line := map[string]interface{}{"event": event, "time": time.Now().UTC().Format(time.RFC3339)}
+	for k, v := range fields {
+		line[k] = v
+	}
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	jsonEnc.Encode(line)
+}
+
+func addJSONFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&jsonMode, "json", false, "Print progress and results as JSON lines on stdout, human output goes to stderr")
+}
+
+// ── shared flags ─────────────────────────────────────────────────────────────
+
+func addConfigFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&configPath, "config", "c", "config.json", "Path to config file")
+}
+
+// addSourceFlags adds the flags choosing which IPs get scanned
+func addSourceFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&subnetsPath, "subnets", "s", "ipv4.txt", "Path to subnets file or CIDR")
+	cmd.Flags().IntVar(&maxIPs, "max-ips", 0, "Maximum IPs to scan (default: all)")
+	cmd.Flags().BoolVar(&shuffle, "shuffle", true, "Shuffle IPs before scanning")
+	cmd.Flags().IntVarP(&threads, "threads", "t", 0, "Number of concurrent workers (overrides config)")
+	cmd.Flags().StringVarP(&outputFmt, "output", "o", "csv", "Output format: csv, json")
+	cmd.Flags().IntVar(&topN, "top", 10, "Number of top results to display")
+}
+
+// addXrayFlags adds the flags that change how xray connects
+func addXrayFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&debug, "debug", false, "Print xray config JSON for first IP")
+	cmd.Flags().StringVar(&fragmentMode, "fragment-mode", "", "Fragment mode: manual, auto, off (overrides config)")
+	cmd.Flags().StringVar(&muxEnabled, "mux", "", "Enable mux: true, false (overrides config)")
+}
+
+// loadCLIConfig loads --config and applies the flag overrides to it
+func loadCLIConfig() (*config.Config, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, withExit(exitUsage, fmt.Errorf("failed to load config: %w", err))
+	}
+
+	if threads > 0 {
+		cfg.Scan.Threads = threads
+	}
+	if adaptive {
+		cfg.Scan.Adaptive = true
+	}
+	if batchSize > 0 {
+		cfg.Scan.BatchSize = batchSize
+	}
+	if prefilter {
+		cfg.Scan.PreFilter.Enabled = true
+	}
+	if fragmentMode != "" {
+		cfg.Fragment.Mode = fragmentMode
+	}
+	if len(probeSpecs) > 0 {
+		cfg.Scan.Probes = cfg.Scan.Probes[:0]
+		for _, spec := range probeSpecs {
+			p, err := config.ParseProbeSpec(spec)
+			if err != nil {
+				return nil, usageErrorf("--probe: %v", err)
+			}
+			cfg.Scan.Probes = append(cfg.Scan.Probes, p)
+		}
+	}
+
+	switch muxEnabled {
+	case "":
+	case "true":
+		cfg.Xray.Mux.Enabled = true
+		if cfg.Xray.Mux.Concurrency <= 0 {
+			cfg.Xray.Mux.Concurrency = 8
+		}
+	case "false":
+		cfg.Xray.Mux.Enabled = false
+		cfg.Xray.Mux.Concurrency = -1
+	default:
+		return nil, usageErrorf("--mux must be true or false")
+	}
+
+	if cfg.Proxy.Method == "reality" {
+		cfg.Fragment.Auto.TestIP = cfg.Proxy.Address
+	} else if testIP != "" {
+		cfg.Fragment.Auto.TestIP = testIP
+	}
+	return cfg, nil
+}
+
+// onInterrupt calls stop once on the first Ctrl+C or SIGTERM
+func onInterrupt(stop func()) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		fmt.Println("\nReceived interrupt signal, stopping...")
+		stop()
+	}()
+}
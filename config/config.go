@@ -1,6 +1,8 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -68,16 +70,60 @@ type MuxConfig struct {
 
 // ProxyConfig represents the proxy settings
 type ProxyConfig struct {
-	UUID    string         `json:"uuid"`
-	Address string         `json:"address"` // IP or domain for vnext
-	Port    int            `json:"port"`
-	Method  string         `json:"method"` // tls, reality
-	Type    string         `json:"type"`   // ws, xhttp, grpc, tcp, httpupgrade
-	TLS     *TlsConfig     `json:"tls,omitempty"`
-	Reality *RealityConfig `json:"reality,omitempty"`
-	WS      *WsConfig      `json:"ws,omitempty"`
-	Grpc    *GrpcConfig    `json:"grpc,omitempty"`
-	Xhttp   *XhttpConfig   `json:"xhttp,omitempty"`
+	Protocol string         `json:"protocol,omitempty"` // vless (default), vmess, trojan, shadowsocks, hysteria2
+	UUID     string         `json:"uuid"`
+	Address  string         `json:"address"` // IP or domain for vnext
+	Port     int            `json:"port"`
+	Method   string         `json:"method"` // tls, reality
+	Type     string         `json:"type"`   // ws, xhttp, grpc, tcp, httpupgrade, quic (hysteria2)
+	TLS      *TlsConfig     `json:"tls,omitempty"`
+	Reality  *RealityConfig `json:"reality,omitempty"`
+	WS       *WsConfig      `json:"ws,omitempty"`
+	Grpc     *GrpcConfig    `json:"grpc,omitempty"`
+	Xhttp    *XhttpConfig   `json:"xhttp,omitempty"`
+	Vmess    *VmessConfig   `json:"vmess,omitempty"`
+	Trojan   *TrojanConfig  `json:"trojan,omitempty"`
+
+	Shadowsocks *ShadowsocksConfig `json:"shadowsocks,omitempty"`
+	Hysteria2   *Hysteria2Config   `json:"hysteria2,omitempty"`
+}
+
+// GetProtocol returns the outbound protocol, defaulting to vless
+func (p *ProxyConfig) GetProtocol() string {
+	if p.Protocol == "" {
+		return "vless"
+	}
+	return p.Protocol
+}
+
+// VmessConfig represents VMess user settings
+type VmessConfig struct {
+	AlterID  int    `json:"alterId"`
+	Security string `json:"security"` // auto, aes-128-gcm, chacha20-poly1305, none, zero
+}
+
+// TrojanConfig represents Trojan user settings
+type TrojanConfig struct {
+	Password string `json:"password"`
+}
+
+// ShadowsocksConfig represents Shadowsocks (SIP002) settings
+type ShadowsocksConfig struct {
+	Cipher     string `json:"cipher"` // aes-256-gcm, chacha20-ietf-poly1305, 2022-blake3-aes-128-gcm, ...
+	Password   string `json:"password"`
+	Plugin     string `json:"plugin,omitempty"`     // v2ray-plugin, obfs-local, ...
+	PluginOpts string `json:"pluginOpts,omitempty"` // raw SIP003 options, e.g. "mode=websocket;host=a.com;tls"
+}
+
+// Hysteria2Config represents Hysteria2 settings (TLS comes from ProxyConfig.TLS)
+type Hysteria2Config struct {
+	Password     string `json:"password"`
+	Obfs         string `json:"obfs,omitempty"` // salamander
+	ObfsPassword string `json:"obfsPassword,omitempty"`
+	Ports        string `json:"ports,omitempty"` // port hopping range, e.g. "20000-30000"
+	PinSHA256    string `json:"pinSHA256,omitempty"`
+	UpMbps       int    `json:"upMbps,omitempty"`
+	DownMbps     int    `json:"downMbps,omitempty"`
 }
 
 // TlsConfig represents TLS security settings
@@ -167,11 +213,14 @@ type ScanConfig struct {
 	Timeout         int    `json:"timeout"` // seconds
 	TestURL         string `json:"testUrl"`
 	MaxLatency      int     `json:"maxLatency"`      // ms
+	MaxTTFBMs       int     `json:"maxTtfbMs"`       // filter: ms from request to first byte (origin path), 0=disabled
 	MaxPacketLoss   float64 `json:"maxPacketLoss"`   // percent, 0=disabled
 	Retries         int    `json:"retries"`
+	BatchSize       int    `json:"batchSize"` // IPs each worker tests at once through one xray instance, 0/1 = one at a time
 	MaxIPs          int    `json:"maxIPs"`
 	Shuffle         bool   `json:"shuffle"`
 	SampleSize      int    `json:"sampleSize"` // IPs per subnet
+	Adaptive        bool   `json:"adaptive"`   // spend MaxIPs on the subnets that pass most after SampleSize probes each
 	SpeedTest       bool   `json:"speedTest"`
 	BandwidthMode   BandwidthMode `json:"bandwidthMode"` // off / estimate / speedtest
 	DownloadURL     string `json:"downloadUrl"`
@@ -183,6 +232,43 @@ type ScanConfig struct {
 	MinDownloadMbps    float64 `json:"minDownloadMbps"`    // filter: 0=disabled
 	MinUploadMbps      float64 `json:"minUploadMbps"`      // filter: 0=disabled
 	MaxPacketLossPct   float64 `json:"maxPacketLossPct"`   // filter: -1=disabled 0=strict
+	PreFilter          PreFilterConfig `json:"preFilter"`
+	Probes             []ProbeConfig   `json:"probes,omitempty"` // probe plan — خالی یعنی GET به testUrl
+	Sustained          SustainedConfig `json:"sustained"`
+}
+
+// PreFilterConfig تست مستقیم TCP + TLS ClientHello قبل از xray
+type PreFilterConfig struct {
+	Enabled   bool `json:"enabled"`
+	Threads   int  `json:"threads"`   // default: 4 × scan.threads
+	TimeoutMs int  `json:"timeoutMs"` // default: 3000
+}
+
+// SustainedConfig دانلود طولانی در phase 2 که throughput رو در bucket های زمانی
+// نمونه می‌گیره تا IP هایی که اول سریعن و بعد چند ثانیه throttle میشن معلوم بشن
+type SustainedConfig struct {
+	Enabled    bool    `json:"enabled"`
+	URL        string  `json:"url"`        // default: 100MB از speed.cloudflare.com
+	WindowSec  int     `json:"windowSec"`  // default: 15
+	BucketMs   int     `json:"bucketMs"`   // default: 1000
+	MaxDropPct float64 `json:"maxDropPct"` // افت انتهای منحنی نسبت به ابتدا بیشتر از این = throttled، default: 50
+}
+
+// WithDefaults فیلدهای خالی رو با پیش‌فرض پر می‌کنه
+func (s SustainedConfig) WithDefaults() SustainedConfig {
+	if s.URL == "" {
+		s.URL = "https://speed.cloudflare.com/__down?bytes=100000000"
+	}
+	if s.WindowSec <= 0 {
+		s.WindowSec = 15
+	}
+	if s.BucketMs <= 0 {
+		s.BucketMs = 1000
+	}
+	if s.MaxDropPct <= 0 {
+		s.MaxDropPct = 50
+	}
+	return s
 }
 
 // ConfigTemplate یه کانفیگ ذخیره‌شده با اسم
@@ -194,6 +280,32 @@ type ConfigTemplate struct {
 	CreatedAt int64  `json:"createdAt"`
 }
 
+// ScanSchedule یه اسکن تکرارشونده‌ی Web UI — template روی ranges ذخیره‌شده
+type ScanSchedule struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Spec       string `json:"spec"`                 // cron ("0 6 * * *") یا interval ("@every 6h")
+	Template   string `json:"template,omitempty"`   // template ID — خالی یعنی config ذخیره‌شده
+	Ranges     string `json:"ranges,omitempty"`     // خالی یعنی ranges ذخیره‌شده
+	MaxIPs     int    `json:"maxIPs,omitempty"`     // 0 یعنی همه
+	HealthTopN int    `json:"healthTopN,omitempty"` // بهترین N IP جای IP های قبلی این schedule توی monitor میرن
+	Enabled    bool   `json:"enabled"`
+	CreatedAt  int64  `json:"createdAt"`
+	LastRun    int64  `json:"lastRun,omitempty"` // unix
+	LastJob    string `json:"lastJob,omitempty"`
+}
+
+// Subscription یه لینک subscription زنده‌ی Web UI که همیشه بهترین IP های فعلی رو میده
+type Subscription struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Token     string `json:"token"`              // توی URL — هر کی داره subscription رو می‌گیره
+	Template  string `json:"template,omitempty"` // template ID — خالی یعنی لینک ذخیره‌شده
+	Source    string `json:"source"`             // "monitor", "session" یا "auto" (monitor، و اگه خالی بود session)
+	MaxIPs    int    `json:"maxIPs"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
 // Phase3Config تنظیمات فاز سوم — تست سرعت جداگانه
 type Phase3Config struct {
 	Enabled     bool    `json:"enabled"`
@@ -243,6 +355,40 @@ type HealthEntry struct {
 	LatencyHistory []int64      `json:"latencyHistory"` // آخرین ۵۰ latency (ms) — 0 یعنی fail
 	CheckTimes     []int64      `json:"checkTimes"`     // timestamp هر check (unix ms)
 	GeoInfo        *GeoInfo     `json:"geoInfo,omitempty"`
+	Source         string       `json:"source,omitempty"` // "schedule:<id>" اگه schedule اضافه‌ش کرده
+}
+
+// HealthRotation سیاست جایگزینی خودکار IP های health monitor
+type HealthRotation struct {
+	Enabled      bool    `json:"enabled"`
+	PoolSize     int     `json:"poolSize"`     // چند IP سالم همیشه توی monitor باشه
+	MinUptimePct float64 `json:"minUptimePct"` // IP با uptime کمتر کنار میره...
+	MinChecks    int     `json:"minChecks"`    // ...ولی فقط بعد از این تعداد چک
+	ScanMaxIPs   int     `json:"scanMaxIPs"`   // سقف IP های mini-scan روی subnet IP های مرده
+}
+
+// NotifySettings هشدارهای Web UI — پایان اسکن، مردن/برگشتن IP های monitor و
+// تموم شدن IP های سالم
+type NotifySettings struct {
+	Enabled      bool           `json:"enabled"`
+	TopN         int            `json:"topN"`         // چند IP برتر توی پیام پایان اسکن
+	MaxPerHour   int            `json:"maxPerHour"`   // سقف پیام هر target در ساعت — 0 یعنی بی‌سقف
+	CooldownMins int            `json:"cooldownMins"` // یه هشدار برای همون IP زودتر از این تکرار نمیشه
+	Targets      []NotifyTarget `json:"targets"`
+}
+
+// NotifyTarget یه مقصد هشدار — webhook یا Telegram bot
+type NotifyTarget struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Type     string            `json:"type"` // "webhook" یا "telegram"
+	Enabled  bool              `json:"enabled"`
+	Events   []string          `json:"events,omitempty"` // خالی یعنی همه
+	URL      string            `json:"url,omitempty"`    // webhook
+	Headers  map[string]string `json:"headers,omitempty"`
+	Body     string            `json:"body,omitempty"` // Go text/template — خالی یعنی JSON خود event
+	BotToken string            `json:"botToken,omitempty"`
+	ChatID   string            `json:"chatId,omitempty"`
 }
 
 // GeoInfo اطلاعات جغرافیایی یه IP
@@ -361,10 +507,35 @@ func LoadConfig(path string) (*Config, error) {
 	return config, nil
 }
 
+// CheckCredentials checks that the protocol is known and its credential is set
+func (p *ProxyConfig) CheckCredentials() error {
+	switch p.GetProtocol() {
+	case "vless", "vmess":
+		if p.UUID == "" {
+			return fmt.Errorf("proxy.uuid is required")
+		}
+	case "trojan":
+		if (p.Trojan == nil || p.Trojan.Password == "") && p.UUID == "" {
+			return fmt.Errorf("proxy.trojan.password is required")
+		}
+	case "shadowsocks":
+		if p.Shadowsocks == nil || p.Shadowsocks.Cipher == "" || p.Shadowsocks.Password == "" {
+			return fmt.Errorf("proxy.shadowsocks.cipher and proxy.shadowsocks.password are required")
+		}
+	case "hysteria2":
+		if p.Hysteria2 == nil || p.Hysteria2.Password == "" {
+			return fmt.Errorf("proxy.hysteria2.password is required")
+		}
+	default:
+		return fmt.Errorf("invalid proxy.protocol: %s (must be 'vless', 'vmess', 'trojan', 'shadowsocks', or 'hysteria2')", p.Protocol)
+	}
+	return nil
+}
+
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
-	if c.Proxy.UUID == "" {
-		return fmt.Errorf("proxy.uuid is required")
+	if err := c.Proxy.CheckCredentials(); err != nil {
+		return err
 	}
 
 	// Validate method
@@ -401,9 +572,14 @@ func (c *Config) Validate() error {
 		c.Proxy.Port = 443
 	}
 
+	// quic is only the hysteria2 marker; buildStreamSettings has no quic transport
 	validTypes := map[string]bool{"ws": true, "xhttp": true, "grpc": true, "tcp": true, "httpupgrade": true}
-	if !validTypes[c.Proxy.Type] {
-		return fmt.Errorf("invalid proxy.type: %s", c.Proxy.Type)
+	if c.Proxy.GetProtocol() == "hysteria2" {
+		if c.Proxy.Type != "quic" {
+			return fmt.Errorf("invalid proxy.type: %s (hysteria2 only runs over 'quic')", c.Proxy.Type)
+		}
+	} else if !validTypes[c.Proxy.Type] {
+		return fmt.Errorf("invalid proxy.type: %s (must be 'ws', 'xhttp', 'grpc', 'tcp' or 'httpupgrade')", c.Proxy.Type)
 	}
 
 	if c.Fragment.Mode == "" {
@@ -419,6 +595,11 @@ func (c *Config) Validate() error {
 	if c.Scan.Timeout <= 0 {
 		c.Scan.Timeout = 10
 	}
+	for i, p := range c.Scan.Probes {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("scan.probes[%d]: %w", i, err)
+		}
+	}
 
 	return nil
 }
@@ -433,6 +614,19 @@ func (c *Config) GetMaxLatency() time.Duration {
 	return time.Duration(c.Scan.MaxLatency) * time.Millisecond
 }
 
+// Fingerprint identifies the proxy setup results were measured with: the
+// proxy and fragment settings plus mux. Scan tuning (threads, timeouts) is
+// left out so the same server tested with different scan settings matches.
+func (c *Config) Fingerprint() string {
+	data, _ := json.Marshal(struct {
+		Proxy    ProxyConfig
+		Fragment FragmentConfig
+		Mux      MuxConfig
+	}{c.Proxy, c.Fragment, c.Xray.Mux})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
 // SaveConfig saves configuration to a JSON file
 func SaveConfig(config *Config, path string) error {
 	data, err := json.MarshalIndent(config, "", "  ")
@@ -455,6 +649,7 @@ func (c *Config) PrintConfigInfo() {
 	fmt.Printf("%s%s%s\n\n", utils.Cyan, line, utils.Reset)
 
 	fmt.Printf("%s%s▸ Proxy Settings%s\n", utils.Bold, utils.Yellow, utils.Reset)
+	fmt.Printf("  %s%-18s%s %s%s%s\n", utils.Gray, "Protocol:", utils.Reset, utils.Green, c.Proxy.GetProtocol(), utils.Reset)
 
 	if c.Proxy.Address != "" {
 		fmt.Printf("  %s%-18s%s %s%s%s\n", utils.Gray, "Address:", utils.Reset, utils.Cyan, c.Proxy.Address, utils.Reset)
@@ -562,6 +757,17 @@ func (c *Config) PrintConfigInfo() {
 	fmt.Printf("  %s%-18s%s %s%dms%s\n", utils.Gray, "Max Latency:", utils.Reset, utils.White, c.Scan.MaxLatency, utils.Reset)
 	fmt.Printf("  %s%-18s%s %s%d%s\n", utils.Gray, "Retries:", utils.Reset, utils.White, c.Scan.Retries, utils.Reset)
 	fmt.Printf("  %s%-18s%s %s%s%s\n", utils.Gray, "Test URL:", utils.Reset, utils.Dim, c.Scan.TestURL, utils.Reset)
+	for i, p := range c.Scan.Probes {
+		label := ""
+		if i == 0 {
+			label = "Probes:"
+		}
+		target := p.URL
+		if p.Type == "tcp" {
+			target = p.Addr
+		}
+		fmt.Printf("  %s%-18s%s %s%-8s %s%s\n", utils.Gray, label, utils.Reset, utils.Dim, p.Type, target, utils.Reset)
+	}
 
 	speedColor := utils.Red
 	speedStatus := "disabled"
@@ -570,6 +776,9 @@ func (c *Config) PrintConfigInfo() {
 		speedStatus = "enabled"
 	}
 	fmt.Printf("  %s%-18s%s %s%s%s\n", utils.Gray, "Speed Test:", utils.Reset, speedColor, speedStatus, utils.Reset)
+	if c.Scan.Sustained.Enabled {
+		fmt.Printf("  %s%-18s%s %s%ds download, throttling check%s\n", utils.Gray, "Sustained:", utils.Reset, utils.Green, c.Scan.Sustained.WithDefaults().WindowSec, utils.Reset)
+	}
 	if c.Scan.SpeedTest {
 		fmt.Printf("  %s%-18s%s %s%s%s\n", utils.Gray, "Download URL:", utils.Reset, utils.Dim, c.Scan.DownloadURL, utils.Reset)
 		fmt.Printf("  %s%-18s%s %s%s%s\n", utils.Gray, "Upload URL:", utils.Reset, utils.Dim, c.Scan.UploadURL, utils.Reset)
@@ -600,6 +809,12 @@ func (c *Config) PrintConfigInfo() {
 	} else {
 		fmt.Printf("  %s%-18s%s %s%.0f%%%s\n", utils.Gray, "Max Pkt Loss:", utils.Reset, utils.Green, c.Scan.MaxPacketLossPct, utils.Reset)
 	}
+	if c.Scan.PreFilter.Enabled {
+		fmt.Printf("  %s%-18s%s %sTCP + TLS hello before xray%s\n", utils.Gray, "Pre-filter:", utils.Reset, utils.Green, utils.Reset)
+	}
+	if c.Scan.MaxTTFBMs > 0 {
+		fmt.Printf("  %s%-18s%s %s%dms%s\n", utils.Gray, "Max TTFB:", utils.Reset, utils.Green, c.Scan.MaxTTFBMs, utils.Reset)
+	}
 	if c.Scan.MinDownloadMbps > 0 {
 		fmt.Printf("  %s%-18s%s %s%.1f Mbps%s\n", utils.Gray, "Min Download:", utils.Reset, utils.Green, c.Scan.MinDownloadMbps, utils.Reset)
 	}
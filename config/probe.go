@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ProbeConfig یه probe از probe plan اسکن؛ همه‌ی probe ها باید پاس بشن تا IP قبول بشه.
+// اگه scan.probes خالی باشه همون GET ساده به testUrl انجام میشه
+type ProbeConfig struct {
+	Type       string `json:"type"`                 // http / head / ws / doh / download / tcp
+	URL        string `json:"url,omitempty"`        // http, head, ws, doh, download
+	Status     int    `json:"status,omitempty"`     // http/head: status مورد انتظار، 0 = هر 2xx/3xx
+	BodySHA256 string `json:"bodySha256,omitempty"` // http: hash بدنه برای تشخیص صفحه‌ی فیلتر
+	Message    string `json:"message,omitempty"`    // ws: پیامی که باید echo بشه
+	Domain     string `json:"domain,omitempty"`     // doh: دامنه‌ای که resolve میشه
+	KB         int    `json:"kb,omitempty"`         // download: چند KB خونده بشه، پیش‌فرض 1024
+	Addr       string `json:"addr,omitempty"`       // tcp: host:port پشت تونل
+	Send       string `json:"send,omitempty"`       // tcp: چیزی که بعد از وصل شدن فرستاده میشه
+	Expect     string `json:"expect,omitempty"`     // tcp: جواب باید اینو داشته باشه
+}
+
+// ProbeTypes نوع probe های پشتیبانی‌شده
+var ProbeTypes = []string{"http", "head", "ws", "doh", "download", "tcp"}
+
+// Validate فیلدهای لازم هر نوع رو چک می‌کنه
+func (p ProbeConfig) Validate() error {
+	checkURL := func(schemes ...string) error {
+		u, err := url.Parse(p.URL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("%s probe needs a url", p.Type)
+		}
+		for _, s := range schemes {
+			if u.Scheme == s {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s probe url must be %s", p.Type, strings.Join(schemes, " or "))
+	}
+	switch p.Type {
+	case "http", "head", "doh", "download":
+		return checkURL("http", "https")
+	case "ws":
+		return checkURL("ws", "wss")
+	case "tcp":
+		if _, port, err := net.SplitHostPort(p.Addr); err != nil || port == "" {
+			return fmt.Errorf("tcp probe needs addr as host:port")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown probe type %q (must be one of %s)", p.Type, strings.Join(ProbeTypes, ", "))
+}
+
+// ParseProbeSpec یه probe رو از شکل خلاصه‌ی CLI می‌سازه:
+//
+//	type:target[;key=value...]
+//
+// مثلا "http:https://example.com/;status=200"، "ws:wss://echo.example.com/;message=hi"،
+// "doh:https://1.1.1.1/dns-query;domain=twitter.com"، "download:https://host/file;kb=512"
+// یا "tcp:1.1.1.1:53". target برای tcp همون addr هست و برای بقیه url.
+// کلیدها: status, sha256, message, domain, kb, send, expect (send و expect مثل Go escape میشن)
+func ParseProbeSpec(spec string) (ProbeConfig, error) {
+	typ, rest, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	if !ok || rest == "" {
+		return ProbeConfig{}, fmt.Errorf("invalid probe %q: want type:target", spec)
+	}
+	p := ProbeConfig{Type: strings.ToLower(typ)}
+	parts := strings.Split(rest, ";")
+	if p.Type == "tcp" {
+		p.Addr = parts[0]
+	} else {
+		p.URL = parts[0]
+	}
+
+	for _, kv := range parts[1:] {
+		key, val, ok := strings.Cut(kv, "=")
+		if !ok {
+			return ProbeConfig{}, fmt.Errorf("invalid probe option %q: want key=value", kv)
+		}
+		var err error
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "status":
+			p.Status, err = strconv.Atoi(val)
+		case "sha256":
+			p.BodySHA256 = val
+		case "message":
+			p.Message = val
+		case "domain":
+			p.Domain = val
+		case "kb":
+			p.KB, err = strconv.Atoi(val)
+		case "send":
+			p.Send, err = unescape(val)
+		case "expect":
+			p.Expect, err = unescape(val)
+		default:
+			return ProbeConfig{}, fmt.Errorf("unknown probe option %q", key)
+		}
+		if err != nil {
+			return ProbeConfig{}, fmt.Errorf("invalid probe option %q: %w", kv, err)
+		}
+	}
+	return p, p.Validate()
+}
+
+// unescape مثلا "\r\n" رو به کاراکتر واقعی تبدیل می‌کنه
+func unescape(s string) (string, error) {
+	return strconv.Unquote(`"` + strings.ReplaceAll(s, `"`, `\"`) + `"`)
+}
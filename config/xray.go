@@ -3,6 +3,10 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"time"
+
+	"piyazche/utils"
 )
 
 // FragmentSettings represents fragment settings for xray config
@@ -25,6 +29,10 @@ func GenerateXrayConfig(cfg *Config, targetIP string, socksPort int) ([]byte, er
 
 // GenerateXrayConfigWithFragment creates an xray configuration with specific fragment settings
 func GenerateXrayConfigWithFragment(cfg *Config, targetIP string, socksPort int, fragment FragmentSettings) ([]byte, error) {
+	if err := checkXraySupport(cfg); err != nil {
+		return nil, err
+	}
+
 	uuid := cfg.Proxy.UUID
 	remarkID := uuid
 	if len(uuid) > 8 {
@@ -43,6 +51,189 @@ func GenerateXrayConfigWithFragment(cfg *Config, targetIP string, socksPort int,
 	return json.MarshalIndent(xrayConfig, "", "    ")
 }
 
+// GenerateProxyOutbound creates just the "proxy" outbound of GenerateXrayConfig,
+// for retargeting a running instance at another IP
+func GenerateProxyOutbound(cfg *Config, targetIP string) ([]byte, error) {
+	if err := checkXraySupport(cfg); err != nil {
+		return nil, err
+	}
+	return json.Marshal(buildOutbounds(cfg, targetIP)[0])
+}
+
+// GenerateBatchXrayConfig creates one xray configuration testing several IPs
+// at once: targets maps each IP to its own SOCKS port, and a routing rule per
+// inbound sends that port's traffic to the IP's outbound
+func GenerateBatchXrayConfig(cfg *Config, targets map[string]int) ([]byte, error) {
+	if err := checkXraySupport(cfg); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets")
+	}
+
+	ips := make([]string, 0, len(targets))
+	for ip := range targets {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	var inbounds, outbounds []map[string]interface{}
+	var shared []map[string]interface{} // direct, block and fragment
+	rules := []map[string]interface{}{
+		{
+			"ip":          []string{"223.5.5.5"},
+			"outboundTag": "direct",
+			"port":        "53",
+			"type":        "field",
+		},
+		{
+			"network":     "udp",
+			"outboundTag": "block",
+			"port":        "443",
+			"type":        "field",
+		},
+	}
+	for i, ip := range ips {
+		inbound := buildInbounds(targets[ip])[0]
+		inbound["tag"] = fmt.Sprintf("socks-%d", i)
+		inbounds = append(inbounds, inbound)
+
+		all := buildOutbounds(cfg, ip)
+		all[0]["tag"] = fmt.Sprintf("proxy-%d", i)
+		outbounds = append(outbounds, all[0])
+		shared = all[1:]
+
+		rules = append(rules, map[string]interface{}{
+			"inboundTag":  []string{inbound["tag"].(string)},
+			"outboundTag": all[0]["tag"],
+			"type":        "field",
+		})
+	}
+
+	xrayConfig := map[string]interface{}{
+		"dns":       buildDNS(),
+		"inbounds":  inbounds,
+		"log":       buildLog(cfg),
+		"outbounds": append(outbounds, shared...),
+		"routing": map[string]interface{}{
+			"domainStrategy": "IPIfNonMatch",
+			"rules":          rules,
+		},
+	}
+
+	return json.MarshalIndent(xrayConfig, "", "    ")
+}
+
+// GatewayBalancer is the balancer tag of GenerateGatewayXrayConfig; its
+// outbounds are tagged GatewayOutboundPrefix + index in the order of ips
+const (
+	GatewayBalancer       = "best"
+	GatewayOutboundPrefix = "proxy-"
+)
+
+// GenerateGatewayXrayConfig creates a long-lived xray configuration serving
+// SOCKS5 (and HTTP when httpPort > 0) on listen, with one outbound per IP and
+// an observatory-driven leastPing balancer sending traffic to the fastest
+// live one. The first IP is the fallback until the first probe round.
+func GenerateGatewayXrayConfig(cfg *Config, ips []string, listen string, socksPort, httpPort int, probeInterval time.Duration) ([]byte, error) {
+	if err := checkXraySupport(cfg); err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no IPs to serve")
+	}
+
+	socks := buildInbounds(socksPort)[0]
+	socks["listen"] = listen
+	inbounds := []map[string]interface{}{socks}
+	if httpPort > 0 {
+		inbounds = append(inbounds, map[string]interface{}{
+			"listen":   listen,
+			"port":     httpPort,
+			"protocol": "http",
+			"settings": map[string]interface{}{"userLevel": 8},
+			"sniffing": socks["sniffing"],
+			"tag":      "http",
+		})
+	}
+
+	var outbounds, shared []map[string]interface{}
+	for i, ip := range ips {
+		all := buildOutbounds(cfg, ip)
+		all[0]["tag"] = fmt.Sprintf("%s%d", GatewayOutboundPrefix, i)
+		outbounds = append(outbounds, all[0])
+		shared = all[1:]
+	}
+
+	xrayConfig := map[string]interface{}{
+		"dns":       buildDNS(),
+		"inbounds":  inbounds,
+		"log":       buildLog(cfg),
+		"outbounds": append(outbounds, shared...),
+		"observatory": map[string]interface{}{
+			"subjectSelector":   []string{GatewayOutboundPrefix},
+			"probeURL":          cfg.Scan.TestURL,
+			"probeInterval":     probeInterval.String(),
+			"enableConcurrency": true,
+		},
+		"routing": map[string]interface{}{
+			"domainStrategy": "IPIfNonMatch",
+			"balancers": []map[string]interface{}{
+				{
+					"tag":         GatewayBalancer,
+					"selector":    []string{GatewayOutboundPrefix},
+					"strategy":    map[string]interface{}{"type": "leastPing"},
+					"fallbackTag": GatewayOutboundPrefix + "0",
+				},
+			},
+			"rules": []map[string]interface{}{
+				{
+					"ip":          []string{"223.5.5.5"},
+					"outboundTag": "direct",
+					"port":        "53",
+					"type":        "field",
+				},
+				{
+					"network":     "udp",
+					"outboundTag": "block",
+					"port":        "443",
+					"type":        "field",
+				},
+				{
+					"balancerTag": GatewayBalancer,
+					"port":        "0-65535",
+					"type":        "field",
+				},
+			},
+		},
+	}
+
+	return json.MarshalIndent(xrayConfig, "", "    ")
+}
+
+// checkXraySupport rejects proxy settings the embedded xray-core cannot run
+func checkXraySupport(cfg *Config) error {
+	switch cfg.Proxy.GetProtocol() {
+	case "hysteria2":
+		// hysteria2 outbounds landed in xray-core v25; the embedded v1.8.24 has no handler for them
+		return fmt.Errorf("hysteria2 is not supported by the embedded xray-core (links can still be exported)")
+	case "shadowsocks":
+		if cfg.Proxy.Shadowsocks != nil {
+			switch cfg.Proxy.Shadowsocks.Plugin {
+			case "":
+			case "v2ray-plugin", "xray-plugin":
+				// only the websocket mode maps onto an xray transport
+				if cfg.Proxy.Type != "ws" {
+					return fmt.Errorf("shadowsocks %s is only supported in websocket mode", cfg.Proxy.Shadowsocks.Plugin)
+				}
+			default:
+				return fmt.Errorf("shadowsocks plugin %q is not supported by xray-core", cfg.Proxy.Shadowsocks.Plugin)
+			}
+		}
+	}
+	return nil
+}
+
 func buildDNS() map[string]interface{} {
 	return map[string]interface{}{
 		"hosts": map[string]interface{}{
@@ -134,6 +325,8 @@ func buildOutboundsWithFragment(cfg *Config, targetIP string, fragment FragmentS
 	if address == "" {
 		address = cfg.Proxy.Address
 	}
+	// xray wants bare IPv6 literals in "address", never [bracketed] ones
+	address = utils.StripBrackets(address)
 
 	muxSettings := map[string]interface{}{
 		"concurrency": cfg.Xray.Mux.Concurrency,
@@ -152,24 +345,9 @@ func buildOutboundsWithFragment(cfg *Config, targetIP string, fragment FragmentS
 	}
 
 	proxyOutbound := map[string]interface{}{
-		"mux":      muxSettings,
-		"protocol": "vless",
-		"settings": map[string]interface{}{
-			"vnext": []map[string]interface{}{
-				{
-					"address": address,
-					"port":    cfg.Proxy.Port,
-					"users": []map[string]interface{}{
-						{
-							"encryption": "none",
-							"flow":       "",
-							"id":         cfg.Proxy.UUID,
-							"level":      8,
-						},
-					},
-				},
-			},
-		},
+		"mux":            muxSettings,
+		"protocol":       cfg.Proxy.GetProtocol(),
+		"settings":       buildProxySettings(cfg, address),
 		"streamSettings": buildStreamSettings(cfg, fragment),
 		"tag":            "proxy",
 	}
@@ -240,6 +418,83 @@ func buildOutboundsWithFragment(cfg *Config, targetIP string, fragment FragmentS
 	return outbounds
 }
 
+// buildProxySettings builds the protocol-specific "settings" block of the proxy outbound
+func buildProxySettings(cfg *Config, address string) map[string]interface{} {
+	switch cfg.Proxy.GetProtocol() {
+	case "vmess":
+		alterID := 0
+		security := "auto"
+		if cfg.Proxy.Vmess != nil {
+			alterID = cfg.Proxy.Vmess.AlterID
+			if cfg.Proxy.Vmess.Security != "" {
+				security = cfg.Proxy.Vmess.Security
+			}
+		}
+		return map[string]interface{}{
+			"vnext": []map[string]interface{}{
+				{
+					"address": address,
+					"port":    cfg.Proxy.Port,
+					"users": []map[string]interface{}{
+						{
+							"alterId":  alterID,
+							"id":       cfg.Proxy.UUID,
+							"level":    8,
+							"security": security,
+						},
+					},
+				},
+			},
+		}
+	case "trojan":
+		// Older configs stored the trojan password in the uuid field
+		password := cfg.Proxy.UUID
+		if cfg.Proxy.Trojan != nil && cfg.Proxy.Trojan.Password != "" {
+			password = cfg.Proxy.Trojan.Password
+		}
+		return map[string]interface{}{
+			"servers": []map[string]interface{}{
+				{
+					"address":  address,
+					"level":    8,
+					"password": password,
+					"port":     cfg.Proxy.Port,
+				},
+			},
+		}
+	case "shadowsocks":
+		server := map[string]interface{}{
+			"address": address,
+			"level":   8,
+			"port":    cfg.Proxy.Port,
+		}
+		if cfg.Proxy.Shadowsocks != nil {
+			server["method"] = cfg.Proxy.Shadowsocks.Cipher
+			server["password"] = cfg.Proxy.Shadowsocks.Password
+		}
+		return map[string]interface{}{
+			"servers": []map[string]interface{}{server},
+		}
+	default:
+		return map[string]interface{}{
+			"vnext": []map[string]interface{}{
+				{
+					"address": address,
+					"port":    cfg.Proxy.Port,
+					"users": []map[string]interface{}{
+						{
+							"encryption": "none",
+							"flow":       "",
+							"id":         cfg.Proxy.UUID,
+							"level":      8,
+						},
+					},
+				},
+			},
+		}
+	}
+}
+
 func buildStreamSettings(cfg *Config, fragment FragmentSettings) map[string]interface{} {
 	method := cfg.Proxy.Method
 	if method == "" {
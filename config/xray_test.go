@@ -0,0 +1,113 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func testConfig(protocol string) *Config {
+	cfg := &Config{Proxy: ProxyConfig{
+		Protocol: protocol,
+		Address:  "example.com",
+		Port:     443,
+		Method:   "tls",
+		Type:     "ws",
+		TLS:      &TlsConfig{SNI: "sni.example.com"},
+		WS:       &WsConfig{Host: "h.example.com", Path: "/ws"},
+	}}
+	switch protocol {
+	case "vless":
+		cfg.Proxy.UUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+	case "vmess":
+		cfg.Proxy.UUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+		cfg.Proxy.Vmess = &VmessConfig{Security: "aes-128-gcm"}
+	case "trojan":
+		cfg.Proxy.Trojan = &TrojanConfig{Password: "secret"}
+	case "shadowsocks":
+		cfg.Proxy.Shadowsocks = &ShadowsocksConfig{Cipher: "aes-256-gcm", Password: "secret"}
+	case "hysteria2":
+		cfg.Proxy.Type = "quic"
+		cfg.Proxy.Hysteria2 = &Hysteria2Config{Password: "secret"}
+	}
+	return cfg
+}
+
+func TestGenerateXrayConfigOutbound(t *testing.T) {
+	tests := []struct {
+		protocol string
+		server   string // "vnext" or "servers"
+		secret   string // field holding the credential
+		want     string
+	}{
+		{"vless", "vnext", "id", "b831381d-6324-4d53-ad4f-8cda48b30811"},
+		{"vmess", "vnext", "security", "aes-128-gcm"},
+		{"trojan", "servers", "password", "secret"},
+		{"shadowsocks", "servers", "method", "aes-256-gcm"},
+	}
+	for _, tt := range tests {
+		cfg := testConfig(tt.protocol)
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("%s: Validate: %v", tt.protocol, err)
+		}
+		raw, err := GenerateXrayConfig(cfg, "104.16.1.2", 10808)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.protocol, err)
+		}
+		var x struct {
+			Outbounds []struct {
+				Protocol string                     `json:"protocol"`
+				Settings map[string]json.RawMessage `json:"settings"`
+			} `json:"outbounds"`
+		}
+		if err := json.Unmarshal(raw, &x); err != nil {
+			t.Fatal(err)
+		}
+		proxy := x.Outbounds[0]
+		if proxy.Protocol != tt.protocol {
+			t.Errorf("%s: outbound protocol is %q", tt.protocol, proxy.Protocol)
+			continue
+		}
+		var servers []map[string]interface{}
+		json.Unmarshal(proxy.Settings[tt.server], &servers)
+		if len(servers) != 1 {
+			t.Errorf("%s: want one %s entry, got %d", tt.protocol, tt.server, len(servers))
+			continue
+		}
+		server := servers[0]
+		if server["address"] != "104.16.1.2" {
+			t.Errorf("%s: outbound address is %v, want the scanned IP", tt.protocol, server["address"])
+		}
+		if users, ok := server["users"].([]interface{}); ok {
+			server = users[0].(map[string]interface{})
+		}
+		if server[tt.secret] != tt.want {
+			t.Errorf("%s: %s = %v, want %s", tt.protocol, tt.secret, server[tt.secret], tt.want)
+		}
+	}
+}
+
+func TestValidateTransport(t *testing.T) {
+	tests := []struct {
+		protocol, typ string
+		ok            bool
+	}{
+		{"vless", "ws", true},
+		{"vless", "quic", false},
+		{"trojan", "grpc", true},
+		{"shadowsocks", "quic", false},
+		{"hysteria2", "quic", true},
+		{"hysteria2", "ws", false},
+	}
+	for _, tt := range tests {
+		cfg := testConfig(tt.protocol)
+		cfg.Proxy.Type = tt.typ
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s over %s: Validate() = %v, want ok=%v", tt.protocol, tt.typ, err, tt.ok)
+		}
+	}
+
+	// hysteria2 validates for export but cannot be scanned with this xray-core
+	if _, err := GenerateXrayConfig(testConfig("hysteria2"), "104.16.1.2", 10808); err == nil {
+		t.Error("GenerateXrayConfig accepted hysteria2")
+	}
+}
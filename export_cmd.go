@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"piyazche/config"
+	"piyazche/utils"
+	"piyazche/webui"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportLink   string
+	exportFormat string
+	exportName   string
+	exportOut    string
+	convertBase  string
+	convertIPs   string
+)
+
+// exportCmd builds the `export` subcommand: the best IPs as client configs
+func exportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write the best IPs as a list, share links or client configs",
+		Long: `Write the best IPs in a format clients import. links, base64, clash and
+singbox put each IP into a copy of the proxy link given with --link.
+
+Formats:
+  txt      one IP per line (default)
+  links    one share link per line
+  base64   the links as a v2ray subscription body
+  clash    Clash proxies and a url-test group
+  singbox  sing-box outbounds and a urltest selector
+  json     [{"ip": ..., "link": ...}]
+
+IPs come from --ips, or --from (see piyazche serve --help).
+
+Exit codes: 0 ok, 1 error, 2 bad flags or config, 3 no IPs.
+
+Example:
+  piyazche export -c config.json --top 5
+  piyazche export -c config.json --link 'vless://...' --format clash --out clash.yaml`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: runExport,
+	}
+	addConfigFlag(cmd)
+	addTargetFlags(cmd)
+	cmd.Flags().IntVar(&topN, "top", 10, "Number of IPs to export")
+	cmd.Flags().StringVar(&exportLink, "link", "", "Proxy share link the IPs are put into")
+	cmd.Flags().StringVarP(&exportFormat, "format", "f", "txt", "Output format: txt, links, base64, clash, singbox, json")
+	cmd.Flags().StringVar(&exportName, "name", "piyazche", "Remark of the links, numbered per IP")
+	cmd.Flags().StringVar(&exportOut, "out", "", "File to write (default: stdout)")
+	addJSONFlag(cmd)
+	return cmd
+}
+
+// convertLinkCmd builds the `convert-link` subcommand
+func convertLinkCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "convert-link <link>",
+		Short: "Turn a share link into a config file, or into links for other IPs",
+		Long: `Parse a vless://, vmess://, trojan://, ss:// or hysteria2:// link and write
+a config file for --config. Scan, fragment and xray settings come from --base
+when given and the defaults otherwise. With --ips the link is rewritten for each
+IP instead.
+
+Exit codes: 0 ok, 1 error, 2 bad link or flags.
+
+Example:
+  piyazche convert-link 'vless://uuid@example.com:443?type=ws&security=tls#me' --out config.json
+  piyazche convert-link 'vless://...' --base config.json --out config2.json
+  piyazche convert-link 'vless://...' --ips 104.16.1.2,104.17.3.4`,
+		Args: usageArgs(cobra.ExactArgs(1)),
+		RunE: runConvertLink,
+	}
+	cmd.Flags().StringVar(&convertBase, "base", "", "Config whose non-proxy settings are kept")
+	cmd.Flags().StringVar(&convertIPs, "ips", "", "Comma-separated IPs to rewrite the link for")
+	cmd.Flags().StringVar(&exportOut, "out", "", "File to write (default: stdout)")
+	addJSONFlag(cmd)
+	return cmd
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	var linkCfg *config.Config
+	switch exportFormat {
+	case "txt", "json":
+	case "links", "base64", "clash", "singbox":
+		if exportLink == "" {
+			return usageErrorf("--format %s needs --link", exportFormat)
+		}
+	default:
+		return usageErrorf("--format must be txt, links, base64, clash, singbox or json")
+	}
+	if exportLink != "" {
+		var err error
+		if linkCfg, err = webui.ParseProxyURL(exportLink); err != nil {
+			return withExit(exitUsage, fmt.Errorf("bad --link: %w", err))
+		}
+	}
+
+	// the config is only needed to find this config's sessions in the history
+	cfg := config.DefaultConfig()
+	if cmd.Flags().Changed("config") || (targetList == "" && targetFrom != "health") {
+		var err error
+		if cfg, err = loadCLIConfig(); err != nil {
+			return err
+		}
+	}
+	ips, source, err := targetIPs(cfg, topN)
+	if err != nil {
+		return err
+	}
+
+	var links []string
+	if linkCfg != nil {
+		for i, ip := range ips {
+			link, err := webui.BuildNamedProxyURL(linkCfg, ip, exportLink, fmt.Sprintf("%s %d", exportName, i+1))
+			if err != nil {
+				return withExit(exitUsage, fmt.Errorf("bad --link: %w", err))
+			}
+			links = append(links, link)
+		}
+	}
+
+	var content string
+	switch exportFormat {
+	case "txt":
+		content = strings.Join(ips, "\n") + "\n"
+	case "links":
+		content = strings.Join(links, "\n") + "\n"
+	case "base64":
+		content = base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n")))
+	case "clash":
+		content = webui.BuildClashProxies(linkCfg, ips, exportLink)
+	case "singbox":
+		content = webui.BuildSingboxOutbounds(linkCfg, ips)
+	case "json":
+		rows := make([]map[string]string, len(ips))
+		for i, ip := range ips {
+			rows[i] = map[string]string{"ip": ip}
+			if links != nil {
+				rows[i]["link"] = links[i]
+			}
+		}
+		data, _ := json.MarshalIndent(rows, "", "  ")
+		content = string(data) + "\n"
+	}
+
+	done := map[string]interface{}{"format": exportFormat, "source": source, "count": len(ips), "ips": ips}
+	if err := writeOutput(content, done); err != nil {
+		return err
+	}
+	emit("done", done)
+	return nil
+}
+
+func runConvertLink(cmd *cobra.Command, args []string) error {
+	parsed, err := webui.ParseProxyURL(args[0])
+	if err != nil {
+		return withExit(exitUsage, err)
+	}
+
+	if convertIPs != "" {
+		var links []string
+		for _, ip := range strings.Split(convertIPs, ",") {
+			if ip = strings.TrimSpace(ip); ip == "" {
+				continue
+			}
+			link, err := webui.BuildProxyURL(parsed, ip, args[0])
+			if err != nil {
+				return withExit(exitUsage, err)
+			}
+			links = append(links, link)
+		}
+		done := map[string]interface{}{"links": links}
+		if err := writeOutput(strings.Join(links, "\n")+"\n", done); err != nil {
+			return err
+		}
+		emit("done", done)
+		return nil
+	}
+
+	cfg := parsed
+	if convertBase != "" {
+		if cfg, err = config.LoadConfig(convertBase); err != nil {
+			return withExit(exitUsage, fmt.Errorf("failed to load --base: %w", err))
+		}
+		cfg.Proxy = parsed.Proxy
+	}
+	if err := cfg.Validate(); err != nil {
+		return withExit(exitUsage, fmt.Errorf("invalid config: %w", err))
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	done := map[string]interface{}{
+		"protocol": cfg.Proxy.GetProtocol(),
+		"address":  cfg.Proxy.Address,
+		"port":     cfg.Proxy.Port,
+	}
+	if err := writeOutput(string(data)+"\n", done); err != nil {
+		return err
+	}
+	emit("done", done)
+	return nil
+}
+
+// writeOutput writes content to --out or stdout; in --json mode without
+// --out it goes into the done event instead
+func writeOutput(content string, done map[string]interface{}) error {
+	if exportOut != "" {
+		if err := os.WriteFile(exportOut, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", exportOut, err)
+		}
+		fmt.Fprintf(os.Stderr, "%sSaved to:%s %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, exportOut, utils.Reset)
+		done["output"] = exportOut
+		return nil
+	}
+	if jsonEnc != nil {
+		done["content"] = content
+		return nil
+	}
+	fmt.Print(content)
+	return nil
+}
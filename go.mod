@@ -6,6 +6,7 @@ require (
 	github.com/schollz/progressbar/v3 v3.14.1
 	github.com/spf13/cobra v1.8.0
 	github.com/xtls/xray-core v1.8.24
+	go.etcd.io/bbolt v1.3.11
 	golang.org/x/net v0.49.0
 )
 
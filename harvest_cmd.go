@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"piyazche/config"
+	"piyazche/shodan"
+	"piyazche/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	harvestQuery     string
+	harvestOut       string
+	harvestAppend    bool
+	harvestIncludeCF bool
+	harvestScan      bool
+)
+
+// harvestCmd builds the `harvest` subcommand: collect IPs from Shodan
+func harvestCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "harvest",
+		Short: "Collect Cloudflare-fronted IPs from Shodan",
+		Long: `Search Shodan for hosts outside Cloudflare's own ranges that serve
+Cloudflare's certificate and save them to a file usable with --subnets.
+The key comes from --shodan-key, env SHODAN_API_KEY or shodan.apiKey of --config.
+
+Exit codes: 0 ok, 1 error, 2 bad flags or config, 3 nothing found
+(with --scan, the codes of piyazche scan).
+
+Example:
+  piyazche harvest --shodan-key KEY --shodan-pages 3
+  piyazche harvest -c config.json --scan --json`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := config.DefaultConfig()
+			if cmd.Flags().Changed("config") || harvestScan {
+				var err error
+				if cfg, err = loadCLIConfig(); err != nil {
+					return err
+				}
+			}
+			cfg.Shodan.Mode = "harvest"
+			if harvestScan {
+				cfg.Shodan.Mode = "both"
+			}
+			applyShodanFlags(cfg)
+			if cmd.Flags().Changed("query") {
+				cfg.Shodan.Query = harvestQuery
+				cfg.Shodan.UseDefaultQuery = harvestQuery == ""
+			}
+			if cmd.Flags().Changed("out") {
+				cfg.Shodan.SaveHarvestedIPs = harvestOut
+			}
+			if cmd.Flags().Changed("append") {
+				cfg.Shodan.AppendToExisting = harvestAppend
+			}
+			if cmd.Flags().Changed("include-cf") {
+				cfg.Shodan.ExcludeCFRanges = !harvestIncludeCF
+			}
+			return runHarvest(cfg)
+		},
+	}
+	addConfigFlag(cmd)
+	cmd.Flags().StringVar(&shodanKey, "shodan-key", "", "Shodan API key (or env SHODAN_API_KEY, overrides config)")
+	cmd.Flags().IntVar(&shodanPages, "shodan-pages", 0, "Result pages to fetch, 100 IPs and one query credit each (overrides config)")
+	cmd.Flags().StringVar(&harvestQuery, "query", "", "Shodan search query (default: Cloudflare certificate outside Cloudflare ranges)")
+	cmd.Flags().StringVar(&harvestOut, "out", "", "File to save the IPs to (default: shodan.saveHarvestedIPs, results/shodan_ips.txt)")
+	cmd.Flags().BoolVar(&harvestAppend, "append", false, "Append to the file instead of replacing it")
+	cmd.Flags().BoolVar(&harvestIncludeCF, "include-cf", false, "Keep IPs inside Cloudflare's own ranges")
+	cmd.Flags().BoolVar(&harvestScan, "scan", false, "Scan the harvested IPs with --config right away")
+	cmd.Flags().IntVar(&topN, "top", 10, "Number of top results to display with --scan")
+	addJSONFlag(cmd)
+	return cmd
+}
+
+// applyShodanFlags applies the --shodan-* overrides and env SHODAN_API_KEY
+func applyShodanFlags(cfg *config.Config) {
+	if shodanMode != "" {
+		cfg.Shodan.Mode = shodanMode
+	}
+	if shodanKey != "" {
+		cfg.Shodan.APIKey = shodanKey
+	} else if cfg.Shodan.APIKey == "" {
+		cfg.Shodan.APIKey = os.Getenv("SHODAN_API_KEY")
+	}
+	if shodanPages > 0 {
+		cfg.Shodan.Pages = shodanPages
+	}
+}
+
+// runHarvest collects IPs as cfg.Shodan says, saves them unless the mode is
+// "scan" and scans them unless it is "harvest"
+func runHarvest(cfg *config.Config) error {
+	sc := cfg.Shodan
+	switch sc.Mode {
+	case "harvest", "scan", "both":
+	default:
+		return usageErrorf("shodan mode must be harvest, scan or both")
+	}
+	if sc.APIKey == "" {
+		return usageErrorf("a Shodan API key is required, use --shodan-key or env SHODAN_API_KEY")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	onInterrupt(cancel)
+
+	h := shodan.NewHarvester(shodan.HarvestConfig{
+		APIKey:          sc.APIKey,
+		Query:           sc.Query,
+		UseDefaultQuery: sc.UseDefaultQuery || sc.Query == "",
+		Pages:           sc.Pages,
+		ExcludeCFRanges: sc.ExcludeCFRanges,
+	})
+	result, err := h.Harvest(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return withExit(exitInterrupted, errors.New("harvest interrupted"))
+		}
+		return err
+	}
+
+	done := map[string]interface{}{"count": len(result.IPs), "total": result.TotalFound, "ips": result.IPs}
+	if sc.Mode != "scan" && len(result.IPs) > 0 {
+		path := sc.SaveHarvestedIPs
+		if path == "" {
+			path = "results/shodan_ips.txt"
+		}
+		if err := shodan.SaveIPs(result.IPs, path, sc.AppendToExisting); err != nil {
+			return fmt.Errorf("failed to save harvested IPs: %w", err)
+		}
+		fmt.Printf("%sHarvested IPs saved to:%s %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, path, utils.Reset)
+		done["output"] = path
+	}
+	emit("harvest", done)
+
+	if len(result.IPs) == 0 {
+		return withExit(exitNoResults, errors.New("no IPs found on Shodan"))
+	}
+	if sc.Mode == "harvest" {
+		return nil
+	}
+
+	if cfg.Proxy.Method == "reality" {
+		return usageErrorf("scanning is not available for reality")
+	}
+	subnetsPath = strings.Join(result.IPs, ",")
+	cfg.PrintConfigInfo()
+	return runScan(cfg)
+}
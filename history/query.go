@@ -0,0 +1,260 @@
+package history
+
+import (
+	"net"
+	"sort"
+	"time"
+
+	"piyazche/utils"
+)
+
+// Filter narrows a query; zero fields match everything. Queries read a
+// history that can't be opened as empty.
+type Filter struct {
+	Since    time.Time
+	ConfigFP string
+	Session  string
+	ip       string // IPHistory
+}
+
+func (f Filter) match(r *Record) bool {
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if f.Session != "" && r.Session != f.Session {
+		return false
+	}
+	if f.ip != "" && r.IP != f.ip {
+		return false
+	}
+	return f.ConfigFP == "" || r.ConfigFP == f.ConfigFP
+}
+
+// LatestSession returns the session of the newest passing test, "" if none
+func (s *Store) LatestSession(f Filter) string {
+	var session string
+	s.scan(f, true, func(r *Record) bool {
+		if r.Passed {
+			session = r.Session
+		}
+		return session == ""
+	})
+	return session
+}
+
+// IPSummary aggregates all tests of one IP. Phase-2 numbers are used when the
+// IP has any, phase-1 numbers otherwise.
+type IPSummary struct {
+	IP           string    `json:"ip"`
+	Phase        string    `json:"phase"` // KindPhase1 or KindPhase2
+	Tests        int       `json:"tests"`
+	Passes       int       `json:"passes"`
+	PassRate     float64   `json:"pass_rate"` // 0-100
+	AvgLatencyMs float64   `json:"avg_latency_ms"`
+	AvgJitterMs  float64   `json:"avg_jitter_ms,omitempty"`
+	AvgScore     float64   `json:"avg_score,omitempty"`
+	Sessions     int       `json:"sessions"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+type ipAgg struct {
+	tests, passes       int
+	latSum, jitSum, sum float64 // latency/jitter over passes, score over all
+	sessions            map[string]bool
+	last                time.Time
+}
+
+func (a *ipAgg) add(r *Record) {
+	a.tests++
+	if r.Passed {
+		a.passes++
+		a.latSum += r.LatencyMs
+		a.jitSum += r.JitterMs
+	}
+	a.sum += r.Score
+	a.sessions[r.Session] = true
+	if r.Time.After(a.last) {
+		a.last = r.Time
+	}
+}
+
+// Best returns the IPs with the highest pass rate, then score, then latency.
+// limit <= 0 returns all of them.
+func (s *Store) Best(f Filter, limit int) []IPSummary {
+	aggs := map[string]map[string]*ipAgg{} // kind -> ip -> agg
+	s.scan(f, false, func(r *Record) bool {
+		byIP := aggs[r.Kind]
+		if byIP == nil {
+			byIP = map[string]*ipAgg{}
+			aggs[r.Kind] = byIP
+		}
+		a := byIP[r.IP]
+		if a == nil {
+			a = &ipAgg{sessions: map[string]bool{}}
+			byIP[r.IP] = a
+		}
+		a.add(r)
+		return true
+	})
+
+	var out []IPSummary
+	summarize := func(ip, kind string, a *ipAgg) {
+		sum := IPSummary{
+			IP:       ip,
+			Phase:    kind,
+			Tests:    a.tests,
+			Passes:   a.passes,
+			PassRate: float64(a.passes) / float64(a.tests) * 100,
+			AvgScore: a.sum / float64(a.tests),
+			Sessions: len(a.sessions),
+			LastSeen: a.last,
+		}
+		if a.passes > 0 {
+			sum.AvgLatencyMs = a.latSum / float64(a.passes)
+			sum.AvgJitterMs = a.jitSum / float64(a.passes)
+		}
+		out = append(out, sum)
+	}
+	for ip, a := range aggs[KindPhase2] {
+		summarize(ip, KindPhase2, a)
+	}
+	for ip, a := range aggs[KindPhase1] {
+		if _, ok := aggs[KindPhase2][ip]; !ok {
+			summarize(ip, KindPhase1, a)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.PassRate != b.PassRate {
+			return a.PassRate > b.PassRate
+		}
+		if a.AvgScore != b.AvgScore {
+			return a.AvgScore > b.AvgScore
+		}
+		if a.AvgLatencyMs != b.AvgLatencyMs {
+			return a.AvgLatencyMs < b.AvgLatencyMs
+		}
+		return a.IP < b.IP
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// IPHistory returns every test of ip, oldest first
+func (s *Store) IPHistory(ip string, f Filter) []Record {
+	f.ip = utils.StripBrackets(ip)
+	if f.ip == "" {
+		return nil
+	}
+	var out []Record
+	s.scan(f, false, func(r *Record) bool {
+		out = append(out, *r)
+		return true
+	})
+	return out
+}
+
+// TrendPoint is one session's phase-1 outcome for a subnet
+type TrendPoint struct {
+	Session      string    `json:"session"`
+	Time         time.Time `json:"time"`
+	Total        int       `json:"total"`
+	Passed       int       `json:"passed"`
+	PassRate     float64   `json:"pass_rate"` // 0-100
+	AvgLatencyMs float64   `json:"avg_latency_ms"`
+}
+
+// SubnetTrend is a subnet's pass rate across sessions, oldest first
+type SubnetTrend struct {
+	Subnet   string       `json:"subnet"`
+	Total    int          `json:"total"`
+	Passed   int          `json:"passed"`
+	PassRate float64      `json:"pass_rate"` // 0-100, over all sessions
+	Points   []TrendPoint `json:"points"`
+}
+
+// SubnetTrends groups phase-1 results by /24 (IPv4) or /64 (IPv6) and session,
+// best subnets first. limit <= 0 returns all of them.
+func (s *Store) SubnetTrends(f Filter, limit int) []SubnetTrend {
+	type key struct{ subnet, session string }
+	points := map[key]*TrendPoint{}
+	latSum := map[key]float64{}
+
+	s.scan(f, false, func(r *Record) bool {
+		if r.Kind != KindPhase1 {
+			return true
+		}
+		subnet := SubnetOf(r.IP)
+		if subnet == "" {
+			return true
+		}
+		k := key{subnet, r.Session}
+		p := points[k]
+		if p == nil {
+			p = &TrendPoint{Session: r.Session, Time: r.Time}
+			points[k] = p
+		}
+		if r.Time.Before(p.Time) {
+			p.Time = r.Time
+		}
+		p.Total++
+		if r.Passed {
+			p.Passed++
+			latSum[k] += r.LatencyMs
+		}
+		return true
+	})
+
+	bySubnet := map[string]*SubnetTrend{}
+	for k, p := range points {
+		p.PassRate = float64(p.Passed) / float64(p.Total) * 100
+		if p.Passed > 0 {
+			p.AvgLatencyMs = latSum[k] / float64(p.Passed)
+		}
+		t := bySubnet[k.subnet]
+		if t == nil {
+			t = &SubnetTrend{Subnet: k.subnet}
+			bySubnet[k.subnet] = t
+		}
+		t.Total += p.Total
+		t.Passed += p.Passed
+		t.Points = append(t.Points, *p)
+	}
+
+	out := make([]SubnetTrend, 0, len(bySubnet))
+	for _, t := range bySubnet {
+		t.PassRate = float64(t.Passed) / float64(t.Total) * 100
+		sort.Slice(t.Points, func(i, j int) bool { return t.Points[i].Time.Before(t.Points[j].Time) })
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].PassRate != out[j].PassRate {
+			return out[i].PassRate > out[j].PassRate
+		}
+		if out[i].Total != out[j].Total {
+			return out[i].Total > out[j].Total
+		}
+		return out[i].Subnet < out[j].Subnet
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// SubnetOf returns the /24 (IPv4) or /64 (IPv6) containing ip, "" if invalid
+func SubnetOf(ip string) string {
+	parsed := net.ParseIP(utils.StripBrackets(ip))
+	if parsed == nil {
+		return ""
+	}
+	bits := 64
+	if v4 := parsed.To4(); v4 != nil {
+		parsed, bits = v4, 24
+	}
+	mask := net.CIDRMask(bits, len(parsed)*8)
+	return (&net.IPNet{IP: parsed.Mask(mask), Mask: mask}).String()
+}
@@ -0,0 +1,510 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"piyazche/scanner"
+)
+
+// Record kinds
+const (
+	KindPhase1 = "p1"
+	KindPhase2 = "p2"
+)
+
+// Record is one test of one IP
+type Record struct {
+	Kind      string    `json:"kind"`
+	Time      time.Time `json:"time"`
+	Session   string    `json:"session"`
+	ConfigFP  string    `json:"config_fp"`
+	IP        string    `json:"ip"`
+	Passed    bool      `json:"passed"` // phase 1: Success, phase 2: Passed
+	LatencyMs float64   `json:"latency_ms,omitempty"`
+	JitterMs  float64   `json:"jitter_ms,omitempty"`
+	LossPct   float64   `json:"loss_pct,omitempty"`
+	DownMbps  float64   `json:"down_mbps,omitempty"`
+	UpMbps    float64   `json:"up_mbps,omitempty"`
+	Score     float64   `json:"score,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// bucketRecords holds every record as JSON under its time key; the index
+// buckets point from a field value and time key back to a record, so a
+// query filtered on that field reads only its own records
+var (
+	bucketRecords   = []byte("records")
+	bucketByConfig  = []byte("by_config")
+	bucketBySession = []byte("by_session")
+	bucketByIP      = []byte("by_ip")
+)
+
+var indexes = []struct {
+	bucket []byte
+	field  func(r *Record) string
+}{
+	{bucketByConfig, func(r *Record) string { return r.ConfigFP }},
+	{bucketBySession, func(r *Record) string { return r.Session }},
+	{bucketByIP, func(r *Record) string { return r.IP }},
+}
+
+// lockTimeout is how long to wait while another process uses the database
+const lockTimeout = 5 * time.Second
+
+// Store is the result history, kept in a bbolt database. Records are keyed
+// by time and indexed by (config fingerprint, time), (session, time) and
+// (ip, time). Nothing is held in memory: the database is opened for each
+// query or batch of writes, so a CLI scan and the Web UI can share it.
+type Store struct {
+	path string
+}
+
+// DefaultPath returns where the result history lives: ./piyazche_history.db
+// when it exists or the working directory is writable, ~/.piyazche/history.db
+// otherwise. Nothing is created; the first Append does that.
+func DefaultPath() string {
+	local := "piyazche_history.db"
+	if _, err := os.Stat(local); err == nil || dirWritable(".") {
+		return local
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return local
+	}
+	return filepath.Join(home, ".piyazche", "history.db")
+}
+
+// dirWritable probes dir with a temporary file that is removed right away
+func dirWritable(dir string) bool {
+	f, err := os.CreateTemp(dir, ".piyazche-probe-*")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
+
+// Open returns the history at path, creating it on first write. A .jsonl
+// path names the database next to it. A JSON-lines history of an older
+// version with the same base name is imported once and renamed to
+// *.jsonl.imported.
+func Open(path string) (*Store, error) {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	if filepath.Ext(path) == ".jsonl" {
+		path = base + ".db"
+	}
+	s := &Store{path: path}
+	if err := s.view(func(*bolt.Tx) error { return nil }); err != nil {
+		return nil, err
+	}
+	if legacy := base + ".jsonl"; fileExists(legacy) {
+		if err := s.importJSONL(legacy); err != nil {
+			return nil, fmt.Errorf("failed to import %s: %w", legacy, err)
+		}
+	}
+	return s, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// importJSONL appends the records of an old JSON-lines history and renames it
+func (s *Store) importJSONL(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	var records []Record
+	sc := bufio.NewScanner(file)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var r Record
+		// a torn last line after a crash is skipped, not fatal
+		if json.Unmarshal(sc.Bytes(), &r) == nil {
+			records = append(records, r)
+		}
+	}
+	file.Close()
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if err := s.Append(records...); err != nil {
+		return err
+	}
+	return os.Rename(path, path+".imported")
+}
+
+// view runs fn in a read transaction; a missing database reads as empty
+func (s *Store) view(fn func(tx *bolt.Tx) error) error {
+	if !fileExists(s.path) {
+		return nil
+	}
+	db, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: lockTimeout, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketRecords) == nil {
+			return nil
+		}
+		return fn(tx)
+	})
+}
+
+// update runs fn in a write transaction, creating the database on first use
+func (s *Store) update(fn func(tx *bolt.Tx) error) error {
+	if dir := filepath.Dir(s.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+	db, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: lockTimeout})
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(bucketRecords); err != nil {
+			return err
+		}
+		for _, ix := range indexes {
+			if _, err := tx.CreateBucketIfNotExists(ix.bucket); err != nil {
+				return err
+			}
+		}
+		return fn(tx)
+	})
+}
+
+// timeKey orders records by time; seq keeps records of the same instant apart
+func timeKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	if !t.IsZero() && t.UnixNano() > 0 {
+		binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	}
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// indexKey is value, a zero byte and the record's time key
+func indexKey(value string, key []byte) []byte {
+	return append(append([]byte(value), 0), key...)
+}
+
+// Len returns the number of stored records
+func (s *Store) Len() int {
+	n := 0
+	s.view(func(tx *bolt.Tx) error {
+		n = tx.Bucket(bucketRecords).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// Append stores records in one transaction
+func (s *Store) Append(records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	err := s.update(func(tx *bolt.Tx) error {
+		recs := tx.Bucket(bucketRecords)
+		for i := range records {
+			r := &records[i]
+			seq, err := recs.NextSequence()
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			key := timeKey(r.Time, seq)
+			if err := recs.Put(key, data); err != nil {
+				return err
+			}
+			for _, ix := range indexes {
+				if v := ix.field(r); v != "" {
+					if err := tx.Bucket(ix.bucket).Put(indexKey(v, key), []byte{}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return nil
+}
+
+// DefaultRetention is how long records are kept when nothing else is configured
+const DefaultRetention = 90 * 24 * time.Hour
+
+// Compact deletes records older than maxAge along with their index entries.
+// The database is not opened for writing when no record is too old.
+func (s *Store) Compact(maxAge time.Duration) (removed int, err error) {
+	if maxAge <= 0 {
+		return 0, nil
+	}
+	cutoff := timeKey(time.Now().Add(-maxAge), 0)
+	stale := func(tx *bolt.Tx) bool {
+		k, _ := tx.Bucket(bucketRecords).Cursor().First()
+		return k != nil && bytes.Compare(k, cutoff) < 0
+	}
+
+	found := false
+	if err := s.view(func(tx *bolt.Tx) error { found = stale(tx); return nil }); err != nil || !found {
+		return 0, err
+	}
+
+	err = s.update(func(tx *bolt.Tx) error {
+		recs := tx.Bucket(bucketRecords)
+		var keys [][]byte
+		var old []Record
+		c := recs.Cursor()
+		for k, v := c.First(); k != nil && bytes.Compare(k, cutoff) < 0; k, v = c.Next() {
+			var r Record
+			json.Unmarshal(v, &r)
+			keys = append(keys, append([]byte(nil), k...))
+			old = append(old, r)
+		}
+		for i, key := range keys {
+			for _, ix := range indexes {
+				if v := ix.field(&old[i]); v != "" {
+					if err := tx.Bucket(ix.bucket).Delete(indexKey(v, key)); err != nil {
+						return err
+					}
+				}
+			}
+			if err := recs.Delete(key); err != nil {
+				return err
+			}
+		}
+		removed = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to compact history: %w", err)
+	}
+	return removed, nil
+}
+
+// Recorder writes the phase-1 results of one run in the background, so
+// scan workers never wait for the disk. Results that arrive while a batch
+// is being written go into the next transaction.
+type Recorder struct {
+	store             *Store
+	session, configFP string
+	onErr             func(error)
+	errOnce           sync.Once
+
+	mu      sync.Mutex
+	pending []scanner.Result
+	closed  bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+// Record appends every phase-1 result rc collects from now on, shortly
+// after it arrives, so the results of a run that crashes are kept up to
+// the last batch. Results restored from a checkpoint were recorded by the
+// run that tested them and do not pass through OnAdd. onErr gets the first
+// failed write only. Close the Recorder when the run is over. A nil Store
+// records nothing.
+func (s *Store) Record(rc *scanner.ResultCollector, session, configFP string, onErr func(error)) *Recorder {
+	if s == nil {
+		return nil
+	}
+	rec := &Recorder{
+		store:    s,
+		session:  session,
+		configFP: configFP,
+		onErr:    onErr,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go rec.loop()
+
+	prev := rc.OnAdd
+	rc.OnAdd = func(r scanner.Result) {
+		if prev != nil {
+			prev(r)
+		}
+		rec.add(r)
+	}
+	return rec
+}
+
+func (rec *Recorder) add(r scanner.Result) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.closed {
+		return
+	}
+	rec.pending = append(rec.pending, r)
+	select {
+	case rec.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (rec *Recorder) loop() {
+	defer close(rec.done)
+	for range rec.wake {
+		rec.flush()
+	}
+	rec.flush()
+}
+
+func (rec *Recorder) flush() {
+	rec.mu.Lock()
+	batch := rec.pending
+	rec.pending = nil
+	rec.mu.Unlock()
+
+	if err := rec.store.AddResults(rec.session, rec.configFP, batch); err != nil && rec.onErr != nil {
+		rec.errOnce.Do(func() { rec.onErr(err) })
+	}
+}
+
+// Close writes the results still queued and stops the Recorder
+func (rec *Recorder) Close() {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	if rec.closed {
+		rec.mu.Unlock()
+		return
+	}
+	rec.closed = true
+	close(rec.wake)
+	rec.mu.Unlock()
+	<-rec.done
+}
+
+// AddResults records phase-1 results of a session
+func (s *Store) AddResults(session, configFP string, results []scanner.Result) error {
+	records := make([]Record, 0, len(results))
+	for _, r := range results {
+		records = append(records, Record{
+			Kind:      KindPhase1,
+			Time:      r.TestedAt,
+			Session:   session,
+			ConfigFP:  configFP,
+			IP:        r.IP,
+			Passed:    r.Success,
+			LatencyMs: float64(r.LatencyMs),
+			LossPct:   r.PacketLossPct,
+			DownMbps:  r.DownloadMbps,
+			UpMbps:    r.UploadMbps,
+			Error:     r.Error,
+		})
+	}
+	return s.Append(records...)
+}
+
+// AddPhase2 records phase-2 results of a session
+func (s *Store) AddPhase2(session, configFP string, results []scanner.Phase2Result) error {
+	now := time.Now()
+	records := make([]Record, 0, len(results))
+	for _, r := range results {
+		records = append(records, Record{
+			Kind:      KindPhase2,
+			Time:      now,
+			Session:   session,
+			ConfigFP:  configFP,
+			IP:        r.IP,
+			Passed:    r.Passed,
+			LatencyMs: r.AvgLatencyMs,
+			JitterMs:  r.JitterMs,
+			LossPct:   r.PacketLossPct,
+			DownMbps:  r.DownloadMbps,
+			UpMbps:    r.UploadMbps,
+			Score:     r.StabilityScore,
+			Error:     r.FailReason,
+		})
+	}
+	return s.Append(records...)
+}
+
+// scan calls fn for every record matching f, oldest first or newest first,
+// until fn returns false. It walks the index of the most selective field f
+// sets, starting at f.Since, and the whole log only when f sets none.
+func (s *Store) scan(f Filter, newestFirst bool, fn func(r *Record) bool) error {
+	return s.view(func(tx *bolt.Tx) error {
+		recs := tx.Bucket(bucketRecords)
+		visit := func(data []byte) bool {
+			var r Record
+			if data == nil || json.Unmarshal(data, &r) != nil || !f.match(&r) {
+				return true
+			}
+			return fn(&r)
+		}
+
+		since := timeKey(f.Since, 0)
+		for _, ix := range []struct {
+			value  string
+			bucket []byte
+		}{{f.ip, bucketByIP}, {f.Session, bucketBySession}, {f.ConfigFP, bucketByConfig}} {
+			if ix.value == "" {
+				continue
+			}
+			prefix := indexKey(ix.value, nil)
+			walk(tx.Bucket(ix.bucket).Cursor(), prefix, since, newestFirst, func(k, _ []byte) bool {
+				return visit(recs.Get(k[len(prefix):]))
+			})
+			return nil
+		}
+		walk(recs.Cursor(), nil, since, newestFirst, func(_, v []byte) bool { return visit(v) })
+		return nil
+	})
+}
+
+// walk visits the keys of c that start with prefix and whose time key is
+// at least since, until fn returns false
+func walk(c *bolt.Cursor, prefix, since []byte, newestFirst bool, fn func(k, v []byte) bool) {
+	start := append(append([]byte(nil), prefix...), since...)
+	if !newestFirst {
+		for k, v := c.Seek(start); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if !fn(k, v) {
+				return
+			}
+		}
+		return
+	}
+
+	// the prefix ends in a zero byte, so the first key after it ends in one
+	var k, v []byte
+	if len(prefix) == 0 {
+		k, v = c.Last()
+	} else {
+		end := append(append([]byte(nil), prefix[:len(prefix)-1]...), 1)
+		if k, _ = c.Seek(end); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+	}
+	for ; k != nil && bytes.HasPrefix(k, prefix) && bytes.Compare(k, start) >= 0; k, v = c.Prev() {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"piyazche/scanner"
+)
+
+func TestRecordWritesEachResult(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := scanner.NewResultCollector()
+	rec := store.Record(rc, "s1", "fp", func(err error) { t.Error(err) })
+
+	rc.Add(scanner.Result{IP: "1.1.1.1", Success: true, TestedAt: time.Now()})
+	rc.Add(scanner.Result{IP: "1.0.0.1", TestedAt: time.Now()})
+	rec.Close()
+	rc.Add(scanner.Result{IP: "1.0.0.2", TestedAt: time.Now()}) // after Close: dropped
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := reopened.Len(); n != 2 {
+		t.Fatalf("history has %d records after two results, want 2", n)
+	}
+}
+
+func TestCompactDropsOldRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, _ := Open(path)
+	now := time.Now()
+	err := store.Append(
+		Record{Kind: KindPhase1, Time: now.Add(-100 * 24 * time.Hour), IP: "1.1.1.1", ConfigFP: "fp"},
+		Record{Kind: KindPhase1, Time: now.Add(-time.Hour), IP: "1.0.0.1", ConfigFP: "fp"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := store.Compact(DefaultRetention)
+	if err != nil || removed != 1 {
+		t.Fatalf("Compact = %d, %v; want 1, nil", removed, err)
+	}
+	reopened, _ := Open(path)
+	if n := reopened.Len(); n != 1 {
+		t.Fatalf("database has %d records after compaction, want 1", n)
+	}
+	if got := reopened.IPHistory("1.1.1.1", Filter{}); len(got) != 0 {
+		t.Fatalf("compacted IP still has %d records in the index", len(got))
+	}
+	if removed, _ := store.Compact(DefaultRetention); removed != 0 {
+		t.Fatalf("second Compact removed %d records, want 0", removed)
+	}
+}
+
+func TestQueriesFilter(t *testing.T) {
+	store, _ := Open(filepath.Join(t.TempDir(), "history.db"))
+	now := time.Now()
+	store.Append(
+		Record{Kind: KindPhase1, Time: now.Add(-3 * time.Hour), Session: "old", ConfigFP: "a", IP: "1.1.1.1", Passed: true, LatencyMs: 100},
+		Record{Kind: KindPhase1, Time: now.Add(-2 * time.Hour), Session: "new", ConfigFP: "a", IP: "1.1.1.1", Passed: true, LatencyMs: 200},
+		Record{Kind: KindPhase1, Time: now.Add(-2 * time.Hour), Session: "new", ConfigFP: "a", IP: "1.0.0.1"},
+		Record{Kind: KindPhase1, Time: now.Add(-time.Hour), Session: "other", ConfigFP: "b", IP: "1.1.1.1", Passed: true},
+		Record{Kind: KindPhase1, Time: now.Add(-time.Minute), Session: "failed", ConfigFP: "a", IP: "1.0.0.2"},
+	)
+
+	if got := store.LatestSession(Filter{ConfigFP: "a"}); got != "new" {
+		t.Errorf("LatestSession(a) = %q, want new", got)
+	}
+	if got := store.LatestSession(Filter{ConfigFP: "c"}); got != "" {
+		t.Errorf("LatestSession(c) = %q, want none", got)
+	}
+
+	best := store.Best(Filter{ConfigFP: "a", Session: "new"}, 0)
+	if len(best) != 2 || best[0].IP != "1.1.1.1" || best[0].AvgLatencyMs != 200 {
+		t.Errorf("Best(a, new) = %+v, want 1.1.1.1 at 200ms first of 2", best)
+	}
+
+	hist := store.IPHistory("1.1.1.1", Filter{Since: now.Add(-150 * time.Minute)})
+	if len(hist) != 2 || hist[0].Session != "new" || hist[1].Session != "other" {
+		t.Errorf("IPHistory since 2.5h = %+v, want the new and other sessions in order", hist)
+	}
+}
+
+func TestOpenImportsJSONL(t *testing.T) {
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, "history.jsonl")
+	lines := `{"kind":"p1","time":"2030-01-01T00:00:00Z","session":"s","config_fp":"fp","ip":"1.1.1.1","passed":true}
+{"kind":"p2","time":"2030-01-01T00:01:00Z","session":"s","config_fp":"fp","ip":"1.1.1.1","passed":true}
+{"kind":"p1","ti`
+	if err := os.WriteFile(legacy, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := Open(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := store.Len(); n != 2 {
+		t.Fatalf("imported %d records, want the 2 whole lines", n)
+	}
+	if _, err := os.Stat(legacy + ".imported"); err != nil {
+		t.Errorf("old file not renamed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "history.db")); err != nil {
+		t.Errorf("database not next to the old file: %v", err)
+	}
+}
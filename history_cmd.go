@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"piyazche/history"
+	"piyazche/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyDays  int
+	historyLimit int
+	historyAllFP bool
+)
+
+// openHistory opens the result history, nil when disabled or unreadable
+func openHistory() *history.Store {
+	path := historyPath
+	if path == "off" {
+		return nil
+	}
+	if path == "" {
+		path = history.DefaultPath()
+	}
+	store, err := history.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sWarning:%s %v\n", utils.Yellow, utils.Reset, err)
+		return nil
+	}
+	if _, err := store.Compact(time.Duration(historyKeep) * 24 * time.Hour); err != nil {
+		fmt.Fprintf(os.Stderr, "%sWarning:%s %v\n", utils.Yellow, utils.Reset, err)
+	}
+	return store
+}
+
+// warnHistory reports a failed history write
+func warnHistory(err error) {
+	fmt.Fprintf(os.Stderr, "%sWarning:%s failed to record history: %v\n", utils.Yellow, utils.Reset, err)
+}
+
+// historyCmd builds the `history` subcommand for querying past scans
+func historyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Query results of past scans",
+		Long: `Query the result history recorded by every scan.
+
+Example:
+  piyazche history best -c config.json --days 7
+  piyazche history ip 104.16.1.2
+  piyazche history subnets --days 30
+
+Exit codes: 0 ok, 1 error, 2 bad flags or config, 3 no results.`,
+	}
+	cmd.PersistentFlags().IntVar(&historyDays, "days", 7, "Only look at the last N days (0 = all)")
+	cmd.PersistentFlags().IntVar(&historyLimit, "limit", 20, "Maximum rows to print (0 = all)")
+
+	best := &cobra.Command{
+		Use:   "best",
+		Short: "Best IPs for the current config",
+		Args:  usageArgs(cobra.NoArgs),
+		RunE:  runHistoryBest,
+	}
+	best.Flags().StringVarP(&configPath, "config", "c", "config.json", "Config whose results to show")
+	best.Flags().BoolVar(&historyAllFP, "all-configs", false, "Include results of every config")
+
+	ip := &cobra.Command{
+		Use:   "ip <address>",
+		Short: "Latency history of one IP",
+		Args:  usageArgs(cobra.ExactArgs(1)),
+		RunE:  runHistoryIP,
+	}
+
+	subnets := &cobra.Command{
+		Use:   "subnets",
+		Short: "Per-subnet pass rate across sessions",
+		Args:  usageArgs(cobra.NoArgs),
+		RunE:  runHistorySubnets,
+	}
+
+	cmd.AddCommand(best, ip, subnets)
+	return cmd
+}
+
+func historyFilter() history.Filter {
+	var f history.Filter
+	if historyDays > 0 {
+		f.Since = time.Now().AddDate(0, 0, -historyDays)
+	}
+	return f
+}
+
+func loadHistory() (*history.Store, error) {
+	store := openHistory()
+	if store == nil {
+		return nil, fmt.Errorf("result history is disabled or unreadable")
+	}
+	if store.Len() == 0 {
+		return nil, withExit(exitNoResults, fmt.Errorf("no scan history yet"))
+	}
+	return store, nil
+}
+
+func runHistoryBest(cmd *cobra.Command, args []string) error {
+	store, err := loadHistory()
+	if err != nil {
+		return err
+	}
+
+	f := historyFilter()
+	if !historyAllFP {
+		cfg, err := loadCLIConfig()
+		if err != nil {
+			return err
+		}
+		f.ConfigFP = cfg.Fingerprint()
+	}
+
+	best := store.Best(f, historyLimit)
+	if len(best) == 0 {
+		return withExit(exitNoResults, fmt.Errorf("no results for this config in the selected period"))
+	}
+
+	fmt.Printf("\n%s%s%-4s %-40s %-6s %8s %10s %8s %8s  %s%s\n",
+		utils.Bold, utils.Cyan, "#", "IP", "Phase", "Pass", "Latency", "Score", "Sessions", "Last seen", utils.Reset)
+	for i, r := range best {
+		fmt.Printf("%-4d %s%-40s%s %-6s %7.0f%% %8.0fms %8.1f %8d  %s\n",
+			i+1, utils.Cyan, r.IP, utils.Reset, r.Phase, r.PassRate, r.AvgLatencyMs, r.AvgScore, r.Sessions,
+			r.LastSeen.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+func runHistoryIP(cmd *cobra.Command, args []string) error {
+	store, err := loadHistory()
+	if err != nil {
+		return err
+	}
+
+	records := store.IPHistory(args[0], historyFilter())
+	if len(records) == 0 {
+		return withExit(exitNoResults, fmt.Errorf("no results for %s in the selected period", args[0]))
+	}
+	if historyLimit > 0 && len(records) > historyLimit {
+		records = records[len(records)-historyLimit:]
+	}
+
+	fmt.Printf("\n%s%s%-16s %-5s %-14s %-12s %10s %8s %7s  %s%s\n",
+		utils.Bold, utils.Cyan, "Time", "Phase", "Session", "Config", "Latency", "Jitter", "Loss", "Result", utils.Reset)
+	for _, r := range records {
+		result := utils.Green + "ok" + utils.Reset
+		if !r.Passed {
+			result = utils.Red + "fail" + utils.Reset
+			if r.Error != "" {
+				result += " " + utils.Gray + r.Error + utils.Reset
+			}
+		}
+		fmt.Printf("%-16s %-5s %-14s %-12s %8.0fms %6.0fms %6.0f%%  %s\n",
+			r.Time.Format("2006-01-02 15:04"), r.Kind, r.Session, r.ConfigFP, r.LatencyMs, r.JitterMs, r.LossPct, result)
+	}
+	return nil
+}
+
+func runHistorySubnets(cmd *cobra.Command, args []string) error {
+	store, err := loadHistory()
+	if err != nil {
+		return err
+	}
+
+	trends := store.SubnetTrends(historyFilter(), historyLimit)
+	if len(trends) == 0 {
+		return withExit(exitNoResults, fmt.Errorf("no results in the selected period"))
+	}
+
+	fmt.Printf("\n%s%s%-24s %8s %8s  %s%s\n", utils.Bold, utils.Cyan, "Subnet", "Tested", "Pass", "Per session (oldest → newest)", utils.Reset)
+	for _, t := range trends {
+		points := make([]string, 0, len(t.Points))
+		for _, p := range t.Points {
+			points = append(points, fmt.Sprintf("%.0f%%", p.PassRate))
+		}
+		fmt.Printf("%-24s %8d %7.0f%%  %s%s%s\n",
+			t.Subnet, t.Total, t.PassRate, utils.Gray, strings.Join(points, " → "), utils.Reset)
+	}
+	return nil
+}
@@ -1,17 +1,8 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
-
-	"piyazche/config"
-	"piyazche/optimizer"
-	"piyazche/scanner"
-	"piyazche/utils"
-	"piyazche/webui"
 
 	"github.com/spf13/cobra"
 )
@@ -40,6 +31,20 @@ var (
 	shodanPages  int
 	uiMode       bool
 	uiPort       int
+	uiBind       string
+	uiPassword   string
+	uiToken      string
+	uiTLS        bool
+	uiCert       string
+	uiKey        string
+	uiWorkers    int
+	resumePath   string
+	historyPath  string
+	historyKeep  int
+	adaptive     bool
+	batchSize    int
+	prefilter    bool
+	probeSpecs   []string
 )
 
 func main() {
@@ -49,11 +54,26 @@ func main() {
 		Long: `Piyazche tests Cloudflare IP addresses for connectivity
 using xray-core as a proxy or ICMP ping, measuring latency and sorting results.
 
+Every mode is a subcommand with its own flags, --json output and exit codes
+(0 ok, 1 error, 2 bad flags or config, 3 nothing passed, 130 interrupted).
+The flags of the root command are kept for existing scripts.
+
 Example:
-  piyazche -c config.json -s ipv4.txt -t 16
-  piyazche -s ipv4.txt --scan-mode icmp -t 32`,
-		RunE: run,
-	}
+  piyazche scan -c config.json -s ipv4.txt -t 16
+  piyazche icmp -s ipv4.txt -t 32
+  piyazche scan -c config.json --resume results/2024-01-01_120000_checkpoint.json`,
+		Args:         usageArgs(cobra.NoArgs),
+		RunE:         run,
+		SilenceUsage: true,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			if jsonMode {
+				startJSON()
+			}
+		},
+	}
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return withExit(exitUsage, err)
+	})
 
 	rootCmd.Flags().StringVarP(&configPath, "config", "c", "config.json", "Path to config file")
 	rootCmd.Flags().StringVarP(&subnetsPath, "subnets", "s", "ipv4.txt", "Path to subnets file or CIDR")
@@ -61,122 +81,97 @@ Example:
 	rootCmd.Flags().StringVarP(&outputFmt, "output", "o", "csv", "Output format: csv, json")
 	rootCmd.Flags().IntVar(&maxIPs, "max-ips", 0, "Maximum IPs to scan (default: all)")
 	rootCmd.Flags().BoolVar(&shuffle, "shuffle", true, "Shuffle IPs before scanning")
+	rootCmd.Flags().BoolVar(&adaptive, "adaptive", false, "Probe scan.sampleSize IPs per subnet, then spend --max-ips on the subnets that pass most")
+	rootCmd.Flags().IntVar(&batchSize, "batch-size", 0, "IPs each worker tests at once through one xray instance (overrides config)")
 	rootCmd.Flags().IntVar(&topN, "top", 10, "Number of top results to display")
 	rootCmd.Flags().BoolVar(&debug, "debug", false, "Print xray config JSON for first IP")
 	rootCmd.Flags().StringVar(&fragmentMode, "fragment-mode", "", "Fragment mode: manual, auto, off (overrides config)")
 	rootCmd.Flags().StringVar(&testIP, "test-ip", "", "IP address to use for fragment optimization tests (overrides config)")
-	rootCmd.Flags().BoolVar(&checkMode, "check", false, "Test single connection (required for reality mode, optional for tls mode)")
+	rootCmd.Flags().BoolVar(&checkMode, "check", false, "Test single connection (same as the check command)")
 	rootCmd.Flags().StringVar(&muxEnabled, "mux", "", "Enable mux: true, false (overrides config)")
-	rootCmd.Flags().StringVar(&scanMode, "scan-mode", "xray", "Scan mode: xray (proxy test) or icmp (ping only)")
+	rootCmd.Flags().StringVar(&scanMode, "scan-mode", "xray", "Scan mode: xray (the scan command) or icmp (the icmp command)")
 	rootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "Show version information")
-	rootCmd.Flags().StringVar(&shodanMode, "shodan-mode", "", "Shodan mode: off, harvest, scan, both (overrides config)")
+	rootCmd.Flags().StringVar(&shodanMode, "shodan-mode", "", "Shodan mode: off, harvest, scan, both (overrides config, see the harvest command)")
 	rootCmd.Flags().StringVar(&shodanKey, "shodan-key", "", "Shodan API key (overrides config)")
 	rootCmd.Flags().IntVar(&shodanPages, "shodan-pages", 0, "Shodan pages to fetch (overrides config)")
-	rootCmd.Flags().BoolVar(&uiMode, "ui", false, "Start Web UI server (24/7 mode)")
+	rootCmd.Flags().BoolVar(&uiMode, "ui", false, "Start Web UI server (same as the ui command)")
 	rootCmd.Flags().IntVar(&uiPort, "ui-port", 9090, "Web UI port (default: 9090)")
+	rootCmd.Flags().StringVar(&uiBind, "ui-bind", "0.0.0.0", "Web UI listen address, e.g. 127.0.0.1 for this machine only")
+	rootCmd.Flags().StringVar(&uiPassword, "ui-password", "", "Web UI login password (or env PIYAZCHE_UI_PASSWORD)")
+	rootCmd.Flags().StringVar(&uiToken, "ui-token", "", "Bearer token for Web UI API clients (or env PIYAZCHE_UI_TOKEN)")
+	rootCmd.Flags().BoolVar(&uiTLS, "ui-tls", false, "Serve the Web UI over HTTPS (self-signed unless --ui-cert/--ui-key are given)")
+	rootCmd.Flags().StringVar(&uiCert, "ui-cert", "", "TLS certificate file for the Web UI")
+	rootCmd.Flags().StringVar(&uiKey, "ui-key", "", "TLS private key file for the Web UI")
+	rootCmd.Flags().IntVar(&uiWorkers, "ui-workers", 0, "IPs the Web UI tests at once across all scan jobs (default 256)")
+	rootCmd.Flags().StringVar(&resumePath, "resume", "", "Resume an interrupted scan from its checkpoint file")
+	addJSONFlag(rootCmd)
+	rootCmd.PersistentFlags().StringVar(&historyPath, "history", "", "Result history database (default: piyazche_history.db), \"off\" to disable")
+	rootCmd.PersistentFlags().IntVar(&historyKeep, "history-keep", 90, "Drop history records older than N days (0 = keep all)")
+	rootCmd.AddCommand(scanCmd())
+	rootCmd.AddCommand(icmpCmd())
+	rootCmd.AddCommand(phase2Cmd())
+	rootCmd.AddCommand(phase3Cmd())
+	rootCmd.AddCommand(checkCmd())
+	rootCmd.AddCommand(optimizeFragmentCmd())
+	rootCmd.AddCommand(harvestCmd())
+	rootCmd.AddCommand(monitorCmd())
+	rootCmd.AddCommand(uiCmd())
+	rootCmd.AddCommand(exportCmd())
+	rootCmd.AddCommand(convertLinkCmd())
+	rootCmd.AddCommand(historyCmd())
+	rootCmd.AddCommand(serveCmd())
 
 	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+		code := exitCodeOf(err)
+		emit("error", map[string]interface{}{"code": code, "message": err.Error()})
+		os.Exit(code)
 	}
 }
 
+// run keeps the flag-driven root command working by handing off to the
+// subcommand that the flags select
 func run(cmd *cobra.Command, args []string) error {
 	if showVersion {
 		fmt.Printf("piyazche version %s (built: %s)\n", Version, BuildTime)
 		return nil
 	}
 
-	cfg, err := config.LoadConfig(configPath)
-	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
-	}
-
-	if threads > 0 {
-		cfg.Scan.Threads = threads
-	}
-
-	if fragmentMode != "" {
-		cfg.Fragment.Mode = fragmentMode
-	}
-
-	switch muxEnabled {
-	case "true":
-		cfg.Xray.Mux.Enabled = true
-		if cfg.Xray.Mux.Concurrency <= 0 {
-			cfg.Xray.Mux.Concurrency = 8
-		}
-	case "false":
-		cfg.Xray.Mux.Enabled = false
-		cfg.Xray.Mux.Concurrency = -1
-	}
-
 	// Web UI mode: سرور رو بالا بیار و منتظر بمون
 	if uiMode {
-		uiServer := webui.NewServer(uiPort)
-		fmt.Printf("\n%s%s▸ Web UI Mode%s\n", utils.Bold, utils.Cyan, utils.Reset)
-		fmt.Printf("  %sURL:%s http://localhost:%d\n", utils.Gray, utils.Reset, uiPort)
-		fmt.Printf("  %sCtrl+C برای خروج%s\n\n", utils.Dim, utils.Reset)
-		if err := uiServer.Start(); err != nil && err.Error() != "http: Server closed" {
-			return fmt.Errorf("web ui error: %w", err)
-		}
-		return nil
+		return runUI()
 	}
 
-	// Shodan overrides
-	if shodanMode != "" {
-		cfg.Shodan.Mode = shodanMode
-	}
-	if shodanKey != "" {
-		cfg.Shodan.APIKey = shodanKey
-	}
-	if shodanPages > 0 {
-		cfg.Shodan.Pages = shodanPages
+	cfg, err := loadCLIConfig()
+	if err != nil {
+		return err
 	}
 
-	isRealityMode := cfg.Proxy.Method == "reality"
-
-	if isRealityMode {
-		cfg.Fragment.Auto.TestIP = cfg.Proxy.Address
-	} else if testIP != "" {
-		cfg.Fragment.Auto.TestIP = testIP
+	applyShodanFlags(cfg)
+	if cfg.Shodan.Mode != "" && cfg.Shodan.Mode != "off" {
+		return runHarvest(cfg)
 	}
 
 	cfg.PrintConfigInfo()
 
-	if cfg.Fragment.Mode == "auto" {
-		fmt.Printf("%s%sAuto Fragment Mode%s - discovering optimal settings...\n\n",
-			utils.Bold, utils.Green, utils.Reset)
-		optimizedSettings, err := runFragmentOptimizer(cfg)
-		if err != nil {
-			fmt.Printf("%sWarning:%s fragment optimizer failed: %v\n", utils.Yellow, utils.Reset, err)
-			fmt.Printf("Falling back to default manual settings...\n")
-		} else if optimizedSettings != nil {
-			// Apply optimized settings from ZoneResult
-			cfg.Fragment.Mode = "manual"
-			cfg.Fragment.Packets = optimizedSettings.Zone
-			cfg.Fragment.Manual.Length = optimizedSettings.SizeRange.String()
-			cfg.Fragment.Manual.Interval = optimizedSettings.IntervalRange.String()
-			fmt.Printf("\n%s✓ Optimized Settings Applied%s\n", utils.Green, utils.Reset)
-			fmt.Printf("  %sLength:%s   %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, cfg.Fragment.Manual.Length, utils.Reset)
-			fmt.Printf("  %sInterval:%s %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, cfg.Fragment.Manual.Interval, utils.Reset)
-			fmt.Printf("  %sPackets:%s  %s%s%s\n\n", utils.Gray, utils.Reset, utils.Magenta, cfg.Fragment.Packets, utils.Reset)
-		}
+	autoFragment := cfg.Fragment.Mode == "auto"
+	if autoFragment {
+		applyAutoFragment(cfg)
 	}
 
-	if isRealityMode {
-		if !checkMode && cfg.Fragment.Mode != "auto" {
-			return fmt.Errorf("scanner mode is not available for reality. Use --check flag to test connection or --fragment-mode auto to optimize fragments")
+	if cfg.Proxy.Method == "reality" {
+		if !checkMode && !autoFragment {
+			return usageErrorf("scanner mode is not available for reality. Use --check flag to test connection or --fragment-mode auto to optimize fragments")
 		}
 
 		if checkMode {
-			return runCheckMode(cfg)
+			return runCheckMode(cfg, cfg.Proxy.Address)
 		}
 
 		return nil
 	}
 
 	if checkMode {
-		return runCheckMode(cfg)
+		return runCheckMode(cfg, cfg.Proxy.Address)
 	}
 
 	// ICMP mode - simple ping scan without xray
@@ -184,225 +179,5 @@ func run(cmd *cobra.Command, args []string) error {
 		return runICMPScan(cfg)
 	}
 
-	s := scanner.NewScannerWithDebug(cfg, debug)
-
-	if err := s.LoadIPs(subnetsPath, maxIPs, shuffle); err != nil {
-		return fmt.Errorf("failed to load IPs: %w", err)
-	}
-
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		<-sigChan
-		fmt.Println("\nReceived interrupt signal, stopping...")
-		s.Stop()
-	}()
-
-	if err := s.Run(); err != nil {
-		return fmt.Errorf("scan failed: %w", err)
-	}
-
-	s.GetResults().PrintTopResults(topN)
-
-	// Phase-2: deep stability test روی IP های موفق فاز اول
-	if s.GetResults().SuccessCount() > 0 && cfg.Scan.StabilityRounds > 0 {
-		phase2Results := scanner.RunPhase2(context.Background(), cfg, s.GetResults().GetSuccessful())
-
-		scanner.PrintPhase2Results(phase2Results, topN, cfg.Scan.SpeedTest, cfg.Scan.JitterTest)
-
-		p2Path := scanner.GeneratePhase2OutputPath(outputFmt)
-		if err := scanner.SavePhase2Results(phase2Results, outputFmt, p2Path); err != nil {
-			fmt.Fprintf(os.Stderr, "%sWarning:%s failed to save phase-2 results: %v\n", utils.Yellow, utils.Reset, err)
-		} else {
-			fmt.Printf("%sPhase-2 results saved to:%s %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, p2Path, utils.Reset)
-		}
-	}
-
-	if s.GetResults().SuccessCount() > 0 {
-		outputPath := scanner.GenerateOutputPath(outputFmt)
-		if err := s.SaveResults(outputFmt, outputPath); err != nil {
-			fmt.Fprintf(os.Stderr, "%sWarning:%s failed to save results: %v\n", utils.Yellow, utils.Reset, err)
-		} else {
-			fmt.Printf("%sResults saved to:%s %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, outputPath, utils.Reset)
-		}
-	}
-
-	return nil
-}
-
-// runCheckMode tests a single connection using the configured address
-func runCheckMode(cfg *config.Config) error {
-	targetIP := cfg.Proxy.Address
-	if targetIP == "" {
-		return fmt.Errorf("proxy.address is required for check mode")
-	}
-
-	fmt.Printf("%s%sConnection Check%s\n", utils.Bold, utils.Cyan, utils.Reset)
-	fmt.Printf("%s─────────────────────────────────────────%s\n", utils.Gray, utils.Reset)
-	fmt.Printf("  %sTarget:%s %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, targetIP, utils.Reset)
-	fmt.Printf("  %sPort:%s   %s%d%s\n", utils.Gray, utils.Reset, utils.Yellow, cfg.Proxy.Port, utils.Reset)
-	fmt.Printf("  %sMethod:%s %s%s%s\n\n", utils.Gray, utils.Reset, utils.Green, cfg.Proxy.Method, utils.Reset)
-
-	zone := cfg.Fragment.Packets
-	if zone == "" {
-		zone = "tlshello"
-	}
-
-	size := 10
-	interval := 10
-
-	if cfg.Fragment.Mode == "manual" || cfg.Fragment.Mode == "" {
-		var minLen int
-		fmt.Sscanf(cfg.Fragment.Manual.Length, "%d", &minLen)
-		if minLen > 0 {
-			size = minLen
-		}
-
-		var minInt int
-		fmt.Sscanf(cfg.Fragment.Manual.Interval, "%d", &minInt)
-		if minInt > 0 {
-			interval = minInt
-		}
-	}
-
-	fmt.Printf("  %sFragment:%s Size=%s%d%s, Interval=%s%d%sms, Packets=%s%s%s\n\n",
-		utils.Gray, utils.Reset,
-		utils.Green, size, utils.Reset,
-		utils.Green, interval, utils.Reset,
-		utils.Yellow, zone, utils.Reset)
-
-	tester := optimizer.NewFragmentTester(cfg, targetIP)
-	tester.WithDebug(debug)
-
-	fmt.Printf("Testing connection...")
-
-	result := tester.TestSingle(zone, size, interval)
-
-	if result.Success {
-		fmt.Printf("\r%s✓ Connection successful%s\n", utils.Green, utils.Reset)
-		fmt.Printf("  %sLatency:%s %s%dms%s\n", utils.Gray, utils.Reset, utils.Yellow, result.Latency.Milliseconds(), utils.Reset)
-	} else {
-		fmt.Printf("\r%s✗ Connection failed%s\n", utils.Red, utils.Reset)
-		if result.Error != "" {
-			fmt.Printf("  %sError:%s %s\n", utils.Gray, utils.Reset, result.Error)
-		}
-	}
-
-	return nil
-}
-
-// runFragmentOptimizer runs the fragment optimizer to find optimal settings
-func runFragmentOptimizer(cfg *config.Config) (*optimizer.ZoneResult, error) {
-	var testIP string
-
-	if cfg.Fragment.Auto.TestIP != "" {
-		testIP = cfg.Fragment.Auto.TestIP
-	} else {
-		var testIPs []string
-		var err error
-
-		if _, statErr := os.Stat(subnetsPath); statErr == nil {
-			testIPs, err = utils.ParseIPsFromFile(subnetsPath, 5, true)
-		} else {
-			testIPs, err = utils.ParseCIDRList(subnetsPath, 5)
-			utils.ShuffleIPs(testIPs)
-		}
-
-		if err != nil {
-			return nil, fmt.Errorf("failed to load IPs for optimization: %w", err)
-		}
-
-		if len(testIPs) == 0 {
-			return nil, fmt.Errorf("no IPs available for optimization")
-		}
-
-		testIP = testIPs[0]
-	}
-
-	fmt.Printf("Testing fragment settings using IP: %s\n\n", testIP)
-
-	tester := optimizer.NewFragmentTester(cfg, testIP)
-	tester.WithDebug(debug)
-
-	finderConfig := optimizer.FinderConfig{
-		MaxTriesPerZone:   cfg.Fragment.Auto.MaxTests,
-		SuccessThreshold:  cfg.Fragment.Auto.SuccessThreshold,
-		MinRangeWidth:     5,
-		EnableCorrelation: true,
-	}
-
-	if finderConfig.MaxTriesPerZone <= 0 {
-		finderConfig.MaxTriesPerZone = 20
-	}
-	if finderConfig.SuccessThreshold <= 0 {
-		finderConfig.SuccessThreshold = 0.5
-	}
-
-	opt := optimizer.NewOptimizer(finderConfig, tester.CreateTesterFunc())
-
-	sizeRange := optimizer.Range{
-		Min: cfg.Fragment.Auto.LengthRange.Min,
-		Max: cfg.Fragment.Auto.LengthRange.Max,
-	}
-	intervalRange := optimizer.Range{
-		Min: cfg.Fragment.Auto.IntervalRange.Min,
-		Max: cfg.Fragment.Auto.IntervalRange.Max,
-	}
-
-	if !sizeRange.IsValid() {
-		sizeRange = optimizer.Range{Min: 10, Max: 60}
-	}
-	if !intervalRange.IsValid() {
-		intervalRange = optimizer.Range{Min: 10, Max: 32}
-	}
-
-	results, err := opt.FindOptimalRanges(context.Background(), sizeRange, intervalRange)
-	if err != nil {
-		return nil, err
-	}
-
-	optimizer.PrintSummary(results)
-
-	best := optimizer.GetBestResult(results)
-	if best == nil {
-		return nil, fmt.Errorf("no working fragment settings found")
-	}
-
-	return best, nil
-}
-
-// runICMPScan runs ICMP ping scan without xray-core
-func runICMPScan(cfg *config.Config) error {
-	s := scanner.NewICMPScanner(cfg)
-
-	if err := s.LoadIPs(subnetsPath, maxIPs, shuffle); err != nil {
-		return fmt.Errorf("failed to load IPs: %w", err)
-	}
-
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		<-sigChan
-		fmt.Println("\nReceived interrupt signal, stopping...")
-		s.Stop()
-	}()
-
-	if err := s.Run(); err != nil {
-		return fmt.Errorf("ICMP scan failed: %w", err)
-	}
-
-	s.GetResults().PrintTopResults(topN)
-
-	if s.GetResults().SuccessCount() > 0 {
-		outputPath := scanner.GenerateOutputPath(outputFmt)
-		if err := s.SaveResults(outputFmt, outputPath); err != nil {
-			fmt.Fprintf(os.Stderr, "%sWarning:%s failed to save results: %v\n", utils.Yellow, utils.Reset, err)
-		} else {
-			fmt.Printf("%sResults saved to:%s %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, outputPath, utils.Reset)
-		}
-	}
-
-	return nil
+	return runScan(cfg)
 }
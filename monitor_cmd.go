@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"piyazche/config"
+	"piyazche/scanner"
+	"piyazche/utils"
+	"piyazche/xray"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	monitorInterval time.Duration
+	monitorOnce     bool
+	monitorWorkers  int
+)
+
+// monitorCheck is one IP's result in a monitor round
+type monitorCheck struct {
+	ip      string
+	success bool
+	latency time.Duration
+	err     string
+}
+
+// monitorCmd builds the `monitor` subcommand: periodic checks of the best IPs
+func monitorCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "monitor",
+		Short: "Check the best IPs through the proxy every interval",
+		Long: `Test each IP through xray against scan.testUrl every --interval and print
+which ones are alive, with latency and uptime since the start. Unlike the Web UI
+health monitor nothing is saved and no IPs are replaced.
+
+IPs come from --ips, or --from (see piyazche serve --help).
+
+Exit codes: 0 ok (with --once: some IP alive), 1 error, 2 bad flags or config,
+3 no IPs to check (with --once: none alive).
+
+Example:
+  piyazche monitor -c config.json --interval 10m
+  piyazche monitor -c config.json --from session --top 5 --once --json`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: runMonitor,
+	}
+	addConfigFlag(cmd)
+	addTargetFlags(cmd)
+	cmd.Flags().IntVar(&topN, "top", 10, "Number of IPs to check")
+	cmd.Flags().DurationVar(&monitorInterval, "interval", 5*time.Minute, "Time between rounds")
+	cmd.Flags().BoolVar(&monitorOnce, "once", false, "Run one round and exit")
+	cmd.Flags().IntVar(&monitorWorkers, "workers", 4, "IPs checked at once")
+	cmd.Flags().StringVar(&muxEnabled, "mux", "", "Enable mux: true, false (overrides config)")
+	addJSONFlag(cmd)
+	return cmd
+}
+
+func runMonitor(cmd *cobra.Command, args []string) error {
+	cfg, err := loadCLIConfig()
+	if err != nil {
+		return err
+	}
+	if monitorInterval < 10*time.Second {
+		return usageErrorf("--interval must be at least 10s")
+	}
+	if monitorWorkers < 1 {
+		monitorWorkers = 1
+	}
+	ips, source, err := targetIPs(cfg, topN)
+	if err != nil {
+		return err
+	}
+	cfg.Xray.LogLevel = "none"
+
+	fmt.Printf("\n%s%s▸ Monitoring %d IPs%s %s(%s)%s\n", utils.Bold, utils.Cyan, len(ips), utils.Reset, utils.Gray, source, utils.Reset)
+	if !monitorOnce {
+		fmt.Printf("  %severy %s, Ctrl+C to stop%s\n\n", utils.Dim, monitorInterval, utils.Reset)
+	}
+	emit("start", map[string]interface{}{"command": "monitor", "ips": ips, "source": source})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	onInterrupt(cancel)
+
+	passes := make(map[string]int, len(ips))
+	for round := 1; ; round++ {
+		checks := checkIPs(ctx, cfg, ips)
+		if ctx.Err() != nil {
+			return withExit(exitInterrupted, errors.New("monitor stopped"))
+		}
+		alive := printMonitorRound(round, checks, passes)
+		if monitorOnce {
+			if alive == 0 {
+				return withExit(exitNoResults, errors.New("no IP is alive"))
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return withExit(exitInterrupted, errors.New("monitor stopped"))
+		case <-time.After(monitorInterval):
+		}
+	}
+}
+
+// checkIPs tests every IP once, monitorWorkers at a time, each worker
+// reusing one xray instance
+func checkIPs(ctx context.Context, cfg *config.Config, ips []string) []monitorCheck {
+	testURL := cfg.Scan.TestURL
+	if testURL == "" {
+		testURL = "https://www.gstatic.com/generate_204"
+	}
+
+	checks := make([]monitorCheck, len(ips))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < monitorWorkers && w < len(ips); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tester := scanner.NewTester(cfg, 9*time.Second)
+			defer tester.Close()
+			for i := range jobs {
+				checks[i] = checkIP(ctx, tester, ips[i], testURL)
+			}
+		}()
+	}
+	for i := range ips {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return checks
+}
+
+func checkIP(ctx context.Context, tester *scanner.Tester, ip, testURL string) monitorCheck {
+	c := monitorCheck{ip: ip}
+	startCtx, startCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer startCancel()
+	port, err := tester.Target(startCtx, ip)
+	if err != nil {
+		c.err = err.Error()
+		return c
+	}
+
+	testCtx, testCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer testCancel()
+	result := xray.TestConnectivityWithContext(testCtx, port, testURL, 9*time.Second)
+	c.success, c.latency = result.Success, result.Latency
+	if result.Error != nil {
+		c.err = result.Error.Error()
+	}
+	return c
+}
+
+// printMonitorRound prints and emits one round, adds its passes and returns
+// how many IPs were alive
+func printMonitorRound(round int, checks []monitorCheck, passes map[string]int) int {
+	sort.SliceStable(checks, func(i, j int) bool {
+		if checks[i].success != checks[j].success {
+			return checks[i].success
+		}
+		return checks[i].latency < checks[j].latency
+	})
+
+	alive := 0
+	for _, c := range checks {
+		if c.success {
+			alive++
+			passes[c.ip]++
+		}
+	}
+	fmt.Printf("%s%s%s  round %d  %d/%d alive\n", utils.Gray, time.Now().Format("15:04:05"), utils.Reset, round, alive, len(checks))
+	for _, c := range checks {
+		uptime := float64(passes[c.ip]) / float64(round) * 100
+		f := map[string]interface{}{"round": round, "ip": c.ip, "success": c.success, "uptime_pct": uptime}
+		if c.success {
+			fmt.Printf("  %s✓%s %-40s %s%dms%s  %s%.0f%% up%s\n", utils.Green, utils.Reset, c.ip, utils.Yellow, c.latency.Milliseconds(), utils.Reset, utils.Gray, uptime, utils.Reset)
+			f["latency_ms"] = c.latency.Milliseconds()
+		} else {
+			fmt.Printf("  %s✗%s %-40s %s%s%s\n", utils.Red, utils.Reset, c.ip, utils.Gray, c.err, utils.Reset)
+			f["error"] = c.err
+		}
+		emit("check", f)
+	}
+	emit("round", map[string]interface{}{"round": round, "alive": alive, "total": len(checks)})
+	return alive
+}
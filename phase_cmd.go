@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"piyazche/config"
+	"piyazche/scanner"
+	"piyazche/utils"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	phaseInput    string
+	phaseRounds   int
+	phaseInterval int
+	phaseJitter   bool
+	phaseSpeed    bool
+	phaseMinDL    float64
+	phaseMaxLoss  float64
+	phaseMaxTTFB  int
+	phaseSustain  int
+	phase3URL     string
+	scanPhase3    bool
+)
+
+const defaultDownloadURL = "https://speed.cloudflare.com/__down?bytes=5000000"
+
+// phase2Cmd builds the `phase2` subcommand: the stability test on given IPs
+func phase2Cmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "phase2",
+		Short: "Run the phase-2 stability test on a list of IPs",
+		Long: `Run scan.stabilityRounds rounds of latency and packet loss tests (and
+jitter or download speed when enabled) on IPs that already passed phase 1, then
+apply the scan.maxPacketLossPct and scan.minDownloadMbps filters.
+
+IPs come from --input (a phase-1 results CSV/JSON or a list of IPs and CIDRs),
+--ips, or --from (see piyazche serve --help).
+
+Exit codes: 0 ok, 1 error, 2 bad flags or config, 3 no IP passed, 130 interrupted.
+
+Example:
+  piyazche phase2 -c config.json --input results/2024-01-01_120000_results.csv
+  piyazche phase2 -c config.json --from session --rounds 5 --jitter --json
+  piyazche phase2 -c config.json --ips 104.16.1.2 --sustained 20`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCLIConfig()
+			if err != nil {
+				return err
+			}
+			applyPhaseFlags(cmd, cfg)
+			if cfg.Scan.StabilityRounds <= 0 {
+				cfg.Scan.StabilityRounds = 3
+			}
+			return runPhaseCommand(cfg, "phase2")
+		},
+	}
+	addPhaseInputFlags(cmd)
+	cmd.Flags().IntVar(&phaseRounds, "rounds", 0, "Stability rounds (overrides config, default 3 when the config has none)")
+	cmd.Flags().IntVar(&phaseInterval, "interval", 0, "Seconds between rounds (overrides config)")
+	cmd.Flags().BoolVar(&phaseJitter, "jitter", false, "Measure latency jitter")
+	cmd.Flags().BoolVar(&phaseSpeed, "speed", false, "Measure download speed in every round")
+	addSustainedFlag(cmd)
+	addPhaseFilterFlags(cmd)
+	addJSONFlag(cmd)
+	return cmd
+}
+
+// phase3Cmd builds the `phase3` subcommand: download speed tests on given IPs
+func phase3Cmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "phase3",
+		Short: "Run download speed tests on a list of IPs",
+		Long: `Download phase3.downloadUrl (default 5MB from speed.cloudflare.com) once
+through each IP and apply the scan.minDownloadMbps (or phase3.minDlMbps) and
+scan.maxPacketLossPct filters.
+
+IPs come from --input (a results CSV/JSON or a list of IPs and CIDRs), --ips,
+or --from (see piyazche serve --help).
+
+Exit codes: 0 ok, 1 error, 2 bad flags or config, 3 no IP passed, 130 interrupted.
+
+Example:
+  piyazche phase3 -c config.json --input results/2024-01-01_120000_phase2.csv --min-dl 10
+  piyazche phase3 -c config.json --ips 104.16.1.2,104.17.3.4 --json`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCLIConfig()
+			if err != nil {
+				return err
+			}
+			applyPhaseFlags(cmd, cfg)
+			return runPhaseCommand(speedTestConfig(cfg), "phase3")
+		},
+	}
+	addPhaseInputFlags(cmd)
+	cmd.Flags().StringVar(&phase3URL, "download-url", "", "URL to download (overrides phase3.downloadUrl)")
+	addSustainedFlag(cmd)
+	addPhaseFilterFlags(cmd)
+	addJSONFlag(cmd)
+	return cmd
+}
+
+func addPhaseInputFlags(cmd *cobra.Command) {
+	addConfigFlag(cmd)
+	addTargetFlags(cmd)
+	cmd.Flags().StringVarP(&phaseInput, "input", "i", "", "Results file (CSV/JSON, passed rows only) or list of IPs and CIDRs to test")
+	cmd.Flags().IntVar(&maxIPs, "max-ips", 0, "Maximum IPs to test (default: all)")
+	cmd.Flags().StringVarP(&outputFmt, "output", "o", "csv", "Output format: csv, json")
+	cmd.Flags().IntVar(&topN, "top", 10, "Number of top results to display")
+	cmd.Flags().StringVar(&muxEnabled, "mux", "", "Enable mux: true, false (overrides config)")
+}
+
+func addPhaseFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().Float64Var(&phaseMinDL, "min-dl", 0, "Fail IPs downloading slower than this many Mbps (overrides config)")
+	cmd.Flags().Float64Var(&phaseMaxLoss, "max-loss", -1, "Fail IPs losing more than this percent of pings, -1 = off (overrides config)")
+	cmd.Flags().IntVar(&phaseMaxTTFB, "max-ttfb", 0, "Fail IPs whose first response byte takes longer than this many ms after the request (overrides config)")
+}
+
+func addSustainedFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&phaseSustain, "sustained", 0, "Download for this many seconds per IP and lower the score of IPs that get throttled, 0 = off (overrides scan.sustained)")
+}
+
+// applyPhaseFlags copies the phase flags the user set into cfg
+func applyPhaseFlags(cmd *cobra.Command, cfg *config.Config) {
+	set := cmd.Flags().Changed
+	if set("rounds") {
+		cfg.Scan.StabilityRounds = phaseRounds
+	}
+	if set("interval") {
+		cfg.Scan.StabilityInterval = phaseInterval
+	}
+	if set("jitter") {
+		cfg.Scan.JitterTest = phaseJitter
+	}
+	if set("speed") {
+		cfg.Scan.SpeedTest = phaseSpeed
+		if phaseSpeed {
+			cfg.Scan.BandwidthMode = config.BandwidthSpeedTest
+			if cfg.Scan.DownloadURL == "" {
+				cfg.Scan.DownloadURL = defaultDownloadURL
+			}
+		}
+	}
+	if set("min-dl") {
+		cfg.Scan.MinDownloadMbps = phaseMinDL
+	}
+	if set("max-loss") {
+		cfg.Scan.MaxPacketLossPct = phaseMaxLoss
+	}
+	if set("sustained") {
+		cfg.Scan.Sustained.Enabled = phaseSustain > 0
+		cfg.Scan.Sustained.WindowSec = phaseSustain
+	}
+	if set("max-ttfb") {
+		cfg.Scan.MaxTTFBMs = phaseMaxTTFB
+	}
+}
+
+// speedTestConfig the phase-3 variant of cfg: one round with a download test
+func speedTestConfig(cfg *config.Config) *config.Config {
+	c := *cfg
+	c.Scan.SpeedTest = true
+	c.Scan.BandwidthMode = config.BandwidthSpeedTest
+	switch {
+	case phase3URL != "":
+		c.Scan.DownloadURL = phase3URL
+	case cfg.Phase3.DownloadURL != "":
+		c.Scan.DownloadURL = cfg.Phase3.DownloadURL
+	case c.Scan.DownloadURL == "":
+		c.Scan.DownloadURL = defaultDownloadURL
+	}
+	if c.Scan.MinDownloadMbps <= 0 && cfg.Phase3.MinDLMbps > 0 {
+		c.Scan.MinDownloadMbps = cfg.Phase3.MinDLMbps
+	}
+	c.Scan.StabilityRounds = 1 // یه دور کافیه
+	c.Scan.PacketLossCount = 1 // phase3 فقط سرعت مهمه، packet loss نه
+	return &c
+}
+
+// runPhaseCommand runs the phase2 or phase3 subcommand on its input IPs
+func runPhaseCommand(cfg *config.Config, phase string) error {
+	var ips []string
+	var err error
+	source := phaseInput
+	if phaseInput != "" {
+		ips, err = loadPhaseInput(phaseInput)
+		if err == nil && maxIPs > 0 && len(ips) > maxIPs {
+			ips = ips[:maxIPs]
+		}
+	} else {
+		ips, source, err = targetIPs(cfg, maxIPs)
+	}
+	if err != nil {
+		return err
+	}
+	if len(ips) == 0 {
+		return withExit(exitNoResults, fmt.Errorf("no IPs in %s", source))
+	}
+	cfg.PrintConfigInfo()
+	fmt.Printf("%sTesting %d IPs from%s %s\n", utils.Gray, len(ips), utils.Reset, source)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	onInterrupt(cancel)
+
+	session := fmt.Sprintf("%d", time.Now().Unix())
+	results, path := runPhaseTests(ctx, cfg, phase, ips, session)
+
+	passed := 0
+	for _, r := range results {
+		if r.Passed {
+			passed++
+		}
+	}
+	done := map[string]interface{}{"tested": len(results), "passed": passed, "session": session, "results": phaseFields(results, topN)}
+	if path != "" {
+		done["output"] = path
+	}
+	emit("done", done)
+
+	if ctx.Err() != nil {
+		return withExit(exitInterrupted, errors.New(phase+" interrupted"))
+	}
+	if passed == 0 {
+		return withExit(exitNoResults, errors.New("no IP passed"))
+	}
+	return nil
+}
+
+// runPhaseTests runs phase 2, or phase 3 with a speedTestConfig, on ips,
+// records every result in the history, prints and saves them; it returns the
+// results and the file they were saved to
+func runPhaseTests(ctx context.Context, cfg *config.Config, phase string, ips []string, session string) ([]scanner.Phase2Result, string) {
+	candidates := make([]scanner.Result, len(ips))
+	for i, ip := range ips {
+		candidates[i] = scanner.Result{IP: ip, Success: true}
+	}
+	if phase == "phase3" {
+		fmt.Printf("\n%s%s▸ Phase 3: Speed Test%s  %s%s%s\n", utils.Bold, utils.Cyan, utils.Reset, utils.Gray, cfg.Scan.DownloadURL, utils.Reset)
+	}
+
+	hist := openHistory()
+	fp := cfg.Fingerprint()
+	emit(phase+"_start", map[string]interface{}{"count": len(ips)})
+	results := scanner.RunPhase2WithCallback(ctx, cfg, candidates, func(r scanner.Phase2Result) {
+		if ctx.Err() != nil {
+			return
+		}
+		emit(phase+"_result", phase2Fields(r))
+		if hist != nil {
+			if err := hist.AddPhase2(session, fp, []scanner.Phase2Result{r}); err != nil {
+				warnHistory(err)
+			}
+		}
+	})
+
+	scanner.PrintPhase2Results(results, topN, cfg.Scan.SpeedTest, cfg.Scan.JitterTest)
+	if len(results) == 0 {
+		return nil, ""
+	}
+
+	label, path := "Phase-2", scanner.GeneratePhase2OutputPath(outputFmt)
+	if phase == "phase3" {
+		label, path = "Phase-3", scanner.GeneratePhase3OutputPath(outputFmt)
+	}
+	if err := scanner.SavePhase2Results(results, outputFmt, path); err != nil {
+		fmt.Fprintf(os.Stderr, "%sWarning:%s failed to save %s results: %v\n", utils.Yellow, utils.Reset, phase, err)
+		return results, ""
+	}
+	fmt.Printf("%s%s results saved to:%s %s%s%s\n", utils.Gray, label, utils.Reset, utils.Cyan, path, utils.Reset)
+	return results, path
+}
+
+// phaseFields the first n results that passed, all of them when n <= 0
+func phaseFields(results []scanner.Phase2Result, n int) []map[string]interface{} {
+	out := []map[string]interface{}{}
+	for _, r := range results {
+		if r.Passed && (n <= 0 || len(out) < n) {
+			out = append(out, phase2Fields(r))
+		}
+	}
+	return out
+}
+
+// loadPhaseInput reads the IPs to test: the passed rows of a results CSV or
+// JSON file (phase 1 or phase 2), or else a list of IPs and CIDRs
+func loadPhaseInput(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, withExit(exitUsage, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		// field names match case-insensitively: ip/IP, success, Passed
+		var rows []struct {
+			IP      string
+			Success *bool
+			Passed  *bool
+		}
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, withExit(exitUsage, fmt.Errorf("failed to parse %s: %w", path, err))
+		}
+		var ips []string
+		for _, r := range rows {
+			if r.IP != "" && (r.Success == nil || *r.Success) && (r.Passed == nil || *r.Passed) {
+				ips = append(ips, r.IP)
+			}
+		}
+		return ips, nil
+
+	case ".csv":
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil || len(records) == 0 {
+			return nil, withExit(exitUsage, fmt.Errorf("failed to parse %s: %v", path, err))
+		}
+		ipCol, statusCol, passedCol := -1, -1, -1
+		for i, h := range records[0] {
+			switch strings.ToLower(h) {
+			case "ip":
+				ipCol = i
+			case "status":
+				statusCol = i
+			case "passed":
+				passedCol = i
+			}
+		}
+		if ipCol < 0 {
+			return nil, usageErrorf("%s has no ip column", path)
+		}
+		var ips []string
+		for _, row := range records[1:] {
+			if ipCol >= len(row) {
+				continue
+			}
+			if statusCol >= 0 && statusCol < len(row) && row[statusCol] != "success" {
+				continue
+			}
+			if passedCol >= 0 && passedCol < len(row) && row[passedCol] != "true" {
+				continue
+			}
+			ips = append(ips, row[ipCol])
+		}
+		return ips, nil
+	}
+
+	src, err := scanner.OpenIPSource(path, utils.SourceOptions{})
+	if err != nil {
+		return nil, withExit(exitUsage, err)
+	}
+	var ips []string
+	for ip, ok := src.Next(); ok; ip, ok = src.Next() {
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"piyazche/config"
+	"piyazche/scanner"
+	"piyazche/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// scanCmd builds the `scan` subcommand: the xray scan followed by phase 2
+func scanCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "scan",
+		Short: "Test IPs through xray and rank them",
+		Long: `Test every IP of --subnets through xray with the proxy of --config, then
+run the phase-2 stability test on the IPs that passed (scan.stabilityRounds or
+--rounds) and, with --phase3, a download speed test on the --top best of them.
+Progress is saved to a checkpoint so an interrupted scan continues with --resume.
+
+Exit codes: 0 ok, 1 error, 2 bad flags or config, 3 no IP passed, 130 interrupted.
+
+Example:
+  piyazche scan -c config.json -s ipv4.txt -t 16
+  piyazche scan -c config.json --adaptive --prefilter --max-ips 2000 --json
+  piyazche scan -c config.json --rounds 3 --phase3 --min-dl 10 --top 5
+  piyazche scan -c config.json --probe 'ws:wss://echo.example.com/' --probe 'download:https://speed.cloudflare.com/__down?bytes=2000000;kb=1024'
+  piyazche scan -c config.json --resume results/2024-01-01_120000_checkpoint.json`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadCLIConfig()
+			if err != nil {
+				return err
+			}
+			if cfg.Proxy.Method == "reality" {
+				return usageErrorf("scanning is not available for reality, test the server with `piyazche check` or tune fragments with `piyazche optimize-fragment`")
+			}
+			applyPhaseFlags(cmd, cfg)
+			cfg.PrintConfigInfo()
+			if cfg.Fragment.Mode == "auto" && resumePath == "" {
+				applyAutoFragment(cfg)
+			}
+			return runScan(cfg)
+		},
+	}
+	addConfigFlag(cmd)
+	addSourceFlags(cmd)
+	addXrayFlags(cmd)
+	cmd.Flags().BoolVar(&adaptive, "adaptive", false, "Probe scan.sampleSize IPs per subnet, then spend --max-ips on the subnets that pass most")
+	cmd.Flags().IntVar(&batchSize, "batch-size", 0, "IPs each worker tests at once through one xray instance (overrides config)")
+	cmd.Flags().BoolVar(&prefilter, "prefilter", false, "Drop IPs that fail a direct TCP + TLS hello before testing them through xray")
+	cmd.Flags().StringArrayVar(&probeSpecs, "probe", nil, "Probe every IP must pass instead of a GET to scan.testUrl, as type:target[;key=value]; repeat for a probe plan (replaces scan.probes)")
+	cmd.Flags().StringVar(&testIP, "test-ip", "", "IP address to use for fragment optimization tests (overrides config)")
+	cmd.Flags().StringVar(&resumePath, "resume", "", "Resume an interrupted scan from its checkpoint file, with the config it was started with")
+	cmd.Flags().IntVar(&phaseRounds, "rounds", 0, "Phase-2 stability rounds, 0 = skip phase 2 (overrides config)")
+	cmd.Flags().BoolVar(&scanPhase3, "phase3", false, "Speed test the --top best IPs after phase 2")
+	cmd.Flags().StringVar(&phase3URL, "download-url", "", "URL the phase-3 speed test downloads (overrides phase3.downloadUrl)")
+	addSustainedFlag(cmd)
+	addPhaseFilterFlags(cmd)
+	addJSONFlag(cmd)
+	return cmd
+}
+
+// icmpCmd builds the `icmp` subcommand: a ping scan without xray
+func icmpCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "icmp",
+		Short: "Ping IPs without xray",
+		Long: `Ping every IP of --subnets. Needs root for real ICMP and falls back to a
+TCP connect without it. --config is optional and only supplies scan settings.
+
+Exit codes: 0 ok, 1 error, 2 bad flags or config, 3 no IP answered.
+
+Example:
+  sudo piyazche icmp -s ipv4.txt -t 64
+  piyazche icmp -s 104.16.0.0/16 --max-ips 500 --json`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := config.DefaultConfig()
+			if cmd.Flags().Changed("config") {
+				var err error
+				if cfg, err = loadCLIConfig(); err != nil {
+					return err
+				}
+			} else if threads > 0 {
+				cfg.Scan.Threads = threads
+			}
+			return runICMPScan(cfg)
+		},
+	}
+	addConfigFlag(cmd)
+	addSourceFlags(cmd)
+	addJSONFlag(cmd)
+	return cmd
+}
+
+// applyAutoFragment runs the fragment optimizer and switches cfg to the
+// settings it found, keeping the config's settings when it finds none
+func applyAutoFragment(cfg *config.Config) {
+	fmt.Printf("%s%sAuto Fragment Mode%s - discovering optimal settings...\n\n",
+		utils.Bold, utils.Green, utils.Reset)
+	optimizedSettings, err := runFragmentOptimizer(cfg)
+	if err != nil {
+		fmt.Printf("%sWarning:%s fragment optimizer failed: %v\n", utils.Yellow, utils.Reset, err)
+		fmt.Printf("Falling back to default manual settings...\n")
+		return
+	}
+	// Apply optimized settings from ZoneResult
+	cfg.Fragment.Mode = "manual"
+	cfg.Fragment.Packets = optimizedSettings.Zone
+	cfg.Fragment.Manual.Length = optimizedSettings.SizeRange.String()
+	cfg.Fragment.Manual.Interval = optimizedSettings.IntervalRange.String()
+	fmt.Printf("\n%s✓ Optimized Settings Applied%s\n", utils.Green, utils.Reset)
+	fmt.Printf("  %sLength:%s   %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, cfg.Fragment.Manual.Length, utils.Reset)
+	fmt.Printf("  %sInterval:%s %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, cfg.Fragment.Manual.Interval, utils.Reset)
+	fmt.Printf("  %sPackets:%s  %s%s%s\n\n", utils.Gray, utils.Reset, utils.Magenta, cfg.Fragment.Packets, utils.Reset)
+	emit("fragment", fragmentFields(optimizedSettings))
+}
+
+// checkResumeConfig rejects resuming a checkpoint with a different proxy
+// config, so one session never mixes results of two configs. An auto fragment
+// config reuses the fragment settings the interrupted scan found.
+func checkResumeConfig(cp *scanner.Checkpoint, cfg *config.Config) error {
+	if len(cp.Config) == 0 {
+		return nil // checkpoint of an older version
+	}
+	saved := config.DefaultConfig()
+	if err := json.Unmarshal(cp.Config, saved); err != nil {
+		return withExit(exitUsage, fmt.Errorf("checkpoint config error: %w", err))
+	}
+	if cfg.Fragment.Mode == "auto" {
+		cfg.Fragment = saved.Fragment
+	}
+	if saved.Fingerprint() != cfg.Fingerprint() {
+		return usageErrorf("%s was started with a different proxy config (%s), resume it with the same --config", resumePath, saved.Fingerprint())
+	}
+	return nil
+}
+
+// runScan runs the xray scan and phase 2 with a checkpoint
+func runScan(cfg *config.Config) error {
+	s := scanner.NewScannerWithDebug(cfg, debug)
+
+	// Checkpoint: پیشرفت اسکن مدام روی دیسک ذخیره میشه تا با --resume ادامه پیدا کنه
+	var cp *scanner.Checkpoint
+	var err error
+	cpPath := resumePath
+	if resumePath != "" {
+		if cp, err = scanner.LoadCheckpoint(resumePath); err != nil {
+			return withExit(exitUsage, err)
+		}
+		if err := checkResumeConfig(cp, cfg); err != nil {
+			return err
+		}
+		fmt.Printf("%sResuming scan:%s %d IPs already tested\n", utils.Gray, utils.Reset, len(cp.Results))
+	} else {
+		cp = scanner.NewCheckpoint(subnetsPath, scanner.SourcePath, cfg.Scan.SampleSize, shuffle, maxIPs)
+		cp.Adaptive = cfg.Scan.Adaptive
+		cp.Config, _ = json.Marshal(cfg)
+		cpPath = scanner.GenerateCheckpointPath()
+	}
+	if err := s.UseCheckpoint(cp, cpPath); err != nil {
+		return withExit(exitUsage, fmt.Errorf("failed to load IPs: %w", err))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	onInterrupt(func() {
+		s.Stop()
+		cancel()
+	})
+
+	hist := openHistory()
+	session, fp := cp.SessionID(), cfg.Fingerprint()
+
+	emitProgress(s.GetResults(), "scan", s.IPCount(), len(cp.Results))
+	rec := hist.Record(s.GetResults(), session, fp, warnHistory)
+	if !cp.Phase1Done {
+		if err := s.Run(); err != nil {
+			rec.Close()
+			return fmt.Errorf("scan failed: %w", err)
+		}
+	}
+	rec.Close()
+
+	s.GetResults().PrintTopResults(topN)
+
+	passed := s.GetResults().SuccessCount()
+	done := map[string]interface{}{
+		"tested":  s.GetResults().Count(),
+		"passed":  passed,
+		"session": session,
+		"results": topResultFields(s.GetResults(), topN),
+	}
+	if failures := s.GetResults().FailureCounts(); len(failures) > 0 {
+		done["failures"] = failures
+	}
+	if st, ok := s.PrefilterStats(); ok {
+		done["prefilter"] = st
+	}
+
+	// Phase-2: deep stability test روی IP های موفق فاز اول
+	var phase2Results []scanner.Phase2Result
+	if passed > 0 && cfg.Scan.StabilityRounds > 0 && ctx.Err() == nil {
+		pending := cp.Phase2Pending(s.GetResults().GetSuccessful())
+		emit("phase2_start", map[string]interface{}{"count": len(pending)})
+		scanner.RunPhase2WithCallback(ctx, cfg, pending, func(r scanner.Phase2Result) {
+			if ctx.Err() != nil {
+				return // cut short by Ctrl+C, retest it on resume
+			}
+			cp.AddPhase2(r)
+			if err := cp.Save(cpPath); err != nil {
+				fmt.Fprintf(os.Stderr, "%sWarning:%s failed to save checkpoint: %v\n", utils.Yellow, utils.Reset, err)
+			}
+			emit("phase2_result", phase2Fields(r))
+			if hist != nil {
+				if err := hist.AddPhase2(session, fp, []scanner.Phase2Result{r}); err != nil {
+					warnHistory(err)
+				}
+			}
+		})
+		phase2Results = cp.Phase2Results()
+
+		scanner.PrintPhase2Results(phase2Results, topN, cfg.Scan.SpeedTest, cfg.Scan.JitterTest)
+
+		p2Path := scanner.GeneratePhase2OutputPath(outputFmt)
+		if err := scanner.SavePhase2Results(phase2Results, outputFmt, p2Path); err != nil {
+			fmt.Fprintf(os.Stderr, "%sWarning:%s failed to save phase-2 results: %v\n", utils.Yellow, utils.Reset, err)
+		} else {
+			fmt.Printf("%sPhase-2 results saved to:%s %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, p2Path, utils.Reset)
+			done["phase2_output"] = p2Path
+		}
+
+		passed = 0
+		var p2 []map[string]interface{}
+		for _, r := range phase2Results {
+			if r.Passed {
+				passed++
+			}
+			if topN <= 0 || len(p2) < topN {
+				p2 = append(p2, phase2Fields(r))
+			}
+		}
+		done["phase2_passed"] = passed
+		done["phase2"] = p2
+	}
+
+	// Phase-3: speed test روی بهترین IP ها، از phase 2 اگه اجرا شده وگرنه از phase 1
+	if scanPhase3 && passed > 0 && ctx.Err() == nil {
+		var ips []string
+		if phase2Results != nil {
+			for _, r := range phase2Results {
+				if r.Passed && (topN <= 0 || len(ips) < topN) {
+					ips = append(ips, r.IP)
+				}
+			}
+		} else {
+			for _, r := range s.GetResults().GetSortedByLatency() {
+				if r.Success && (topN <= 0 || len(ips) < topN) {
+					ips = append(ips, r.IP)
+				}
+			}
+		}
+		phase3Results, p3Path := runPhaseTests(ctx, speedTestConfig(cfg), "phase3", ips, session)
+		if p3Path != "" {
+			done["phase3_output"] = p3Path
+		}
+		passed = 0
+		for _, r := range phase3Results {
+			if r.Passed {
+				passed++
+			}
+		}
+		done["phase3_passed"] = passed
+		done["phase3"] = phaseFields(phase3Results, topN)
+	}
+
+	if s.GetResults().SuccessCount() > 0 {
+		outputPath := scanner.GenerateOutputPath(outputFmt)
+		if err := s.SaveResults(outputFmt, outputPath); err != nil {
+			fmt.Fprintf(os.Stderr, "%sWarning:%s failed to save results: %v\n", utils.Yellow, utils.Reset, err)
+		} else {
+			fmt.Printf("%sResults saved to:%s %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, outputPath, utils.Reset)
+			done["output"] = outputPath
+		}
+	}
+
+	if ctx.Err() != nil || !cp.Phase1Done {
+		done["checkpoint"] = cpPath
+		emit("done", done)
+		return withExit(exitInterrupted, fmt.Errorf("scan interrupted, resume with: --resume %s", cpPath))
+	}
+	os.Remove(cpPath)
+	emit("done", done)
+
+	if passed == 0 {
+		return withExit(exitNoResults, errors.New("no IP passed"))
+	}
+	return nil
+}
+
+// runICMPScan runs ICMP ping scan without xray-core
+func runICMPScan(cfg *config.Config) error {
+	s := scanner.NewICMPScanner(cfg)
+
+	if err := s.LoadIPs(subnetsPath, maxIPs, shuffle); err != nil {
+		return withExit(exitUsage, fmt.Errorf("failed to load IPs: %w", err))
+	}
+
+	var stopped atomic.Bool
+	onInterrupt(func() {
+		stopped.Store(true)
+		s.Stop()
+	})
+
+	emitProgress(s.GetResults(), "icmp", s.IPCount(), 0)
+	rec := openHistory().Record(s.GetResults(), fmt.Sprintf("%d", time.Now().Unix()), "icmp", warnHistory)
+	err := s.Run()
+	rec.Close()
+	if err != nil {
+		return fmt.Errorf("ICMP scan failed: %w", err)
+	}
+
+	s.GetResults().PrintTopResults(topN)
+
+	passed := s.GetResults().SuccessCount()
+	done := map[string]interface{}{
+		"tested":  s.GetResults().Count(),
+		"passed":  passed,
+		"results": topResultFields(s.GetResults(), topN),
+	}
+	if passed > 0 {
+		outputPath := scanner.GenerateOutputPath(outputFmt)
+		if err := s.SaveResults(outputFmt, outputPath); err != nil {
+			fmt.Fprintf(os.Stderr, "%sWarning:%s failed to save results: %v\n", utils.Yellow, utils.Reset, err)
+		} else {
+			fmt.Printf("%sResults saved to:%s %s%s%s\n", utils.Gray, utils.Reset, utils.Cyan, outputPath, utils.Reset)
+			done["output"] = outputPath
+		}
+	}
+	emit("done", done)
+
+	if stopped.Load() {
+		return withExit(exitInterrupted, errors.New("ICMP scan interrupted"))
+	}
+	if passed == 0 {
+		return withExit(exitNoResults, errors.New("no IP answered"))
+	}
+	return nil
+}
+
+// emitProgress emits a start event and then one result event per tested IP
+func emitProgress(rc *scanner.ResultCollector, command string, total, resumed int) {
+	if jsonEnc == nil {
+		return
+	}
+	emit("start", map[string]interface{}{"command": command, "total": total, "resumed": resumed})
+	var done atomic.Int64
+	done.Store(int64(resumed))
+	rc.OnAdd = func(r scanner.Result) {
+		f := resultFields(r)
+		f["done"] = done.Add(1)
+		f["total"] = total
+		emit("result", f)
+	}
+}
+
+func resultFields(r scanner.Result) map[string]interface{} {
+	f := map[string]interface{}{"ip": r.IP, "success": r.Success}
+	if r.Success {
+		f["latency_ms"] = r.LatencyMs
+		f["edge_connect_ms"] = r.EdgeConnectMs
+		f["edge_tls_ms"] = r.EdgeTLSMs
+		f["tunnel_ms"] = r.TunnelMs
+		f["ttfb_ms"] = r.TTFBMs
+	}
+	if r.Error != "" {
+		f["error"] = r.Error
+	}
+	if r.Failure != "" {
+		f["failure"] = r.Failure
+	}
+	if len(r.Probes) > 0 {
+		f["probes"] = r.Probes
+	}
+	return f
+}
+
+// topResultFields the n fastest IPs that passed, all of them when n <= 0
+func topResultFields(rc *scanner.ResultCollector, n int) []map[string]interface{} {
+	sorted := rc.GetSortedByLatency()
+	if n > 0 && len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	out := make([]map[string]interface{}, len(sorted))
+	for i, r := range sorted {
+		out[i] = resultFields(r)
+	}
+	return out
+}
+
+func phase2Fields(r scanner.Phase2Result) map[string]interface{} {
+	f := map[string]interface{}{
+		"ip":              r.IP,
+		"passed":          r.Passed,
+		"avg_latency_ms":  r.AvgLatencyMs,
+		"edge_connect_ms": r.AvgEdgeConnectMs,
+		"edge_tls_ms":     r.AvgEdgeTLSMs,
+		"tunnel_ms":       r.AvgTunnelMs,
+		"ttfb_ms":         r.AvgTTFBMs,
+		"jitter_ms":       r.JitterMs,
+		"loss_pct":        r.PacketLossPct,
+		"score":           r.StabilityScore,
+	}
+	if r.DownloadMbps > 0 {
+		f["down_mbps"] = r.DownloadMbps
+	}
+	if r.UploadMbps > 0 {
+		f["up_mbps"] = r.UploadMbps
+	}
+	if len(r.Throughput) > 0 {
+		f["sustained_mbps"] = r.SustainedMbps
+		f["throughput_mbps"] = r.Throughput
+		f["throughput_drop_pct"] = r.ThroughputDrop
+		f["stalls"] = r.Stalls
+		f["throttled"] = r.Throttled
+		if r.ThrottleReason != "" {
+			f["throttle_reason"] = r.ThrottleReason
+		}
+	}
+	if r.Failure != "" {
+		f["failure"] = r.Failure
+	}
+	if r.FailReason != "" {
+		f["fail_reason"] = r.FailReason
+	}
+	return f
+}
@@ -0,0 +1,66 @@
+package scanner
+
+import (
+	"context"
+)
+
+// Budget caps how many IPs are under test at once across every scanner and
+// phase-2 run that shares it. Workers waiting for a slot stop their xray
+// instance, so the budget also caps the instances and local ports in use.
+// A nil Budget is unlimited.
+type Budget struct {
+	slots chan struct{}
+}
+
+// NewBudget creates a budget of size concurrent tests
+func NewBudget(size int) *Budget {
+	if size < 1 {
+		size = 1
+	}
+	return &Budget{slots: make(chan struct{}, size)}
+}
+
+// acquire waits for a free slot; false means the scan stopped first
+func (b *Budget) acquire(ctx context.Context, quit <-chan struct{}) bool {
+	if b == nil {
+		return true
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-quit:
+		return false
+	}
+}
+
+// tryAcquire takes a slot only if one is free right now
+func (b *Budget) tryAcquire() bool {
+	if b == nil {
+		return true
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *Budget) release(n int) {
+	if b == nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		<-b.slots
+	}
+}
+
+// Usage returns how many slots are taken and how many the budget has
+func (b *Budget) Usage() (inUse, size int) {
+	if b == nil {
+		return 0, 0
+	}
+	return len(b.slots), cap(b.slots)
+}
@@ -0,0 +1,198 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"piyazche/utils"
+)
+
+const checkpointVersion = 1
+
+// CheckpointInterval is how often a running scan flushes its checkpoint
+const CheckpointInterval = 10 * time.Second
+
+// Source types recorded in a checkpoint
+const (
+	SourcePath = "path" // file path or comma-separated CIDR list, see OpenIPSource
+	SourceText = "text" // newline-separated IPs/CIDRs (web UI input)
+)
+
+// Checkpoint is the on-disk state of a scan: the parameters needed to rebuild
+// the exact same IP sequence, how far into it the scan got, and every finished
+// result. It is flushed periodically so an interrupted scan can be resumed.
+type Checkpoint struct {
+	Version    int    `json:"version"`
+	Source     string `json:"source"`
+	SourceType string `json:"source_type"`
+	SampleSize int    `json:"sample_size"`
+	Shuffle    bool   `json:"shuffle"`
+	Seed       int64  `json:"seed"`
+	MaxIPs     int    `json:"max_ips"`
+	Adaptive   bool   `json:"adaptive,omitempty"` // MaxIPs is the adaptive budget
+	// Config is the full config of the run, for callers without a config file (web UI)
+	Config json.RawMessage `json:"config,omitempty"`
+
+	// Cursor is a position in the IP sequence before which every IP is finished;
+	// IPs after it may be finished too, those are skipped by their Result
+	Cursor     int            `json:"cursor"`
+	Phase1Done bool           `json:"phase1_done"`
+	Results    []Result       `json:"results"`
+	Phase2     []Phase2Result `json:"phase2,omitempty"`
+
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	baseCursor int // Cursor when this run opened the source
+	mu         sync.Mutex
+	saveMu     sync.Mutex // serializes Save, phase-2 callbacks run concurrently
+}
+
+// NewCheckpoint describes a fresh scan. The shuffle seed is fixed here so a
+// resumed run walks the IPs in the same order as the original one.
+func NewCheckpoint(source, sourceType string, sampleSize int, shuffle bool, maxIPs int) *Checkpoint {
+	return &Checkpoint{
+		Version:    checkpointVersion,
+		Source:     source,
+		SourceType: sourceType,
+		SampleSize: sampleSize,
+		Shuffle:    shuffle,
+		Seed:       rand.Int63() | 1,
+		MaxIPs:     maxIPs,
+		StartedAt:  time.Now(),
+	}
+}
+
+// LoadCheckpoint reads a checkpoint written by Save
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d", cp.Version)
+	}
+	return &cp, nil
+}
+
+// GenerateCheckpointPath generates a timestamped checkpoint file path
+func GenerateCheckpointPath() string {
+	timestamp := time.Now().Format("2006-01-02_150405")
+	return filepath.Join("results", timestamp+"_checkpoint.json")
+}
+
+// SessionID identifies the scan across resumes
+func (c *Checkpoint) SessionID() string {
+	return fmt.Sprintf("%d", c.StartedAt.Unix())
+}
+
+// OpenSource rebuilds the scan's IP source and moves it to the cursor
+func (c *Checkpoint) OpenSource() (utils.IPSource, error) {
+	opts := utils.SourceOptions{SampleSize: c.SampleSize, Shuffle: c.Shuffle, Seed: c.Seed}
+	if c.Adaptive {
+		// the adaptive order depends on results, Scanner.UseCheckpoint replays them
+		opts.Adaptive, opts.Budget = true, c.MaxIPs
+	}
+
+	var src utils.IPSource
+	switch c.SourceType {
+	case SourceText:
+		src = utils.NewTextSource(c.Source, opts)
+	default:
+		var err error
+		src, err = OpenIPSource(c.Source, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	src = utils.LimitSource(src, c.MaxIPs)
+	src.Skip(c.Cursor)
+	c.baseCursor = c.Cursor
+	return src, nil
+}
+
+// Update records the current phase-1 state
+func (c *Checkpoint) Update(cursor int, results []Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cursor > c.Cursor {
+		c.Cursor = cursor
+	}
+	c.Results = results
+}
+
+// FinishPhase1 marks the IP source as fully scanned
+func (c *Checkpoint) FinishPhase1(results []Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Phase1Done = true
+	c.Results = results
+}
+
+// AddPhase2 records a finished phase-2 result
+func (c *Checkpoint) AddPhase2(r Phase2Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Phase2 = append(c.Phase2, r)
+}
+
+// Phase2Pending returns the phase-1 results that still need a phase-2 test
+func (c *Checkpoint) Phase2Pending(phase1 []Result) []Result {
+	c.mu.Lock()
+	done := make(map[string]bool, len(c.Phase2))
+	for _, r := range c.Phase2 {
+		done[r.IP] = true
+	}
+	c.mu.Unlock()
+
+	var pending []Result
+	for _, r := range phase1 {
+		if !done[r.IP] {
+			pending = append(pending, r)
+		}
+	}
+	return pending
+}
+
+// Phase2Results returns every recorded phase-2 result, best first
+func (c *Checkpoint) Phase2Results() []Phase2Result {
+	c.mu.Lock()
+	out := make([]Phase2Result, len(c.Phase2))
+	copy(out, c.Phase2)
+	c.mu.Unlock()
+	SortPhase2Results(out)
+	return out
+}
+
+// Save writes the checkpoint atomically (temp file + rename)
+func (c *Checkpoint) Save(path string) error {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	c.mu.Lock()
+	c.UpdatedAt = time.Now()
+	data, err := json.Marshal(c)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -3,13 +3,13 @@ package scanner
 import (
 	"context"
 	"fmt"
-	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"piyazche/config"
 	"piyazche/utils"
+	"piyazche/xray"
 
 	"github.com/schollz/progressbar/v3"
 )
@@ -17,7 +17,7 @@ import (
 // ICMPScanner scans IPs using ICMP ping (no xray-core needed)
 type ICMPScanner struct {
 	cfg       *config.Config
-	ips       []string
+	source    utils.IPSource
 	results   *ResultCollector
 	quit      chan struct{}
 	ctx       context.Context
@@ -39,39 +39,28 @@ func NewICMPScanner(cfg *config.Config) *ICMPScanner {
 
 // LoadIPs loads IPs from file or CIDR string
 func (s *ICMPScanner) LoadIPs(source string, maxIPs int, shuffle bool) error {
-	var ips []string
-	var err error
-
-	if _, statErr := os.Stat(source); statErr == nil {
-		ips, err = utils.ParseIPsFromFile(source, s.cfg.Scan.SampleSize, shuffle)
-	} else {
-		ips, err = utils.ParseCIDRList(source, s.cfg.Scan.SampleSize)
-		if shuffle {
-			utils.ShuffleIPs(ips)
-		}
-	}
-
+	src, err := OpenIPSource(source, utils.SourceOptions{SampleSize: s.cfg.Scan.SampleSize, Shuffle: shuffle})
 	if err != nil {
-		return fmt.Errorf("failed to load IPs: %w", err)
-	}
-
-	if len(ips) == 0 {
-		return fmt.Errorf("no IPs found in source")
+		return err
 	}
+	s.source = utils.LimitSource(src, maxIPs)
+	return nil
+}
 
-	if maxIPs > 0 && maxIPs < len(ips) {
-		ips = ips[:maxIPs]
+// IPCount returns the number of IPs to ping
+func (s *ICMPScanner) IPCount() int {
+	if s.source == nil {
+		return 0
 	}
-
-	s.ips = ips
-	return nil
+	return s.source.Len()
 }
 
 // Run starts the ICMP scanning process
 func (s *ICMPScanner) Run() error {
-	if len(s.ips) == 0 {
+	if s.source == nil || s.source.Len() == 0 {
 		return fmt.Errorf("no IPs loaded")
 	}
+	total := s.source.Len()
 
 	s.startTime = time.Now()
 	threads := s.cfg.Scan.Threads
@@ -93,7 +82,7 @@ func (s *ICMPScanner) Run() error {
 
 	fmt.Printf("%s%sConnectivity Scan%s (%s%s%s)\n", utils.Bold, utils.Cyan, utils.Reset, utils.Yellow, pingMode, utils.Reset)
 	fmt.Printf("   %sIPs:%s %d  %sWorkers:%s %d  %sTimeout:%s %v  %sRetries:%s %d\n\n",
-		utils.Gray, utils.Reset, len(s.ips),
+		utils.Gray, utils.Reset, total,
 		utils.Gray, utils.Reset, threads,
 		utils.Gray, utils.Reset, timeout,
 		utils.Gray, utils.Reset, retries)
@@ -101,7 +90,7 @@ func (s *ICMPScanner) Run() error {
 	jobs := make(chan string, threads*2)
 	logger := make(chan string, threads*4)
 
-	bar := progressbar.NewOptions(len(s.ips),
+	bar := progressbar.NewOptions(total,
 		progressbar.OptionEnableColorCodes(true),
 		progressbar.OptionShowCount(),
 		progressbar.OptionShowIts(),
@@ -158,7 +147,11 @@ func (s *ICMPScanner) Run() error {
 
 	// Feed jobs
 	go func() {
-		for _, ip := range s.ips {
+		for {
+			ip, ok := s.source.Next()
+			if !ok {
+				break
+			}
 			select {
 			case <-s.quit:
 				close(jobs)
@@ -234,6 +227,7 @@ func (s *ICMPScanner) processIP(ip string, processed *atomic.Int64, logger chan<
 
 	if result.Error != nil {
 		scanResult.Error = result.Error.Error()
+		scanResult.Failure = xray.ClassifyError(result.Error)
 	}
 
 	s.results.Add(scanResult)
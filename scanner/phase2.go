@@ -4,14 +4,17 @@ import (
 	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"piyazche/config"
 	"piyazche/utils"
@@ -20,17 +23,28 @@ import (
 
 // Phase2Result holds the deep-test results for a single IP
 type Phase2Result struct {
-	IP             string
-	AvgLatencyMs   float64
-	MinLatencyMs   int64
-	MaxLatencyMs   int64
-	JitterMs       float64
-	PacketLossPct  float64
-	DownloadMbps   float64
-	UploadMbps     float64
-	StabilityScore float64
-	Passed         bool
-	FailReason     string
+	IP               string
+	AvgLatencyMs     float64
+	MinLatencyMs     int64
+	MaxLatencyMs     int64
+	AvgEdgeConnectMs float64 // میانگین فازهای latency، مثل Result
+	AvgEdgeTLSMs     float64
+	AvgTunnelMs      float64
+	AvgTTFBMs        float64
+	JitterMs         float64
+	PacketLossPct    float64
+	DownloadMbps     float64
+	UploadMbps       float64
+	SustainedMbps    float64   // میانگین دانلود طولانی (scan.sustained)
+	Throughput       []float64 // منحنی throughput دانلود طولانی، Mbps در هر bucket
+	ThroughputDrop   float64   // درصد افت انتهای منحنی نسبت به ابتداش
+	Stalls           int       // bucket هایی که هیچ داده‌ای نرسید
+	Throttled        bool      // افت، stall یا reset وسط دانلود؛ از StabilityScore کم میشه
+	ThrottleReason   string
+	StabilityScore   float64
+	Passed           bool
+	FailReason       string
+	Failure          xray.Failure // کلاس FailReason
 }
 
 // RunPhase2 takes the successful IPs from phase-1 and runs deep tests
@@ -39,9 +53,15 @@ func RunPhase2(ctx context.Context, cfg *config.Config, phase1Results []Result)
 }
 
 // RunPhase2WithCallback مثل RunPhase2 ولی هر بار که یه IP تموم شد callback میزنه
-// معماری جدید: هر IP یه xray instance جداگانه داره ولی log level رو none میذاره
-// تا terminal پر از Error نشه. Upload test حذف شد چون دقیق نبود.
+// هر worker یه xray instance ثابت داره و فقط outbound اون رو برای هر IP عوض میکنه؛
+// log level رو none میذاره تا terminal پر از Error نشه. Upload test حذف شد چون دقیق نبود.
 func RunPhase2WithCallback(ctx context.Context, cfg *config.Config, phase1Results []Result, onDone func(Phase2Result)) []Phase2Result {
+	return RunPhase2WithBudget(ctx, cfg, phase1Results, nil, onDone)
+}
+
+// RunPhase2WithBudget مثل RunPhase2WithCallback ولی هر IP قبل از تست یه slot از budget
+// می‌گیره تا phase 2 هم با اسکن‌های دیگه توی همون سقف بمونه؛ nil یعنی بی‌سقف
+func RunPhase2WithBudget(ctx context.Context, cfg *config.Config, phase1Results []Result, budget *Budget, onDone func(Phase2Result)) []Phase2Result {
 	rounds := cfg.Scan.StabilityRounds
 	if rounds <= 0 {
 		rounds = 1
@@ -80,78 +100,101 @@ func RunPhase2WithCallback(ctx context.Context, cfg *config.Config, phase1Result
 	final := make([]Phase2Result, total)
 	var mu sync.Mutex
 	var wg sync.WaitGroup
-	sem := make(chan struct{}, concurrency)
 	var doneCount int64
 
-	for i, candidate := range candidates {
-		select {
-		case <-ctx.Done():
-			goto waitAll
-		default:
-		}
+	// log level رو none بذار تا terminal پر از Error نشه
+	p2Cfg := *cfg
+	p2Cfg.Xray.LogLevel = "none"
 
-		wg.Add(1)
-		sem <- struct{}{}
+	runOne := func(tester *Tester, idx int, ip string) {
+		p2 := testIPPhase2(ctx, cfg, tester, ip, rounds, interval)
+		applyFilters(cfg, &p2)
 
-		go func(idx int, ip string) {
-			defer wg.Done()
-			defer func() { <-sem }()
-
-			p2 := testIPPhase2(ctx, cfg, ip, rounds, interval)
-			applyFilters(cfg, &p2)
-
-			done := int(atomic.AddInt64(&doneCount, 1))
-			_ = done
-			_ = done
-
-			statusIcon := fmt.Sprintf("%s✓%s", utils.Green, utils.Reset)
-			statusStr := ""
-			plColor := utils.Green
-			if !p2.Passed {
-				statusIcon = fmt.Sprintf("%s✗%s", utils.Red, utils.Reset)
-				statusStr = fmt.Sprintf(" %s(%s)%s", utils.Red, p2.FailReason, utils.Reset)
-				plColor = utils.Red
-			}
-			if p2.PacketLossPct > 30 {
-				plColor = utils.Red
-			} else if p2.PacketLossPct > 10 {
-				plColor = utils.Yellow
-			}
+		done := int(atomic.AddInt64(&doneCount, 1))
+		_ = done
+		_ = done
 
-			jitterStr := ""
-			if cfg.Scan.JitterTest {
-				jitterStr = fmt.Sprintf(" %sJ:%s%s%.0fms%s", utils.Gray, utils.Reset, utils.Magenta, p2.JitterMs, utils.Reset)
-			}
-			speedStr := ""
-			if cfg.Scan.SpeedTest && p2.DownloadMbps > 0 {
-				dlColor := utils.Green
-				if p2.DownloadMbps < 1 {
-					dlColor = utils.Red
-				} else if p2.DownloadMbps < 5 {
-					dlColor = utils.Yellow
-				}
-				speedStr = fmt.Sprintf(" %s↓%s%s%.1fM%s", utils.Blue, utils.Reset, dlColor, p2.DownloadMbps, utils.Reset)
+		statusIcon := fmt.Sprintf("%s✓%s", utils.Green, utils.Reset)
+		statusStr := ""
+		plColor := utils.Green
+		if !p2.Passed {
+			statusIcon = fmt.Sprintf("%s✗%s", utils.Red, utils.Reset)
+			statusStr = fmt.Sprintf(" %s(%s)%s", utils.Red, p2.FailReason, utils.Reset)
+			plColor = utils.Red
+		}
+		if p2.PacketLossPct > 30 {
+			plColor = utils.Red
+		} else if p2.PacketLossPct > 10 {
+			plColor = utils.Yellow
+		}
+
+		jitterStr := ""
+		if cfg.Scan.JitterTest {
+			jitterStr = fmt.Sprintf(" %sJ:%s%s%.0fms%s", utils.Gray, utils.Reset, utils.Magenta, p2.JitterMs, utils.Reset)
+		}
+		speedStr := ""
+		if cfg.Scan.SpeedTest && p2.DownloadMbps > 0 {
+			dlColor := utils.Green
+			if p2.DownloadMbps < 1 {
+				dlColor = utils.Red
+			} else if p2.DownloadMbps < 5 {
+				dlColor = utils.Yellow
 			}
+			speedStr = fmt.Sprintf(" %s↓%s%s%.1fM%s", utils.Blue, utils.Reset, dlColor, p2.DownloadMbps, utils.Reset)
+		}
+
+		mu.Lock()
+		fmt.Printf("[%d/%d] %s %s%s%s %s─%s %s%.0fms%s %sPL:%s%s%.0f%%%s%s%s%s\n",
+			done, total,
+			statusIcon,
+			utils.Cyan, ip, utils.Reset,
+			utils.Gray, utils.Reset,
+			utils.Yellow, p2.AvgLatencyMs, utils.Reset,
+			utils.Gray, utils.Reset, plColor, p2.PacketLossPct, utils.Reset,
+			jitterStr, speedStr, statusStr)
+		final[idx] = p2
+		mu.Unlock()
+
+		if onDone != nil {
+			onDone(p2)
+		}
+	}
 
-			mu.Lock()
-			fmt.Printf("[%d/%d] %s %s%s%s %s─%s %s%.0fms%s %sPL:%s%s%.0f%%%s%s%s%s\n",
-				done, total,
-				statusIcon,
-				utils.Cyan, ip, utils.Reset,
-				utils.Gray, utils.Reset,
-				utils.Yellow, p2.AvgLatencyMs, utils.Reset,
-				utils.Gray, utils.Reset, plColor, p2.PacketLossPct, utils.Reset,
-				jitterStr, speedStr, statusStr)
-			final[idx] = p2
-			mu.Unlock()
-
-			if onDone != nil {
-				onDone(p2)
+	type phase2Job struct {
+		idx int
+		ip  string
+	}
+	jobs := make(chan phase2Job)
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tester := NewTester(&p2Cfg, 6*time.Second)
+			defer tester.Close()
+			for job := range jobs {
+				// worker منتظر slot، xray خودش رو می‌بنده تا instance و port نگه نداره
+				if !budget.tryAcquire() {
+					tester.Close()
+					if !budget.acquire(ctx, nil) {
+						continue
+					}
+				}
+				runOne(tester, job.idx, job.ip)
+				budget.release(1)
 			}
-		}(i, candidate.IP)
+		}()
 	}
 
-waitAll:
+feed:
+	for i, candidate := range candidates {
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- phase2Job{i, candidate.IP}:
+		}
+	}
+	close(jobs)
 	wg.Wait()
 
 	var nonEmpty []Phase2Result
@@ -161,65 +204,38 @@ waitAll:
 		}
 	}
 
-	sort.Slice(nonEmpty, func(i, j int) bool {
-		if nonEmpty[i].StabilityScore != nonEmpty[j].StabilityScore {
-			return nonEmpty[i].StabilityScore > nonEmpty[j].StabilityScore
-		}
-		return nonEmpty[i].AvgLatencyMs < nonEmpty[j].AvgLatencyMs
-	})
+	SortPhase2Results(nonEmpty)
 
 	return nonEmpty
 }
 
-func testIPPhase2(ctx context.Context, cfg *config.Config, ip string, rounds int, interval time.Duration) Phase2Result {
-	p2 := Phase2Result{IP: ip}
-
-	port := utils.AcquirePort()
-	defer utils.ReleasePort(port)
+// SortPhase2Results orders results by stability score, then latency
+func SortPhase2Results(results []Phase2Result) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].StabilityScore != results[j].StabilityScore {
+			return results[i].StabilityScore > results[j].StabilityScore
+		}
+		return results[i].AvgLatencyMs < results[j].AvgLatencyMs
+	})
+}
 
-	// log level رو none بذار تا terminal پر از Error نشه
-	p2Cfg := *cfg
-	p2Cfg.Xray.LogLevel = "none"
+func testIPPhase2(ctx context.Context, cfg *config.Config, tester *Tester, ip string, rounds int, interval time.Duration) Phase2Result {
+	p2 := Phase2Result{IP: ip}
 
-	xrayConfig, err := config.GenerateXrayConfig(&p2Cfg, ip, port)
-	if err != nil {
-		p2.FailReason = "config error"
+	readyCtx, readyCancel := context.WithTimeout(ctx, 6*time.Second)
+	port, err := tester.Target(readyCtx, ip)
+	readyCancel()
+	switch {
+	case errors.Is(err, errXrayConfig):
+		p2.FailReason, p2.Failure = "config error", xray.FailXray
 		return p2
-	}
-
-	manager := xray.NewManagerWithDebug(false)
-
-	var startErr error
-	for attempt := 0; attempt < 3; attempt++ {
-		if attempt > 0 {
-			utils.ReleasePort(port)
-			time.Sleep(200 * time.Millisecond)
-			port = utils.AcquirePort()
-			xrayConfig, err = config.GenerateXrayConfig(&p2Cfg, ip, port)
-			if err != nil {
-				p2.FailReason = "config error"
-				return p2
-			}
-		}
-		startErr = manager.Start(xrayConfig, port)
-		if startErr == nil {
-			break
-		}
-		time.Sleep(150 * time.Millisecond)
-	}
-	if startErr != nil {
-		p2.FailReason = "xray start failed"
+	case errors.Is(err, errXrayStart):
+		p2.FailReason, p2.Failure = "xray start failed", xray.FailXray
 		return p2
-	}
-	defer manager.Stop()
-
-	readyCtx, readyCancel := context.WithTimeout(ctx, 6*time.Second)
-	if err := manager.WaitForReadyWithContext(readyCtx, 6*time.Second); err != nil {
-		readyCancel()
-		p2.FailReason = "xray not ready"
+	case err != nil:
+		p2.FailReason, p2.Failure = "xray not ready", xray.FailXray
 		return p2
 	}
-	readyCancel()
 
 	connTimeout := time.Duration(cfg.Scan.Timeout) * time.Second
 	if connTimeout < 10*time.Second {
@@ -235,6 +251,21 @@ func testIPPhase2(ctx context.Context, cfg *config.Config, ip string, rounds int
 	var lossTotal float64
 	roundsDone := 0
 
+	// جمع فازهای latency نمونه‌های موفق، برای میانگین
+	var tunnelSum, ttfbSum time.Duration
+	var edgeConnectSum, edgeTLSSum time.Duration // dial مستقیم، هر round یه بار
+	edgeSamples := 0
+	var lastFailure xray.Failure // اگه هیچ نمونه‌ای موفق نشد
+	addSample := func(r *xray.TestResult) {
+		if !r.Success {
+			lastFailure = r.Failure
+			return
+		}
+		latencies = append(latencies, r.Latency.Milliseconds())
+		tunnelSum += r.Tunnel
+		ttfbSum += r.TTFB
+	}
+
 	// جیتر حداقل ۳ sample نیاز داره؛ اگه rounds کمتره، latency extra بگیر
 	minLatencySamples := rounds
 	if cfg.Scan.JitterTest && minLatencySamples < 3 {
@@ -251,8 +282,11 @@ func testIPPhase2(ctx context.Context, cfg *config.Config, ip string, rounds int
 
 		// ۱. Latency — یه HTTP request ساده
 		connResult := xray.TestConnectivityWithContext(ctx, port, cfg.Scan.TestURL, connTimeout)
-		if connResult.Success {
-			latencies = append(latencies, connResult.Latency.Milliseconds())
+		addSample(connResult)
+		if connect, handshake := edgeTiming(ctx, cfg, ip, connTimeout); connect > 0 {
+			edgeConnectSum += connect
+			edgeTLSSum += handshake
+			edgeSamples++
 		}
 
 		// ۲. Packet Loss — sequential HEAD requests (نه concurrent، نه keepalive)
@@ -305,9 +339,7 @@ done:
 		extraCtx, extraCancel := context.WithTimeout(ctx, connTimeout)
 		result := xray.TestConnectivityWithContext(extraCtx, port, cfg.Scan.TestURL, connTimeout)
 		extraCancel()
-		if result.Success {
-			latencies = append(latencies, result.Latency.Milliseconds())
-		}
+		addSample(result)
 		if i < extraLatencies-1 {
 			select {
 			case <-ctx.Done():
@@ -319,7 +351,10 @@ done:
 skipExtra:
 
 	if len(latencies) == 0 {
-		p2.FailReason = "no successful latency samples"
+		p2.FailReason, p2.Failure = "no successful latency samples", lastFailure
+		if p2.Failure == "" {
+			p2.Failure = xray.FailOther
+		}
 		return p2
 	}
 
@@ -337,6 +372,13 @@ skipExtra:
 		}
 	}
 	p2.AvgLatencyMs = float64(sum) / float64(len(latencies))
+	samples := float64(len(latencies))
+	p2.AvgTunnelMs = float64(tunnelSum.Milliseconds()) / samples
+	if edgeSamples > 0 {
+		p2.AvgEdgeConnectMs = float64(edgeConnectSum.Milliseconds()) / float64(edgeSamples)
+		p2.AvgEdgeTLSMs = float64(edgeTLSSum.Milliseconds()) / float64(edgeSamples)
+	}
+	p2.AvgTTFBMs = float64(ttfbSum.Milliseconds()) / samples
 
 	// Jitter — RFC 3550 style: mean of |diff between consecutive samples|
 	// This is more stable than variance and matches what tools like ping report.
@@ -430,6 +472,11 @@ skipExtra:
 		}
 	}
 
+	throttlePenalty := 0.0
+	if cfg.Scan.Sustained.Enabled {
+		throttlePenalty = runSustained(ctx, cfg, port, &p2)
+	}
+
 	// Smart Score: 0-100
 	// latency: 40pt, packet loss: 35pt, jitter: 15pt, bandwidth: 10pt
 	latScore := math.Max(0, math.Min(40, 40*(1-(p2.AvgLatencyMs-50)/2950)))
@@ -442,12 +489,44 @@ skipExtra:
 	if p2.DownloadMbps > 0 {
 		bwScore = math.Min(10, p2.DownloadMbps/5*10) // 5Mbps = full score
 	}
-	p2.StabilityScore = latScore + plScore + jitterScore + bwScore
+	p2.StabilityScore = math.Max(0, latScore+plScore+jitterScore+bwScore-throttlePenalty)
 	p2.Passed = true
 
 	return p2
 }
 
+// runSustained دانلود طولانی رو انجام میده و منحنی و نشونه‌های throttling رو
+// توی p2 میذاره؛ جریمه‌ی امتیاز رو برمیگردونه: تا ۳۰، reset کامل، افت به نسبت
+// درصدش و هر stall ۷.۵. اگه جوابی نیاد (خطای request یا غیر 2xx) IP رد نمیشه،
+// فقط منحنی نداره؛ ولی reset یا stall قبل از اولین بایت بدترین حالت throttling هست
+func runSustained(ctx context.Context, cfg *config.Config, port int, p2 *Phase2Result) float64 {
+	st := cfg.Scan.Sustained.WithDefaults()
+	window := time.Duration(st.WindowSec) * time.Second
+	sr := xray.TestSustainedDownload(ctx, port, st.URL, window, time.Duration(st.BucketMs)*time.Millisecond)
+	if !sr.Responded {
+		return 0
+	}
+
+	p2.SustainedMbps = sr.AvgMbps
+	p2.Throughput = sr.Curve
+	p2.ThroughputDrop = sr.DropPct
+	p2.Stalls = sr.Stalls
+	severity := 0.0
+	switch {
+	case sr.Reset:
+		p2.ThrottleReason = fmt.Sprintf("%s after %d KB", sr.Failure, sr.Bytes/1024)
+		severity = 1
+	case sr.DropPct >= st.MaxDropPct:
+		p2.ThrottleReason = fmt.Sprintf("throughput fell %.0f%% (%.1f -> %.1f Mbps)", sr.DropPct, sr.HeadMbps, sr.TailMbps)
+		severity = math.Max(sr.DropPct/100, float64(sr.Stalls)/4)
+	case sr.Stalls >= 2:
+		p2.ThrottleReason = fmt.Sprintf("stalled %d times", sr.Stalls)
+		severity = float64(sr.Stalls) / 4
+	}
+	p2.Throttled = p2.ThrottleReason != ""
+	return 30 * math.Min(1, severity)
+}
+
 // doSimplePing یه HEAD request ساده بدون keepalive میزنه
 func doSimplePing(ctx context.Context, socksPort int, testURL string) bool {
 	// از TestConnectivityWithContext استفاده میکنیم که ساده‌ترین روشه
@@ -462,13 +541,20 @@ func applyFilters(cfg *config.Config, p2 *Phase2Result) {
 	}
 
 	if cfg.Scan.MaxPacketLossPct >= 0 && p2.PacketLossPct > cfg.Scan.MaxPacketLossPct {
-		p2.Passed = false
+		p2.Passed, p2.Failure = false, xray.FailLimit
 		p2.FailReason = fmt.Sprintf("packet loss %.0f%% > max %.0f%%", p2.PacketLossPct, cfg.Scan.MaxPacketLossPct)
 		return
 	}
 
+	// edge سریع ولی مسیر تا origin کند
+	if cfg.Scan.MaxTTFBMs > 0 && p2.AvgTTFBMs > float64(cfg.Scan.MaxTTFBMs) {
+		p2.Passed, p2.Failure = false, xray.FailLimit
+		p2.FailReason = fmt.Sprintf("first byte after %.0fms > max %dms", p2.AvgTTFBMs, cfg.Scan.MaxTTFBMs)
+		return
+	}
+
 	if cfg.Scan.MinDownloadMbps > 0 && p2.DownloadMbps < cfg.Scan.MinDownloadMbps {
-		p2.Passed = false
+		p2.Passed, p2.Failure = false, xray.FailLimit
 		p2.FailReason = fmt.Sprintf("download %.1fMbps < min %.1fMbps", p2.DownloadMbps, cfg.Scan.MinDownloadMbps)
 		return
 	}
@@ -487,6 +573,29 @@ func PrintPhase2Results(results []Phase2Result, n int, hasSpeed bool, hasJitter
 		utils.Green, len(passed), utils.Reset,
 		utils.White, len(results), utils.Reset)
 
+	counts := make(map[xray.Failure]int)
+	for _, r := range results {
+		if !r.Passed {
+			counts[r.Failure]++
+		}
+	}
+	if line := FormatFailures(counts); line != "" {
+		fmt.Printf("  %sFailures:%s %s\n", utils.Gray, utils.Reset, line)
+	}
+
+	hasCurve, throttled := false, 0
+	for _, r := range results {
+		if len(r.Throughput) > 0 {
+			hasCurve = true
+		}
+		if r.Throttled {
+			throttled++
+		}
+	}
+	if throttled > 0 {
+		fmt.Printf("  %sThrottled:%s %s%d%s (score lowered)\n", utils.Gray, utils.Reset, utils.Yellow, throttled, utils.Reset)
+	}
+
 	if len(passed) == 0 {
 		fmt.Printf("%sNo IPs passed phase-2 filters.%s\n", utils.Yellow, utils.Reset)
 		return
@@ -503,6 +612,9 @@ func PrintPhase2Results(results []Phase2Result, n int, hasSpeed bool, hasJitter
 	if hasSpeed {
 		fmt.Printf("┬──────────────")
 	}
+	if hasCurve {
+		fmt.Printf("┬─────────────────────")
+	}
 	fmt.Printf("┬──────────┐%s\n", utils.Reset)
 
 	fmt.Printf("%s│%s %-20s %s│%s %6s %s│%s %8s %s│%s %9s ",
@@ -516,6 +628,9 @@ func PrintPhase2Results(results []Phase2Result, n int, hasSpeed bool, hasJitter
 	if hasSpeed {
 		fmt.Printf("%s│%s %12s ", utils.Gray, utils.Reset, utils.Bold+"Download"+utils.Reset)
 	}
+	if hasCurve {
+		fmt.Printf("%s│%s %-27s ", utils.Gray, utils.Reset, utils.Bold+"Sustained"+utils.Reset)
+	}
 	fmt.Printf("%s│%s\n", utils.Gray, utils.Reset)
 
 	for i, r := range passed {
@@ -563,6 +678,17 @@ func PrintPhase2Results(results []Phase2Result, n int, hasSpeed bool, hasJitter
 			}
 			fmt.Printf("%s│%s %s%12s%s ", utils.Gray, utils.Reset, dlColor, dlStr, utils.Reset)
 		}
+		if hasCurve {
+			curveColor, curveStr := utils.Dim, "-"
+			if len(r.Throughput) > 0 {
+				curveColor = utils.Green
+				if r.Throttled {
+					curveColor = utils.Red
+				}
+				curveStr = fmt.Sprintf("%s %5.1fMbps", sparkline(r.Throughput, 8), r.SustainedMbps)
+			}
+			fmt.Printf("%s│%s %s%s%s%*s ", utils.Gray, utils.Reset, curveColor, curveStr, utils.Reset, 19-utf8.RuneCountInString(curveStr), "")
+		}
 		fmt.Printf("%s│%s\n", utils.Gray, utils.Reset)
 	}
 
@@ -573,9 +699,41 @@ func PrintPhase2Results(results []Phase2Result, n int, hasSpeed bool, hasJitter
 	if hasSpeed {
 		fmt.Printf("┴──────────────")
 	}
+	if hasCurve {
+		fmt.Printf("┴─────────────────────")
+	}
 	fmt.Printf("┘%s\n", utils.Reset)
 }
 
+// sparkline منحنی رو در width کاراکتر نشون میده، هر کاراکتر میانگین چند bucket
+func sparkline(curve []float64, width int) string {
+	const bars = "▁▂▃▄▅▆▇█"
+	if len(curve) < width {
+		width = len(curve)
+	}
+	cols := make([]float64, width)
+	peak := 0.0
+	for i := range cols {
+		lo, hi := i*len(curve)/width, (i+1)*len(curve)/width
+		var sum float64
+		for _, v := range curve[lo:hi] {
+			sum += v
+		}
+		cols[i] = sum / float64(hi-lo)
+		peak = math.Max(peak, cols[i])
+	}
+	levels := []rune(bars)
+	var sb strings.Builder
+	for _, v := range cols {
+		i := 0
+		if peak > 0 {
+			i = int(v / peak * float64(len(levels)-1))
+		}
+		sb.WriteRune(levels[i])
+	}
+	return sb.String()
+}
+
 func SavePhase2Results(results []Phase2Result, format string, path string) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -608,10 +766,20 @@ func SavePhase2Results(results []Phase2Result, format string, path string) error
 			break
 		}
 	}
-	header := []string{"ip", "avg_latency_ms", "min_latency_ms", "max_latency_ms", "jitter_ms", "packet_loss_pct", "stability_score", "passed", "fail_reason"}
+	hasCurve := false
+	for _, r := range results {
+		if len(r.Throughput) > 0 {
+			hasCurve = true
+			break
+		}
+	}
+	header := []string{"ip", "avg_latency_ms", "min_latency_ms", "max_latency_ms", "edge_connect_ms", "edge_tls_ms", "tunnel_ms", "ttfb_ms", "jitter_ms", "packet_loss_pct", "stability_score", "passed", "fail_reason", "failure"}
 	if hasSpeed {
 		header = append(header, "download_mbps")
 	}
+	if hasCurve {
+		header = append(header, "sustained_mbps", "throughput_drop_pct", "stalls", "throttled", "throttle_reason", "throughput_mbps")
+	}
 	w.Write(header)
 
 	for _, r := range results {
@@ -620,15 +788,33 @@ func SavePhase2Results(results []Phase2Result, format string, path string) error
 			fmt.Sprintf("%.1f", r.AvgLatencyMs),
 			fmt.Sprintf("%d", r.MinLatencyMs),
 			fmt.Sprintf("%d", r.MaxLatencyMs),
+			fmt.Sprintf("%.1f", r.AvgEdgeConnectMs),
+			fmt.Sprintf("%.1f", r.AvgEdgeTLSMs),
+			fmt.Sprintf("%.1f", r.AvgTunnelMs),
+			fmt.Sprintf("%.1f", r.AvgTTFBMs),
 			fmt.Sprintf("%.1f", r.JitterMs),
 			fmt.Sprintf("%.1f", r.PacketLossPct),
 			fmt.Sprintf("%.1f", r.StabilityScore),
 			fmt.Sprintf("%t", r.Passed),
 			r.FailReason,
+			string(r.Failure),
 		}
 		if hasSpeed {
 			row = append(row, fmt.Sprintf("%.2f", r.DownloadMbps))
 		}
+		if hasCurve {
+			curve := make([]string, len(r.Throughput))
+			for i, v := range r.Throughput {
+				curve[i] = fmt.Sprintf("%.2f", v)
+			}
+			row = append(row,
+				fmt.Sprintf("%.2f", r.SustainedMbps),
+				fmt.Sprintf("%.0f", r.ThroughputDrop),
+				fmt.Sprintf("%d", r.Stalls),
+				fmt.Sprintf("%t", r.Throttled),
+				r.ThrottleReason,
+				strings.Join(curve, " "))
+		}
 		w.Write(row)
 	}
 	w.Flush()
@@ -637,11 +823,20 @@ func SavePhase2Results(results []Phase2Result, format string, path string) error
 
 // GeneratePhase2OutputPath generates a timestamped output file path for phase2 results
 func GeneratePhase2OutputPath(format string) string {
+	return generatePhaseOutputPath(format, "phase2")
+}
+
+// GeneratePhase3OutputPath generates a timestamped output file path for speed test results
+func GeneratePhase3OutputPath(format string) string {
+	return generatePhaseOutputPath(format, "phase3")
+}
+
+func generatePhaseOutputPath(format, phase string) string {
 	timestamp := time.Now().Format("2006-01-02_150405")
 	ext := format
 	if ext == "" {
 		ext = "csv"
 	}
-	filename := fmt.Sprintf("%s_phase2.%s", timestamp, ext)
+	filename := fmt.Sprintf("%s_%s.%s", timestamp, phase, ext)
 	return filepath.Join("results", filename)
 }
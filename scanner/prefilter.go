@@ -0,0 +1,288 @@
+package scanner
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"piyazche/config"
+	"piyazche/utils"
+	"piyazche/xray"
+)
+
+// PrefilterStats آمار pre-filter: چند IP قبل از xray چک شد و چرا حذف شد
+type PrefilterStats struct {
+	Checked int64 `json:"checked"`
+	Passed  int64 `json:"passed"`
+	Timeout int64 `json:"timeout"`
+	Refused int64 `json:"refused"`
+	Reset   int64 `json:"reset"`
+	TLS     int64 `json:"tls"` // TCP وصل شد ولی handshake نه
+}
+
+// Dropped تعداد IP هایی که pre-filter حذف کرد
+func (p PrefilterStats) Dropped() int64 {
+	return p.Checked - p.Passed
+}
+
+// Prefilter قبل از بالا آوردن xray یه TCP connect و TLS ClientHello مستقیم
+// به ip:port میزنه (با همون SNI/ALPN و fragment کانفیگ) تا IP های مرده
+// یا reset شده هزینه‌ی تست xray رو نداشته باشن
+type Prefilter struct {
+	cfg     *config.Config
+	port    string
+	timeout time.Duration
+	threads int
+
+	checked, passed                atomic.Int64
+	timeouts, refused, resets, tls atomic.Int64
+}
+
+// NewPrefilter از scan.preFilter می‌سازه؛ اگه خاموش باشه یا transport روی
+// UDP باشه nil برمیگردونه
+func NewPrefilter(cfg *config.Config) *Prefilter {
+	pf := cfg.Scan.PreFilter
+	if !pf.Enabled || udpTransport(cfg) {
+		return nil
+	}
+	threads := pf.Threads
+	if threads <= 0 {
+		threads = cfg.Scan.Threads * 4
+	}
+	if threads <= 0 {
+		threads = 64
+	}
+	timeout := time.Duration(pf.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	return &Prefilter{cfg: cfg, port: edgePort(cfg), timeout: timeout, threads: threads}
+}
+
+// Stats آمار تا این لحظه
+func (p *Prefilter) Stats() PrefilterStats {
+	return PrefilterStats{
+		Checked: p.checked.Load(),
+		Passed:  p.passed.Load(),
+		Timeout: p.timeouts.Load(),
+		Refused: p.refused.Load(),
+		Reset:   p.resets.Load(),
+		TLS:     p.tls.Load(),
+	}
+}
+
+// runPrefilter IP های in رو با threads تا همزمان چک می‌کنه، زنده‌ها رو به
+// out میده و بقیه رو مثل یه تست ناموفق ثبت می‌کنه؛ آخر کار out رو می‌بنده
+func (s *Scanner) runPrefilter(in <-chan string, out chan<- string, processed *atomic.Int64, adaptive *utils.AdaptiveSource) {
+	var wg sync.WaitGroup
+	for i := 0; i < s.prefilter.threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range in {
+				failure, err := s.prefilter.Check(s.ctx, ip)
+				if s.ctx.Err() != nil {
+					continue // بعد از resume دوباره تست میشه
+				}
+				if err != nil {
+					s.results.Add(Result{IP: ip, Error: err.Error(), Failure: failure})
+					if adaptive != nil {
+						adaptive.Observe(ip, false, 0)
+					}
+					processed.Add(1)
+					continue
+				}
+				select {
+				case out <- ip:
+				case <-s.quit:
+				case <-s.ctx.Done():
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(out)
+}
+
+// Check یه IP رو چک می‌کنه؛ nil یعنی edge جواب داد و ارزش تست xray داره.
+// Failure خطا از xray.ClassifyError میاد
+func (p *Prefilter) Check(ctx context.Context, ip string) (xray.Failure, error) {
+	p.checked.Add(1)
+	err := p.handshake(ctx, ip)
+	if err == nil {
+		p.passed.Add(1)
+		return "", nil
+	}
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	failure := xray.ClassifyError(err)
+	switch failure {
+	case xray.FailTimeout:
+		p.timeouts.Add(1)
+		return failure, fmt.Errorf("prefilter: timeout after %s", p.timeout)
+	case xray.FailRefused:
+		p.refused.Add(1)
+		return failure, fmt.Errorf("prefilter: connection refused")
+	case xray.FailReset:
+		p.resets.Add(1)
+		return failure, fmt.Errorf("prefilter: connection reset: %w", err)
+	}
+	p.tls.Add(1)
+	return failure, fmt.Errorf("prefilter: %w", err)
+}
+
+func (p *Prefilter) handshake(ctx context.Context, ip string) error {
+	_, _, err := dialEdge(ctx, p.cfg, ip, p.port, p.timeout)
+	return err
+}
+
+// dialEdge مستقیم به ip:port یه TCP connect و، اگه کانفیگ TLS داره، همون
+// ClientHello ای که xray میفرسته میزنه و زمان هر کدوم رو جدا برمیگردونه.
+// از پشت SOCKS این دو تا دیده نمیشن، برای همین فاز edge رو اینجا می‌گیریم
+func dialEdge(ctx context.Context, cfg *config.Config, ip, port string, timeout time.Duration) (connect, handshake time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	connect = time.Since(start)
+
+	// بدون TLS همون TCP connect کافیه
+	sni, alpn := edgeServerName(cfg.Proxy)
+	if cfg.Proxy.Method == "none" || sni == "" {
+		return connect, 0, nil
+	}
+
+	if f := cfg.Fragment; f.Enabled && f.Mode != "off" {
+		conn = newFragmentConn(conn, f.Manual.Length, f.Manual.Interval)
+	}
+	tc := tls.Client(conn, &tls.Config{
+		ServerName:         sni,
+		NextProtos:         alpn,
+		InsecureSkipVerify: true, // فقط رسیدن به edge مهمه، تست واقعی با xray هست
+	})
+	tlsStart := time.Now()
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return connect, 0, err
+	}
+	return connect, time.Since(tlsStart), nil
+}
+
+// edgeTiming زمان connect و TLS مستقیم به edge برای نتایج موفق؛ روی UDP
+// (hysteria2/quic) یا اگه dial نشد صفر برمیگردونه
+func edgeTiming(ctx context.Context, cfg *config.Config, ip string, timeout time.Duration) (connect, handshake time.Duration) {
+	if udpTransport(cfg) {
+		return 0, 0
+	}
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	connect, handshake, err := dialEdge(ctx, cfg, ip, edgePort(cfg), timeout)
+	if err != nil {
+		return 0, 0
+	}
+	return connect, handshake
+}
+
+// udpTransport یعنی edge با TCP/TLS دیده نمیشه
+func udpTransport(cfg *config.Config) bool {
+	return cfg.Proxy.GetProtocol() == "hysteria2" || cfg.Proxy.Type == "quic"
+}
+
+func edgePort(cfg *config.Config) string {
+	if cfg.Proxy.Port <= 0 {
+		return "443"
+	}
+	return strconv.Itoa(cfg.Proxy.Port)
+}
+
+// edgeServerName همون SNI و ALPN ای که xray میفرسته
+func edgeServerName(px config.ProxyConfig) (string, []string) {
+	switch {
+	case px.Method == "reality" && px.Reality != nil:
+		return px.Reality.ServerName, nil
+	case px.TLS != nil:
+		sni := px.TLS.SNI
+		if sni == "" {
+			sni = px.Address
+		}
+		return sni, px.TLS.ALPN
+	}
+	return "", nil
+}
+
+// fragmentConn مثل fragment خود xray اولین write (ClientHello) رو تیکه
+// تیکه با فاصله میفرسته؛ length و interval به شکل "10-20"
+type fragmentConn struct {
+	net.Conn
+	once     sync.Once
+	length   [2]int
+	interval [2]int
+}
+
+func newFragmentConn(conn net.Conn, length, interval string) net.Conn {
+	return &fragmentConn{Conn: conn, length: parseSpan(length, 10), interval: parseSpan(interval, 10)}
+}
+
+func (c *fragmentConn) Write(b []byte) (int, error) {
+	first := false
+	c.once.Do(func() { first = true })
+	if !first {
+		return c.Conn.Write(b)
+	}
+	written := 0
+	for written < len(b) {
+		n := spanRand(c.length)
+		if n < 1 {
+			n = 1
+		}
+		if written+n > len(b) {
+			n = len(b) - written
+		}
+		m, err := c.Conn.Write(b[written : written+n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		if written < len(b) {
+			time.Sleep(time.Duration(spanRand(c.interval)) * time.Millisecond)
+		}
+	}
+	return written, nil
+}
+
+// parseSpan "10-20" یا "15" رو به [min, max] تبدیل می‌کنه
+func parseSpan(s string, def int) [2]int {
+	lo, hi, found := strings.Cut(strings.TrimSpace(s), "-")
+	a, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return [2]int{def, def}
+	}
+	b := a
+	if found {
+		if v, err := strconv.Atoi(strings.TrimSpace(hi)); err == nil && v >= a {
+			b = v
+		}
+	}
+	return [2]int{a, b}
+}
+
+func spanRand(span [2]int) int {
+	if span[1] <= span[0] {
+		return span[0]
+	}
+	return span[0] + rand.Intn(span[1]-span[0]+1)
+}
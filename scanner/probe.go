@@ -0,0 +1,34 @@
+package scanner
+
+import (
+	"net/http"
+
+	"piyazche/config"
+	"piyazche/xray"
+)
+
+// BuildProbePlan probe های scan.probes رو به ترتیب می‌سازه؛ اگه خالی باشه
+// همون GET به testUrl هست
+func BuildProbePlan(cfg *config.Config) xray.ProbePlan {
+	if len(cfg.Scan.Probes) == 0 {
+		return xray.ProbePlan{xray.HTTPProbe{URL: cfg.Scan.TestURL}}
+	}
+	plan := make(xray.ProbePlan, 0, len(cfg.Scan.Probes))
+	for _, p := range cfg.Scan.Probes {
+		switch p.Type {
+		case "http":
+			plan = append(plan, xray.HTTPProbe{URL: p.URL, Status: p.Status, BodySHA256: p.BodySHA256})
+		case "head":
+			plan = append(plan, xray.HTTPProbe{URL: p.URL, Method: http.MethodHead, Status: p.Status})
+		case "ws":
+			plan = append(plan, xray.WebSocketProbe{URL: p.URL, Message: p.Message})
+		case "doh":
+			plan = append(plan, xray.DoHProbe{URL: p.URL, Domain: p.Domain})
+		case "download":
+			plan = append(plan, xray.DownloadProbe{URL: p.URL, Bytes: int64(p.KB) * 1024})
+		case "tcp":
+			plan = append(plan, xray.TCPProbe{Addr: p.Addr, Send: p.Send, Expect: p.Expect})
+		}
+	}
+	return plan
+}
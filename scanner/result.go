@@ -7,10 +7,12 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"piyazche/utils"
+	"piyazche/xray"
 )
 
 // Result represents the scan result for a single IP
@@ -19,18 +21,27 @@ type Result struct {
 	Success       bool          `json:"success"`
 	Latency       time.Duration `json:"latency"`
 	LatencyMs     int64         `json:"latency_ms"`
+	EdgeConnectMs int64         `json:"edge_connect_ms,omitempty"` // direct TCP connect to ip:port
+	EdgeTLSMs     int64         `json:"edge_tls_ms,omitempty"`     // direct TLS handshake with the edge
+	TunnelMs      int64         `json:"tunnel_ms,omitempty"`       // phases of Latency, see xray.TestResult
+	TTFBMs        int64         `json:"ttfb_ms,omitempty"`
 	StatusCode    int           `json:"status_code,omitempty"`
 	Error         string        `json:"error,omitempty"`
+	Failure       xray.Failure  `json:"failure,omitempty"` // class of Error
 	TestedAt      time.Time     `json:"tested_at"`
 	DownloadMbps  float64       `json:"download_mbps,omitempty"`
 	UploadMbps    float64       `json:"upload_mbps,omitempty"`
 	PacketLossPct float64       `json:"packet_loss_pct,omitempty"`
+	Probes        []xray.ProbeOutcome `json:"probes,omitempty"` // per probe, only with scan.probes set
 }
 
 // ResultCollector collects and manages scan results
 type ResultCollector struct {
 	results []Result
 	mu      sync.RWMutex
+
+	// OnAdd, when set before the scan starts, is called with every new result
+	OnAdd func(Result)
 }
 
 // NewResultCollector creates a new result collector
@@ -43,10 +54,20 @@ func NewResultCollector() *ResultCollector {
 // Add adds a result to the collection
 func (rc *ResultCollector) Add(result Result) {
 	rc.mu.Lock()
-	defer rc.mu.Unlock()
 	result.LatencyMs = result.Latency.Milliseconds()
 	result.TestedAt = time.Now()
 	rc.results = append(rc.results, result)
+	rc.mu.Unlock()
+	if rc.OnAdd != nil {
+		rc.OnAdd(result)
+	}
+}
+
+// restore adds a result loaded from a checkpoint, keeping its original timestamp
+func (rc *ResultCollector) restore(result Result) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.results = append(rc.results, result)
 }
 
 // GetResults returns a copy of all results
@@ -72,6 +93,34 @@ func (rc *ResultCollector) GetSuccessful() []Result {
 	return successful
 }
 
+// FailureCounts counts the failed results per failure class
+func (rc *ResultCollector) FailureCounts() map[xray.Failure]int {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	counts := make(map[xray.Failure]int)
+	for _, r := range rc.results {
+		if !r.Success {
+			f := r.Failure
+			if f == "" {
+				f = xray.FailOther
+			}
+			counts[f]++
+		}
+	}
+	return counts
+}
+
+// FormatFailures یه خط مثل "12 timeout, 3 reset" به ترتیب xray.Failures
+func FormatFailures(counts map[xray.Failure]int) string {
+	var parts []string
+	for _, f := range xray.Failures {
+		if counts[f] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[f], f))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
 // GetSortedByLatency returns results sorted by latency (ascending)
 func (rc *ResultCollector) GetSortedByLatency() []Result {
 	results := rc.GetSuccessful()
@@ -120,24 +169,29 @@ func (rc *ResultCollector) SaveToCSV(path string) error {
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
 
-	header := []string{"IP", "Latency (ms)", "Download (Mbps)", "Upload (Mbps)", "Packet Loss (%)", "Status", "Tested At"}
+	header := []string{"IP", "Latency (ms)", "Edge Connect (ms)", "Edge TLS (ms)", "Tunnel (ms)", "TTFB (ms)", "Download (Mbps)", "Upload (Mbps)", "Packet Loss (%)", "Status", "Failure", "Tested At"}
 	if err := writer.Write(header); err != nil {
 		return fmt.Errorf("failed to write header: %w", err)
 	}
 
 	for _, r := range results {
 		status := "failed"
-		if r.StatusCode >= 200 && r.StatusCode < 300 {
+		if r.Success { // tcp/ws probes have no status code
 			status = "success"
 		}
 
 		row := []string{
 			r.IP,
 			fmt.Sprintf("%d", r.LatencyMs),
+			fmt.Sprintf("%d", r.EdgeConnectMs),
+			fmt.Sprintf("%d", r.EdgeTLSMs),
+			fmt.Sprintf("%d", r.TunnelMs),
+			fmt.Sprintf("%d", r.TTFBMs),
 			fmt.Sprintf("%.2f", r.DownloadMbps),
 			fmt.Sprintf("%.2f", r.UploadMbps),
 			fmt.Sprintf("%.1f", r.PacketLossPct),
 			status,
+			string(r.Failure),
 			r.TestedAt.Format(time.RFC3339),
 		}
 		if err := writer.Write(row); err != nil {
@@ -203,14 +257,15 @@ func (rc *ResultCollector) PrintTopResults(n int) {
 
 	if hasSpeed {
 		fmt.Printf("\n%s%sTop %d IPs by Latency%s\n", utils.Bold, utils.Cyan, n, utils.Reset)
-		fmt.Printf("%s┌──────────────────────┬──────────────┬──────────────────┬──────────────────┬──────────────┐%s\n", utils.Gray, utils.Reset)
-		fmt.Printf("%s│%s %-20s %s│%s %12s %s│%s %16s %s│%s %16s %s│%s %12s %s│%s\n",
+		fmt.Printf("%s┌──────────────────────┬──────────────┬────────────────────┬──────────────────┬──────────────────┬──────────────┐%s\n", utils.Gray, utils.Reset)
+		fmt.Printf("%s│%s %-20s %s│%s %12s %s│%s %26s %s│%s %16s %s│%s %16s %s│%s %12s %s│%s\n",
 			utils.Gray, utils.Reset, utils.Bold+"IP"+utils.Reset, utils.Gray, utils.Reset,
 			utils.Bold+"Latency"+utils.Reset, utils.Gray, utils.Reset,
+			utils.Bold+"TCP/TLS/Tun/TTFB"+utils.Reset, utils.Gray, utils.Reset,
 			utils.Bold+"Download"+utils.Reset, utils.Gray, utils.Reset,
 			utils.Bold+"Upload"+utils.Reset, utils.Gray, utils.Reset,
 			utils.Bold+"Pkt Loss"+utils.Reset, utils.Gray, utils.Reset)
-		fmt.Printf("%s├──────────────────────┼──────────────┼──────────────────┼──────────────────┼──────────────┤%s\n", utils.Gray, utils.Reset)
+		fmt.Printf("%s├──────────────────────┼──────────────┼────────────────────┼──────────────────┼──────────────────┼──────────────┤%s\n", utils.Gray, utils.Reset)
 
 		for i := 0; i < n; i++ {
 			r := results[i]
@@ -243,22 +298,24 @@ func (rc *ResultCollector) PrintTopResults(n int) {
 			}
 
 			rank := fmt.Sprintf("%d.", i+1)
-			fmt.Printf("%s│%s %s%-2s%-17s %s│%s %s%8dms%s   %s│%s %s%12.2f Mbps%s %s│%s %s%12.2f Mbps%s %s│%s %s%9.1f%%%s    %s│%s\n",
+			fmt.Printf("%s│%s %s%-2s%-17s %s│%s %s%8dms%s   %s│%s %s%18s%s %s│%s %s%12.2f Mbps%s %s│%s %s%12.2f Mbps%s %s│%s %s%9.1f%%%s    %s│%s\n",
 				utils.Gray, utils.Reset, utils.Dim, rank, utils.Cyan+r.IP+utils.Reset, utils.Gray, utils.Reset,
 				latencyColor, r.LatencyMs, utils.Reset, utils.Gray, utils.Reset,
+				utils.Gray, latencyBreakdown(r), utils.Reset, utils.Gray, utils.Reset,
 				dlColor, r.DownloadMbps, utils.Reset, utils.Gray, utils.Reset,
 				ulColor, r.UploadMbps, utils.Reset, utils.Gray, utils.Reset,
 				plColor, r.PacketLossPct, utils.Reset, utils.Gray, utils.Reset)
 		}
-		fmt.Printf("%s└──────────────────────┴──────────────┴──────────────────┴──────────────────┴──────────────┘%s\n\n", utils.Gray, utils.Reset)
+		fmt.Printf("%s└──────────────────────┴──────────────┴────────────────────┴──────────────────┴──────────────────┴──────────────┘%s\n\n", utils.Gray, utils.Reset)
 	} else {
 		fmt.Printf("\n%s%sTop %d IPs by Latency%s\n", utils.Bold, utils.Cyan, n, utils.Reset)
-		fmt.Printf("%s┌──────────────────────┬──────────────┬──────────────┐%s\n", utils.Gray, utils.Reset)
-		fmt.Printf("%s│%s %-20s %s│%s %12s %s│%s %12s %s│%s\n",
+		fmt.Printf("%s┌──────────────────────┬──────────────┬────────────────────┬──────────────┐%s\n", utils.Gray, utils.Reset)
+		fmt.Printf("%s│%s %-20s %s│%s %12s %s│%s %26s %s│%s %12s %s│%s\n",
 			utils.Gray, utils.Reset, utils.Bold+"IP"+utils.Reset, utils.Gray, utils.Reset,
 			utils.Bold+"Latency"+utils.Reset, utils.Gray, utils.Reset,
+			utils.Bold+"TCP/TLS/Tun/TTFB"+utils.Reset, utils.Gray, utils.Reset,
 			utils.Bold+"Pkt Loss"+utils.Reset, utils.Gray, utils.Reset)
-		fmt.Printf("%s├──────────────────────┼──────────────┼──────────────┤%s\n", utils.Gray, utils.Reset)
+		fmt.Printf("%s├──────────────────────┼──────────────┼────────────────────┼──────────────┤%s\n", utils.Gray, utils.Reset)
 
 		for i := 0; i < n; i++ {
 			r := results[i]
@@ -277,13 +334,23 @@ func (rc *ResultCollector) PrintTopResults(n int) {
 			}
 
 			rank := fmt.Sprintf("%d.", i+1)
-			fmt.Printf("%s│%s %s%-2s%-17s %s│%s %s%8dms%s   %s│%s %s%9.1f%%%s    %s│%s\n",
+			fmt.Printf("%s│%s %s%-2s%-17s %s│%s %s%8dms%s   %s│%s %s%18s%s %s│%s %s%9.1f%%%s    %s│%s\n",
 				utils.Gray, utils.Reset, utils.Dim, rank, utils.Cyan+r.IP+utils.Reset, utils.Gray, utils.Reset,
 				latencyColor, r.LatencyMs, utils.Reset, utils.Gray, utils.Reset,
+				utils.Gray, latencyBreakdown(r), utils.Reset, utils.Gray, utils.Reset,
 				plColor, r.PacketLossPct, utils.Reset, utils.Gray, utils.Reset)
 		}
-		fmt.Printf("%s└──────────────────────┴──────────────┴──────────────┘%s\n\n", utils.Gray, utils.Reset)
+		fmt.Printf("%s└──────────────────────┴──────────────┴────────────────────┴──────────────┘%s\n\n", utils.Gray, utils.Reset)
+	}
+}
+
+// latencyBreakdown ستون TCP/TLS/Tun/TTFB جدول نتایج، مثلا "12/30/410/140":
+// TCP و TLS مستقیم با edge، Tun کل راه‌اندازی تونل تا origin
+func latencyBreakdown(r Result) string {
+	if r.TunnelMs == 0 && r.TTFBMs == 0 {
+		return "-"
 	}
+	return fmt.Sprintf("%d/%d/%d/%d", r.EdgeConnectMs, r.EdgeTLSMs, r.TunnelMs, r.TTFBMs)
 }
 
 // All همه نتایج رو برمیگردونه (موفق و ناموفق)
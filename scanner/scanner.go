@@ -17,7 +17,7 @@ import (
 // Scanner is the main scanner orchestrator
 type Scanner struct {
 	cfg        *config.Config
-	ips        []string
+	source     utils.IPSource
 	results    *ResultCollector
 	quit       chan struct{}
 	quitOnce   sync.Once
@@ -29,6 +29,13 @@ type Scanner struct {
 	startTime  time.Time
 	debug      bool
 	OnIPStart  func(ip string)
+	Budget     *Budget // limits concurrent tests together with other scanners
+
+	checkpoint     *Checkpoint
+	checkpointPath string
+	finished       map[string]bool // IPs restored from the checkpoint
+
+	prefilter *Prefilter // nil unless scan.preFilter is enabled
 }
 
 // NewScanner creates a new scanner
@@ -47,44 +54,102 @@ func NewScannerWithDebug(cfg *config.Config, debug bool) *Scanner {
 		ctx:     ctx,
 		cancel:  cancel,
 		debug:   debug,
+
+		prefilter: NewPrefilter(cfg),
 	}
 }
 
 // LoadIPs loads IPs from file or CIDR string
 func (s *Scanner) LoadIPs(source string, maxIPs int, shuffle bool) error {
-	var ips []string
+	opts := utils.SourceOptions{SampleSize: s.cfg.Scan.SampleSize, Shuffle: shuffle}
+	if s.cfg.Scan.Adaptive {
+		opts.Adaptive, opts.Budget = true, maxIPs
+	}
+	src, err := OpenIPSource(source, opts)
+	if err != nil {
+		return err
+	}
+	s.source = utils.LimitSource(src, maxIPs)
+	return nil
+}
+
+// LoadSource uses src as the scan targets; IPs are pulled from it lazily
+func (s *Scanner) LoadSource(src utils.IPSource, maxIPs int) {
+	s.source = utils.LimitSource(src, maxIPs)
+}
+
+// UseCheckpoint loads IPs from cp's source, continuing after everything it
+// has already finished, and keeps cp flushed to path while the scan runs
+func (s *Scanner) UseCheckpoint(cp *Checkpoint, path string) error {
+	src, err := cp.OpenSource()
+	if err != nil {
+		return err
+	}
+	s.source = src
+	s.checkpoint = cp
+	s.checkpointPath = path
+	s.finished = make(map[string]bool, len(cp.Results))
+	adaptive, _ := src.(*utils.AdaptiveSource)
+	for _, r := range cp.Results {
+		s.results.restore(r)
+		s.finished[r.IP] = true
+		if adaptive != nil {
+			adaptive.Restore(r.IP, r.Success, r.LatencyMs)
+		}
+	}
+	return nil
+}
+
+// Checkpoint returns the checkpoint set by UseCheckpoint, or nil
+func (s *Scanner) Checkpoint() *Checkpoint {
+	return s.checkpoint
+}
+
+// saveCheckpoint flushes the current progress to the checkpoint file.
+// consumed is how many IPs were taken from the source in this run.
+func (s *Scanner) saveCheckpoint(consumed int64, inFlight int) {
+	if s.checkpoint == nil {
+		return
+	}
+	// IPs still queued or under test are not finished yet, keep them after the cursor
+	safe := int(consumed) - inFlight
+	if safe < 0 {
+		safe = 0
+	}
+	s.checkpoint.Update(s.checkpoint.baseCursor+safe, s.results.GetResults())
+	if err := s.checkpoint.Save(s.checkpointPath); err != nil {
+		fmt.Fprintf(os.Stderr, "%sWarning:%s failed to save checkpoint: %v\n", utils.Yellow, utils.Reset, err)
+	}
+}
+
+// OpenIPSource opens a file path or comma-separated CIDR list as an IP source
+func OpenIPSource(source string, opts utils.SourceOptions) (utils.IPSource, error) {
+	var src utils.IPSource
 	var err error
 
 	if _, statErr := os.Stat(source); statErr == nil {
-		ips, err = utils.ParseIPsFromFile(source, s.cfg.Scan.SampleSize, shuffle)
+		src, err = utils.NewFileSource(source, opts)
 	} else {
-		ips, err = utils.ParseCIDRList(source, s.cfg.Scan.SampleSize)
-		if shuffle {
-			utils.ShuffleIPs(ips)
-		}
+		src, err = utils.NewCIDRSource(source, opts)
 	}
 
 	if err != nil {
-		return fmt.Errorf("failed to load IPs: %w", err)
-	}
-
-	if len(ips) == 0 {
-		return fmt.Errorf("no IPs found in source")
+		return nil, fmt.Errorf("failed to load IPs: %w", err)
 	}
 
-	if maxIPs > 0 && maxIPs < len(ips) {
-		ips = ips[:maxIPs]
+	if src.Len() == 0 {
+		return nil, fmt.Errorf("no IPs found in source")
 	}
 
-	s.ips = ips
-	return nil
+	return src, nil
 }
 
 // Run starts the scanning process
 func (s *Scanner) Run() error {
-	if len(s.ips) == 0 {
+	if s.IPCount() == 0 {
 		return fmt.Errorf("no IPs loaded")
 	}
+	total := s.source.Len()
 
 	s.startTime = time.Now()
 	threads := s.cfg.Scan.Threads
@@ -92,13 +157,31 @@ func (s *Scanner) Run() error {
 		threads = 16
 	}
 
+	// batching workers take several IPs at once, keep enough of them queued
+	batch := s.cfg.Scan.BatchSize
+	if batch < 1 {
+		batch = 1
+	}
+
 	fmt.Printf("%s%sStarting Scan%s\n", utils.Bold, utils.Cyan, utils.Reset)
-	fmt.Printf("   %sIPs:%s %d  %sWorkers:%s %d\n\n", utils.Gray, utils.Reset, len(s.ips), utils.Gray, utils.Reset, threads)
+	if batch > 1 {
+		fmt.Printf("   %sIPs:%s %d  %sWorkers:%s %d  %sBatch:%s %d\n\n", utils.Gray, utils.Reset, total, utils.Gray, utils.Reset, threads, utils.Gray, utils.Reset, batch)
+	} else {
+		fmt.Printf("   %sIPs:%s %d  %sWorkers:%s %d\n\n", utils.Gray, utils.Reset, total, utils.Gray, utils.Reset, threads)
+	}
 
-	jobs := make(chan string, threads*2)
+	jobs := make(chan string, threads*2*batch)
 	logger := make(chan string, threads*4)
 
-	bar := progressbar.NewOptions(len(s.ips),
+	// with the pre-filter the feeder fills feed, and only IPs whose edge
+	// answers move on to jobs
+	feed := jobs
+	if s.prefilter != nil {
+		feed = make(chan string, s.prefilter.threads*2)
+		fmt.Printf("   %sPre-filter:%s TCP + TLS hello, %d at once, %s timeout\n\n", utils.Gray, utils.Reset, s.prefilter.threads, s.prefilter.timeout)
+	}
+
+	bar := progressbar.NewOptions(total,
 		progressbar.OptionEnableColorCodes(true),
 		progressbar.OptionShowCount(),
 		progressbar.OptionShowIts(),
@@ -116,6 +199,13 @@ func (s *Scanner) Run() error {
 	)
 
 	var processed atomic.Int64
+	processed.Store(int64(len(s.finished)))
+	var consumed atomic.Int64
+	var exhausted atomic.Bool
+	inFlight := cap(jobs) + threads*batch + 1
+	if s.prefilter != nil {
+		inFlight += cap(feed) + s.prefilter.threads
+	}
 
 	logDone := make(chan struct{})
 	go func() {
@@ -128,14 +218,37 @@ func (s *Scanner) Run() error {
 
 	var debugOnce sync.Once
 
+	// adaptive sources choose the next IPs from the results so far
+	adaptive, _ := s.source.(*utils.AdaptiveSource)
+
 	var wg sync.WaitGroup
 	for i := 0; i < threads; i++ {
 		wg.Add(1)
 		worker := NewWorker(i, s.cfg, s.results, &wg, jobs, s.quit, s.ctx, &processed, logger, s.debug, &debugOnce)
+		worker.adaptive = adaptive
+		worker.budget = s.Budget
 		worker.Start()
 	}
+	if s.prefilter != nil {
+		go s.runPrefilter(feed, jobs, &processed, adaptive)
+	}
 
 	done := make(chan struct{})
+	if s.checkpoint != nil {
+		go func() {
+			ticker := time.NewTicker(CheckpointInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					s.saveCheckpoint(consumed.Load(), inFlight)
+				}
+			}
+		}()
+	}
+
 	go func() {
 		ticker := time.NewTicker(100 * time.Millisecond)
 		defer ticker.Stop()
@@ -156,23 +269,32 @@ func (s *Scanner) Run() error {
 	}()
 
 	go func() {
-		for _, ip := range s.ips {
+		for {
+			ip, ok := s.source.Next()
+			if !ok {
+				exhausted.Store(true)
+				break
+			}
+			consumed.Add(1)
+			if s.finished[ip] {
+				continue
+			}
 			// بررسی pause قبل از هر IP
 			for {
 				pauseCh := s.pauseChannel()
 				select {
 				case <-s.quit:
-					close(jobs)
+					close(feed)
 					return
 				case <-s.ctx.Done():
-					close(jobs)
+					close(feed)
 					return
 				case <-pauseCh:
 					// paused — منتظر resume بمون
 					for s.IsPaused() {
 						select {
 						case <-s.quit:
-							close(jobs)
+							close(feed)
 							return
 						case <-time.After(200 * time.Millisecond):
 						}
@@ -185,23 +307,40 @@ func (s *Scanner) Run() error {
 
 			select {
 			case <-s.quit:
-				close(jobs)
+				close(feed)
 				return
-			case jobs <- ip:
+			case feed <- ip:
 				if s.OnIPStart != nil {
 					s.OnIPStart(ip)
 				}
 			}
 		}
-		close(jobs)
+		close(feed)
 	}()
 
 	wg.Wait()
+	if adaptive != nil {
+		// a stopped scan leaves the feeder waiting on results that never come
+		adaptive.Close()
+	}
 	close(done)
 	close(logger)
 	<-logDone
 	bar.Finish()
 
+	if s.checkpoint != nil {
+		if exhausted.Load() && s.ctx.Err() == nil {
+			s.checkpoint.FinishPhase1(s.results.GetResults())
+			if err := s.checkpoint.Save(s.checkpointPath); err != nil {
+				fmt.Fprintf(os.Stderr, "%sWarning:%s failed to save checkpoint: %v\n", utils.Yellow, utils.Reset, err)
+			}
+		} else {
+			// IPs still buffered in jobs/feed or waiting on the budget were never
+			// tested, and results after Ctrl+C are dropped, so keep the margin
+			s.saveCheckpoint(consumed.Load(), inFlight)
+		}
+	}
+
 	s.printSummary()
 
 	return nil
@@ -250,6 +389,14 @@ func (s *Scanner) pauseChannel() chan struct{} {
 	return s.pauseCh
 }
 
+// PrefilterStats returns the pre-filter counters; false when the scan runs without one
+func (s *Scanner) PrefilterStats() (PrefilterStats, bool) {
+	if s.prefilter == nil {
+		return PrefilterStats{}, false
+	}
+	return s.prefilter.Stats(), true
+}
+
 // GetResults returns the result collector
 func (s *Scanner) GetResults() *ResultCollector {
 	return s.results
@@ -276,6 +423,28 @@ func (s *Scanner) printSummary() {
 		utils.Green, successful, utils.Reset,
 		rateColor, successRate, utils.Reset)
 
+	if adaptive, ok := s.source.(*utils.AdaptiveSource); ok {
+		subnets, productive, abandoned := adaptive.Stats()
+		fmt.Printf("  %s%-18s%s %s%d%s probed, %s%d%s productive, %s%d%s abandoned\n",
+			utils.Gray, "Subnets:", utils.Reset,
+			utils.White, subnets, utils.Reset,
+			utils.Green, productive, utils.Reset,
+			utils.Red, abandoned, utils.Reset)
+	}
+
+	if line := FormatFailures(s.results.FailureCounts()); line != "" {
+		fmt.Printf("  %s%-18s%s %s\n", utils.Gray, "Failures:", utils.Reset, line)
+	}
+
+	if st, ok := s.PrefilterStats(); ok && st.Checked > 0 {
+		fmt.Printf("  %s%-18s%s %s%d%s checked, %s%d%s dropped (%.0f%%): %d timeout, %d reset, %d refused, %d tls\n",
+			utils.Gray, "Pre-filter:", utils.Reset,
+			utils.White, st.Checked, utils.Reset,
+			utils.Red, st.Dropped(), utils.Reset,
+			float64(st.Dropped())/float64(st.Checked)*100,
+			st.Timeout, st.Reset, st.Refused, st.TLS)
+	}
+
 	if successful > 0 {
 		sorted := s.results.GetSortedByLatency()
 		latencyColor := utils.Green
@@ -309,16 +478,13 @@ func (s *Scanner) SaveResults(format string, path string) error {
 
 // LoadIPsFromList IP ها رو مستقیم از یه slice لود می‌کنه (برای Shodan integration)
 func (s *Scanner) LoadIPsFromList(ips []string, maxIPs int, shuffle bool) {
-	if shuffle {
-		utils.ShuffleIPs(ips)
-	}
-	if maxIPs > 0 && maxIPs < len(ips) {
-		ips = ips[:maxIPs]
-	}
-	s.ips = ips
+	s.LoadSource(utils.NewListSource(ips, shuffle), maxIPs)
 }
 
 // IPCount تعداد IP های لود شده رو برمیگردونه
 func (s *Scanner) IPCount() int {
-	return len(s.ips)
+	if s.source == nil {
+		return 0
+	}
+	return s.source.Len()
 }
@@ -0,0 +1,165 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"piyazche/config"
+	"piyazche/utils"
+	"piyazche/xray"
+)
+
+var (
+	errXrayConfig = errors.New("failed to generate xray config")
+	errXrayStart  = errors.New("failed to start xray")
+	errXrayReady  = errors.New("xray not ready")
+)
+
+// Tester keeps one long-lived xray instance and points its "proxy" outbound
+// at a different IP for every test, so the core is started once per worker
+// instead of once per IP. A Tester is not safe for concurrent use.
+type Tester struct {
+	cfg          *config.Config
+	ReadyTimeout time.Duration
+	Debug        bool // xray debug output, applies to the next start
+
+	manager *xray.Manager
+	port    int
+}
+
+// NewTester creates a tester; the xray instance starts on the first Target
+func NewTester(cfg *config.Config, readyTimeout time.Duration) *Tester {
+	return &Tester{cfg: cfg, ReadyTimeout: readyTimeout}
+}
+
+// Target routes the tester's SOCKS port to ip and returns the port. A running
+// instance only gets its outbound swapped; it is restarted if that fails.
+func (t *Tester) Target(ctx context.Context, ip string) (int, error) {
+	if t.manager != nil && t.manager.IsRunning() {
+		outbound, err := config.GenerateProxyOutbound(t.cfg, ip)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", errXrayConfig, err)
+		}
+		if err := t.manager.SetOutbound(outbound); err == nil {
+			return t.port, nil
+		}
+		t.Close()
+	}
+	return t.start(ctx, ip)
+}
+
+func (t *Tester) start(ctx context.Context, ip string) (int, error) {
+	manager := xray.NewManagerWithDebug(t.Debug)
+	port := utils.AcquirePort()
+
+	// the port can be taken between AcquirePort and the listen; try another
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			utils.ReleasePort(port)
+			time.Sleep(150 * time.Millisecond)
+			port = utils.AcquirePort()
+		}
+		var xrayConfig []byte
+		xrayConfig, err = config.GenerateXrayConfig(t.cfg, ip, port)
+		if err != nil {
+			utils.ReleasePort(port)
+			return 0, fmt.Errorf("%w: %v", errXrayConfig, err)
+		}
+		if err = manager.Start(xrayConfig, port); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		utils.ReleasePort(port)
+		return 0, fmt.Errorf("%w: %v", errXrayStart, err)
+	}
+
+	if err := manager.WaitForReadyWithContext(ctx, t.ReadyTimeout); err != nil {
+		manager.Stop()
+		utils.ReleasePort(port)
+		return 0, fmt.Errorf("%w: %v", errXrayReady, err)
+	}
+
+	t.manager, t.port = manager, port
+	return port, nil
+}
+
+// Close stops the xray instance; a later Target starts a new one
+func (t *Tester) Close() {
+	if t.manager == nil {
+		return
+	}
+	t.manager.Stop()
+	utils.ReleasePort(t.port)
+	t.manager, t.port = nil, 0
+}
+
+// TesterPool lends idle testers to short, independent checks (the web UI
+// health monitor) so they share running xray instances too. Testers are
+// matched by config fingerprint; idle ones of an older config are closed.
+type TesterPool struct {
+	mu           sync.Mutex
+	idle         []*Tester
+	readyTimeout time.Duration
+	maxIdle      int
+}
+
+// NewTesterPool creates a pool keeping at most maxIdle idle testers
+func NewTesterPool(readyTimeout time.Duration, maxIdle int) *TesterPool {
+	return &TesterPool{readyTimeout: readyTimeout, maxIdle: maxIdle}
+}
+
+// Get returns an idle tester for cfg, or a new one
+func (p *TesterPool) Get(cfg *config.Config) *Tester {
+	key := testerKey(cfg)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	kept := p.idle[:0]
+	var found *Tester
+	for _, t := range p.idle {
+		switch {
+		case testerKey(t.cfg) != key:
+			t.Close()
+		case found == nil:
+			found = t
+		default:
+			kept = append(kept, t)
+		}
+	}
+	p.idle = kept
+	if found != nil {
+		return found
+	}
+	return NewTester(cfg, p.readyTimeout)
+}
+
+// Put hands a tester back for reuse
+func (p *TesterPool) Put(t *Tester) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) >= p.maxIdle {
+		t.Close()
+		return
+	}
+	p.idle = append(p.idle, t)
+}
+
+// Close stops every idle tester
+func (p *TesterPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range p.idle {
+		t.Close()
+	}
+	p.idle = nil
+}
+
+func testerKey(cfg *config.Config) string {
+	return cfg.Fingerprint() + "/" + cfg.Xray.LogLevel
+}
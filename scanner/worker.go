@@ -25,6 +25,10 @@ type Worker struct {
 	logger    chan<- string
 	debug     bool
 	debugOnce *sync.Once
+	adaptive  *utils.AdaptiveSource // fed every result, nil unless adaptive
+	tester    *Tester               // this worker's xray instance, retargeted per IP
+	budget    *Budget               // shared with other scanners, nil is unlimited
+	probes    xray.ProbePlan        // what every IP has to pass, see BuildProbePlan
 }
 
 // NewWorker creates a new scanner worker
@@ -42,6 +46,7 @@ func NewWorker(id int, cfg *config.Config, results *ResultCollector,
 		logger:    logger,
 		debug:     debug,
 		debugOnce: debugOnce,
+		probes:    BuildProbePlan(cfg),
 	}
 }
 
@@ -53,6 +58,14 @@ func (w *Worker) Start() {
 func (w *Worker) run() {
 	defer w.wg.Done()
 
+	if w.cfg.Scan.BatchSize > 1 {
+		w.runBatches(w.cfg.Scan.BatchSize)
+		return
+	}
+
+	w.tester = NewTester(w.cfg, 2*time.Second)
+	defer w.tester.Close()
+
 	for {
 		select {
 		case <-w.ctx.Done():
@@ -68,11 +81,26 @@ func (w *Worker) run() {
 				return
 			default:
 			}
+			if !w.acquireSlot() {
+				return
+			}
 			w.processIP(ip)
+			w.budget.release(1)
 		}
 	}
 }
 
+// acquireSlot takes a budget slot for the next IP. A worker that has to wait
+// for one first stops its idle xray instance, so only workers holding a slot
+// keep an instance and a port; the next Target starts it again.
+func (w *Worker) acquireSlot() bool {
+	if w.budget.tryAcquire() {
+		return true
+	}
+	w.tester.Close()
+	return w.budget.acquire(w.ctx, w.quit)
+}
+
 func (w *Worker) processIP(ip string) {
 	defer w.processed.Add(1)
 
@@ -82,53 +110,152 @@ func (w *Worker) processIP(ip string) {
 	default:
 	}
 
-	result := Result{
-		IP: ip,
+	// One port for all tests of this IP (connectivity + packet loss + speed)
+	port, err := w.target(ip)
+	if err != nil {
+		w.record(Result{IP: ip, Error: err.Error(), Failure: xray.FailXray})
+		return
 	}
+	w.testPort(ip, port)
+}
 
-	maxRetries := w.cfg.Scan.Retries
-	if maxRetries <= 0 {
-		maxRetries = 1
+// runBatches takes up to size IPs at a time and tests them together
+func (w *Worker) runBatches(size int) {
+	for {
+		batch, more := w.nextBatch(size)
+		if len(batch) > 0 {
+			w.processBatch(batch)
+			w.budget.release(len(batch))
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+// nextBatch waits for one IP, then adds whatever else is queued up to size
+// while the budget has room; every IP in the batch holds a budget slot.
+// more is false once the jobs run out or the scan stops.
+func (w *Worker) nextBatch(size int) (batch []string, more bool) {
+	select {
+	case <-w.ctx.Done():
+		return nil, false
+	case <-w.quit:
+		return nil, false
+	case ip, ok := <-w.jobs:
+		if !ok {
+			return nil, false
+		}
+		if !w.budget.acquire(w.ctx, w.quit) {
+			return nil, false
+		}
+		batch = append(batch, ip)
+	}
+	for len(batch) < size {
+		if !w.budget.tryAcquire() {
+			return batch, true
+		}
+		select {
+		case ip, ok := <-w.jobs:
+			if !ok {
+				w.budget.release(1)
+				return batch, false
+			}
+			batch = append(batch, ip)
+		default:
+			w.budget.release(1)
+			return batch, true
+		}
 	}
+	return batch, true
+}
 
-	// Grab a single port and reuse it for all tests (connectivity + packet loss + speed)
-	port := utils.AcquirePort()
-	defer utils.ReleasePort(port)
+// processBatch tests ips concurrently through one xray instance that gives
+// every IP its own SOCKS port and outbound
+func (w *Worker) processBatch(ips []string) {
+	ports := make(map[string]int, len(ips))
+	for _, ip := range ips {
+		if _, dup := ports[ip]; !dup {
+			ports[ip] = utils.AcquirePort()
+		}
+	}
+	defer func() {
+		for _, port := range ports {
+			utils.ReleasePort(port)
+		}
+	}()
 
-	xrayConfig, err := config.GenerateXrayConfig(w.cfg, ip, port)
+	fail := func(err error) {
+		for _, ip := range ips {
+			w.record(Result{IP: ip, Error: err.Error(), Failure: xray.FailXray})
+			w.processed.Add(1)
+		}
+	}
+
+	if w.ctx.Err() != nil {
+		w.processed.Add(int64(len(ips)))
+		return
+	}
+
+	xrayConfig, err := config.GenerateBatchXrayConfig(w.cfg, ports)
 	if err != nil {
-		result.Error = fmt.Sprintf("failed to generate xray config: %v", err)
-		w.results.Add(result)
+		fail(fmt.Errorf("%w: %v", errXrayConfig, err))
 		return
 	}
 
 	showDebug := false
 	if w.debug {
-		w.debugOnce.Do(func() {
-			showDebug = true
-			w.printFragmentDebugInfo(ip, port)
-		})
+		w.debugOnce.Do(func() { showDebug = true })
 	}
 
 	manager := xray.NewManagerWithDebug(showDebug)
-	if err := manager.Start(xrayConfig, port); err != nil {
-		result.Error = fmt.Sprintf("failed to start xray: %v", err)
-		w.results.Add(result)
+	if err := manager.StartBatch(xrayConfig, ports); err != nil {
+		fail(fmt.Errorf("%w: %v", errXrayStart, err))
 		return
 	}
 	defer manager.Stop()
 
-	readyTimeout := 2 * time.Second
+	// every inbound has to come up, so allow a little more than a single one
+	readyTimeout := 2*time.Second + time.Duration(len(ports))*50*time.Millisecond
 	if err := manager.WaitForReadyWithContext(w.ctx, readyTimeout); err != nil {
-		result.Error = fmt.Sprintf("xray not ready: %v", err)
-		w.results.Add(result)
+		fail(fmt.Errorf("%w: %v", errXrayReady, err))
 		return
 	}
+	if showDebug {
+		port, _ := manager.TargetPort(ips[0])
+		w.printFragmentDebugInfo(ips[0], port)
+	}
+
+	var wg sync.WaitGroup
+	for _, ip := range ips {
+		port, _ := manager.TargetPort(ip)
+		wg.Add(1)
+		go func(ip string, port int) {
+			defer wg.Done()
+			defer w.processed.Add(1)
+			w.testPort(ip, port)
+		}(ip, port)
+	}
+	wg.Wait()
+}
+
+// testPort runs the connectivity, packet loss and speed tests of ip through
+// the SOCKS proxy on port and records the result
+func (w *Worker) testPort(ip string, port int) {
+	result := Result{
+		IP: ip,
+	}
+
+	maxRetries := w.cfg.Scan.Retries
+	if maxRetries <= 0 {
+		maxRetries = 1
+	}
 
 	timeout := time.Duration(w.cfg.Scan.Timeout) * time.Second
 
 	// Connectivity test (with retries)
 	var lastErr error
+	var lastFailure xray.Failure
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		select {
 		case <-w.ctx.Done():
@@ -136,24 +263,22 @@ func (w *Worker) processIP(ip string) {
 		default:
 		}
 
-		testResult := xray.TestConnectivityWithContext(w.ctx, port, w.cfg.Scan.TestURL, timeout)
+		testResult, outcomes := w.probes.Run(w.ctx, port, timeout)
 		testResult.IP = ip
-
-		if testResult.Success {
-			if w.cfg.Scan.MaxLatency > 0 && testResult.Latency.Milliseconds() > int64(w.cfg.Scan.MaxLatency) {
-				testResult.Success = false
-				testResult.Error = fmt.Errorf("latency %dms exceeds max %dms",
-					testResult.Latency.Milliseconds(), w.cfg.Scan.MaxLatency)
-			}
+		w.applyLimits(testResult)
+		if len(w.cfg.Scan.Probes) > 0 {
+			result.Probes = outcomes
 		}
 
 		if testResult.Success {
 			result.Success = true
 			result.Latency = testResult.Latency
 			result.StatusCode = testResult.StatusCode
+			result.TunnelMs = testResult.Tunnel.Milliseconds()
+			result.TTFBMs = testResult.TTFB.Milliseconds()
 			break
 		}
-		lastErr = testResult.Error
+		lastErr, lastFailure = testResult.Error, testResult.Failure
 
 		if attempt < maxRetries-1 {
 			select {
@@ -166,6 +291,11 @@ func (w *Worker) processIP(ip string) {
 
 	if !result.Success && lastErr != nil {
 		result.Error = lastErr.Error()
+		result.Failure = lastFailure
+	}
+	if result.Success {
+		connect, handshake := edgeTiming(w.ctx, w.cfg, ip, timeout)
+		result.EdgeConnectMs, result.EdgeTLSMs = connect.Milliseconds(), handshake.Milliseconds()
 	}
 
 	// Packet loss + speed test روی همون xray instance
@@ -211,7 +341,7 @@ func (w *Worker) processIP(ip string) {
 		}
 	}
 
-	w.results.Add(result)
+	w.record(result)
 
 	if w.logger != nil {
 		var logMsg string
@@ -245,73 +375,72 @@ func (w *Worker) processIP(ip string) {
 	}
 }
 
-func (w *Worker) testIP(ip string) *xray.TestResult {
-	select {
-	case <-w.ctx.Done():
-		return &xray.TestResult{IP: ip, Error: w.ctx.Err()}
-	default:
-	}
-
-	// Grab a free port for this test's SOCKS proxy
-	port := utils.AcquirePort()
-	defer utils.ReleasePort(port)
-
-	xrayConfig, err := config.GenerateXrayConfig(w.cfg, ip, port)
-	if err != nil {
-		return &xray.TestResult{
-			IP:    ip,
-			Error: fmt.Errorf("failed to generate xray config: %w", err),
-		}
-	}
-
-	// Debug output only for the first IP to avoid log spam
-	showDebug := false
-	if w.debug {
-		w.debugOnce.Do(func() {
-			showDebug = true
-			w.printFragmentDebugInfo(ip, port)
-		})
+// record stores a finished result. Results of tests cut short by Stop are
+// dropped so a resumed scan tests those IPs again instead of trusting them.
+func (w *Worker) record(result Result) {
+	if w.ctx.Err() != nil {
+		return
 	}
-
-	manager := xray.NewManagerWithDebug(showDebug)
-	if err := manager.Start(xrayConfig, port); err != nil {
-		return &xray.TestResult{
-			IP:    ip,
-			Error: fmt.Errorf("failed to start xray: %w", err),
-		}
+	w.results.Add(result)
+	if w.adaptive != nil {
+		w.adaptive.Observe(result.IP, result.Success, result.LatencyMs)
 	}
-	defer manager.Stop()
+}
 
+func (w *Worker) testIP(ip string) *xray.TestResult {
 	select {
 	case <-w.ctx.Done():
 		return &xray.TestResult{IP: ip, Error: w.ctx.Err()}
 	default:
 	}
 
-	// Wait for xray to spin up and open the port
-	readyTimeout := 2 * time.Second
-	if err := manager.WaitForReadyWithContext(w.ctx, readyTimeout); err != nil {
-		return &xray.TestResult{
-			IP:    ip,
-			Error: fmt.Errorf("xray not ready: %w", err),
-		}
+	port, err := w.target(ip)
+	if err != nil {
+		return &xray.TestResult{IP: ip, Error: err}
 	}
 
 	// Run a test request through the proxy and time it
 	timeout := time.Duration(w.cfg.Scan.Timeout) * time.Second
-	testResult := xray.TestConnectivityWithContext(w.ctx, port, w.cfg.Scan.TestURL, timeout)
+	testResult, _ := w.probes.Run(w.ctx, port, timeout)
 	testResult.IP = ip
+	w.applyLimits(testResult)
 
-	// Too slow? Mark as failed even if it connected
-	if testResult.Success && w.cfg.Scan.MaxLatency > 0 {
-		if testResult.Latency.Milliseconds() > int64(w.cfg.Scan.MaxLatency) {
-			testResult.Success = false
-			testResult.Error = fmt.Errorf("latency %dms exceeds max %dms",
-				testResult.Latency.Milliseconds(), w.cfg.Scan.MaxLatency)
-		}
+	return testResult
+}
+
+// applyLimits marks a connected result as failed when it is too slow overall
+// (maxLatency) or its origin path is (maxTtfbMs), even if the edge is fast
+func (w *Worker) applyLimits(r *xray.TestResult) {
+	if !r.Success {
+		return
+	}
+	if w.cfg.Scan.MaxLatency > 0 && r.Latency.Milliseconds() > int64(w.cfg.Scan.MaxLatency) {
+		r.Success, r.Failure = false, xray.FailLimit
+		r.Error = fmt.Errorf("latency %dms exceeds max %dms",
+			r.Latency.Milliseconds(), w.cfg.Scan.MaxLatency)
+		return
 	}
+	if w.cfg.Scan.MaxTTFBMs > 0 && r.TTFB.Milliseconds() > int64(w.cfg.Scan.MaxTTFBMs) {
+		r.Success, r.Failure = false, xray.FailLimit
+		r.Error = fmt.Errorf("first byte after %dms exceeds max %dms",
+			r.TTFB.Milliseconds(), w.cfg.Scan.MaxTTFBMs)
+	}
+}
 
-	return testResult
+// target points the worker's xray instance at ip and returns its SOCKS port
+func (w *Worker) target(ip string) (int, error) {
+	// Debug output only for the first IP to avoid log spam
+	showDebug := false
+	if w.debug {
+		w.debugOnce.Do(func() { showDebug = true })
+	}
+	w.tester.Debug = showDebug
+	port, err := w.tester.Target(w.ctx, ip)
+	w.tester.Debug = false
+	if err == nil && showDebug {
+		w.printFragmentDebugInfo(ip, port)
+	}
+	return port, err
 }
 
 // printFragmentDebugInfo prints colorized fragment debug information
@@ -342,6 +471,8 @@ func (w *Worker) printFragmentDebugInfo(ip string, port int) {
 	// Connection info
 	fmt.Printf("\n  %s%s▸ Connection Details%s\n", utils.Bold, utils.Yellow, utils.Reset)
 
+	fmt.Printf("    %s%-16s%s %s%s%s\n", utils.Gray, "Protocol:", utils.Reset, utils.Green, w.cfg.Proxy.GetProtocol(), utils.Reset)
+
 	// Show SNI based on method
 	if w.cfg.Proxy.Method == "tls" && w.cfg.Proxy.TLS != nil {
 		fmt.Printf("    %s%-16s%s %s%s%s\n", utils.Gray, "SNI:", utils.Reset, utils.Cyan, w.cfg.Proxy.TLS.SNI, utils.Reset)
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"piyazche/config"
+	"piyazche/history"
+	"piyazche/utils"
+	"piyazche/webui"
+	"piyazche/xray"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	targetFrom    string
+	targetList    string
+	serveListen   string
+	serveSocks    int
+	serveHTTP     int
+	serveProbe    time.Duration
+	serveStatusIv time.Duration
+)
+
+// serveCmd builds the `serve` subcommand: a local proxy over the best IPs
+func serveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run a local SOCKS5/HTTP proxy that balances over the best IPs",
+		Long: `Run a long-lived xray instance with SOCKS5 and HTTP inbounds on localhost
+and one outbound per IP. xray's observatory probes every IP and a leastPing
+balancer sends traffic to the fastest live one.
+
+IPs come from --ips, or --from:
+  session  best IPs of the newest scan of this config in the result history
+  health   alive IPs of the Web UI health monitor (piyazche_ui.json), only
+           when the Web UI's proxy config matches --config
+  auto     health, falling back to session (default)
+
+Exit codes: 0 ok, 1 error, 2 bad flags or config, 3 no IPs, 130 stopped.
+
+Example:
+  piyazche serve -c config.json
+  piyazche serve -c config.json --from session --top 5 --socks 1080 --http 8080
+  piyazche serve -c config.json --ips 104.16.1.2,104.17.3.4`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: runServe,
+	}
+	addConfigFlag(cmd)
+	addTargetFlags(cmd)
+	cmd.Flags().IntVar(&topN, "top", 10, "Number of IPs to balance over")
+	cmd.Flags().StringVar(&serveListen, "listen", "127.0.0.1", "Address the proxy inbounds listen on")
+	cmd.Flags().IntVar(&serveSocks, "socks", 10808, "SOCKS5 port")
+	cmd.Flags().IntVar(&serveHTTP, "http", 10809, "HTTP proxy port (0 = off)")
+	cmd.Flags().DurationVar(&serveProbe, "probe-interval", time.Minute, "How often every IP is probed")
+	cmd.Flags().BoolVar(&debug, "debug", false, "Print the xray config")
+	cmd.Flags().DurationVar(&serveStatusIv, "status-interval", 5*time.Minute, "How often the probe results are printed (0 = never)")
+	addJSONFlag(cmd)
+	return cmd
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	cfg, err := loadCLIConfig()
+	if err != nil {
+		return err
+	}
+	if serveProbe < 10*time.Second {
+		return usageErrorf("--probe-interval must be at least 10s")
+	}
+
+	ips, source, err := targetIPs(cfg, topN)
+	if err != nil {
+		return err
+	}
+
+	xrayCfg, err := config.GenerateGatewayXrayConfig(cfg, ips, serveListen, serveSocks, serveHTTP, serveProbe)
+	if err != nil {
+		return err
+	}
+	mgr := xray.NewManagerWithDebug(debug)
+	if err := mgr.Start(xrayCfg, serveSocks); err != nil {
+		return err
+	}
+	defer mgr.Stop()
+	if err := mgr.WaitForReady(10 * time.Second); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%s%s▸ Serving %d IPs%s %s(%s)%s\n", utils.Bold, utils.Cyan, len(ips), utils.Reset, utils.Gray, source, utils.Reset)
+	fmt.Printf("  %sSOCKS5:%s socks5://%s\n", utils.Gray, utils.Reset, net.JoinHostPort(serveListen, strconv.Itoa(serveSocks)))
+	if serveHTTP > 0 {
+		fmt.Printf("  %sHTTP:%s   http://%s\n", utils.Gray, utils.Reset, net.JoinHostPort(serveListen, strconv.Itoa(serveHTTP)))
+	}
+	fmt.Printf("  %sIPs:%s    %s\n", utils.Gray, utils.Reset, strings.Join(ips, ", "))
+	fmt.Printf("  %sCtrl+C to stop%s\n\n", utils.Dim, utils.Reset)
+	start := map[string]interface{}{"command": "serve", "ips": ips, "source": source,
+		"socks": "socks5://" + net.JoinHostPort(serveListen, strconv.Itoa(serveSocks))}
+	if serveHTTP > 0 {
+		start["http"] = "http://" + net.JoinHostPort(serveListen, strconv.Itoa(serveHTTP))
+	}
+	emit("start", start)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	onInterrupt(cancel)
+
+	var tick <-chan time.Time
+	if serveStatusIv > 0 {
+		ticker := time.NewTicker(serveStatusIv)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return withExit(exitInterrupted, errors.New("serve stopped"))
+		case <-tick:
+			printServeStatus(mgr, ips)
+		}
+	}
+}
+
+// addTargetFlags adds --from and --ips for commands working on the best IPs
+func addTargetFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&targetFrom, "from", "auto", "Where the IPs come from: auto, session or health")
+	cmd.Flags().StringVar(&targetList, "ips", "", "Comma-separated IPs to use instead of --from")
+}
+
+// targetIPs picks up to limit IPs (0 = all) from --ips or --from and says
+// where they came from
+func targetIPs(cfg *config.Config, limit int) ([]string, string, error) {
+	ips, source, err := pickTargets(cfg)
+	if err == nil && len(ips) == 0 {
+		err = withExit(exitNoResults, fmt.Errorf("no IPs from %s", source))
+	}
+	if limit > 0 && len(ips) > limit {
+		ips = ips[:limit]
+	}
+	return ips, source, err
+}
+
+func pickTargets(cfg *config.Config) ([]string, string, error) {
+	if targetList != "" {
+		var ips []string
+		for _, ip := range strings.Split(targetList, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				ips = append(ips, ip)
+			}
+		}
+		return ips, "--ips", nil
+	}
+
+	switch targetFrom {
+	case "auto", "health":
+		ips, err := webui.LoadHealthPool(cfg.Fingerprint())
+		if len(ips) > 0 {
+			return ips, "health monitor", nil
+		}
+		if targetFrom == "health" {
+			if err == nil {
+				err = fmt.Errorf("no alive IPs in the Web UI health monitor")
+			}
+			return nil, "", withExit(exitNoResults, err)
+		}
+		fallthrough
+	case "session":
+		store, err := loadHistory()
+		if err != nil {
+			return nil, "", err
+		}
+		f := history.Filter{ConfigFP: cfg.Fingerprint()}
+		if f.Session = store.LatestSession(f); f.Session == "" {
+			return nil, "", withExit(exitNoResults, fmt.Errorf("no passing IPs for this config in the result history, run a scan first"))
+		}
+		var ips []string
+		for _, r := range store.Best(f, 0) {
+			if r.Passes > 0 {
+				ips = append(ips, r.IP)
+			}
+		}
+		return ips, "session " + f.Session, nil
+	default:
+		return nil, "", usageErrorf("--from must be auto, session or health")
+	}
+}
+
+// printServeStatus prints the observatory's latest probe of every IP
+func printServeStatus(mgr *xray.Manager, ips []string) {
+	statuses, err := mgr.Observation()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sWarning:%s %v\n", utils.Yellow, utils.Reset, err)
+		return
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Alive != statuses[j].Alive {
+			return statuses[i].Alive
+		}
+		return statuses[i].DelayMs < statuses[j].DelayMs
+	})
+
+	alive := 0
+	for _, st := range statuses {
+		if st.Alive {
+			alive++
+		}
+	}
+	fmt.Printf("%s%s%s  %d/%d alive\n", utils.Gray, time.Now().Format("15:04:05"), utils.Reset, alive, len(ips))
+	for _, st := range statuses {
+		ip := st.Tag
+		if i, err := strconv.Atoi(strings.TrimPrefix(st.Tag, config.GatewayOutboundPrefix)); err == nil && i < len(ips) {
+			ip = ips[i]
+		}
+		f := map[string]interface{}{"ip": ip, "success": st.Alive}
+		if st.Alive {
+			fmt.Printf("  %s✓%s %-40s %s%dms%s\n", utils.Green, utils.Reset, ip, utils.Yellow, st.DelayMs, utils.Reset)
+			f["latency_ms"] = st.DelayMs
+		} else {
+			fmt.Printf("  %s✗%s %-40s %s%s%s\n", utils.Red, utils.Reset, ip, utils.Gray, st.Error, utils.Reset)
+			f["error"] = st.Error
+		}
+		emit("check", f)
+	}
+	emit("round", map[string]interface{}{"alive": alive, "total": len(ips)})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"piyazche/utils"
+	"piyazche/webui"
+
+	"github.com/spf13/cobra"
+)
+
+// uiCmd builds the `ui` subcommand: the Web UI server
+func uiCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ui",
+		Short: "Start the Web UI server (24/7 mode)",
+		Long: `Start the Web UI: scan jobs, schedules, the health monitor, subscriptions
+and the REST API at /api/v1. State is kept in piyazche_ui.json.
+
+Example:
+  piyazche ui --port 9090
+  piyazche ui --bind 127.0.0.1 --token SECRET --tls`,
+		Args: usageArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runUI()
+		},
+	}
+	cmd.Flags().IntVar(&uiPort, "port", 9090, "Web UI port")
+	cmd.Flags().StringVar(&uiBind, "bind", "0.0.0.0", "Listen address, e.g. 127.0.0.1 for this machine only")
+	cmd.Flags().StringVar(&uiPassword, "password", "", "Login password (or env PIYAZCHE_UI_PASSWORD)")
+	cmd.Flags().StringVar(&uiToken, "token", "", "Bearer token for API clients (or env PIYAZCHE_UI_TOKEN)")
+	cmd.Flags().BoolVar(&uiTLS, "tls", false, "Serve over HTTPS (self-signed unless --cert/--key are given)")
+	cmd.Flags().StringVar(&uiCert, "cert", "", "TLS certificate file")
+	cmd.Flags().StringVar(&uiKey, "key", "", "TLS private key file")
+	cmd.Flags().IntVar(&uiWorkers, "workers", 0, "IPs tested at once across all scan jobs (default 256)")
+	addJSONFlag(cmd)
+	return cmd
+}
+
+// runUI serves the Web UI until it fails
+func runUI() error {
+	if uiPassword == "" {
+		uiPassword = os.Getenv("PIYAZCHE_UI_PASSWORD")
+	}
+	if uiToken == "" {
+		uiToken = os.Getenv("PIYAZCHE_UI_TOKEN")
+	}
+	if uiPort <= 0 || uiPort > 65535 {
+		return usageErrorf("invalid port %d", uiPort)
+	}
+	uiServer := webui.NewServerWithOptions(uiPort, webui.Options{
+		Bind:     uiBind,
+		Password: uiPassword,
+		Token:    uiToken,
+		TLS:      uiTLS || uiCert != "" || uiKey != "",
+		CertFile: uiCert,
+		KeyFile:  uiKey,
+		Workers:  uiWorkers,
+	})
+	scheme, host := "http", "localhost"
+	if uiTLS || uiCert != "" || uiKey != "" {
+		scheme = "https"
+	}
+	if ip := net.ParseIP(uiBind); ip != nil && !ip.IsUnspecified() {
+		host = ip.String()
+	}
+	url := fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(uiPort)))
+	fmt.Printf("\n%s%s▸ Web UI Mode%s\n", utils.Bold, utils.Cyan, utils.Reset)
+	fmt.Printf("  %sURL:%s %s\n", utils.Gray, utils.Reset, url)
+	fmt.Printf("  %sCtrl+C برای خروج%s\n\n", utils.Dim, utils.Reset)
+	emit("listening", map[string]interface{}{"url": url})
+	if err := uiServer.Start(); err != nil && err.Error() != "http: Server closed" {
+		return fmt.Errorf("web ui error: %w", err)
+	}
+	return nil
+}
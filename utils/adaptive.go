@@ -0,0 +1,362 @@
+package utils
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+)
+
+// AdaptiveSource schedules IPs like a multi-armed bandit over subnets (one
+// arm per 256-address block, /64 for sampled IPv6 prefixes). Every subnet is
+// probed SampleSize times first; the rest of the budget then goes to the
+// subnets with the best UCB1 score over a latency-weighted pass reward, and
+// subnets whose probes all failed are abandoned.
+//
+// Scan results must be fed back with Observe. Next blocks while only pending
+// results could decide what to scan next; Close releases it.
+type AdaptiveSource struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	arms        []*arm
+	blockOwner  map[string]int // contiguous block start -> arm
+	listOwner   map[string]int // explicitly listed IP -> arm
+	order       []int          // exploration order of arms
+	sample      int
+	budget      int
+	total       int
+	explorePass int
+	exploreIdx  int
+	yielded     int
+	observed    int
+	pending     map[string]int // yielded IP awaiting Observe -> arm
+	ready       armHeap
+	closed      bool
+}
+
+type arm struct {
+	idx    int
+	r      ipRange
+	next   uint64          // addresses of r taken so far
+	skip   map[string]bool // restored IPs, never yielded again
+	pulls  int
+	obs    int
+	passes int
+	reward float64
+	dead   bool // abandoned: every probe failed
+	queued bool // in the ready heap
+}
+
+func (a *arm) left() bool { return a.next < a.r.take }
+
+func (a *arm) pull() (string, bool) {
+	for a.next < a.r.take {
+		ip := a.r.at(a.next)
+		a.next++
+		if a.skip[ip] {
+			continue
+		}
+		return ip, true
+	}
+	return "", false
+}
+
+func newAdaptiveSource(rng *rand.Rand, ranges []ipRange, opts SourceOptions) *AdaptiveSource {
+	s := &AdaptiveSource{
+		blockOwner: map[string]int{},
+		listOwner:  map[string]int{},
+		pending:    map[string]int{},
+		sample:     opts.SampleSize,
+		budget:     opts.Budget,
+	}
+	if s.sample <= 0 {
+		s.sample = 1
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	listArms := map[string]int{}
+	for _, r := range ranges {
+		if r.list != nil {
+			for _, ip := range r.list {
+				key := listBlock(ip)
+				i, ok := listArms[key]
+				if !ok {
+					i = len(s.arms)
+					listArms[key] = i
+					s.arms = append(s.arms, &arm{idx: i, r: ipRange{list: []string{}}})
+				}
+				a := s.arms[i]
+				a.r.list = append(a.r.list, ip)
+				a.r.take++
+				s.listOwner[ip] = i
+			}
+			continue
+		}
+
+		// split contiguous ranges at 256-address boundaries
+		for off := uint64(0); off < r.size; {
+			start := addToIP(r.start, off)
+			n := 256 - uint64(start[len(start)-1])
+			if n > r.size-off {
+				n = r.size - off
+			}
+			a := &arm{idx: len(s.arms), r: ipRange{start: start, size: n, take: n}}
+			if opts.Shuffle && n > 1 {
+				a.r.pick = newPermutation(rng, n)
+			}
+			if _, ok := s.blockOwner[blockKey(start)]; !ok {
+				s.blockOwner[blockKey(start)] = len(s.arms)
+			}
+			s.arms = append(s.arms, a)
+			off += n
+		}
+	}
+
+	for _, a := range s.arms {
+		s.total += int(a.r.take)
+	}
+	s.order = make([]int, len(s.arms))
+	for i := range s.order {
+		s.order[i] = i
+	}
+	if opts.Shuffle && len(s.order) > 1 {
+		p := newPermutation(rng, uint64(len(s.order)))
+		for i := range s.order {
+			s.order[i] = int(p.at(uint64(i)))
+		}
+	}
+	return s
+}
+
+// blockKey identifies the 256-address block containing ip
+func blockKey(ip net.IP) string {
+	b := make(net.IP, len(ip))
+	copy(b, ip)
+	b[len(b)-1] = 0
+	return b.String()
+}
+
+// listBlock groups listed IPs: /24 for IPv4, /64 for IPv6
+func listBlock(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return blockKey(v4)
+	}
+	return parsed.Mask(net.CIDRMask(64, 128)).String()
+}
+
+// Next returns the next IP to scan, see AdaptiveSource
+func (s *AdaptiveSource) Next() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if s.closed || (s.budget > 0 && s.yielded >= s.budget) {
+			return "", false
+		}
+		if i, ip, ok := s.explore(); ok {
+			return s.yield(i, ip), true
+		}
+		if i, ip, ok := s.exploit(); ok {
+			return s.yield(i, ip), true
+		}
+		if len(s.pending) == 0 {
+			return "", false
+		}
+		// nothing known to be worth scanning until more results come in
+		s.cond.Wait()
+	}
+}
+
+func (s *AdaptiveSource) yield(i int, ip string) string {
+	s.arms[i].pulls++
+	s.yielded++
+	s.pending[ip] = i
+	return ip
+}
+
+// explore takes the next probe of the initial round-robin over all subnets
+func (s *AdaptiveSource) explore() (int, string, bool) {
+	for s.explorePass < s.sample {
+		for s.exploreIdx < len(s.order) {
+			i := s.order[s.exploreIdx]
+			s.exploreIdx++
+			a := s.arms[i]
+			if a.dead || a.pulls > s.explorePass {
+				continue
+			}
+			if ip, ok := a.pull(); ok {
+				return i, ip, true
+			}
+		}
+		s.explorePass++
+		s.exploreIdx = 0
+	}
+	return 0, "", false
+}
+
+// exploit takes an IP from the subnet with the best current score. Heap
+// scores go stale as results arrive, so the top is re-scored before use.
+func (s *AdaptiveSource) exploit() (int, string, bool) {
+	for s.ready.Len() > 0 {
+		e := heap.Pop(&s.ready).(armScore)
+		a := s.arms[e.arm]
+		if a.dead || !a.left() {
+			a.queued = false
+			continue
+		}
+		fresh := s.score(a)
+		if s.ready.Len() > 0 && fresh < s.ready[0].score {
+			heap.Push(&s.ready, armScore{e.arm, fresh})
+			continue
+		}
+		ip, ok := a.pull()
+		if !ok {
+			a.queued = false
+			continue
+		}
+		heap.Push(&s.ready, armScore{e.arm, s.scoreAfterPull(a)})
+		return e.arm, ip, true
+	}
+	return 0, "", false
+}
+
+// score is UCB1: mean reward plus an exploration bonus that shrinks with the
+// number of pulls, pending ones included so one subnet is not flooded while
+// its results are still out
+func (s *AdaptiveSource) score(a *arm) float64 {
+	mean := a.reward / float64(a.obs)
+	n := math.Max(float64(s.observed), 2)
+	return mean + math.Sqrt(2*math.Log(n)/float64(a.pulls))
+}
+
+func (s *AdaptiveSource) scoreAfterPull(a *arm) float64 {
+	a.pulls++
+	sc := s.score(a)
+	a.pulls--
+	return sc
+}
+
+// Observe feeds back the result of an IP returned by Next
+func (s *AdaptiveSource) Observe(ip string, success bool, latencyMs int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.pending[ip]
+	if !ok {
+		return
+	}
+	delete(s.pending, ip)
+	s.record(s.arms[i], success, latencyMs)
+	s.cond.Broadcast()
+}
+
+// Restore accounts for a result from an earlier run of the same scan (a
+// checkpoint): it counts towards the budget and the subnet's score, and the
+// IP is never yielded again
+func (s *AdaptiveSource) Restore(ip string, success bool, latencyMs int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.listOwner[ip]
+	if !ok {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return
+		}
+		if v4 := parsed.To4(); v4 != nil {
+			parsed = v4
+		}
+		if i, ok = s.blockOwner[blockKey(parsed)]; !ok {
+			return
+		}
+	}
+	a := s.arms[i]
+	if a.skip == nil {
+		a.skip = map[string]bool{}
+	}
+	a.skip[ip] = true
+	a.pulls++
+	s.yielded++
+	s.record(a, success, latencyMs)
+}
+
+func (s *AdaptiveSource) record(a *arm, success bool, latencyMs int64) {
+	a.obs++
+	s.observed++
+	if success {
+		a.passes++
+		// 1 for an instant answer, 0.5 at one second, towards 0 beyond
+		a.reward += 1 / (1 + float64(latencyMs)/1000)
+		if !a.dead && !a.queued && a.left() {
+			a.queued = true
+			heap.Push(&s.ready, armScore{a.idx, s.score(a)})
+		}
+	} else if a.passes == 0 && a.obs >= s.sample {
+		a.dead = true
+	}
+}
+
+// Close makes Next return false, releasing a caller blocked on results
+func (s *AdaptiveSource) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// Len is the IP budget, or every address when there is none. The scan can
+// end earlier when the remaining subnets are abandoned.
+func (s *AdaptiveSource) Len() int {
+	if s.budget > 0 && s.budget < s.total {
+		return s.budget
+	}
+	return s.total
+}
+
+// Skip is a no-op: the adaptive order depends on results, so a resumed scan
+// replays them with Restore instead of skipping a prefix
+func (s *AdaptiveSource) Skip(n int) {}
+
+// Stats reports how many subnets were probed, how many produced a passing IP
+// and how many were abandoned
+func (s *AdaptiveSource) Stats() (subnets, productive, abandoned int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.arms {
+		if a.pulls > 0 {
+			subnets++
+		}
+		if a.passes > 0 {
+			productive++
+		}
+		if a.dead {
+			abandoned++
+		}
+	}
+	return
+}
+
+type armScore struct {
+	arm   int
+	score float64
+}
+
+// armHeap is a max-heap of subnet scores
+type armHeap []armScore
+
+func (h armHeap) Len() int            { return len(h) }
+func (h armHeap) Less(i, j int) bool  { return h[i].score > h[j].score }
+func (h armHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *armHeap) Push(x interface{}) { *h = append(*h, x.(armScore)) }
+func (h *armHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// runAdaptive drains src, passing every IP of good and failing the rest
+func runAdaptive(t *testing.T, src *AdaptiveSource, good string) map[string]int {
+	t.Helper()
+	seen := map[string]bool{}
+	perBlock := map[string]int{}
+	for {
+		ip, ok := src.Next()
+		if !ok {
+			break
+		}
+		if seen[ip] {
+			t.Fatalf("%s yielded twice", ip)
+		}
+		seen[ip] = true
+		block := ip[:strings.LastIndex(ip, ".")]
+		perBlock[block]++
+		src.Observe(ip, block == good, 100)
+	}
+	return perBlock
+}
+
+func TestAdaptiveSourceFavoursProductiveSubnets(t *testing.T) {
+	src := NewTextSource("10.0.0.0/24\n10.0.1.0/24\n10.0.2.0/24", SourceOptions{
+		Adaptive: true, SampleSize: 4, Budget: 60, Shuffle: true, Seed: 1,
+	}).(*AdaptiveSource)
+
+	got := runAdaptive(t, src, "10.0.1")
+	total := got["10.0.0"] + got["10.0.1"] + got["10.0.2"]
+	if total != 60 {
+		t.Errorf("scanned %d IPs, want the budget of 60", total)
+	}
+	// failing subnets get their probes and are then abandoned
+	if got["10.0.0"] != 4 || got["10.0.2"] != 4 {
+		t.Errorf("failing subnets got %d and %d IPs, want 4 probes each", got["10.0.0"], got["10.0.2"])
+	}
+	subnets, productive, abandoned := src.Stats()
+	if subnets != 3 || productive != 1 || abandoned != 2 {
+		t.Errorf("Stats() = %d, %d, %d; want 3, 1, 2", subnets, productive, abandoned)
+	}
+}
+
+func TestAdaptiveSourceRestore(t *testing.T) {
+	opts := SourceOptions{Adaptive: true, SampleSize: 2, Budget: 10, Seed: 3}
+	src := NewTextSource("10.0.0.0/24", opts).(*AdaptiveSource)
+	src.Restore("10.0.0.1", true, 50)
+	src.Restore("10.0.0.2", true, 50)
+
+	got := runAdaptive(t, src, "10.0.0")
+	if got["10.0.0"] != 8 {
+		t.Errorf("scanned %d IPs after restoring 2 of a budget of 10, want 8", got["10.0.0"])
+	}
+}
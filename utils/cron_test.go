@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"0 0 30 2 *", // no February 30th
+		"0 0 31 4,6,9,11 *",
+		"@every 30s",
+		"@every soon",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// Wednesday 2025-01-15 10:07 UTC
+	from := time.Date(2025, 1, 15, 10, 7, 30, 0, time.UTC)
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2025, month, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", at(1, 15, 10, 8)},
+		{"*/15 * * * *", at(1, 15, 10, 15)},
+		{"0-30/10 * * * *", at(1, 15, 10, 10)},
+		{"5,50 * * * *", at(1, 15, 10, 50)},
+		{"30 6 * * *", at(1, 16, 6, 30)},
+		{"0 9-17 * * *", at(1, 15, 11, 0)},
+		{"@hourly", at(1, 15, 11, 0)},
+		{"@daily", at(1, 16, 0, 0)},
+		{"@weekly", at(1, 19, 0, 0)}, // next Sunday
+		{"@monthly", at(2, 1, 0, 0)},
+		{"0 0 * * 7", at(1, 19, 0, 0)},    // 7 is Sunday too
+		{"0 0 * * 1-5", at(1, 16, 0, 0)},  // weekdays
+		{"0 0 * * 6,0", at(1, 18, 0, 0)},  // weekend
+		{"0 0 20 * *", at(1, 20, 0, 0)},   // day of month only
+		{"0 0 20 * 5", at(1, 17, 0, 0)},   // Friday 17th comes before the 20th: either day field matches
+		{"0 0 16 * 1", at(1, 16, 0, 0)},   // the 16th comes before Monday
+		{"0 0 31 * *", at(1, 31, 0, 0)},   // January has one
+		{"0 0 31 2-3 *", at(3, 31, 0, 0)}, // February does not
+		{"0 12 1 */3 *", at(4, 1, 12, 0)}, // quarterly
+		{"@every 6h", from.Add(6 * time.Hour)},
+		{"90m", from.Add(90 * time.Minute)},
+	}
+	for _, tt := range tests {
+		sched, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := sched.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: Next = %s, want %s", tt.spec, got.Format(time.RFC3339), tt.want.Format(time.RFC3339))
+		}
+	}
+
+	// a leap day is years away but still found
+	sched, _ := ParseSchedule("0 0 29 2 *")
+	if got, want := sched.Next(from), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Feb 29: Next = %s, want %s", got.Format(time.RFC3339), want.Format(time.RFC3339))
+	}
+}
@@ -1,94 +1,168 @@
 package utils
 
 import (
-	"bufio"
 	"fmt"
+	"math/big"
 	"math/rand"
 	"net"
-	"os"
 	"strings"
 )
 
-// ParseIPsFromFile reads IP addresses and CIDR blocks from a file
+// ParseIPsFromFile reads IP addresses and CIDR blocks from a file.
+// Prefer NewFileSource for large inputs; this collects the whole list.
 func ParseIPsFromFile(path string, sampleSize int, shuffle bool) ([]string, error) {
-	file, err := os.Open(path)
+	src, err := NewFileSource(path, SourceOptions{SampleSize: sampleSize, Shuffle: shuffle})
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
+	return CollectIPs(src), nil
+}
 
-	var ips []string
-	scanner := bufio.NewScanner(file)
+// DefaultIPv6SampleSize is how many addresses are drawn from an IPv6 prefix
+// when no sample size is given, since such prefixes cannot be enumerated
+const DefaultIPv6SampleSize = 256
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
+// maxIPv6EnumerateBits is the largest IPv6 host part (a /112) that is still
+// walked address by address instead of sampled
+const maxIPv6EnumerateBits = 16
 
-		if strings.Contains(line, "/") {
-			subnetIPs, err := ExpandCIDR(line, sampleSize)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: invalid CIDR %s: %v\n", line, err)
-				continue
-			}
-			ips = append(ips, subnetIPs...)
-		} else {
-			if net.ParseIP(line) != nil {
-				ips = append(ips, line)
-			}
-		}
+// ExpandCIDR expands a CIDR block to individual IP addresses.
+// Optionally samples random IPs if sampleSize > 0.
+// IPv6 prefixes larger than a /112 are sampled without enumeration: prefixes
+// shorter than /64 get one address per /64 stratum, see SampleIPv6.
+func ExpandCIDR(cidr string, sampleSize int) ([]string, error) {
+	if !strings.Contains(cidr, "/") {
+		return nil, fmt.Errorf("invalid CIDR address: %s", cidr)
+	}
+	r, err := parseRange(newRand(0), cidr, sampleSize)
+	if err != nil {
+		return nil, err
 	}
+	return CollectIPs(newRangeSource(nil, []ipRange{r}, false)), nil
+}
+
+// SampleCIDR picks n distinct random addresses from ipnet without enumerating it.
+// The all-zero host address is skipped; fewer than n addresses are returned
+// when the prefix is too small to hold them.
+func SampleCIDR(ipnet *net.IPNet, n int) []string {
+	return sampleCIDR(newRand(0), ipnet, n)
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file: %w", err)
+func sampleCIDR(rng *rand.Rand, ipnet *net.IPNet, n int) []string {
+	ones, bits := ipnet.Mask.Size()
+	hostBits := uint(bits - ones)
+	base := new(big.Int).SetBytes(ipnet.IP.Mask(ipnet.Mask))
+	size := new(big.Int).Lsh(big.NewInt(1), hostBits)
+
+	// a prefix holds size-1 usable addresses; asking for more would never finish
+	if size.IsInt64() && int64(n) > size.Int64()-1 {
+		n = int(size.Int64() - 1)
 	}
 
-	if shuffle {
-		ShuffleIPs(ips)
+	seen := make(map[string]struct{}, n)
+	ips := make([]string, 0, n)
+	for len(ips) < n {
+		offset := randBigInt(rng, size)
+		if offset.Sign() == 0 {
+			continue
+		}
+		ip := bigToIP(new(big.Int).Add(base, offset), bits)
+		key := ip.String()
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+		ips = append(ips, key)
 	}
+	return ips
+}
 
-	return ips, nil
+// SampleIPv6 does stratified sampling over the /64 subnets of an IPv6 prefix
+// shorter than /64: the /64 space is split into `subnets` equal strata, one
+// random /64 is picked from each, and perSubnet random interface IDs are drawn
+// inside it. When the prefix has fewer /64s than requested every /64 is used
+// and perSubnet grows so the sample keeps its size.
+func SampleIPv6(ipnet *net.IPNet, subnets, perSubnet int) []string {
+	return sampleIPv6(newRand(0), ipnet, subnets, perSubnet)
 }
 
-// ExpandCIDR expands a CIDR block to individual IP addresses.
-// Optionally samples random IPs if sampleSize > 0.
-func ExpandCIDR(cidr string, sampleSize int) ([]string, error) {
-	ip, ipnet, err := net.ParseCIDR(cidr)
-	if err != nil {
-		return nil, err
+func sampleIPv6(rng *rand.Rand, ipnet *net.IPNet, subnets, perSubnet int) []string {
+	ones, bits := ipnet.Mask.Size()
+	if bits != 128 || ones >= 64 {
+		return sampleCIDR(rng, ipnet, subnets*perSubnet)
+	}
+	if subnets <= 0 || perSubnet <= 0 {
+		return nil
 	}
 
-	var ips []string
-	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); incrementIP(ip) {
-		// Skip network and broadcast addresses for typical subnets
-		ones, bits := ipnet.Mask.Size()
-		if ones >= 24 && bits == 32 {
-			lastOctet := ip[3]
-			if lastOctet == 0 || lastOctet == 255 {
+	base := new(big.Int).SetBytes(ipnet.IP.Mask(ipnet.Mask))
+	count := new(big.Int).Lsh(big.NewInt(1), uint(64-ones))
+	if count.IsInt64() && int64(subnets) > count.Int64() {
+		// fewer /64s than strata: spread the same sample size over the ones there are
+		total := subnets * perSubnet
+		subnets = int(count.Int64())
+		perSubnet = (total + subnets - 1) / subnets
+	}
+	width := new(big.Int).Div(count, big.NewInt(int64(subnets)))
+	iidSpace := new(big.Int).Lsh(big.NewInt(1), 64)
+
+	ips := make([]string, 0, subnets*perSubnet)
+	for i := 0; i < subnets; i++ {
+		// random /64 inside stratum i
+		idx := new(big.Int).Mul(width, big.NewInt(int64(i)))
+		idx.Add(idx, randBigInt(rng, width))
+		prefix := new(big.Int).Lsh(idx, 64)
+		prefix.Add(prefix, base)
+
+		seen := make(map[string]struct{}, perSubnet)
+		for len(seen) < perSubnet {
+			iid := randBigInt(rng, iidSpace)
+			if iid.Sign() == 0 {
 				continue
 			}
+			key := bigToIP(new(big.Int).Add(prefix, iid), bits).String()
+			if _, dup := seen[key]; dup {
+				continue
+			}
+			seen[key] = struct{}{}
+			ips = append(ips, key)
 		}
-		ips = append(ips, ip.String())
 	}
+	return ips
+}
 
-	if sampleSize > 0 && sampleSize < len(ips) {
-		return SampleIPs(ips, sampleSize), nil
+// newRand returns a generator for seed, or a randomly seeded one when seed is 0
+func newRand(seed int64) *rand.Rand {
+	if seed == 0 {
+		seed = rand.Int63()
 	}
-
-	return ips, nil
+	return rand.New(rand.NewSource(seed))
 }
 
-// incrementIP increments an IP address by 1
-func incrementIP(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
+// randBigInt returns a uniform random integer in [0, max)
+func randBigInt(rng *rand.Rand, max *big.Int) *big.Int {
+	if max.IsInt64() {
+		return big.NewInt(rng.Int63n(max.Int64()))
+	}
+	buf := make([]byte, (max.BitLen()+7)/8)
+	for {
+		rng.Read(buf)
+		// drop the bits above max's bit length so the rejection loop stays short
+		buf[0] &= byte(0xff >> (uint(len(buf)*8 - max.BitLen())))
+		v := new(big.Int).SetBytes(buf)
+		if v.Cmp(max) < 0 {
+			return v
 		}
 	}
 }
 
+// bigToIP converts an integer back to a 4- or 16-byte IP
+func bigToIP(v *big.Int, bits int) net.IP {
+	ip := make(net.IP, bits/8)
+	v.FillBytes(ip)
+	return ip
+}
+
 // SampleIPs randomly samples n IPs from the list
 func SampleIPs(ips []string, n int) []string {
 	if n >= len(ips) {
@@ -113,33 +187,14 @@ func ShuffleIPs(ips []string) {
 	})
 }
 
-// ParseCIDRList parses a comma-separated list of CIDRs
+// ParseCIDRList parses a comma-separated list of CIDRs.
+// Prefer NewCIDRSource for large inputs; this collects the whole list.
 func ParseCIDRList(input string, sampleSize int) ([]string, error) {
-	var ips []string
-	cidrs := strings.Split(input, ",")
-
-	for _, cidr := range cidrs {
-		cidr = strings.TrimSpace(cidr)
-		if cidr == "" {
-			continue
-		}
-
-		if strings.Contains(cidr, "/") {
-			subnetIPs, err := ExpandCIDR(cidr, sampleSize)
-			if err != nil {
-				return nil, fmt.Errorf("invalid CIDR %s: %w", cidr, err)
-			}
-			ips = append(ips, subnetIPs...)
-		} else {
-			if net.ParseIP(cidr) != nil {
-				ips = append(ips, cidr)
-			} else {
-				return nil, fmt.Errorf("invalid IP: %s", cidr)
-			}
-		}
+	src, err := NewCIDRSource(input, SourceOptions{SampleSize: sampleSize})
+	if err != nil {
+		return nil, err
 	}
-
-	return ips, nil
+	return CollectIPs(src), nil
 }
 
 // IsValidIP checks if a string is a valid IP address
@@ -147,6 +202,17 @@ func IsValidIP(ip string) bool {
 	return net.ParseIP(ip) != nil
 }
 
+// IsIPv6 reports whether ip (optionally in [brackets]) is an IPv6 address
+func IsIPv6(ip string) bool {
+	parsed := net.ParseIP(StripBrackets(ip))
+	return parsed != nil && parsed.To4() == nil
+}
+
+// StripBrackets removes the [] around an IPv6 literal, e.g. "[2606:4700::1]"
+func StripBrackets(host string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
 // IsValidCIDR checks if a string is a valid CIDR block
 func IsValidCIDR(cidr string) bool {
 	_, _, err := net.ParseCIDR(cidr)
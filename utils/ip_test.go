@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSampleIPv6(t *testing.T) {
+	tests := []struct {
+		cidr      string
+		subnets   int
+		perSubnet int
+		want      int // total addresses
+		want64s   int // distinct /64s they fall in
+	}{
+		{"2001:db8::/48", 16, 2, 32, 16},
+		{"2001:db8::/62", 16, 2, 32, 4}, // 4 /64s: 8 addresses each
+		{"2001:db8::/63", 3, 1, 4, 2},   // ceil(3/2) = 2 per /64
+		{"2001:db8::/64", 4, 4, 16, 1},  // /64 or longer is a plain sample
+		{"2001:db8::/48", 0, 4, 0, 0},
+	}
+	for _, tt := range tests {
+		_, ipnet, err := net.ParseCIDR(tt.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ips := sampleIPv6(newRand(1), ipnet, tt.subnets, tt.perSubnet)
+		if len(ips) != tt.want {
+			t.Errorf("%s %d×%d: got %d addresses, want %d", tt.cidr, tt.subnets, tt.perSubnet, len(ips), tt.want)
+		}
+
+		seen := map[string]bool{}
+		nets := map[string]bool{}
+		for _, s := range ips {
+			ip := net.ParseIP(s)
+			if ip == nil || !ipnet.Contains(ip) {
+				t.Errorf("%s: %s is outside the prefix", tt.cidr, s)
+				continue
+			}
+			if seen[s] {
+				t.Errorf("%s: %s sampled twice", tt.cidr, s)
+			}
+			seen[s] = true
+			nets[ip.Mask(net.CIDRMask(64, 128)).String()] = true
+		}
+		if len(nets) != tt.want64s {
+			t.Errorf("%s %d×%d: addresses in %d /64s, want %d", tt.cidr, tt.subnets, tt.perSubnet, len(nets), tt.want64s)
+		}
+	}
+}
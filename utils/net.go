@@ -11,6 +11,7 @@ type PortPool struct {
 	endPort   int32
 	current   atomic.Int32
 	inUse     sync.Map
+	used      atomic.Int64
 }
 
 // NewPortPool creates a new port pool
@@ -33,6 +34,7 @@ func (p *PortPool) Acquire() int {
 		}
 
 		if _, loaded := p.inUse.LoadOrStore(port, true); !loaded {
+			p.used.Add(1)
 			return int(port)
 		}
 	}
@@ -40,7 +42,14 @@ func (p *PortPool) Acquire() int {
 
 // Release returns a port to the pool
 func (p *PortPool) Release(port int) {
-	p.inUse.Delete(int32(port))
+	if _, loaded := p.inUse.LoadAndDelete(int32(port)); loaded {
+		p.used.Add(-1)
+	}
+}
+
+// Usage returns how many ports are taken and how many the pool has
+func (p *PortPool) Usage() (inUse, size int) {
+	return int(p.used.Load()), int(p.endPort-p.startPort) + 1
 }
 
 // DefaultPortPool is the default port pool instance
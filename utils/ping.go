@@ -4,12 +4,14 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 // PingResult holds the result of an ICMP ping
@@ -83,7 +85,7 @@ func tcpPing(targetIP string, timeout time.Duration) PingResult {
 	ports := []int{443, 80}
 
 	for _, port := range ports {
-		addr := fmt.Sprintf("%s:%d", targetIP, port)
+		addr := net.JoinHostPort(StripBrackets(targetIP), strconv.Itoa(port))
 		start := time.Now()
 
 		conn, err := net.DialTimeout("tcp", addr, timeout)
@@ -99,13 +101,27 @@ func tcpPing(targetIP string, timeout time.Duration) PingResult {
 
 // icmpPing sends a real ICMP echo request (requires root)
 func icmpPing(targetIP string, timeout time.Duration) PingResult {
-	dst, err := net.ResolveIPAddr("ip4", targetIP)
+	targetIP = StripBrackets(targetIP)
+	v6 := IsIPv6(targetIP)
+
+	network, listenNet, listenAddr, proto := "ip4", "ip4:icmp", "0.0.0.0", 1
+	var echoType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if v6 {
+		network, listenNet, listenAddr, proto = "ip6", "ip6:ipv6-icmp", "::", 58
+		echoType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	dst, err := net.ResolveIPAddr(network, targetIP)
 	if err != nil {
 		return PingResult{Success: false, Error: err}
 	}
 
-	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	conn, err := icmp.ListenPacket(listenNet, listenAddr)
 	if err != nil {
+		if v6 {
+			// raw ICMPv6 may be unavailable even when ICMPv4 works
+			return tcpPing(targetIP, timeout)
+		}
 		return PingResult{Success: false, Error: err}
 	}
 	defer conn.Close()
@@ -132,7 +148,7 @@ func icmpPing(targetIP string, timeout time.Duration) PingResult {
 	}
 
 	msg := icmp.Message{
-		Type: ipv4.ICMPTypeEcho,
+		Type: echoType,
 		Code: 0,
 		Body: &icmp.Echo{
 			ID:   echoID,
@@ -167,12 +183,12 @@ func icmpPing(targetIP string, timeout time.Duration) PingResult {
 
 		latency := time.Since(start)
 
-		parsed, err := icmp.ParseMessage(1, reply[:n])
+		parsed, err := icmp.ParseMessage(proto, reply[:n])
 		if err != nil {
 			continue
 		}
 
-		if parsed.Type == ipv4.ICMPTypeEchoReply {
+		if parsed.Type == replyType {
 			if echo, ok := parsed.Body.(*icmp.Echo); ok {
 				if echo.ID == echoID && echo.Seq == echoSeq {
 					return PingResult{Success: true, Latency: latency}
@@ -0,0 +1,355 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// IPSource yields scan targets one at a time so large ranges never have to
+// be materialised as a []string. Next is called from a single goroutine.
+type IPSource interface {
+	// Next returns the next IP, or ok=false once the source is exhausted
+	Next() (ip string, ok bool)
+	// Len is the total number of IPs the source yields
+	Len() int
+	// Skip advances past the next n IPs without producing them
+	Skip(n int)
+}
+
+// SourceOptions controls how range-based sources sample and order IPs
+type SourceOptions struct {
+	SampleSize int  // IPs per subnet, 0 = all
+	Shuffle    bool // walk the IPs in a random order
+	// Seed makes sampling and shuffling reproducible: the same input and
+	// seed always yield the same sequence. 0 picks a random seed.
+	Seed int64
+	// Adaptive returns an *AdaptiveSource: SampleSize becomes the initial
+	// probes per subnet and Budget caps the total (0 = no cap)
+	Adaptive bool
+	Budget   int
+}
+
+// parseSample is the per-range sample size used while parsing; adaptive
+// sources keep whole ranges and sample subnets themselves
+func (o SourceOptions) parseSample() int {
+	if o.Adaptive {
+		return 0
+	}
+	return o.SampleSize
+}
+
+func buildSource(rng *rand.Rand, ranges []ipRange, opts SourceOptions) IPSource {
+	if opts.Adaptive {
+		return newAdaptiveSource(rng, ranges, opts)
+	}
+	return newRangeSource(rng, ranges, opts.Shuffle)
+}
+
+// NewFileSource reads IPs and CIDR blocks from a file, one per line.
+// Only one small range record is kept per line, never one string per IP.
+func NewFileSource(path string, opts SourceOptions) (IPSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	rng := newRand(opts.Seed)
+	ranges, err := readRanges(rng, file, opts.parseSample())
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+	return buildSource(rng, ranges, opts), nil
+}
+
+// NewTextSource is NewFileSource for newline-separated input already in memory
+func NewTextSource(text string, opts SourceOptions) IPSource {
+	rng := newRand(opts.Seed)
+	ranges, _ := readRanges(rng, strings.NewReader(text), opts.parseSample())
+	return buildSource(rng, ranges, opts)
+}
+
+// NewCIDRSource parses a comma-separated list of IPs and CIDRs.
+// Unlike the file source, any invalid entry is an error.
+func NewCIDRSource(input string, opts SourceOptions) (IPSource, error) {
+	rng := newRand(opts.Seed)
+	var ranges []ipRange
+	for _, entry := range strings.Split(input, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		r, err := parseRange(rng, entry, opts.parseSample())
+		if err != nil {
+			if strings.Contains(entry, "/") {
+				return nil, fmt.Errorf("invalid CIDR %s: %w", entry, err)
+			}
+			return nil, err
+		}
+		if r.take > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	return buildSource(rng, ranges, opts), nil
+}
+
+// NewListSource wraps an in-memory IP list (e.g. a Shodan harvest).
+// Shuffling walks a permutation instead of reordering the slice.
+func NewListSource(ips []string, shuffle bool) IPSource {
+	return newRangeSource(newRand(0), []ipRange{{list: ips, take: uint64(len(ips))}}, shuffle)
+}
+
+// LimitSource stops src after n IPs; n <= 0 means no limit
+func LimitSource(src IPSource, n int) IPSource {
+	if n <= 0 || n >= src.Len() {
+		return src
+	}
+	return &limitSource{src: src, left: n, total: n}
+}
+
+// CollectIPs drains src into a slice, for callers that need the full list
+func CollectIPs(src IPSource) []string {
+	ips := make([]string, 0, src.Len())
+	for {
+		ip, ok := src.Next()
+		if !ok {
+			return ips
+		}
+		ips = append(ips, ip)
+	}
+}
+
+type limitSource struct {
+	src   IPSource
+	left  int
+	total int
+}
+
+func (l *limitSource) Next() (string, bool) {
+	if l.left <= 0 {
+		return "", false
+	}
+	l.left--
+	return l.src.Next()
+}
+
+func (l *limitSource) Len() int { return l.total }
+
+func (l *limitSource) Skip(n int) {
+	if n > l.left {
+		n = l.left
+	}
+	l.left -= n
+	l.src.Skip(n)
+}
+
+// readRanges parses one IP or CIDR per line, skipping blanks and # comments.
+// Invalid CIDRs are reported and skipped, invalid IPs are skipped silently.
+func readRanges(rng *rand.Rand, r io.Reader, sampleSize int) ([]ipRange, error) {
+	var ranges []ipRange
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rg, err := parseRange(rng, line, sampleSize)
+		if err != nil {
+			if strings.Contains(line, "/") {
+				fmt.Fprintf(os.Stderr, "Warning: invalid CIDR %s: %v\n", line, err)
+			}
+			continue
+		}
+		if rg.take > 0 {
+			ranges = append(ranges, rg)
+		}
+	}
+	return ranges, scanner.Err()
+}
+
+// ipRange is a contiguous run of addresses, or an explicit list when the
+// range had to be sampled (large IPv6 prefixes)
+type ipRange struct {
+	start net.IP       // first usable address
+	size  uint64       // usable addresses from start
+	take  uint64       // addresses yielded; < size when sampled
+	pick  *permutation // chooses which `take` of the `size` addresses, nil when all are used
+	list  []string     // explicit addresses, used instead of start/size
+}
+
+// at returns the i-th address of the range, i < take
+func (r *ipRange) at(i uint64) string {
+	if r.list != nil {
+		return r.list[i]
+	}
+	if r.pick != nil {
+		i = r.pick.at(i)
+	}
+	return addToIP(r.start, i).String()
+}
+
+// parseRange turns a single IP or CIDR entry into a range, applying the same
+// rules as ExpandCIDR: .0/.255 are skipped in IPv4 /24 and smaller, and
+// sampleSize > 0 keeps a random subset of that many addresses
+func parseRange(rng *rand.Rand, entry string, sampleSize int) (ipRange, error) {
+	if !strings.Contains(entry, "/") {
+		entry = StripBrackets(entry)
+		if net.ParseIP(entry) == nil {
+			return ipRange{}, fmt.Errorf("invalid IP: %s", entry)
+		}
+		return ipRange{list: []string{entry}, take: 1}, nil
+	}
+
+	_, ipnet, err := net.ParseCIDR(entry)
+	if err != nil {
+		return ipRange{}, err
+	}
+
+	ones, size := ipnet.Mask.Size()
+	hostBits := size - ones
+	if size == 128 && hostBits > maxIPv6EnumerateBits {
+		if sampleSize <= 0 {
+			sampleSize = DefaultIPv6SampleSize
+		}
+		var ips []string
+		if ones < 64 {
+			ips = sampleIPv6(rng, ipnet, sampleSize, 1)
+		} else {
+			ips = sampleCIDR(rng, ipnet, sampleSize)
+		}
+		return ipRange{list: ips, take: uint64(len(ips))}, nil
+	}
+
+	start := ipnet.IP.Mask(ipnet.Mask)
+	if v4 := start.To4(); v4 != nil {
+		start = v4
+	}
+	count := uint64(1) << uint(hostBits)
+
+	// Skip network and broadcast addresses for typical subnets
+	if ones >= 24 && size == 32 {
+		if start[3] == 0 {
+			start = addToIP(start, 1)
+			count--
+		}
+		if count > 0 && addToIP(start, count-1)[3] == 255 {
+			count--
+		}
+	}
+
+	r := ipRange{start: start, size: count, take: count}
+	if sampleSize > 0 && uint64(sampleSize) < count {
+		r.take = uint64(sampleSize)
+		r.pick = newPermutation(rng, count)
+	}
+	return r, nil
+}
+
+// addToIP returns ip + n without modifying ip
+func addToIP(ip net.IP, n uint64) net.IP {
+	out := make(net.IP, len(ip))
+	copy(out, ip)
+	for i := len(out) - 1; i >= 0 && n > 0; i-- {
+		sum := uint64(out[i]) + (n & 0xff)
+		out[i] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+	return out
+}
+
+// rangeSource walks a list of ranges in order, or in a random order driven
+// by a permutation over the combined index space when shuffled
+type rangeSource struct {
+	ranges []ipRange
+	ends   []uint64 // ends[i] = total take of ranges[0..i]
+	total  uint64
+	order  *permutation
+	pos    uint64
+}
+
+func newRangeSource(rng *rand.Rand, ranges []ipRange, shuffle bool) *rangeSource {
+	s := &rangeSource{ranges: ranges, ends: make([]uint64, len(ranges))}
+	for i, r := range ranges {
+		s.total += r.take
+		s.ends[i] = s.total
+	}
+	if shuffle && s.total > 1 {
+		s.order = newPermutation(rng, s.total)
+	}
+	return s
+}
+
+func (s *rangeSource) Next() (string, bool) {
+	if s.pos >= s.total {
+		return "", false
+	}
+	idx := s.pos
+	s.pos++
+	if s.order != nil {
+		idx = s.order.at(idx)
+	}
+
+	i := sort.Search(len(s.ends), func(i int) bool { return s.ends[i] > idx })
+	if i > 0 {
+		idx -= s.ends[i-1]
+	}
+	return s.ranges[i].at(idx), true
+}
+
+func (s *rangeSource) Len() int { return int(s.total) }
+
+func (s *rangeSource) Skip(n int) {
+	s.pos += uint64(n)
+	if s.pos > s.total {
+		s.pos = s.total
+	}
+}
+
+// permutation is a random bijection on [0, n) with O(1) memory and random
+// access. It mixes indices with a keyed bijection on the smallest power-of-two
+// domain covering n and cycle-walks values that land outside [0, n).
+type permutation struct {
+	n     uint64
+	mask  uint64
+	shift uint
+	mul   [4]uint64
+	add   [4]uint64
+}
+
+func newPermutation(rng *rand.Rand, n uint64) *permutation {
+	width := uint(bits.Len64(n - 1))
+	p := &permutation{n: n, mask: ^uint64(0), shift: width/2 + 1}
+	if width < 64 {
+		p.mask = 1<<width - 1
+	}
+	for i := range p.mul {
+		p.mul[i] = rng.Uint64() | 1 // odd multipliers are invertible mod 2^k
+		p.add[i] = rng.Uint64()
+	}
+	return p
+}
+
+// at returns the i-th element of the permutation, i < n
+func (p *permutation) at(i uint64) uint64 {
+	if p.n <= 1 {
+		return i
+	}
+	x := i
+	for {
+		for r := range p.mul {
+			x = (x * p.mul[r]) & p.mask
+			x ^= x >> p.shift
+			x = (x + p.add[r]) & p.mask
+		}
+		if x < p.n {
+			return x
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestPermutationIsBijection(t *testing.T) {
+	for _, n := range []uint64{1, 2, 3, 7, 64, 1000, 4097, 65537} {
+		for seed := int64(1); seed <= 3; seed++ {
+			p := newPermutation(newRand(seed), n)
+			seen := make([]bool, n)
+			for i := uint64(0); i < n; i++ {
+				v := p.at(i)
+				if v >= n {
+					t.Fatalf("n=%d seed=%d: at(%d) = %d is out of range", n, seed, i, v)
+				}
+				if seen[v] {
+					t.Fatalf("n=%d seed=%d: %d returned twice", n, seed, v)
+				}
+				seen[v] = true
+			}
+		}
+	}
+}
+
+func TestShuffledSourceCoversEveryIP(t *testing.T) {
+	src, err := NewCIDRSource("10.0.0.0/28, 10.0.1.0/30", SourceOptions{Shuffle: true, Seed: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := NewCIDRSource("10.0.0.0/28, 10.0.1.0/30", SourceOptions{})
+
+	got, want := CollectIPs(src), CollectIPs(plain)
+	if len(got) != len(want) {
+		t.Fatalf("shuffled source gave %d IPs, want %d", len(got), len(want))
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("shuffled source differs from the plain one: %v vs %v", got, want)
+		}
+	}
+}
+
+func TestSourceSkipResumesSequence(t *testing.T) {
+	opts := SourceOptions{Shuffle: true, Seed: 42}
+	full := CollectIPs(NewTextSource("10.0.0.0/27\n10.0.2.0/29", opts))
+
+	// a resumed scan rebuilds the source with the same seed and skips what it already did
+	src := NewTextSource("10.0.0.0/27\n10.0.2.0/29", opts)
+	src.Skip(10)
+	rest := CollectIPs(src)
+	if len(rest) != len(full)-10 {
+		t.Fatalf("got %d IPs after Skip(10), want %d", len(rest), len(full)-10)
+	}
+	for i := range rest {
+		if rest[i] != full[10+i] {
+			t.Fatalf("IP %d after Skip is %s, want %s", i, rest[i], full[10+i])
+		}
+	}
+}
@@ -0,0 +1,1071 @@
+package webui
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"piyazche/config"
+	"piyazche/history"
+	"piyazche/scanner"
+)
+
+// ── REST API v1 ───────────────────────────────────────────────────────────────
+//
+// /api/v1 سطح پایدار و نسخه‌دار API برای اسکریپت‌ها و داشبوردهاست؛ /api/* قدیمی
+// مخصوص صفحه‌ی HTML می‌مونه. همه‌ی پاسخ‌ها یه شکل دارن:
+//   موفق:  {"data": ...}              لیست‌ها: {"data": [...], "page": {...}}
+//   خطا:   {"error": {"code": "...", "message": "..."}}
+// سند OpenAPI از جدول v1Routes ساخته میشه: /api/v1/openapi.json
+
+// apiError خطای helper ها — status برای HTTP و code برای client ها
+type apiError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string { return e.Message }
+
+func errBadRequest(msg string) error { return &apiError{400, "bad_request", msg} }
+func errNotFound(msg string) error   { return &apiError{404, "not_found", msg} }
+func errConflict(msg string) error   { return &apiError{409, "conflict", msg} }
+func errUnavailable(msg string) error {
+	return &apiError{503, "unavailable", msg}
+}
+
+func asAPIError(err error) *apiError {
+	var ae *apiError
+	if errors.As(err, &ae) {
+		return ae
+	}
+	return &apiError{500, "internal", err.Error()}
+}
+
+// jsonAPIError خطای helper ها رو به فرمت /api قدیمی می‌نویسه
+func jsonAPIError(w http.ResponseWriter, err error) {
+	jsonError(w, err.Error(), asAPIError(err).Status)
+}
+
+// pageInfo موقعیت یه صفحه از لیست
+type pageInfo struct {
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+	Total  int `json:"total"` // تعداد کل بعد از فیلتر
+}
+
+type errorBody struct {
+	Error apiError `json:"error"`
+}
+
+func writeV1(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if status == http.StatusNoContent {
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func writeV1Page(w http.ResponseWriter, data interface{}, page pageInfo) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data, "page": page})
+}
+
+func writeV1Error(w http.ResponseWriter, err error) {
+	ae := asAPIError(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(ae.Status)
+	json.NewEncoder(w).Encode(errorBody{Error: *ae})
+}
+
+// decodeV1 body درخواست رو می‌خونه؛ فیلد ناشناخته خطاست تا typo ها گم نشن
+func decodeV1(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return errBadRequest("invalid request body: " + err.Error())
+	}
+	return nil
+}
+
+// pageParams ?offset= و ?limit= (پیش‌فرض ۱۰۰، حداکثر ۱۰۰۰)
+func pageParams(r *http.Request) (offset, limit int, err error) {
+	limit = 100
+	q := r.URL.Query()
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, errBadRequest("offset must be a non-negative integer")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 1000 {
+			return 0, 0, errBadRequest("limit must be between 1 and 1000")
+		}
+	}
+	return offset, limit, nil
+}
+
+func paginate[T any](items []T, offset, limit int) ([]T, pageInfo) {
+	page := pageInfo{Offset: offset, Limit: limit, Total: len(items)}
+	if offset >= len(items) {
+		return []T{}, page
+	}
+	end := offset + limit
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[offset:end], page
+}
+
+func queryBool(r *http.Request, name string) (*bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, errBadRequest(name + " must be true or false")
+	}
+	return &b, nil
+}
+
+func queryFloat(r *http.Request, name string) (float64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, errBadRequest(name + " must be a number")
+	}
+	return f, nil
+}
+
+// querySort ?sort= و ?order= — sort باید یکی از allowed باشه
+func querySort(r *http.Request, allowed []string, def string, defDesc bool) (string, bool, error) {
+	q := r.URL.Query()
+	key := q.Get("sort")
+	if key == "" {
+		key = def
+	}
+	ok := false
+	for _, a := range allowed {
+		ok = ok || a == key
+	}
+	if !ok {
+		return "", false, errBadRequest("sort must be one of " + strings.Join(allowed, ", "))
+	}
+	desc := defDesc
+	switch q.Get("order") {
+	case "":
+	case "asc":
+		desc = false
+	case "desc":
+		desc = true
+	default:
+		return "", false, errBadRequest("order must be asc or desc")
+	}
+	return key, desc, nil
+}
+
+// ── Routes ────────────────────────────────────────────────────────────────────
+
+type apiParam struct {
+	Name     string
+	In       string // "query" یا "path"
+	Type     string // "string", "integer", "number", "boolean"
+	Desc     string
+	Required bool
+}
+
+type apiRoute struct {
+	Method  string
+	Path    string
+	Tag     string
+	Summary string
+	Params  []apiParam
+	Body    interface{} // نمونه‌ی body برای schema — nil یعنی بدون body
+	Resp    interface{} // نمونه‌ی data پاسخ — nil یعنی 204
+	Status  int         // status موفق، پیش‌فرض 200
+	Paged   bool
+	Handle  func(s *Server, w http.ResponseWriter, r *http.Request)
+}
+
+var pageQuery = []apiParam{
+	{Name: "offset", In: "query", Type: "integer", Desc: "Items to skip"},
+	{Name: "limit", In: "query", Type: "integer", Desc: "Page size, 1-1000 (default 100)"},
+}
+
+func withPage(params ...apiParam) []apiParam {
+	return append(params, pageQuery...)
+}
+
+var jobQuery = apiParam{Name: "job", In: "query", Type: "string", Desc: "Job ID (default: latest job)"}
+
+var phase1Query = withPage(
+	apiParam{Name: "passed", In: "query", Type: "boolean", Desc: "Only passing (true) or failing (false) IPs"},
+	apiParam{Name: "ip", In: "query", Type: "string", Desc: "IP prefix, e.g. 104.16."},
+	apiParam{Name: "maxLatency", In: "query", Type: "number", Desc: "Max latency in ms"},
+	apiParam{Name: "sort", In: "query", Type: "string", Desc: "latency (default), ip or tested"},
+	apiParam{Name: "order", In: "query", Type: "string", Desc: "asc or desc"},
+)
+
+var phase2Query = withPage(
+	apiParam{Name: "passed", In: "query", Type: "boolean", Desc: "Only passing (true) or failing (false) IPs"},
+	apiParam{Name: "ip", In: "query", Type: "string", Desc: "IP prefix, e.g. 104.16."},
+	apiParam{Name: "maxLatency", In: "query", Type: "number", Desc: "Max average latency in ms"},
+	apiParam{Name: "sort", In: "query", Type: "string", Desc: "score (default), latency or ip"},
+	apiParam{Name: "order", In: "query", Type: "string", Desc: "asc or desc"},
+)
+
+type scanStartV1 struct {
+	IPRanges string          `json:"ipRanges"`           // CIDR/IP ها، هر خط یکی — خالی یعنی ipv4.txt
+	MaxIPs   int             `json:"maxIPs,omitempty"`   // 0 یعنی همه
+	Settings json.RawMessage `json:"settings,omitempty"` // override های scan config، مثل quickSettings
+	Name     string          `json:"name,omitempty"`     // اسم job
+	Template string          `json:"template,omitempty"` // template ID — proxy اون به جای config ذخیره‌شده
+}
+
+type scanStartedV1 struct {
+	Job   string `json:"job"`
+	Total int    `json:"total"` // 0 اگه از قبل معلوم نیست
+	Done  int    `json:"done"`  // IP هایی که از checkpoint برگشتن
+}
+
+type healthAddV1 struct {
+	IP            string  `json:"ip"`
+	BaseLatencyMs float64 `json:"baseLatencyMs,omitempty"`
+}
+
+type healthSettingsV1 struct {
+	Enabled       bool                  `json:"enabled"`
+	IntervalMins  int                   `json:"intervalMins"`
+	TrafficDetect bool                  `json:"trafficDetect"`
+	Rotation      config.HealthRotation `json:"rotation"`
+}
+
+type templateAddV1 struct {
+	Name   string `json:"name"`
+	RawURL string `json:"rawUrl"`
+}
+
+type bestV1 struct {
+	ConfigFP string              `json:"fp"`
+	Results  []history.IPSummary `json:"results"`
+}
+
+var jobPath = apiParam{Name: "id", In: "path", Type: "string", Required: true}
+
+var schedulePath = apiParam{Name: "id", In: "path", Type: "string", Required: true}
+
+var subscriptionPath = apiParam{Name: "id", In: "path", Type: "string", Required: true}
+
+// notifyTestV1 — target خالی یعنی همه
+type notifyTestV1 struct {
+	Target string `json:"target,omitempty"`
+}
+
+var v1Routes = []apiRoute{
+	{Method: "GET", Path: "/api/v1/jobs", Tag: "jobs", Summary: "Scan jobs, oldest first",
+		Resp: []jobStatus{}, Handle: (*Server).v1Jobs},
+	{Method: "POST", Path: "/api/v1/jobs", Tag: "jobs", Summary: "Start a scan job; jobs run side by side and share the worker budget",
+		Body: scanStartV1{}, Resp: scanStartedV1{}, Status: 202, Handle: (*Server).v1ScanStart},
+	{Method: "GET", Path: "/api/v1/jobs/{id}", Tag: "jobs", Summary: "One scan job",
+		Params: []apiParam{jobPath}, Resp: jobStatus{}, Handle: (*Server).v1ScanStatus},
+	{Method: "DELETE", Path: "/api/v1/jobs/{id}", Tag: "jobs", Summary: "Forget a finished job; its session and checkpoint stay",
+		Params: []apiParam{jobPath}, Status: 204, Handle: (*Server).v1JobDelete},
+	{Method: "POST", Path: "/api/v1/jobs/{id}/stop", Tag: "jobs", Summary: "Stop a job",
+		Params: []apiParam{jobPath}, Resp: jobStatus{}, Handle: (*Server).v1ScanStop},
+	{Method: "POST", Path: "/api/v1/jobs/{id}/pause", Tag: "jobs", Summary: "Pause phase 1 of a job",
+		Params: []apiParam{jobPath}, Resp: jobStatus{}, Handle: (*Server).v1ScanPause},
+	{Method: "POST", Path: "/api/v1/jobs/{id}/resume", Tag: "jobs", Summary: "Resume a paused job, or a stopped one from its checkpoint",
+		Params: []apiParam{jobPath}, Resp: scanStartedV1{}, Status: 202, Handle: (*Server).v1ScanResume},
+	{Method: "GET", Path: "/api/v1/jobs/{id}/results/phase1", Tag: "jobs", Summary: "Phase-1 results of a job",
+		Params: append([]apiParam{jobPath}, phase1Query...), Resp: []scanner.Result{}, Paged: true, Handle: (*Server).v1ResultsPhase1},
+	{Method: "GET", Path: "/api/v1/jobs/{id}/results/phase2", Tag: "jobs", Summary: "Phase-2 results of a job",
+		Params: append([]apiParam{jobPath}, phase2Query...), Resp: []scanner.Phase2Result{}, Paged: true, Handle: (*Server).v1ResultsPhase2},
+
+	// /scan و /results همیشه روی آخرین job کار می‌کنن
+	{Method: "GET", Path: "/api/v1/scan", Tag: "scan", Summary: "Status and progress of the latest job",
+		Resp: jobStatus{}, Handle: (*Server).v1ScanStatus},
+	{Method: "POST", Path: "/api/v1/scan", Tag: "scan", Summary: "Start a scan job (same as POST /api/v1/jobs)",
+		Body: scanStartV1{}, Resp: scanStartedV1{}, Status: 202, Handle: (*Server).v1ScanStart},
+	{Method: "POST", Path: "/api/v1/scan/stop", Tag: "scan", Summary: "Stop the latest job",
+		Resp: jobStatus{}, Handle: (*Server).v1ScanStop},
+	{Method: "POST", Path: "/api/v1/scan/pause", Tag: "scan", Summary: "Pause phase 1 of the latest job",
+		Resp: jobStatus{}, Handle: (*Server).v1ScanPause},
+	{Method: "POST", Path: "/api/v1/scan/resume", Tag: "scan", Summary: "Resume the latest job if paused, otherwise the newest interrupted scan from its checkpoint",
+		Resp: scanStartedV1{}, Status: 202, Handle: (*Server).v1ScanResume},
+
+	{Method: "GET", Path: "/api/v1/results/phase1", Tag: "results", Summary: "Phase-1 results of the latest job, or of ?job=",
+		Params: append([]apiParam{jobQuery}, phase1Query...), Resp: []scanner.Result{}, Paged: true, Handle: (*Server).v1ResultsPhase1},
+	{Method: "GET", Path: "/api/v1/results/phase2", Tag: "results", Summary: "Phase-2 results of the latest job, or of ?job=",
+		Params: append([]apiParam{jobQuery}, phase2Query...), Resp: []scanner.Phase2Result{}, Paged: true, Handle: (*Server).v1ResultsPhase2},
+
+	{Method: "GET", Path: "/api/v1/sessions", Tag: "sessions", Summary: "Finished scan sessions, newest first",
+		Params: pageQuery, Resp: []ScanSession{}, Paged: true, Handle: (*Server).v1Sessions},
+	{Method: "GET", Path: "/api/v1/sessions/{id}", Tag: "sessions", Summary: "One scan session",
+		Params: []apiParam{{Name: "id", In: "path", Type: "string", Required: true}},
+		Resp:   ScanSession{}, Handle: (*Server).v1Session},
+
+	{Method: "GET", Path: "/api/v1/health", Tag: "health", Summary: "IPs watched by the health monitor",
+		Params: withPage(apiParam{Name: "status", In: "query", Type: "string", Desc: "alive, dead, recovered or unknown"}),
+		Resp:   []config.HealthEntry{}, Paged: true, Handle: (*Server).v1HealthList},
+	{Method: "POST", Path: "/api/v1/health", Tag: "health", Summary: "Watch an IP and check it right away",
+		Body: healthAddV1{}, Resp: config.HealthEntry{}, Status: 201, Handle: (*Server).v1HealthAdd},
+	{Method: "GET", Path: "/api/v1/health/{ip}", Tag: "health", Summary: "One watched IP",
+		Params: []apiParam{{Name: "ip", In: "path", Type: "string", Required: true}},
+		Resp:   config.HealthEntry{}, Handle: (*Server).v1HealthGet},
+	{Method: "DELETE", Path: "/api/v1/health/{ip}", Tag: "health", Summary: "Stop watching an IP",
+		Params: []apiParam{{Name: "ip", In: "path", Type: "string", Required: true}},
+		Status: 204, Handle: (*Server).v1HealthDelete},
+	{Method: "POST", Path: "/api/v1/health/check", Tag: "health", Summary: "Check every watched IP now",
+		Status: 202, Handle: (*Server).v1HealthCheck},
+	{Method: "GET", Path: "/api/v1/health/settings", Tag: "health", Summary: "Health monitor settings",
+		Resp: healthSettingsV1{}, Handle: (*Server).v1HealthSettingsGet},
+	{Method: "PATCH", Path: "/api/v1/health/settings", Tag: "health", Summary: "Change health monitor settings; omitted fields stay",
+		Body: healthSettings{}, Resp: healthSettingsV1{}, Handle: (*Server).v1HealthSettingsPatch},
+
+	{Method: "GET", Path: "/api/v1/templates", Tag: "templates", Summary: "Saved config templates",
+		Resp: []config.ConfigTemplate{}, Handle: (*Server).v1Templates},
+	{Method: "POST", Path: "/api/v1/templates", Tag: "templates", Summary: "Save a proxy link as a template",
+		Body: templateAddV1{}, Resp: config.ConfigTemplate{}, Status: 201, Handle: (*Server).v1TemplateAdd},
+	{Method: "DELETE", Path: "/api/v1/templates/{id}", Tag: "templates", Summary: "Delete a template",
+		Params: []apiParam{{Name: "id", In: "path", Type: "string", Required: true}},
+		Status: 204, Handle: (*Server).v1TemplateDelete},
+
+	{Method: "GET", Path: "/api/v1/schedules", Tag: "schedules", Summary: "Recurring scans with their next run",
+		Resp: []scheduleView{}, Handle: (*Server).v1Schedules},
+	{Method: "POST", Path: "/api/v1/schedules", Tag: "schedules", Summary: "Add a recurring scan; spec is a cron expression (\"0 6 * * *\") or an interval (\"@every 6h\")",
+		Body: scheduleEdit{}, Resp: scheduleView{}, Status: 201, Handle: (*Server).v1ScheduleAdd},
+	{Method: "GET", Path: "/api/v1/schedules/{id}", Tag: "schedules", Summary: "One recurring scan",
+		Params: []apiParam{schedulePath}, Resp: scheduleView{}, Handle: (*Server).v1Schedule},
+	{Method: "PATCH", Path: "/api/v1/schedules/{id}", Tag: "schedules", Summary: "Change a recurring scan; omitted fields stay",
+		Params: []apiParam{schedulePath}, Body: scheduleEdit{}, Resp: scheduleView{}, Handle: (*Server).v1ScheduleUpdate},
+	{Method: "DELETE", Path: "/api/v1/schedules/{id}", Tag: "schedules", Summary: "Delete a recurring scan; IPs it added to the health monitor stay",
+		Params: []apiParam{schedulePath}, Status: 204, Handle: (*Server).v1ScheduleDelete},
+	{Method: "POST", Path: "/api/v1/schedules/{id}/run", Tag: "schedules", Summary: "Run a recurring scan now as a job",
+		Params: []apiParam{schedulePath}, Resp: scanStartedV1{}, Status: 202, Handle: (*Server).v1ScheduleRun},
+
+	{Method: "GET", Path: "/api/v1/subscriptions", Tag: "subscriptions", Summary: "Live subscription links with the IPs they serve now",
+		Resp: []subscriptionView{}, Handle: (*Server).v1Subscriptions},
+	{Method: "POST", Path: "/api/v1/subscriptions", Tag: "subscriptions", Summary: "Add a live subscription; source is auto (default), monitor or session. GET its url without auth, with ?format=v2ray (default), clash or singbox",
+		Body: subscriptionEdit{}, Resp: subscriptionView{}, Status: 201, Handle: (*Server).v1SubscriptionAdd},
+	{Method: "DELETE", Path: "/api/v1/subscriptions/{id}", Tag: "subscriptions", Summary: "Delete a subscription; its link stops working",
+		Params: []apiParam{subscriptionPath}, Status: 204, Handle: (*Server).v1SubscriptionDelete},
+	{Method: "POST", Path: "/api/v1/subscriptions/{id}/token", Tag: "subscriptions", Summary: "Issue a new token; the old link stops working",
+		Params: []apiParam{subscriptionPath}, Resp: subscriptionView{}, Handle: (*Server).v1SubscriptionToken},
+
+	{Method: "GET", Path: "/api/v1/notify", Tag: "notify", Summary: "Notification settings and targets",
+		Resp: config.NotifySettings{}, Handle: (*Server).v1Notify},
+	{Method: "PUT", Path: "/api/v1/notify", Tag: "notify", Summary: "Replace notification settings. Targets are webhooks (JSON POST; body is a Go template over the event, {{json .Text}} quotes a value) or Telegram bots; events are scan_done, ip_dead, ip_recovered and no_healthy",
+		Body: config.NotifySettings{}, Resp: config.NotifySettings{}, Handle: (*Server).v1NotifyUpdate},
+	{Method: "POST", Path: "/api/v1/notify/test", Tag: "notify", Summary: "Send a test message to one target or all of them and wait for the results",
+		Body: notifyTestV1{}, Resp: []notifyTestResult{}, Handle: (*Server).v1NotifyTest},
+
+	{Method: "GET", Path: "/api/v1/subnets", Tag: "subnets", Summary: "Per-subnet pass rates of past scans",
+		Params: withPage(
+			apiParam{Name: "minPassRate", In: "query", Type: "number", Desc: "Minimum pass rate, 0-100"},
+			apiParam{Name: "sort", In: "query", Type: "string", Desc: "passRate (default), latency or total"},
+			apiParam{Name: "order", In: "query", Type: "string", Desc: "asc or desc"},
+		),
+		Resp: []config.SubnetStat{}, Paged: true, Handle: (*Server).v1Subnets},
+
+	{Method: "GET", Path: "/api/v1/history/best", Tag: "history", Summary: "Best IPs across past scans",
+		Params: []apiParam{
+			{Name: "days", In: "query", Type: "integer", Desc: "Look back N days, 0 = all (default 7)"},
+			{Name: "fp", In: "query", Type: "string", Desc: "Config fingerprint, \"all\" for every config (default: current config)"},
+			{Name: "limit", In: "query", Type: "integer", Desc: "Maximum IPs (default 50)"},
+		},
+		Resp: bestV1{}, Handle: (*Server).v1HistoryBest},
+	{Method: "GET", Path: "/api/v1/history/ips/{ip}", Tag: "history", Summary: "Every recorded test of an IP, oldest first",
+		Params: []apiParam{
+			{Name: "ip", In: "path", Type: "string", Required: true},
+			{Name: "days", In: "query", Type: "integer", Desc: "Look back N days, 0 = all (default 7)"},
+			{Name: "fp", In: "query", Type: "string", Desc: "Config fingerprint"},
+		},
+		Resp: []history.Record{}, Handle: (*Server).v1HistoryIP},
+	{Method: "GET", Path: "/api/v1/history/subnets", Tag: "history", Summary: "Subnet pass rates per session",
+		Params: []apiParam{
+			{Name: "days", In: "query", Type: "integer", Desc: "Look back N days, 0 = all (default 7)"},
+			{Name: "fp", In: "query", Type: "string", Desc: "Config fingerprint"},
+			{Name: "limit", In: "query", Type: "integer", Desc: "Maximum subnets (default 50)"},
+		},
+		Resp: []history.SubnetTrend{}, Handle: (*Server).v1HistorySubnets},
+}
+
+// registerV1 route های /api/v1 رو روی mux ثبت می‌کنه
+func (s *Server) registerV1(mux *http.ServeMux) {
+	for _, rt := range v1Routes {
+		handle := rt.Handle
+		mux.HandleFunc(rt.Method+" "+rt.Path, func(w http.ResponseWriter, r *http.Request) {
+			handle(s, w, r)
+		})
+	}
+	mux.HandleFunc("GET /api/v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openAPIDoc(v1Routes))
+	})
+	// بقیه‌ی /api/v1 — به جای صفحه‌ی HTML خطای JSON
+	mux.HandleFunc("/api/v1/", func(w http.ResponseWriter, r *http.Request) {
+		for _, rt := range v1Routes {
+			if pathMatches(rt.Path, r.URL.Path) {
+				w.Header().Set("Allow", allowedMethods(r.URL.Path))
+				writeV1Error(w, &apiError{405, "method_not_allowed", r.Method + " is not supported here"})
+				return
+			}
+		}
+		writeV1Error(w, errNotFound("no such endpoint"))
+	})
+}
+
+func pathMatches(pattern, path string) bool {
+	ps, xs := strings.Split(pattern, "/"), strings.Split(path, "/")
+	if len(ps) != len(xs) {
+		return false
+	}
+	for i := range ps {
+		if !strings.HasPrefix(ps[i], "{") && ps[i] != xs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func allowedMethods(path string) string {
+	var methods []string
+	for _, rt := range v1Routes {
+		if pathMatches(rt.Path, path) {
+			methods = append(methods, rt.Method)
+		}
+	}
+	return strings.Join(methods, ", ")
+}
+
+// ── Handlers ──────────────────────────────────────────────────────────────────
+
+// requestJob ID job درخواست — از path (/jobs/{id}) یا ?job=؛ خالی یعنی آخرین job
+func requestJob(r *http.Request) string {
+	if id := r.PathValue("id"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("job")
+}
+
+// jobStatusOf وضعیت یه job — بدون job و بدون id یعنی idle
+func (s *Server) jobStatusOf(id string) (jobStatus, error) {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	j, err := s.findJobLocked(id)
+	if err != nil {
+		if id != "" {
+			return jobStatus{}, err
+		}
+		return jobStatus{Status: "idle"}, nil
+	}
+	return j.status(), nil
+}
+
+func (s *Server) writeJobStatus(w http.ResponseWriter, id string) {
+	st, err := s.jobStatusOf(id)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	if st.ID == "" {
+		st.Resumable = s.scanResumable()
+	}
+	writeV1(w, 200, st)
+}
+
+func (s *Server) v1Jobs(w http.ResponseWriter, r *http.Request) {
+	writeV1(w, 200, s.jobStatuses())
+}
+
+func (s *Server) v1JobDelete(w http.ResponseWriter, r *http.Request) {
+	if err := s.removeJob(r.PathValue("id")); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeV1(w, 204, nil)
+}
+
+func (s *Server) v1ScanStatus(w http.ResponseWriter, r *http.Request) {
+	s.writeJobStatus(w, requestJob(r))
+}
+
+func (s *Server) v1ScanStart(w http.ResponseWriter, r *http.Request) {
+	var req scanStartV1
+	if err := decodeV1(r, &req); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	job, total, err := s.startScan(scanRequest{
+		QuickSettings: req.Settings,
+		IPRanges:      req.IPRanges,
+		MaxIPs:        req.MaxIPs,
+		Name:          req.Name,
+		Template:      req.Template,
+	})
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeV1(w, 202, scanStartedV1{Job: job.ID, Total: total})
+}
+
+func (s *Server) v1ScanStop(w http.ResponseWriter, r *http.Request) {
+	id := requestJob(r)
+	if err := s.stopScan(id); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	s.writeJobStatus(w, id)
+}
+
+func (s *Server) v1ScanPause(w http.ResponseWriter, r *http.Request) {
+	id := requestJob(r)
+	if st, err := s.jobStatusOf(id); err == nil && st.Status == "paused" {
+		writeV1Error(w, errConflict("scan is already paused"))
+		return
+	}
+	if _, err := s.togglePause(id); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	s.writeJobStatus(w, id)
+}
+
+func (s *Server) v1ScanResume(w http.ResponseWriter, r *http.Request) {
+	id := requestJob(r)
+	if st, err := s.jobStatusOf(id); err == nil && st.Status == "paused" {
+		if _, err := s.togglePause(id); err != nil {
+			writeV1Error(w, err)
+			return
+		}
+		writeV1(w, 202, scanStartedV1{Job: st.ID, Total: st.Progress.Total, Done: st.Progress.Done})
+		return
+	}
+	job, total, done, err := s.resumeScan(id)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeV1(w, 202, scanStartedV1{Job: job.ID, Total: total, Done: done})
+}
+
+// resultFilter فیلترهای مشترک results
+type resultFilter struct {
+	passed     *bool
+	ipPrefix   string
+	maxLatency float64
+}
+
+func parseResultFilter(r *http.Request) (resultFilter, error) {
+	var f resultFilter
+	var err error
+	if f.passed, err = queryBool(r, "passed"); err != nil {
+		return f, err
+	}
+	if f.maxLatency, err = queryFloat(r, "maxLatency"); err != nil {
+		return f, err
+	}
+	f.ipPrefix = r.URL.Query().Get("ip")
+	return f, nil
+}
+
+func (f resultFilter) match(ip string, passed bool, latencyMs float64) bool {
+	if f.passed != nil && *f.passed != passed {
+		return false
+	}
+	if f.ipPrefix != "" && !strings.HasPrefix(ip, f.ipPrefix) {
+		return false
+	}
+	return f.maxLatency <= 0 || (passed && latencyMs <= f.maxLatency)
+}
+
+func (s *Server) v1ResultsPhase1(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := pageParams(r)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	f, err := parseResultFilter(r)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	key, desc, err := querySort(r, []string{"latency", "ip", "tested"}, "latency", false)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+
+	s.state.mu.RLock()
+	var all []scanner.Result
+	if j, err := s.findJobLocked(requestJob(r)); err == nil {
+		all = j.Results
+	} else if requestJob(r) != "" {
+		s.state.mu.RUnlock()
+		writeV1Error(w, err)
+		return
+	}
+	out := make([]scanner.Result, 0, len(all))
+	for _, res := range all {
+		if f.match(res.IP, res.Success, float64(res.LatencyMs)) {
+			out = append(out, res)
+		}
+	}
+	s.state.mu.RUnlock()
+
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if desc {
+			a, b = b, a
+		}
+		switch key {
+		case "ip":
+			return a.IP < b.IP
+		case "tested":
+			return a.TestedAt.Before(b.TestedAt)
+		}
+		// ناموفق‌ها همیشه آخر
+		if a.Success != b.Success {
+			return a.Success != desc
+		}
+		return a.LatencyMs < b.LatencyMs
+	})
+	items, page := paginate(out, offset, limit)
+	writeV1Page(w, items, page)
+}
+
+func (s *Server) v1ResultsPhase2(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := pageParams(r)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	f, err := parseResultFilter(r)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	key, desc, err := querySort(r, []string{"score", "latency", "ip"}, "score", true)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+
+	s.state.mu.RLock()
+	var all []scanner.Phase2Result
+	if j, err := s.findJobLocked(requestJob(r)); err == nil {
+		all = j.Phase2Results
+	} else if requestJob(r) != "" {
+		s.state.mu.RUnlock()
+		writeV1Error(w, err)
+		return
+	}
+	out := make([]scanner.Phase2Result, 0, len(all))
+	for _, res := range all {
+		if f.match(res.IP, res.Passed, res.AvgLatencyMs) {
+			out = append(out, res)
+		}
+	}
+	s.state.mu.RUnlock()
+
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if desc {
+			a, b = b, a
+		}
+		switch key {
+		case "ip":
+			return a.IP < b.IP
+		case "latency":
+			return a.AvgLatencyMs < b.AvgLatencyMs
+		}
+		return a.StabilityScore < b.StabilityScore
+	})
+	items, page := paginate(out, offset, limit)
+	writeV1Page(w, items, page)
+}
+
+func (s *Server) v1Sessions(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := pageParams(r)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	s.state.mu.RLock()
+	sessions := make([]ScanSession, len(s.state.Sessions))
+	copy(sessions, s.state.Sessions)
+	s.state.mu.RUnlock()
+
+	items, page := paginate(sessions, offset, limit)
+	writeV1Page(w, items, page)
+}
+
+func (s *Server) v1Session(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	for _, ss := range s.state.Sessions {
+		if ss.ID == id {
+			writeV1(w, 200, ss)
+			return
+		}
+	}
+	writeV1Error(w, errNotFound("no session "+id))
+}
+
+func (s *Server) healthEntry(ip string) (config.HealthEntry, bool) {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	e, ok := s.state.HealthEntries[ip]
+	if !ok {
+		return config.HealthEntry{}, false
+	}
+	return *e, true
+}
+
+func (s *Server) v1HealthList(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := pageParams(r)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	status := config.HealthStatus(r.URL.Query().Get("status"))
+
+	s.state.mu.RLock()
+	entries := make([]config.HealthEntry, 0, len(s.state.HealthEntries))
+	for _, e := range s.state.HealthEntries {
+		if status == "" || e.Status == status {
+			entries = append(entries, *e)
+		}
+	}
+	s.state.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].IP < entries[j].IP })
+	items, page := paginate(entries, offset, limit)
+	writeV1Page(w, items, page)
+}
+
+func (s *Server) v1HealthAdd(w http.ResponseWriter, r *http.Request) {
+	var req healthAddV1
+	if err := decodeV1(r, &req); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	if req.IP == "" {
+		writeV1Error(w, errBadRequest("ip is required"))
+		return
+	}
+	s.addHealthEntry(req.IP, req.BaseLatencyMs, "")
+	e, _ := s.healthEntry(req.IP)
+	writeV1(w, 201, e)
+}
+
+func (s *Server) v1HealthGet(w http.ResponseWriter, r *http.Request) {
+	e, ok := s.healthEntry(r.PathValue("ip"))
+	if !ok {
+		writeV1Error(w, errNotFound("ip is not monitored"))
+		return
+	}
+	writeV1(w, 200, e)
+}
+
+func (s *Server) v1HealthDelete(w http.ResponseWriter, r *http.Request) {
+	if !s.removeHealthEntry(r.PathValue("ip")) {
+		writeV1Error(w, errNotFound("ip is not monitored"))
+		return
+	}
+	writeV1(w, 204, nil)
+}
+
+func (s *Server) v1HealthCheck(w http.ResponseWriter, r *http.Request) {
+	go s.runHealthChecks()
+	writeV1(w, 202, nil)
+}
+
+func (s *Server) currentHealthSettings() healthSettingsV1 {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	return healthSettingsV1{
+		Enabled:       s.state.HealthEnabled,
+		IntervalMins:  s.state.HealthIntervalMins,
+		TrafficDetect: s.state.TrafficDetectEnabled,
+		Rotation:      s.state.HealthRotation,
+	}
+}
+
+func (s *Server) v1HealthSettingsGet(w http.ResponseWriter, r *http.Request) {
+	writeV1(w, 200, s.currentHealthSettings())
+}
+
+func (s *Server) v1HealthSettingsPatch(w http.ResponseWriter, r *http.Request) {
+	var req healthSettings
+	if err := decodeV1(r, &req); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	s.updateHealthSettings(req)
+	writeV1(w, 200, s.currentHealthSettings())
+}
+
+func (s *Server) v1Templates(w http.ResponseWriter, r *http.Request) {
+	s.state.mu.RLock()
+	templates := make([]config.ConfigTemplate, len(s.state.Templates))
+	copy(templates, s.state.Templates)
+	s.state.mu.RUnlock()
+	writeV1(w, 200, templates)
+}
+
+func (s *Server) v1TemplateAdd(w http.ResponseWriter, r *http.Request) {
+	var req templateAddV1
+	if err := decodeV1(r, &req); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	if req.Name == "" || req.RawURL == "" {
+		writeV1Error(w, errBadRequest("name and rawUrl are required"))
+		return
+	}
+	tmpl, err := s.saveTemplate(req.Name, req.RawURL)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeV1(w, 201, tmpl)
+}
+
+func (s *Server) v1TemplateDelete(w http.ResponseWriter, r *http.Request) {
+	if !s.deleteTemplate(r.PathValue("id")) {
+		writeV1Error(w, errNotFound("no template "+r.PathValue("id")))
+		return
+	}
+	writeV1(w, 204, nil)
+}
+
+func (s *Server) v1Schedules(w http.ResponseWriter, r *http.Request) {
+	writeV1(w, 200, s.scheduleViews())
+}
+
+func (s *Server) v1Schedule(w http.ResponseWriter, r *http.Request) {
+	sc, err := s.scheduleByID(r.PathValue("id"))
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeV1(w, 200, sc)
+}
+
+func (s *Server) v1ScheduleAdd(w http.ResponseWriter, r *http.Request) {
+	var req scheduleEdit
+	if err := decodeV1(r, &req); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	sc, err := s.addSchedule(req)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeV1(w, 201, sc)
+}
+
+func (s *Server) v1ScheduleUpdate(w http.ResponseWriter, r *http.Request) {
+	var req scheduleEdit
+	if err := decodeV1(r, &req); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	sc, err := s.updateSchedule(r.PathValue("id"), req)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeV1(w, 200, sc)
+}
+
+func (s *Server) v1ScheduleDelete(w http.ResponseWriter, r *http.Request) {
+	if !s.deleteSchedule(r.PathValue("id")) {
+		writeV1Error(w, errNotFound("no schedule "+r.PathValue("id")))
+		return
+	}
+	writeV1(w, 204, nil)
+}
+
+func (s *Server) v1ScheduleRun(w http.ResponseWriter, r *http.Request) {
+	job, total, err := s.runSchedule(r.PathValue("id"))
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeV1(w, 202, scanStartedV1{Job: job.ID, Total: total})
+}
+
+func (s *Server) v1Subscriptions(w http.ResponseWriter, r *http.Request) {
+	writeV1(w, 200, s.subscriptionViews())
+}
+
+func (s *Server) v1SubscriptionAdd(w http.ResponseWriter, r *http.Request) {
+	var req subscriptionEdit
+	if err := decodeV1(r, &req); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	sub, err := s.addSubscription(req)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeV1(w, 201, sub)
+}
+
+func (s *Server) v1SubscriptionDelete(w http.ResponseWriter, r *http.Request) {
+	if !s.deleteSubscription(r.PathValue("id")) {
+		writeV1Error(w, errNotFound("no subscription "+r.PathValue("id")))
+		return
+	}
+	writeV1(w, 204, nil)
+}
+
+func (s *Server) v1SubscriptionToken(w http.ResponseWriter, r *http.Request) {
+	sub, err := s.rotateSubscriptionToken(r.PathValue("id"))
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeV1(w, 200, sub)
+}
+
+func (s *Server) v1Notify(w http.ResponseWriter, r *http.Request) {
+	writeV1(w, 200, s.notifySettings())
+}
+
+func (s *Server) v1NotifyUpdate(w http.ResponseWriter, r *http.Request) {
+	var req config.NotifySettings
+	if err := decodeV1(r, &req); err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	n, err := s.updateNotifySettings(req)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeV1(w, 200, n)
+}
+
+func (s *Server) v1NotifyTest(w http.ResponseWriter, r *http.Request) {
+	var req notifyTestV1
+	if r.ContentLength != 0 {
+		if err := decodeV1(r, &req); err != nil {
+			writeV1Error(w, err)
+			return
+		}
+	}
+	results, err := s.sendTestNotification(req.Target)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	writeV1(w, 200, results)
+}
+
+func (s *Server) v1Subnets(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := pageParams(r)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	minRate, err := queryFloat(r, "minPassRate")
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+	key, desc, err := querySort(r, []string{"passRate", "latency", "total"}, "passRate", true)
+	if err != nil {
+		writeV1Error(w, err)
+		return
+	}
+
+	s.state.mu.RLock()
+	stats := make([]config.SubnetStat, 0, len(s.state.SubnetStats))
+	for _, st := range s.state.SubnetStats {
+		if st.PassRate >= minRate {
+			stats = append(stats, st)
+		}
+	}
+	s.state.mu.RUnlock()
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if desc {
+			a, b = b, a
+		}
+		switch key {
+		case "latency":
+			return a.AvgLatMs < b.AvgLatMs
+		case "total":
+			return a.Total < b.Total
+		}
+		return a.PassRate < b.PassRate
+	})
+	items, page := paginate(stats, offset, limit)
+	writeV1Page(w, items, page)
+}
+
+func (s *Server) v1HistoryBest(w http.ResponseWriter, r *http.Request) {
+	if s.hist == nil {
+		writeV1Error(w, errUnavailable("history unavailable"))
+		return
+	}
+	f := historyFilter(r)
+	fp := f.ConfigFP
+	switch f.ConfigFP {
+	case "all":
+		f.ConfigFP = ""
+	case "":
+		cfg, err := s.buildMergedConfig("")
+		if err != nil {
+			writeV1Error(w, errBadRequest("config parse error: "+err.Error()))
+			return
+		}
+		f.ConfigFP = cfg.Fingerprint()
+		fp = f.ConfigFP
+	}
+	results := s.hist.Best(f, queryLimit(r, 50))
+	if results == nil {
+		results = []history.IPSummary{}
+	}
+	writeV1(w, 200, bestV1{ConfigFP: fp, Results: results})
+}
+
+func (s *Server) v1HistoryIP(w http.ResponseWriter, r *http.Request) {
+	if s.hist == nil {
+		writeV1Error(w, errUnavailable("history unavailable"))
+		return
+	}
+	records := s.hist.IPHistory(r.PathValue("ip"), historyFilter(r))
+	if records == nil {
+		records = []history.Record{}
+	}
+	writeV1(w, 200, records)
+}
+
+func (s *Server) v1HistorySubnets(w http.ResponseWriter, r *http.Request) {
+	if s.hist == nil {
+		writeV1Error(w, errUnavailable("history unavailable"))
+		return
+	}
+	writeV1(w, 200, s.hist.SubnetTrends(historyFilter(r), queryLimit(r, 50)))
+}
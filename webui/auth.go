@@ -0,0 +1,321 @@
+package webui
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options تنظیمات شبکه، امنیت و ظرفیت Web UI
+type Options struct {
+	Bind     string // آدرس listen — خالی یعنی همه interface ها
+	Password string // رمز صفحه login — خالی یعنی بدون login
+	Token    string // bearer token برای API client ها
+	TLS      bool   // HTTPS
+	CertFile string // خالی با TLS یعنی self-signed
+	KeyFile  string
+	Workers  int // سقف تست‌های همزمان همه‌ی اسکن‌ها — 0 یعنی پیش‌فرض
+}
+
+const (
+	sessionCookie = "piyazche_session"
+	sessionTTL    = 7 * 24 * time.Hour
+)
+
+// authGuard جلوی همه route ها می‌شینه: session cookie (بعد از login) یا
+// "Authorization: Bearer <token>" لازمه. بدون password و token غیرفعاله.
+type authGuard struct {
+	password string
+	token    string
+	secure   bool // cookie فقط روی HTTPS
+
+	mu       sync.Mutex
+	sessions map[string]time.Time // session id → انقضا
+}
+
+func newAuthGuard(opts Options) *authGuard {
+	return &authGuard{
+		password: opts.Password,
+		token:    opts.Token,
+		secure:   opts.TLS,
+		sessions: make(map[string]time.Time),
+	}
+}
+
+func (a *authGuard) enabled() bool {
+	return a.password != "" || a.token != ""
+}
+
+// wrap همه request ها رو چک می‌کنه؛ صفحه‌ها به /login redirect میشن و
+// API ها 401 می‌گیرن. /sub/ با token خودش محافظت میشه.
+func (a *authGuard) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.enabled() || r.URL.Path == "/login" || strings.HasPrefix(r.URL.Path, "/sub/") || a.allowed(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodGet && !isAPIPath(r.URL.Path) {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="piyazche"`)
+		if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+			writeV1Error(w, &apiError{http.StatusUnauthorized, "unauthorized", "login or a bearer token is required"})
+			return
+		}
+		jsonError(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+func isAPIPath(path string) bool {
+	return strings.HasPrefix(path, "/api/") || path == "/ws" || path == "/metrics"
+}
+
+func (a *authGuard) allowed(r *http.Request) bool {
+	if a.token != "" {
+		if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+			return secretEqual(strings.TrimPrefix(h, "Bearer "), a.token)
+		}
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	exp, ok := a.sessions[c.Value]
+	if ok && time.Now().After(exp) {
+		delete(a.sessions, c.Value)
+		return false
+	}
+	return ok
+}
+
+// checkSecret رمز صفحه login رو چک می‌کنه — token هم قبوله
+func (a *authGuard) checkSecret(secret string) bool {
+	if secret == "" {
+		return false
+	}
+	return (a.password != "" && secretEqual(secret, a.password)) ||
+		(a.token != "" && secretEqual(secret, a.token))
+}
+
+func (a *authGuard) newSession() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	for k, exp := range a.sessions {
+		if now.After(exp) {
+			delete(a.sessions, k)
+		}
+	}
+	a.sessions[id] = now.Add(sessionTTL)
+	return id, nil
+}
+
+func (a *authGuard) endSession(r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		a.mu.Lock()
+		delete(a.sessions, c.Value)
+		a.mu.Unlock()
+	}
+}
+
+func (a *authGuard) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   a.secure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func secretEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// handleLogin — GET صفحه login، POST چک رمز و ساخت session
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if !s.auth.enabled() {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		failed := ""
+		if r.URL.Query().Get("failed") != "" {
+			failed = `<div class="err">Wrong password</div>`
+		}
+		fmt.Fprintf(w, loginHTML, failed)
+		return
+	}
+
+	if !s.auth.checkSecret(r.FormValue("password")) {
+		// کند کردن حدس زدن رمز
+		time.Sleep(time.Second)
+		http.Redirect(w, r, "/login?failed=1", http.StatusSeeOther)
+		return
+	}
+	id, err := s.auth.newSession()
+	if err != nil {
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+	s.auth.setCookie(w, id, int(sessionTTL.Seconds()))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleLogout — پاک کردن session
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", 405)
+		return
+	}
+	s.auth.endSession(r)
+	s.auth.setCookie(w, "", -1)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// checkSameOrigin فقط WebSocket هایی رو قبول می‌کنه که از همین host باز شدن.
+// client های غیر مرورگر Origin نمی‌فرستن و فقط auth براشون چک میشه.
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// tlsConfig certificate داده‌شده رو لود می‌کنه، یا یه self-signed کنار
+// فایل تنظیمات UI می‌سازه تا exception مرورگر بین اجراها بمونه
+func tlsConfig(opts Options) (*tls.Config, error) {
+	certFile, keyFile := opts.CertFile, opts.KeyFile
+	if certFile == "" && keyFile == "" {
+		dir := filepath.Dir(configPersistPath())
+		certFile = filepath.Join(dir, "piyazche_ui_cert.pem")
+		keyFile = filepath.Join(dir, "piyazche_ui_key.pem")
+		if _, err := os.Stat(certFile); os.IsNotExist(err) {
+			if err := writeSelfSigned(certFile, keyFile, opts.Bind); err != nil {
+				return nil, fmt.Errorf("failed to create self-signed certificate: %w", err)
+			}
+			fmt.Printf("  Self-signed certificate: %s\n", certFile)
+		}
+	} else if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both a certificate and a key file are needed")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func writeSelfSigned(certFile, keyFile, bind string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "piyazche web ui"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(5, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, err := os.Hostname(); err == nil {
+		tmpl.DNSNames = append(tmpl.DNSNames, host)
+	}
+	if ip := net.ParseIP(bind); ip != nil && !ip.IsUnspecified() {
+		tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+	} else if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// loginHTML — %s جای پیام خطاست
+const loginHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width,initial-scale=1">
+<title>Piyazche — Login</title>
+<style>
+body{margin:0;min-height:100vh;display:flex;align-items:center;justify-content:center;background:#04060a;color:#e8f0ff;font-family:'Space Grotesk',system-ui,sans-serif}
+form{background:#0d1420;border:1px solid #1a2840;border-radius:10px;padding:28px;width:280px;display:flex;flex-direction:column;gap:12px}
+.logo{font-weight:700;letter-spacing:3px;color:#38bfff;text-align:center}
+input{background:#07090f;border:1px solid #243350;border-radius:6px;color:#e8f0ff;padding:9px;font-size:14px}
+button{background:#00cc88;border:0;border-radius:6px;color:#04060a;font-weight:600;padding:9px;cursor:pointer}
+.err{color:#ff3d75;font-size:12px;text-align:center}
+</style>
+</head>
+<body>
+<form method="POST" action="/login">
+  <div class="logo">PIYAZCHE</div>
+  %s
+  <input type="password" name="password" placeholder="Password or token" autofocus autocomplete="current-password">
+  <button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`
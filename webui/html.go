@@ -2,17 +2,21 @@ package webui
 
 import (
 	_ "embed"
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
 	"piyazche/config"
+	"piyazche/history"
 	"piyazche/scanner"
+	"piyazche/xray"
 )
 
 // ── Disk Persistence ─────────────────────────────────────────────────────────
@@ -26,9 +30,13 @@ type persistedState struct {
 	HealthEnabled        *bool                          `json:"healthEnabled,omitempty"`
 	HealthIntervalMins   *int                           `json:"healthIntervalMins,omitempty"`
 	TrafficDetectEnabled *bool                          `json:"trafficDetect,omitempty"`
+	HealthRotation       *config.HealthRotation         `json:"healthRotation,omitempty"`
 	Sessions             []ScanSession                  `json:"sessions,omitempty"`
 	SavedRanges          string                         `json:"savedRanges,omitempty"`
 	SubnetStats          []config.SubnetStat            `json:"subnetStats,omitempty"`
+	Schedules            []config.ScanSchedule          `json:"schedules,omitempty"`
+	Subscriptions        []config.Subscription          `json:"subscriptions,omitempty"`
+	Notify               *config.NotifySettings         `json:"notify,omitempty"`
 }
 
 // configPersistPath returns the path for UI config.
@@ -49,7 +57,7 @@ func configPersistPath() string {
 	return filepath.Join(dir, "ui.json")
 }
 
-func saveStateToDisk(proxyJSON, scanJSON, rawURL string, templates []config.ConfigTemplate, healthEntries map[string]*config.HealthEntry, healthEnabled bool, healthIntervalMins int, trafficDetect bool, sessions []ScanSession, savedRanges string, subnetStats []config.SubnetStat) {
+func saveStateToDisk(proxyJSON, scanJSON, rawURL string, templates []config.ConfigTemplate, healthEntries map[string]*config.HealthEntry, healthEnabled bool, healthIntervalMins int, trafficDetect bool, rotation config.HealthRotation, sessions []ScanSession, savedRanges string, subnetStats []config.SubnetStat, schedules []config.ScanSchedule, subscriptions []config.Subscription, notify config.NotifySettings) {
 	// HealthEntries رو deep copy کن قبل از persist
 	heCopy := make(map[string]*config.HealthEntry, len(healthEntries))
 	for k, v := range healthEntries {
@@ -65,9 +73,13 @@ func saveStateToDisk(proxyJSON, scanJSON, rawURL string, templates []config.Conf
 		HealthEnabled:        &healthEnabled,
 		HealthIntervalMins:   &healthIntervalMins,
 		TrafficDetectEnabled: &trafficDetect,
+		HealthRotation:       &rotation,
 		Sessions:             sessions,
 		SavedRanges:          savedRanges,
 		SubnetStats:          subnetStats,
+		Schedules:            schedules,
+		Subscriptions:        subscriptions,
+		Notify:               &notify,
 	}, "", "  ")
 	os.WriteFile(configPersistPath(), data, 0644)
 }
@@ -83,6 +95,7 @@ func (s *Server) saveStateToDiskNow() {
 	healthEnabled := s.state.HealthEnabled
 	healthIntervalMins := s.state.HealthIntervalMins
 	trafficDetect := s.state.TrafficDetectEnabled
+	rotation := s.state.HealthRotation
 	heCopy := make(map[string]*config.HealthEntry, len(s.state.HealthEntries))
 	for k, v := range s.state.HealthEntries {
 		cp := *v
@@ -93,44 +106,81 @@ func (s *Server) saveStateToDiskNow() {
 	savedRanges := s.state.SavedRanges
 	subnetStats := make([]config.SubnetStat, len(s.state.SubnetStats))
 	copy(subnetStats, s.state.SubnetStats)
+	schedules := make([]config.ScanSchedule, len(s.state.Schedules))
+	copy(schedules, s.state.Schedules)
+	subscriptions := make([]config.Subscription, len(s.state.Subscriptions))
+	copy(subscriptions, s.state.Subscriptions)
+	notify := s.state.Notify
+	notify.Targets = append([]config.NotifyTarget(nil), s.state.Notify.Targets...)
 	s.state.mu.RUnlock()
-	saveStateToDisk(proxyJSON, scanJSON, rawURL, templates, heCopy, healthEnabled, healthIntervalMins, trafficDetect, sessions, savedRanges, subnetStats)
+	saveStateToDisk(proxyJSON, scanJSON, rawURL, templates, heCopy, healthEnabled, healthIntervalMins, trafficDetect, rotation, sessions, savedRanges, subnetStats, schedules, subscriptions, notify)
 }
 
-func loadStateFromDisk() (proxyJSON, scanJSON, rawURL string, templates []config.ConfigTemplate, healthEntries map[string]*config.HealthEntry, healthEnabled *bool, healthIntervalMins *int, trafficDetect *bool, sessions []ScanSession, savedRanges string, subnetStats []config.SubnetStat) {
+func loadStateFromDisk() (proxyJSON, scanJSON, rawURL string, templates []config.ConfigTemplate, healthEntries map[string]*config.HealthEntry, healthEnabled *bool, healthIntervalMins *int, trafficDetect *bool, rotation *config.HealthRotation, sessions []ScanSession, savedRanges string, subnetStats []config.SubnetStat, schedules []config.ScanSchedule, subscriptions []config.Subscription, notify *config.NotifySettings) {
 	data, err := os.ReadFile(configPersistPath())
 	if err != nil {
-		return "", "", "", nil, nil, nil, nil, nil, nil, "", nil
+		return "", "", "", nil, nil, nil, nil, nil, nil, nil, "", nil, nil, nil, nil
 	}
 	var ps persistedState
 	if json.Unmarshal(data, &ps) != nil {
-		return "", "", "", nil, nil, nil, nil, nil, nil, "", nil
+		return "", "", "", nil, nil, nil, nil, nil, nil, nil, "", nil, nil, nil, nil
 	}
-	return ps.ProxyConfig, ps.ScanConfig, ps.RawURL, ps.Templates, ps.HealthEntries, ps.HealthEnabled, ps.HealthIntervalMins, ps.TrafficDetectEnabled, ps.Sessions, ps.SavedRanges, ps.SubnetStats
+	return ps.ProxyConfig, ps.ScanConfig, ps.RawURL, ps.Templates, ps.HealthEntries, ps.HealthEnabled, ps.HealthIntervalMins, ps.TrafficDetectEnabled, ps.HealthRotation, ps.Sessions, ps.SavedRanges, ps.SubnetStats, ps.Schedules, ps.Subscriptions, ps.Notify
+}
+
+// ErrHealthPoolConfig یعنی health monitor با یه کانفیگ proxy دیگه چک می‌کنه
+var ErrHealthPoolConfig = errors.New("the Web UI health monitor tests a different proxy config")
+
+// LoadHealthPool IP های زنده‌ی health monitor ذخیره‌شده، بهترین uptime اول —
+// برای `piyazche serve`. اگه کانفیگ ذخیره‌شده‌ی UI fingerprint دیگه‌ای از
+// configFP داشته باشه ErrHealthPoolConfig برمیگردونه، چون اون IP ها با این
+// proxy تست نشدن. فایل state رو نمی‌سازه اگه نباشه.
+func LoadHealthPool(configFP string) ([]string, error) {
+	paths := []string{"piyazche_ui.json"}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".piyazche", "ui.json"))
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var ps persistedState
+		if json.Unmarshal(data, &ps) != nil {
+			return nil, nil
+		}
+		if mergeConfig(ps.ProxyConfig, ps.ScanConfig, "").Fingerprint() != configFP {
+			return nil, ErrHealthPoolConfig
+		}
+		return aliveByUptime(ps.HealthEntries), nil
+	}
+	return nil, nil
 }
 
 // ── Server ────────────────────────────────────────────────────────────────────
 
 // Server — Web UI HTTP server
 type Server struct {
-	port    int
-	state   *AppState
-	hub     *WSHub
-	srv     *http.Server
-	mu      sync.Mutex
+	port     int
+	state    *AppState
+	hub      *WSHub
+	srv      *http.Server
+	mu       sync.Mutex
+	hist     *history.Store      // nil اگه فایل تاریخچه خونده نشد
+	testers  *scanner.TesterPool // xray instance های health monitor
+	budget   *scanner.Budget     // سقف تست‌های همزمان همه‌ی job ها
+	started  time.Time           // schedule ها اجراهای قبل از این رو جبران نمی‌کنن
+	opts     Options
+	auth     *authGuard
+	notifier *notifier
 }
 
 // AppState وضعیت کلی app — اینجا همه چیز نگه داشته میشه
 type AppState struct {
 	mu sync.RWMutex
 
-	// scan state
-	ScanStatus   string          // "idle", "scanning", "paused", "done"
-	ScanPhase    string          // "phase1", "phase2"
-	Progress     ScanProgress
-	P2Progress   P2ScanProgress  // وضعیت فاز ۲
-	Results      []scanner.Result
-	Phase2Results []scanner.Phase2Result
+	// scan jobs — قدیمی‌ترین اول، آخری job پیش‌فرض API های بدون ?job= هست
+	Jobs []*ScanJob
 
 	// history
 	Sessions []ScanSession
@@ -139,16 +189,10 @@ type AppState struct {
 	CurrentConfig *config.Config
 	ConfigRaw     string
 
-	// scan control
-	cancelFn      context.CancelFunc
-	phase2CancelFn context.CancelFunc // جداگانه برای فاز 2
-	scannerRef    *scanner.Scanner
-	CurrentIP     string
-
 	// saved config
 	SavedProxyConfig string
 	SavedScanConfig  string
-	SavedRawURL      string // لینک اصلی (vless:// vmess:// trojan://) برای copy-with-IP
+	SavedRawURL      string // لینک اصلی (vless:// vmess:// trojan:// ss:// hysteria2://) برای copy-with-IP
 	SavedRanges      string // IP ranges ذخیره شده
 
 	// config templates
@@ -157,6 +201,15 @@ type AppState struct {
 	// subnet intelligence
 	SubnetStats []config.SubnetStat
 
+	// scheduled scans (schedules.go)
+	Schedules []config.ScanSchedule
+
+	// live subscriptions (subscriptions.go)
+	Subscriptions []config.Subscription
+
+	// هشدارها (notify.go)
+	Notify config.NotifySettings
+
 	// health monitor
 	HealthEntries        map[string]*config.HealthEntry
 	healthStop           chan struct{}
@@ -165,6 +218,10 @@ type AppState struct {
 	HealthIntervalMins   int  // default: 3
 	HealthEnabled        bool // مانیتور فعال/غیرفعال
 	TrafficDetectEnabled bool // تشخیص ترافیک بدون speed test
+	HealthRotation       config.HealthRotation
+	rotationJob          string                  // mini-scan جایگزینی در حال اجرا
+	rotationRetired      map[string]time.Time    // IP های کنارگذاشته → زمان، تا زود برنگردن
+	rotationScans        map[string]rotationScan // subnet های mini-scan → آخرین اجرا و backoff
 
 	// TUI log
 	TUILog []string
@@ -185,6 +242,7 @@ type ScanProgress struct {
 	Done      int
 	Succeeded int
 	Failed    int
+	Failures  map[xray.Failure]int // Failed به تفکیک کلاس خطا
 	Rate      float64 // IPs/sec
 	StartTime time.Time
 	ETA       string
@@ -197,13 +255,19 @@ type ScanSession struct {
 	TotalIPs  int       `json:"totalIPs"`
 	Passed    int       `json:"passed"`
 	Config    string    `json:"config"` // config name
+	ConfigFP  string    `json:"configFp,omitempty"` // config.Fingerprint کانفیگ اسکن
 	Results   []scanner.Phase2Result `json:"results"`
 }
 
-// NewServer یه server جدید می‌سازه
+// NewServer یه server جدید بدون auth و TLS روی همه interface ها می‌سازه
 func NewServer(port int) *Server {
+	return NewServerWithOptions(port, Options{})
+}
+
+// NewServerWithOptions یه server جدید با تنظیمات bind/auth/TLS می‌سازه
+func NewServerWithOptions(port int, opts Options) *Server {
 	// Load persisted UI config from disk
-	proxyJSON, scanJSON, rawURL, savedTemplates, savedHealthEntries, savedHealthEnabled, savedHealthInterval, savedTrafficDetect, savedSessions, savedRanges, savedSubnetStats := loadStateFromDisk()
+	proxyJSON, scanJSON, rawURL, savedTemplates, savedHealthEntries, savedHealthEnabled, savedHealthInterval, savedTrafficDetect, savedRotation, savedSessions, savedRanges, savedSubnetStats, savedSchedules, savedSubscriptions, savedNotify := loadStateFromDisk()
 
 	if savedTemplates == nil {
 		savedTemplates = []config.ConfigTemplate{}
@@ -217,6 +281,12 @@ func NewServer(port int) *Server {
 	if savedSubnetStats == nil {
 		savedSubnetStats = []config.SubnetStat{}
 	}
+	if savedSchedules == nil {
+		savedSchedules = []config.ScanSchedule{}
+	}
+	if savedSubscriptions == nil {
+		savedSubscriptions = []config.Subscription{}
+	}
 
 	// مقادیر پیش‌فرض monitor — بعد از لود از دیسک override میشن
 	healthEnabled := true
@@ -231,9 +301,16 @@ func NewServer(port int) *Server {
 	if savedTrafficDetect != nil {
 		trafficDetect = *savedTrafficDetect
 	}
+	rotation := defaultHealthRotation()
+	if savedRotation != nil {
+		rotation = *savedRotation
+	}
+	notify := defaultNotifySettings()
+	if savedNotify != nil {
+		notify = *savedNotify
+	}
 
 	state := &AppState{
-		ScanStatus:           "idle",
 		Sessions:             savedSessions,
 		SavedProxyConfig:     proxyJSON,
 		SavedScanConfig:      scanJSON,
@@ -245,18 +322,40 @@ func NewServer(port int) *Server {
 		HealthIntervalMins:   healthIntervalMins,
 		HealthEnabled:        healthEnabled,
 		TrafficDetectEnabled: trafficDetect,
+		HealthRotation:       rotation,
+		rotationRetired:      make(map[string]time.Time),
+		rotationScans:        make(map[string]rotationScan),
 		SubnetStats:          savedSubnetStats,
+		Schedules:            savedSchedules,
+		Subscriptions:        savedSubscriptions,
+		Notify:               notify,
 	}
 
 	hub := NewWSHub()
 
 	mux := http.NewServeMux()
-	s := &Server{port: port, state: state, hub: hub}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = defaultWorkerBudget
+	}
+	s := &Server{port: port, state: state, hub: hub, testers: scanner.NewTesterPool(9*time.Second, 4), budget: scanner.NewBudget(workers), started: time.Now(), opts: opts, auth: newAuthGuard(opts), notifier: newNotifier()}
+	if hist, err := history.Open(history.DefaultPath()); err == nil {
+		if _, err := hist.Compact(history.DefaultRetention); err != nil {
+			fmt.Printf("  Warning: %v\n", err)
+		}
+		s.hist = hist
+	} else {
+		fmt.Printf("  Warning: %v\n", err)
+	}
 	s.registerRoutes(mux)
 
+	bind := opts.Bind
+	if bind == "" {
+		bind = "0.0.0.0"
+	}
 	s.srv = &http.Server{
-		Addr:    fmt.Sprintf("0.0.0.0:%d", port),
-		Handler: mux,
+		Addr:    net.JoinHostPort(bind, strconv.Itoa(port)),
+		Handler: s.auth.wrap(mux),
 	}
 
 	return s
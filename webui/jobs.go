@@ -0,0 +1,294 @@
+package webui
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"piyazche/config"
+	"piyazche/scanner"
+)
+
+// ── Scan Jobs ─────────────────────────────────────────────────────────────────
+//
+// هر اسکن یه job جداست با پیشرفت، نتایج، checkpoint و stop/pause خودش، پس
+// چند config (template) یا region همزمان اسکن میشن. phase 1 و phase 2 همه‌ی
+// job ها از یه scanner.Budget مشترک استفاده می‌کنن تا جمع تست‌ها (و xray
+// instance ها و port ها) از سقف --ui-workers بیشتر نشه. پیام‌های WebSocket هر job فیلد
+// "job" دارن.
+
+// defaultWorkerBudget سقف پیش‌فرض تست‌های همزمان همه‌ی job ها
+const defaultWorkerBudget = 256
+
+// maxFinishedJobs چند job تموم‌شده توی لیست بمونن — نتایجشون توی sessions هم هست
+const maxFinishedJobs = 20
+
+// ScanJob یه اسکن — همه‌ی فیلدها با state.mu محافظت میشن
+type ScanJob struct {
+	ID         string
+	Name       string
+	Template   string // template ID — خالی یعنی config ذخیره‌شده
+	Schedule   string // schedule ID — خالی یعنی دستی
+	RawURL     string // لینک proxy این job برای export
+	Status     string // "scanning", "paused", "stopped", "done"
+	Phase      string // "phase1", "phase2"
+	StartedAt  time.Time
+	Progress   ScanProgress
+	P2Progress P2ScanProgress
+	CurrentIP  string
+
+	Results       []scanner.Result
+	Phase2Results []scanner.Phase2Result
+
+	cfg            *config.Config
+	cp             *scanner.Checkpoint
+	cpPath         string
+	cancelFn       context.CancelFunc
+	phase2CancelFn context.CancelFunc // جداگانه برای فاز 2
+	scannerRef     *scanner.Scanner
+	onDone         func(*ScanJob) // بعد از اجرای کامل — schedule و rotation
+	internal       bool           // mini-scan rotation، session و notify نداره
+}
+
+func (j *ScanJob) active() bool {
+	return j.Status == "scanning" || j.Status == "paused"
+}
+
+// jobStatus خلاصه‌ی یه job برای API
+type jobStatus struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Template  string         `json:"template,omitempty"`
+	Schedule  string         `json:"schedule,omitempty"`
+	Status    string         `json:"status"` // idle (بدون job), scanning, paused, stopped, done
+	Phase     string         `json:"phase"`  // phase1, phase2 یا خالی
+	StartedAt time.Time      `json:"startedAt"`
+	CurrentIP string         `json:"currentIp,omitempty"`
+	Progress  ScanProgress   `json:"progress"`
+	Phase2    P2ScanProgress `json:"phase2"`
+	Resumable bool           `json:"resumable"` // checkpoint روی دیسک هست
+
+	Prefilter *scanner.PrefilterStats `json:"prefilter,omitempty"` // فقط وقتی scan.preFilter روشنه
+}
+
+// status — باید با state.mu گرفته‌شده صدا زده بشه
+func (j *ScanJob) status() jobStatus {
+	st := jobStatus{
+		ID:        j.ID,
+		Name:      j.Name,
+		Template:  j.Template,
+		Schedule:  j.Schedule,
+		Status:    j.Status,
+		Phase:     j.Phase,
+		StartedAt: j.StartedAt,
+		CurrentIP: j.CurrentIP,
+		Progress:  j.Progress,
+		Phase2:    j.P2Progress,
+	}
+	if j.scannerRef != nil {
+		if pf, ok := j.scannerRef.PrefilterStats(); ok {
+			st.Prefilter = &pf
+		}
+	}
+	if !j.active() {
+		_, err := os.Stat(j.cpPath)
+		st.Resumable = err == nil
+	}
+	return st
+}
+
+// jobStatuses همه‌ی job ها، قدیمی‌ترین اول
+func (s *Server) jobStatuses() []jobStatus {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	out := make([]jobStatus, 0, len(s.state.Jobs))
+	for _, j := range s.state.Jobs {
+		out = append(out, j.status())
+	}
+	return out
+}
+
+// findJob — id خالی یعنی آخرین job
+func (s *Server) findJob(id string) (*ScanJob, error) {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	return s.findJobLocked(id)
+}
+
+func (s *Server) findJobLocked(id string) (*ScanJob, error) {
+	jobs := s.state.Jobs
+	if id == "" {
+		if len(jobs) == 0 {
+			return nil, errNotFound("no scan job")
+		}
+		return jobs[len(jobs)-1], nil
+	}
+	for _, j := range jobs {
+		if j.ID == id {
+			return j, nil
+		}
+	}
+	return nil, errNotFound("no scan job " + id)
+}
+
+// addJob یه job ثبت و اجرا می‌کنه. ID همون session checkpoint هست تا
+// تاریخچه و sessions به job وصل بمونن. job با cpPath یعنی resume.
+func (s *Server) addJob(j *ScanJob) {
+	s.state.mu.Lock()
+	if j.cpPath == "" {
+		// session ID ثانیه‌ایه — دو job همزمان با جابجا کردن StartedAt جدا میشن
+		for {
+			j.ID = j.cp.SessionID()
+			if _, err := s.findJobLocked(j.ID); err != nil {
+				break
+			}
+			j.cp.StartedAt = j.cp.StartedAt.Add(time.Second)
+		}
+		j.cpPath = jobCheckpointPath(j.ID)
+	} else {
+		// run قبلی همین اسکن جاش رو به این یکی میده
+		j.ID = j.cp.SessionID()
+		s.dropJobLocked(j.ID)
+	}
+	if j.Name == "" {
+		j.Name = "scan " + j.ID
+	}
+	j.Status = "scanning"
+	j.Phase = "phase1"
+	j.StartedAt = time.Now()
+	j.Progress = ScanProgress{StartTime: j.StartedAt}
+	s.state.Jobs = append(s.state.Jobs, j)
+	s.pruneJobsLocked()
+	s.state.mu.Unlock()
+
+	go s.runJob(j)
+}
+
+// pruneJobsLocked قدیمی‌ترین job های تموم‌شده رو از لیست برمی‌داره
+func (s *Server) pruneJobsLocked() {
+	finished := 0
+	for _, j := range s.state.Jobs {
+		if !j.active() {
+			finished++
+		}
+	}
+	kept := s.state.Jobs[:0]
+	for _, j := range s.state.Jobs {
+		if !j.active() && finished > maxFinishedJobs {
+			finished--
+			continue
+		}
+		kept = append(kept, j)
+	}
+	s.state.Jobs = kept
+}
+
+// removeJob یه job تموم‌شده رو از لیست حذف می‌کنه (checkpoint و session می‌مونن)
+func (s *Server) removeJob(id string) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+	j, err := s.findJobLocked(id)
+	if err != nil {
+		return err
+	}
+	if j.active() {
+		return errConflict("job is still running, stop it first")
+	}
+	s.dropJobLocked(id)
+	return nil
+}
+
+func (s *Server) dropJobLocked(id string) {
+	kept := s.state.Jobs[:0]
+	for _, j := range s.state.Jobs {
+		if j.ID != id || j.active() {
+			kept = append(kept, j)
+		}
+	}
+	s.state.Jobs = kept
+}
+
+// jobBroadcast پیام WebSocket یه job — فیلد "job" اضافه میشه
+func (s *Server) jobBroadcast(j *ScanJob, msgType string, payload map[string]interface{}) {
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	payload["job"] = j.ID
+	payload["jobName"] = j.Name
+	s.hub.Broadcast(msgType, payload)
+}
+
+// jobLog مثل tuiLog، با اسم job جلوش وقتی بیشتر از یه job هست
+func (s *Server) jobLog(j *ScanJob, msg, level string) {
+	s.state.mu.RLock()
+	multi := len(s.state.Jobs) > 1
+	s.state.mu.RUnlock()
+	if multi {
+		msg = "[" + j.Name + "] " + msg
+	}
+	s.tuiLog(msg, level)
+}
+
+// jobCheckpointPath مسیر checkpoint هر job — کنار فایل state
+func jobCheckpointPath(id string) string {
+	return filepath.Join(filepath.Dir(configPersistPath()), "piyazche_checkpoint_"+id+".json")
+}
+
+// resumableCheckpoints checkpoint هایی که job در حال اجرا ندارن، جدیدترین اول.
+// piyazche_checkpoint.json نسخه‌های قبلی هم حساب میشه.
+func (s *Server) resumableCheckpoints() []string {
+	paths, _ := filepath.Glob(filepath.Join(filepath.Dir(configPersistPath()), "piyazche_checkpoint*.json"))
+
+	s.state.mu.RLock()
+	running := map[string]bool{}
+	for _, j := range s.state.Jobs {
+		if j.active() {
+			running[j.cpPath] = true
+		}
+	}
+	s.state.mu.RUnlock()
+
+	type cpFile struct {
+		path string
+		mod  time.Time
+	}
+	var files []cpFile
+	for _, p := range paths {
+		if running[p] {
+			continue
+		}
+		if fi, err := os.Stat(p); err == nil {
+			files = append(files, cpFile{p, fi.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, k int) bool { return files[i].mod.After(files[k].mod) })
+	out := make([]string, len(files))
+	for i, f := range files {
+		out[i] = f.path
+	}
+	return out
+}
+
+// scanResumable یعنی یه اسکن نصفه‌کاره روی دیسک هست
+func (s *Server) scanResumable() bool {
+	return len(s.resumableCheckpoints()) > 0
+}
+
+func (s *Server) savedRawURL() string {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	return s.state.SavedRawURL
+}
+
+// legacyStatus وضعیت آخرین job به شکل /api/status قدیمی — stopped همون idle
+func (s *Server) legacyStatus() (status, phase string) {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	j, err := s.findJobLocked("")
+	if err != nil || j.Status == "stopped" {
+		return "idle", ""
+	}
+	return j.Status, j.Phase
+}
@@ -0,0 +1,169 @@
+package webui
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"piyazche/config"
+	"piyazche/utils"
+	"piyazche/xray"
+)
+
+// handleMetrics وضعیت job های اسکن و health monitor رو به فرمت متنی Prometheus میده
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.state.mu.RLock()
+	jobs := make([]jobStatus, 0, len(s.state.Jobs))
+	for _, j := range s.state.Jobs {
+		jobs = append(jobs, j.status())
+	}
+	health := make([]config.HealthEntry, 0, len(s.state.HealthEntries))
+	for _, e := range s.state.HealthEntries {
+		health = append(health, *e)
+	}
+	healthEnabled := s.state.HealthEnabled
+	s.state.mu.RUnlock()
+
+	sort.Slice(health, func(i, j int) bool { return health[i].IP < health[j].IP })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m := &metricWriter{w: w}
+
+	statuses := []string{"scanning", "paused", "stopped", "done"}
+	m.family("piyazche_scan_jobs", "gauge", "Scan jobs by status")
+	for _, st := range statuses {
+		n := 0
+		for _, j := range jobs {
+			if j.Status == st {
+				n++
+			}
+		}
+		m.sample("piyazche_scan_jobs", float64(n), "status", st)
+	}
+
+	// هر job با label های job (ID) و name
+	perJob := func(name, help string, value func(j jobStatus) float64) {
+		m.family(name, "gauge", help)
+		for _, j := range jobs {
+			m.sample(name, value(j), "job", j.ID, "name", j.Name)
+		}
+	}
+	m.family("piyazche_scan_status", "gauge", "1 for the current status of a job")
+	for _, j := range jobs {
+		for _, st := range statuses {
+			m.sample("piyazche_scan_status", boolValue(j.Status == st), "job", j.ID, "name", j.Name, "status", st)
+		}
+	}
+	m.family("piyazche_scan_phase", "gauge", "1 for the current phase of a job")
+	for _, j := range jobs {
+		for _, ph := range []string{"phase1", "phase2"} {
+			m.sample("piyazche_scan_phase", boolValue(j.Phase == ph), "job", j.ID, "name", j.Name, "phase", ph)
+		}
+	}
+
+	perJob("piyazche_scan_ips_total", "IPs in a job", func(j jobStatus) float64 { return float64(j.Progress.Total) })
+	perJob("piyazche_scan_ips_done", "IPs tested so far in a job", func(j jobStatus) float64 { return float64(j.Progress.Done) })
+	perJob("piyazche_scan_ips_succeeded", "IPs that passed phase 1", func(j jobStatus) float64 { return float64(j.Progress.Succeeded) })
+	perJob("piyazche_scan_ips_failed", "IPs that failed phase 1", func(j jobStatus) float64 { return float64(j.Progress.Failed) })
+	perJob("piyazche_scan_rate", "Phase-1 IPs tested per second", func(j jobStatus) float64 { return j.Progress.Rate })
+	m.family("piyazche_scan_ips_failed_by_class", "gauge", "Phase-1 failures of a job per failure class")
+	for _, j := range jobs {
+		for _, f := range xray.Failures {
+			m.sample("piyazche_scan_ips_failed_by_class", float64(j.Progress.Failures[f]), "job", j.ID, "name", j.Name, "class", string(f))
+		}
+	}
+
+	perJob("piyazche_phase2_ips_total", "Candidates in phase 2 of a job", func(j jobStatus) float64 { return float64(j.Phase2.Total) })
+	perJob("piyazche_phase2_ips_done", "Candidates tested so far in phase 2", func(j jobStatus) float64 { return float64(j.Phase2.Done) })
+	perJob("piyazche_phase2_ips_passed", "Candidates that passed phase 2", func(j jobStatus) float64 { return float64(j.Phase2.Passed) })
+	perJob("piyazche_phase2_rate", "Phase-2 IPs tested per second", func(j jobStatus) float64 { return j.Phase2.Rate })
+
+	inUse, size := s.budget.Usage()
+	m.gauge("piyazche_worker_budget_in_use", "IPs under test across all jobs", float64(inUse))
+	m.gauge("piyazche_worker_budget_size", "Concurrent tests allowed across all jobs (--ui-workers)", float64(size))
+
+	m.gauge("piyazche_health_enabled", "1 when the health monitor is running", boolValue(healthEnabled))
+	m.gauge("piyazche_health_entries", "IPs watched by the health monitor", float64(len(health)))
+
+	m.family("piyazche_health_status", "gauge", "1 for the current status of a monitored IP")
+	for _, e := range health {
+		st := string(e.Status)
+		if st == "" {
+			st = string(config.HealthUnknown)
+		}
+		m.sample("piyazche_health_status", 1, "ip", e.IP, "status", st)
+	}
+	m.family("piyazche_health_up", "gauge", "1 when the last check of a monitored IP passed")
+	for _, e := range health {
+		up := e.Status == config.HealthAlive || e.Status == config.HealthRecovered
+		m.sample("piyazche_health_up", boolValue(up), "ip", e.IP)
+	}
+	m.family("piyazche_health_latency_ms", "gauge", "Latency of the last check in milliseconds")
+	for _, e := range health {
+		m.sample("piyazche_health_latency_ms", e.LatencyMs, "ip", e.IP)
+	}
+	m.family("piyazche_health_uptime_percent", "gauge", "Share of checks that passed, 0-100")
+	for _, e := range health {
+		m.sample("piyazche_health_uptime_percent", e.UptimePct, "ip", e.IP)
+	}
+	m.family("piyazche_health_consecutive_failures", "gauge", "Failed checks in a row")
+	for _, e := range health {
+		m.sample("piyazche_health_consecutive_failures", float64(e.ConsecFails), "ip", e.IP)
+	}
+	m.family("piyazche_health_checks_total", "counter", "Checks run against a monitored IP")
+	for _, e := range health {
+		m.sample("piyazche_health_checks_total", float64(e.TotalChecks), "ip", e.IP)
+	}
+
+	starts, failures := xray.StartStats()
+	m.counter("piyazche_xray_starts_total", "xray-core instances started", float64(starts))
+	m.counter("piyazche_xray_start_failures_total", "xray-core instances that failed to start", float64(failures))
+
+	inUse, size = utils.DefaultPortPool.Usage()
+	m.gauge("piyazche_port_pool_in_use", "Local SOCKS ports currently taken", float64(inUse))
+	m.gauge("piyazche_port_pool_size", "Local SOCKS ports in the pool", float64(size))
+}
+
+// metricWriter فرمت متنی Prometheus رو بدون وابستگی خارجی می‌نویسه
+type metricWriter struct {
+	w io.Writer
+}
+
+func (m *metricWriter) family(name, kind, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample یه مقدار با label ها (جفت name, value) می‌نویسه
+func (m *metricWriter) sample(name string, value float64, labels ...string) {
+	if len(labels) == 0 {
+		fmt.Fprintf(m.w, "%s %g\n", name, value)
+		return
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	fmt.Fprintf(m.w, "%s{%s} %g\n", name, strings.Join(pairs, ","), value)
+}
+
+func (m *metricWriter) gauge(name, help string, value float64) {
+	m.family(name, "gauge", help)
+	m.sample(name, value)
+}
+
+func (m *metricWriter) counter(name, help string, value float64) {
+	m.family(name, "counter", help)
+	m.sample(name, value)
+}
+
+// labelEscaper مقدار label رو طبق فرمت متنی Prometheus escape می‌کنه
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,428 @@
+package webui
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"piyazche/config"
+)
+
+// ── Notifications ─────────────────────────────────────────────────────────────
+//
+// هشدارهای حالت 24/7: پایان اسکن با IP های برتر، مردن یا برگشتن IP های
+// monitor و تموم شدن IP های سالم. هر target یه webhook (JSON POST با body
+// قابل template) یا یه Telegram bot ئه. MaxPerHour سقف پیام هر target ئه و
+// CooldownMins جلوی تکرار هشدار یه IP که مدام قطع و وصل میشه رو می‌گیره.
+
+const (
+	notifyScanDone    = "scan_done"
+	notifyIPDead      = "ip_dead"
+	notifyIPRecovered = "ip_recovered"
+	notifyNoHealthy   = "no_healthy"
+	notifyTest        = "test"
+)
+
+var notifyEvents = []string{notifyScanDone, notifyIPDead, notifyIPRecovered, notifyNoHealthy}
+
+// telegramAPI آدرس Bot API
+var telegramAPI = "https://api.telegram.org"
+
+func defaultNotifySettings() config.NotifySettings {
+	return config.NotifySettings{TopN: 5, MaxPerHour: 20, CooldownMins: 30}
+}
+
+// notifyEvent چیزی که به target ها فرستاده میشه — body پیش‌فرض webhook و
+// داده‌ی template
+type notifyEvent struct {
+	Event     string     `json:"event"`
+	Time      time.Time  `json:"time"`
+	Text      string     `json:"text"` // متن آماده برای چت
+	Job       string     `json:"job,omitempty"`
+	Name      string     `json:"name,omitempty"`
+	Passed    int        `json:"passed,omitempty"`
+	Total     int        `json:"total,omitempty"`
+	Duration  string     `json:"duration,omitempty"`
+	Top       []notifyIP `json:"top,omitempty"`
+	IP        string     `json:"ip,omitempty"`
+	LatencyMs float64    `json:"latencyMs,omitempty"`
+	UptimePct float64    `json:"uptimePct,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Monitored int        `json:"monitored,omitempty"`
+}
+
+type notifyIP struct {
+	IP        string  `json:"ip"`
+	LatencyMs float64 `json:"latencyMs"`
+	Score     float64 `json:"score"`
+	Grade     string  `json:"grade"`
+}
+
+// cooldownKey هشدارهای IP با همون کلید توی CooldownMins یه بار فرستاده میشن
+func (ev notifyEvent) cooldownKey() string {
+	switch ev.Event {
+	case notifyIPDead, notifyIPRecovered:
+		return ev.Event + ":" + ev.IP
+	case notifyNoHealthy:
+		return ev.Event
+	}
+	return ""
+}
+
+// notifier وضعیت rate limit و client مشترک
+type notifier struct {
+	mu     sync.Mutex
+	sent   map[string][]time.Time // target ID → پیام‌های ساعت اخیر
+	last   map[string]time.Time   // cooldownKey → آخرین هشدار
+	client *http.Client
+}
+
+func newNotifier() *notifier {
+	return &notifier{
+		sent:   make(map[string][]time.Time),
+		last:   make(map[string]time.Time),
+		client: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// cooldown — false یعنی همین هشدار تازه فرستاده شده
+func (n *notifier) cooldown(key string, d time.Duration) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	if at, ok := n.last[key]; ok && now.Sub(at) < d {
+		return false
+	}
+	n.last[key] = now
+	return true
+}
+
+// allow یه پیام برای target ثبت می‌کنه، اگه از سقف ساعتی رد نشده باشه
+func (n *notifier) allow(target string, perHour int) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	recent := n.sent[target][:0]
+	for _, at := range n.sent[target] {
+		if now.Sub(at) < time.Hour {
+			recent = append(recent, at)
+		}
+	}
+	if perHour > 0 && len(recent) >= perHour {
+		n.sent[target] = recent
+		return false
+	}
+	n.sent[target] = append(recent, now)
+	return true
+}
+
+// notify یه event رو به target هایی که می‌خوانش می‌فرسته — بدون block
+func (s *Server) notify(ev notifyEvent) {
+	s.state.mu.RLock()
+	settings := s.state.Notify
+	targets := append([]config.NotifyTarget(nil), settings.Targets...)
+	s.state.mu.RUnlock()
+	if !settings.Enabled {
+		return
+	}
+	if key := ev.cooldownKey(); key != "" && !s.notifier.cooldown(key, time.Duration(settings.CooldownMins)*time.Minute) {
+		return
+	}
+	ev.Time = time.Now()
+
+	for _, t := range targets {
+		if !t.Enabled || !targetWants(t, ev.Event) {
+			continue
+		}
+		if !s.notifier.allow(t.ID, settings.MaxPerHour) {
+			s.tuiLog(fmt.Sprintf("🔔 %s: سقف %d پیام در ساعت — %s فرستاده نشد", t.Name, settings.MaxPerHour, ev.Event), "warn")
+			continue
+		}
+		go func(t config.NotifyTarget) {
+			if err := s.notifier.send(t, ev); err != nil {
+				s.tuiLog("🔔 "+t.Name+": "+err.Error(), "warn")
+			}
+		}(t)
+	}
+}
+
+func targetWants(t config.NotifyTarget, event string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, e := range t.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// send یه event رو به یه target می‌فرسته
+func (n *notifier) send(t config.NotifyTarget, ev notifyEvent) error {
+	var endpoint string
+	var body []byte
+	var headers map[string]string
+	switch t.Type {
+	case "webhook":
+		endpoint = t.URL
+		var err error
+		if body, err = webhookBody(t, ev); err != nil {
+			return err
+		}
+		headers = t.Headers
+	case "telegram":
+		endpoint = telegramAPI + "/bot" + t.BotToken + "/sendMessage"
+		body, _ = json.Marshal(map[string]interface{}{
+			"chat_id":                  t.ChatID,
+			"text":                     ev.Text,
+			"disable_web_page_preview": true,
+		})
+	default:
+		return fmt.Errorf("unknown target type %q", t.Type)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "piyazche")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// آدرس telegram توکن bot رو داره — توی لاگ نیاد
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
+		}
+		return fmt.Errorf("%s failed: %w", t.Type, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		var tg struct {
+			Description string `json:"description"`
+		}
+		if json.Unmarshal(msg, &tg) == nil && tg.Description != "" {
+			msg = []byte(tg.Description)
+		}
+		return fmt.Errorf("%s returned %s: %s", t.Type, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// webhookBody — JSON خود event، یا Body با template روی event
+func webhookBody(t config.NotifyTarget, ev notifyEvent) ([]byte, error) {
+	if t.Body == "" {
+		return json.Marshal(ev)
+	}
+	tmpl, err := parseWebhookBody(t.Body)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ev); err != nil {
+		return nil, fmt.Errorf("body template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseWebhookBody — {{json .Text}} یه مقدار رو به شکل JSON امن می‌نویسه
+func parseWebhookBody(body string) (*template.Template, error) {
+	tmpl, err := template.New("body").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("body template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// notifyScanDone پایان یه job با IP های برتر و grade شون
+func (s *Server) notifyScanDone(j *ScanJob, passed int, duration time.Duration) {
+	s.state.mu.RLock()
+	topN := s.state.Notify.TopN
+	ranked := rankPassed(j.Phase2Results)
+	total := j.Progress.Total
+	s.state.mu.RUnlock()
+
+	if topN > 0 && len(ranked) > topN {
+		ranked = ranked[:topN]
+	} else if topN <= 0 {
+		ranked = nil
+	}
+	ev := notifyEvent{
+		Event:    notifyScanDone,
+		Job:      j.ID,
+		Name:     j.Name,
+		Passed:   passed,
+		Total:    total,
+		Duration: duration.Round(time.Second).String(),
+	}
+	name := j.Name
+	if name == "" {
+		name = "job " + j.ID
+	}
+	text := fmt.Sprintf("✅ Scan finished — %s\n%d passed of %d IPs in %s", name, passed, total, ev.Duration)
+	for i, r := range ranked {
+		grade := scoreToGrade(r.StabilityScore)
+		ev.Top = append(ev.Top, notifyIP{IP: r.IP, LatencyMs: r.AvgLatencyMs, Score: r.StabilityScore, Grade: grade})
+		if i == 0 {
+			text += "\n"
+		}
+		text += fmt.Sprintf("\n%d. %s  %.0fms  %s (%.0f)", i+1, r.IP, r.AvgLatencyMs, grade, r.StabilityScore)
+	}
+	ev.Text = text
+	s.notify(ev)
+}
+
+// healthTransitionEventsLocked هشدارهای تغییر وضعیت یه IP monitor
+func (s *Server) healthTransitionEventsLocked(he *config.HealthEntry, prev config.HealthStatus, errMsg string) []notifyEvent {
+	var events []notifyEvent
+	switch {
+	case he.Status == config.HealthDead && (prev == config.HealthAlive || prev == config.HealthRecovered):
+		text := fmt.Sprintf("🔴 %s is down (uptime %.0f%%)", he.IP, he.UptimePct)
+		if errMsg != "" {
+			text += "\n" + errMsg
+		}
+		events = append(events, notifyEvent{Event: notifyIPDead, IP: he.IP, UptimePct: he.UptimePct, Error: errMsg, Text: text})
+		if len(aliveByUptime(s.state.HealthEntries)) == 0 {
+			n := len(s.state.HealthEntries)
+			events = append(events, notifyEvent{Event: notifyNoHealthy, Monitored: n,
+				Text: fmt.Sprintf("⚠️ No healthy IPs left in the health monitor (%d monitored)", n)})
+		}
+	case he.Status == config.HealthRecovered && prev == config.HealthDead:
+		events = append(events, notifyEvent{Event: notifyIPRecovered, IP: he.IP, LatencyMs: he.LatencyMs, UptimePct: he.UptimePct,
+			Text: fmt.Sprintf("🟢 %s is back — %.0fms", he.IP, he.LatencyMs)})
+	}
+	return events
+}
+
+// notifySettings یه کپی از تنظیمات
+func (s *Server) notifySettings() config.NotifySettings {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	n := s.state.Notify
+	n.Targets = append([]config.NotifyTarget{}, n.Targets...)
+	return n
+}
+
+// updateNotifySettings تنظیمات رو چک و کامل جایگزین می‌کنه
+func (s *Server) updateNotifySettings(n config.NotifySettings) (config.NotifySettings, error) {
+	if n.TopN < 0 || n.MaxPerHour < 0 || n.CooldownMins < 0 {
+		return n, errBadRequest("topN, maxPerHour and cooldownMins must not be negative")
+	}
+	seen := map[string]bool{}
+	for i := range n.Targets {
+		t := &n.Targets[i]
+		if err := validateNotifyTarget(t); err != nil {
+			return n, errBadRequest(fmt.Sprintf("target %d: %v", i+1, err))
+		}
+		for t.ID == "" || seen[t.ID] {
+			t.ID = fmt.Sprintf("%d%d", time.Now().UnixMilli(), i)
+		}
+		seen[t.ID] = true
+	}
+	if n.Targets == nil {
+		n.Targets = []config.NotifyTarget{}
+	}
+
+	s.state.mu.Lock()
+	s.state.Notify = n
+	s.state.mu.Unlock()
+	go s.saveStateToDiskNow()
+	return n, nil
+}
+
+func validateNotifyTarget(t *config.NotifyTarget) error {
+	switch t.Type {
+	case "webhook":
+		u, err := url.Parse(t.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook needs an http(s) url")
+		}
+		if t.Body != "" {
+			if _, err := parseWebhookBody(t.Body); err != nil {
+				return err
+			}
+		}
+	case "telegram":
+		if t.BotToken == "" || t.ChatID == "" {
+			return fmt.Errorf("telegram needs botToken and chatId")
+		}
+	default:
+		return fmt.Errorf("type must be webhook or telegram")
+	}
+	for _, e := range t.Events {
+		if !containsString(notifyEvents, e) {
+			return fmt.Errorf("unknown event %q, expected one of %s", e, strings.Join(notifyEvents, ", "))
+		}
+	}
+	if t.Name == "" {
+		t.Name = t.Type
+	}
+	return nil
+}
+
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyTestResult نتیجه‌ی پیام آزمایشی یه target
+type notifyTestResult struct {
+	Target string `json:"target"`
+	Name   string `json:"name"`
+	Error  string `json:"error,omitempty"`
+}
+
+// sendTestNotification یه پیام آزمایشی به یه target (یا همه اگه id خالیه)
+// می‌فرسته و منتظر جواب می‌مونه — فعال بودن و rate limit رو نادیده می‌گیره
+func (s *Server) sendTestNotification(id string) ([]notifyTestResult, error) {
+	settings := s.notifySettings()
+	ev := notifyEvent{Event: notifyTest, Time: time.Now(), Text: "🔔 Piyazche test notification"}
+
+	var targets []config.NotifyTarget
+	for _, t := range settings.Targets {
+		if id == "" || t.ID == id {
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		if id != "" {
+			return nil, errNotFound("no notification target " + id)
+		}
+		return nil, errBadRequest("no notification targets")
+	}
+
+	var wg sync.WaitGroup
+	results := make([]notifyTestResult, len(targets))
+	for i, t := range targets {
+		results[i] = notifyTestResult{Target: t.ID, Name: t.Name}
+		wg.Add(1)
+		go func(r *notifyTestResult, t config.NotifyTarget) {
+			defer wg.Done()
+			if err := s.notifier.send(t, ev); err != nil {
+				r.Error = err.Error()
+			}
+		}(&results[i], t)
+	}
+	wg.Wait()
+	return results, nil
+}
@@ -0,0 +1,232 @@
+package webui
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPIDoc سند OpenAPI 3 رو از جدول route ها و type های پاسخ می‌سازه، پس
+// با خود کد همیشه یکیه
+func openAPIDoc(routes []apiRoute) map[string]interface{} {
+	g := &schemaGen{components: map[string]interface{}{}}
+
+	errResp := map[string]interface{}{
+		"description": "Error",
+		"content":     jsonContent(g.schema(reflect.TypeOf(errorBody{}))),
+	}
+	paths := map[string]map[string]interface{}{}
+	for _, rt := range routes {
+		op := map[string]interface{}{
+			"summary":     rt.Summary,
+			"tags":        []string{rt.Tag},
+			"operationId": operationID(rt),
+			"responses":   map[string]interface{}{"default": errResp},
+		}
+		if len(rt.Params) > 0 {
+			params := make([]map[string]interface{}, 0, len(rt.Params))
+			for _, p := range rt.Params {
+				param := map[string]interface{}{
+					"name":     p.Name,
+					"in":       p.In,
+					"required": p.Required || p.In == "path",
+					"schema":   map[string]interface{}{"type": p.Type},
+				}
+				if p.Desc != "" {
+					param["description"] = p.Desc
+				}
+				params = append(params, param)
+			}
+			op["parameters"] = params
+		}
+		if rt.Body != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(g.schema(reflect.TypeOf(rt.Body))),
+			}
+		}
+
+		status := rt.Status
+		if status == 0 {
+			status = 200
+		}
+		resp := map[string]interface{}{"description": "OK"}
+		if rt.Resp != nil {
+			props := map[string]interface{}{"data": g.schema(reflect.TypeOf(rt.Resp))}
+			required := []string{"data"}
+			if rt.Paged {
+				props["page"] = g.schema(reflect.TypeOf(pageInfo{}))
+				required = append(required, "page")
+			}
+			resp["content"] = jsonContent(map[string]interface{}{
+				"type":       "object",
+				"properties": props,
+				"required":   required,
+			})
+		} else if status != 204 {
+			resp["content"] = jsonContent(map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"data": map[string]interface{}{"nullable": true}},
+			})
+		}
+		op["responses"].(map[string]interface{})[strconv.Itoa(status)] = resp
+
+		if paths[rt.Path] == nil {
+			paths[rt.Path] = map[string]interface{}{}
+		}
+		paths[rt.Path][strings.ToLower(rt.Method)] = op
+	}
+	paths["/api/v1/openapi.json"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "This document",
+			"tags":        []string{"meta"},
+			"operationId": "getOpenAPI",
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "OpenAPI 3 document",
+					"content":     jsonContent(map[string]interface{}{"type": "object"}),
+				},
+			},
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Piyazche API",
+			"version":     "1",
+			"description": "Scan, monitor and query clean IPs. Errors always look like {\"error\": {\"code\", \"message\"}}.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.components,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"cookie": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": sessionCookie},
+			},
+		},
+		"security": []map[string]interface{}{
+			{"bearer": []string{}},
+			{"cookie": []string{}},
+		},
+	}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// operationID مثلاً "GET /api/v1/health/{ip}" → "getHealthByIp"
+func operationID(rt apiRoute) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(rt.Method))
+	for _, part := range strings.Split(strings.TrimPrefix(rt.Path, "/api/v1/"), "/") {
+		if strings.HasPrefix(part, "{") {
+			part = "by-" + strings.Trim(part, "{}")
+		}
+		for _, word := range strings.Split(part, "-") {
+			if word != "" {
+				b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+			}
+		}
+	}
+	return b.String()
+}
+
+// schemaGen JSON schema رو با reflection از type های Go می‌سازه؛ struct های
+// با اسم توی components میرن و با $ref صدا زده میشن
+type schemaGen struct {
+	components map[string]interface{}
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	durType     = reflect.TypeOf(time.Duration(0))
+	rawJSONType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durType:
+		return map[string]interface{}{"type": "integer", "description": "nanoseconds"}
+	case rawJSONType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schema(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		name := schemaName(t)
+		if _, done := g.components[name]; !done {
+			g.components[name] = nil // جلوی recursion
+			g.components[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+func (g *schemaGen) structSchema(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	g.addFields(t, props)
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// addFields فیلدهای export شده رو با اسم JSON اضافه می‌کنه؛ struct های
+// embedded مثل encoding/json صاف میشن
+func (g *schemaGen) addFields(t reflect.Type, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.addFields(f.Type, props)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+	}
+}
+
+// schemaName اسم component — پکیج‌ها prefix میشن تا Result و ... قاطی نشن
+func schemaName(t reflect.Type) string {
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	if pkg == "" || pkg == "webui" {
+		name := strings.TrimSuffix(t.Name(), "V1")
+		return strings.ToUpper(name[:1]) + name[1:]
+	}
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + t.Name()
+}
@@ -0,0 +1,236 @@
+package webui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"piyazche/config"
+	"piyazche/history"
+	"piyazche/scanner"
+)
+
+// ── Health Rotation ───────────────────────────────────────────────────────────
+//
+// بعد از هر دور چک monitor، IP هایی که بعد از MinChecks چک uptime شون زیر
+// MinUptimePct مونده کنار میرن. اگه تعداد IP های سالم از PoolSize کمتر بود،
+// اول بهترین نتایج session های اخیر اضافه میشن و اگه کم بود یه mini-scan
+// روی subnet IP های مرده بقیه رو پیدا می‌کنه. IP های جایگزین با Source
+// "rotation" ثبت میشن. mini-scan یه مجموعه subnet حداقل rotationScanCooldown
+// فاصله داره و هر اجرای بی‌نتیجه این فاصله رو دو برابر می‌کنه؛ نه session
+// ذخیره می‌کنه نه notify میده.
+
+const (
+	rotationSource       = "rotation"
+	rotationRetireTTL    = 24 * time.Hour   // IP کنارگذاشته تا این مدت دوباره اضافه نمیشه
+	rotationSessions     = 5                // چند session آخر منبع جایگزین هستن
+	rotationScanCooldown = 30 * time.Minute // حداقل فاصله‌ی mini-scan های یه مجموعه subnet
+	rotationScanMaxWait  = 24 * time.Hour   // سقف backoff
+)
+
+// rotationScan آخرین mini-scan یه مجموعه subnet و تعداد اجراهای پشت سر هم
+// که هیچ IP جایگزینی پیدا نکردن
+type rotationScan struct {
+	at        time.Time
+	fruitless int
+}
+
+// wait فاصله تا mini-scan بعدی: cooldown × 2^fruitless تا سقف rotationScanMaxWait
+func (r rotationScan) wait() time.Duration {
+	if r.fruitless >= 6 {
+		return rotationScanMaxWait
+	}
+	return min(rotationScanCooldown<<r.fruitless, rotationScanMaxWait)
+}
+
+func defaultHealthRotation() config.HealthRotation {
+	return config.HealthRotation{PoolSize: 10, MinUptimePct: 50, MinChecks: 10, ScanMaxIPs: 256}
+}
+
+// rotateHealthPool سیاست rotation رو یه بار اجرا می‌کنه
+func (s *Server) rotateHealthPool() {
+	s.state.mu.Lock()
+	pol := s.state.HealthRotation
+	if !pol.Enabled {
+		s.state.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	for ip, at := range s.state.rotationRetired {
+		if now.Sub(at) > rotationRetireTTL {
+			delete(s.state.rotationRetired, ip)
+		}
+	}
+	for key, rs := range s.state.rotationScans {
+		if now.Sub(rs.at) > rotationScanMaxWait {
+			delete(s.state.rotationScans, key)
+		}
+	}
+
+	var retired []string
+	deadSubnets := map[string]bool{}
+	for ip, e := range s.state.HealthEntries {
+		if e.TotalChecks > 0 && e.TotalChecks >= pol.MinChecks && e.UptimePct < pol.MinUptimePct {
+			retired = append(retired, ip)
+			delete(s.state.HealthEntries, ip)
+			s.state.rotationRetired[ip] = now
+		} else if e.Status != config.HealthDead {
+			continue
+		}
+		if subnet := history.SubnetOf(ip); subnet != "" {
+			deadSubnets[subnet] = true
+		}
+	}
+
+	need := pol.PoolSize - s.healthyCountLocked()
+	var recent []scanner.Phase2Result
+	for i := 0; i < len(s.state.Sessions) && i < rotationSessions; i++ {
+		recent = append(recent, s.state.Sessions[i].Results...)
+	}
+	replacements := s.rotationCandidatesLocked(recent, need)
+	need -= len(replacements)
+
+	scanRunning := false
+	if s.state.rotationJob != "" {
+		if j, err := s.findJobLocked(s.state.rotationJob); err == nil {
+			scanRunning = j.active()
+		}
+	}
+	subnets := make([]string, 0, len(deadSubnets))
+	for subnet := range deadSubnets {
+		subnets = append(subnets, subnet)
+	}
+	sort.Strings(subnets)
+	scanKey := strings.Join(subnets, "\n")
+	last, scanned := s.state.rotationScans[scanKey]
+	scanDue := !scanned || now.Sub(last.at) >= last.wait()
+	s.state.mu.Unlock()
+
+	for _, r := range replacements {
+		s.addHealthEntry(r.IP, r.AvgLatencyMs, rotationSource)
+	}
+	if len(retired) > 0 {
+		go s.saveStateToDiskNow()
+	}
+
+	scanJob := ""
+	if need > 0 && len(subnets) > 0 && !scanRunning && scanDue {
+		job, _, err := s.startScan(scanRequest{
+			IPRanges:    scanKey,
+			MaxIPs:      pol.ScanMaxIPs,
+			Name:        "rotation",
+			forcePhase2: true,
+			onDone:      func(j *ScanJob) { s.rotationDone(j, scanKey) },
+			internal:    true,
+		})
+		if err != nil {
+			s.tuiLog("♻ rotation mini-scan: "+err.Error(), "warn")
+		} else {
+			scanJob = job.ID
+			s.state.mu.Lock()
+			s.state.rotationJob = job.ID
+			s.state.rotationScans[scanKey] = rotationScan{at: now, fruitless: last.fruitless}
+			s.state.mu.Unlock()
+		}
+	}
+
+	if len(retired) == 0 && len(replacements) == 0 && scanJob == "" {
+		return
+	}
+	added := make([]string, len(replacements))
+	for i, r := range replacements {
+		added[i] = r.IP
+	}
+	msg := fmt.Sprintf("♻ rotation — %d IP کنار رفت، %d IP از session های اخیر اضافه شد", len(retired), len(added))
+	if scanJob != "" {
+		msg += fmt.Sprintf("، mini-scan روی %d subnet", len(deadSubnets))
+	}
+	s.tuiLog(msg, "info")
+	s.hub.Broadcast("health_rotation", map[string]interface{}{
+		"retired": retired,
+		"added":   added,
+		"scanJob": scanJob,
+	})
+}
+
+// rotationDone بهترین نتایج mini-scan رو تا پر شدن pool اضافه می‌کنه؛ اگه
+// چیزی پیدا نشد backoff مجموعه subnet های scanKey بیشتر میشه
+func (s *Server) rotationDone(j *ScanJob, scanKey string) {
+	s.state.mu.Lock()
+	need := s.state.HealthRotation.PoolSize - s.healthyCountLocked()
+	replacements := s.rotationCandidatesLocked(j.Phase2Results, need)
+	if rs, ok := s.state.rotationScans[scanKey]; ok {
+		if len(replacements) == 0 {
+			rs.fruitless++
+		} else {
+			rs.fruitless = 0
+		}
+		s.state.rotationScans[scanKey] = rs
+	}
+	s.state.mu.Unlock()
+
+	added := make([]string, len(replacements))
+	for i, r := range replacements {
+		s.addHealthEntry(r.IP, r.AvgLatencyMs, rotationSource)
+		added[i] = r.IP
+	}
+	s.jobLog(j, fmt.Sprintf("♻ rotation — %d IP جایگزین از mini-scan اضافه شد", len(added)), "ok")
+	s.hub.Broadcast("health_rotation", map[string]interface{}{
+		"retired": []string{},
+		"added":   added,
+		"scanJob": j.ID,
+	})
+}
+
+// healthyCountLocked IP هایی که مرده نیستن — unknown یعنی هنوز چک اولش نرسیده
+func (s *Server) healthyCountLocked() int {
+	n := 0
+	for _, e := range s.state.HealthEntries {
+		if e.Status != config.HealthDead {
+			n++
+		}
+	}
+	return n
+}
+
+// rotationCandidatesLocked بهترین n نتیجه‌ی pass شده که توی monitor نیستن و
+// تازه کنار نرفتن
+func (s *Server) rotationCandidatesLocked(results []scanner.Phase2Result, n int) []scanner.Phase2Result {
+	if n <= 0 {
+		return nil
+	}
+	seen := map[string]bool{}
+	var out []scanner.Phase2Result
+	for _, r := range rankPassed(results) {
+		if seen[r.IP] || s.state.HealthEntries[r.IP] != nil {
+			continue
+		}
+		if _, retired := s.state.rotationRetired[r.IP]; retired {
+			continue
+		}
+		seen[r.IP] = true
+		if out = append(out, r); len(out) == n {
+			break
+		}
+	}
+	return out
+}
+
+// rankPassed نتایج pass شده، بهترین score اول و بعد کمترین latency
+func rankPassed(results []scanner.Phase2Result) []scanner.Phase2Result {
+	var passed []scanner.Phase2Result
+	for _, r := range results {
+		if r.Passed {
+			passed = append(passed, r)
+		}
+	}
+	sort.SliceStable(passed, func(a, b int) bool {
+		if passed[a].StabilityScore != passed[b].StabilityScore {
+			return passed[a].StabilityScore > passed[b].StabilityScore
+		}
+		return passed[a].AvgLatencyMs < passed[b].AvgLatencyMs
+	})
+	return passed
+}
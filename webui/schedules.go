@@ -0,0 +1,336 @@
+package webui
+
+import (
+	"fmt"
+	"time"
+
+	"piyazche/config"
+	"piyazche/utils"
+)
+
+// ── Scheduled Scans ───────────────────────────────────────────────────────────
+//
+// هر schedule یه template رو با cron یا interval روی ranges ذخیره‌شده اسکن
+// می‌کنه. هر اجرا یه job معمولیه (با فاز 2) و session ش مثل اسکن دستی ذخیره
+// میشه؛ با HealthTopN بهترین IP ها جای IP های اجرای قبلی همون schedule توی
+// health monitor میرن. اجراهایی که server خاموش بوده تکرار نمیشن.
+
+// scheduleTick هر چند وقت schedule ها چک میشن — cron دقیقه‌ایه
+const scheduleTick = 20 * time.Second
+
+// scheduleView یه schedule با وضعیت اجراش برای API
+type scheduleView struct {
+	config.ScanSchedule
+	NextRun *time.Time `json:"nextRun"` // nil یعنی غیرفعال
+	Running bool       `json:"running"` // اجرای قبلی هنوز در حال اسکنه
+}
+
+// scheduleEdit فیلدهای ساخت/تغییر schedule — فیلدهای nil دست نمی‌خورن
+type scheduleEdit struct {
+	Name       *string `json:"name,omitempty"`
+	Spec       *string `json:"spec,omitempty"`
+	Template   *string `json:"template,omitempty"`
+	Ranges     *string `json:"ranges,omitempty"`
+	MaxIPs     *int    `json:"maxIPs,omitempty"`
+	HealthTopN *int    `json:"healthTopN,omitempty"`
+	Enabled    *bool   `json:"enabled,omitempty"`
+}
+
+// runScheduler schedule هایی که وقتشون رسیده رو اجرا می‌کنه
+func (s *Server) runScheduler() {
+	ticker := time.NewTicker(scheduleTick)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, id := range s.claimDueSchedules(now) {
+			if _, _, err := s.runSchedule(id); err != nil {
+				s.tuiLog("⏰ schedule "+id+": "+err.Error(), "warn")
+			}
+		}
+	}
+}
+
+// claimDueSchedules schedule های سررسید رو برمی‌گردونه و LastRun شون رو
+// جلو می‌بره تا حتی اگه اجرا نشد، تیک بعدی دوباره امتحانش نکنه
+func (s *Server) claimDueSchedules(now time.Time) []string {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+	var due []string
+	for i := range s.state.Schedules {
+		sc := &s.state.Schedules[i]
+		if next, ok := s.nextRunLocked(*sc); ok && !now.Before(next) {
+			sc.LastRun = now.Unix()
+			due = append(due, sc.ID)
+		}
+	}
+	return due
+}
+
+// nextRunLocked زمان اجرای بعدی — از آخرین اجرا، ساخت schedule یا start server
+func (s *Server) nextRunLocked(sc config.ScanSchedule) (time.Time, bool) {
+	if !sc.Enabled {
+		return time.Time{}, false
+	}
+	sched, err := utils.ParseSchedule(sc.Spec)
+	if err != nil {
+		return time.Time{}, false
+	}
+	anchor := s.started
+	for _, t := range []int64{sc.LastRun, sc.CreatedAt} {
+		if at := time.Unix(t, 0); at.After(anchor) {
+			anchor = at
+		}
+	}
+	next := sched.Next(anchor)
+	return next, !next.IsZero()
+}
+
+// runSchedule یه اجرای schedule رو همین الان به عنوان job شروع می‌کنه
+func (s *Server) runSchedule(id string) (*ScanJob, int, error) {
+	s.state.mu.Lock()
+	sc := s.findScheduleLocked(id)
+	if sc == nil {
+		s.state.mu.Unlock()
+		return nil, 0, errNotFound("no schedule " + id)
+	}
+	if j, err := s.findJobLocked(sc.LastJob); sc.LastJob != "" && err == nil && j.active() {
+		s.state.mu.Unlock()
+		return nil, 0, errConflict("the previous run is still scanning")
+	}
+	req := scanRequest{
+		IPRanges: sc.Ranges,
+		MaxIPs:   sc.MaxIPs,
+		Name:     sc.Name,
+		Template: sc.Template,
+		schedule: sc.ID,
+		// session و monitor نتیجه‌ی فاز 2 رو می‌خوان
+		forcePhase2: true,
+		onDone:      s.scheduleDone,
+	}
+	if req.IPRanges == "" {
+		req.IPRanges = s.state.SavedRanges
+	}
+	sc.LastRun = time.Now().Unix()
+	s.state.mu.Unlock()
+
+	job, total, err := s.startScan(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	s.state.mu.Lock()
+	if sc := s.findScheduleLocked(id); sc != nil {
+		sc.LastJob = job.ID
+	}
+	s.state.mu.Unlock()
+	go s.saveStateToDiskNow()
+
+	s.jobLog(job, "⏰ اجرای زمان‌بندی‌شده", "info")
+	return job, total, nil
+}
+
+// scheduleDone بعد از اجرای کامل، بهترین IP ها رو جای IP های قبلی همین
+// schedule توی monitor میذاره
+func (s *Server) scheduleDone(j *ScanJob) {
+	s.state.mu.RLock()
+	topN := 0
+	if sc := s.findScheduleLocked(j.Schedule); sc != nil {
+		topN = sc.HealthTopN
+	}
+	passed := rankPassed(j.Phase2Results)
+	s.state.mu.RUnlock()
+	if topN <= 0 {
+		return
+	}
+	if len(passed) == 0 {
+		s.jobLog(j, "⚠ هیچ IP ای pass نشد — لیست monitor دست نخورد", "warn")
+		return
+	}
+	if len(passed) > topN {
+		passed = passed[:topN]
+	}
+	keep := make(map[string]bool, len(passed))
+	for _, r := range passed {
+		keep[r.IP] = true
+	}
+
+	source := "schedule:" + j.Schedule
+	removed := 0
+	s.state.mu.Lock()
+	for ip, e := range s.state.HealthEntries {
+		if e.Source == source && !keep[ip] {
+			delete(s.state.HealthEntries, ip)
+			removed++
+		}
+	}
+	s.state.mu.Unlock()
+	for _, r := range passed {
+		s.addHealthEntry(r.IP, r.AvgLatencyMs, source)
+	}
+	s.jobLog(j, fmt.Sprintf("♡ monitor آپدیت شد — %d IP برتر، %d IP قبلی حذف شد", len(passed), removed), "ok")
+}
+
+func (s *Server) findScheduleLocked(id string) *config.ScanSchedule {
+	for i := range s.state.Schedules {
+		if s.state.Schedules[i].ID == id {
+			return &s.state.Schedules[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) scheduleViewLocked(sc config.ScanSchedule) scheduleView {
+	v := scheduleView{ScanSchedule: sc}
+	if next, ok := s.nextRunLocked(sc); ok {
+		v.NextRun = &next
+	}
+	if j, err := s.findJobLocked(sc.LastJob); sc.LastJob != "" && err == nil {
+		v.Running = j.active()
+	}
+	return v
+}
+
+func (s *Server) scheduleViews() []scheduleView {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	out := make([]scheduleView, 0, len(s.state.Schedules))
+	for _, sc := range s.state.Schedules {
+		out = append(out, s.scheduleViewLocked(sc))
+	}
+	return out
+}
+
+func (s *Server) scheduleByID(id string) (scheduleView, error) {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	sc := s.findScheduleLocked(id)
+	if sc == nil {
+		return scheduleView{}, errNotFound("no schedule " + id)
+	}
+	return s.scheduleViewLocked(*sc), nil
+}
+
+// addSchedule یه schedule جدید می‌سازه — spec لازمه و پیش‌فرض فعاله
+func (s *Server) addSchedule(req scheduleEdit) (scheduleView, error) {
+	if req.Spec == nil {
+		return scheduleView{}, errBadRequest("spec is required")
+	}
+	sc := config.ScanSchedule{
+		ID:        fmt.Sprintf("%d", time.Now().UnixMilli()),
+		Enabled:   true,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := s.applyScheduleEdit(&sc, req); err != nil {
+		return scheduleView{}, err
+	}
+
+	s.state.mu.Lock()
+	for s.findScheduleLocked(sc.ID) != nil {
+		sc.ID += "0"
+	}
+	s.state.Schedules = append(s.state.Schedules, sc)
+	v := s.scheduleViewLocked(sc)
+	s.state.mu.Unlock()
+
+	go s.saveStateToDiskNow()
+	return v, nil
+}
+
+// updateSchedule فیلدهای داده‌شده رو عوض می‌کنه
+func (s *Server) updateSchedule(id string, req scheduleEdit) (scheduleView, error) {
+	sc, err := s.scheduleByID(id)
+	if err != nil {
+		return scheduleView{}, err
+	}
+	updated := sc.ScanSchedule
+	if err := s.applyScheduleEdit(&updated, req); err != nil {
+		return scheduleView{}, err
+	}
+
+	s.state.mu.Lock()
+	cur := s.findScheduleLocked(id)
+	if cur == nil {
+		s.state.mu.Unlock()
+		return scheduleView{}, errNotFound("no schedule " + id)
+	}
+	// LastRun/LastJob ممکنه وسطش عوض شده باشن
+	updated.LastRun, updated.LastJob = cur.LastRun, cur.LastJob
+	*cur = updated
+	v := s.scheduleViewLocked(updated)
+	s.state.mu.Unlock()
+
+	go s.saveStateToDiskNow()
+	return v, nil
+}
+
+// applyScheduleEdit تغییرات رو چک و روی sc اعمال می‌کنه
+func (s *Server) applyScheduleEdit(sc *config.ScanSchedule, req scheduleEdit) error {
+	if req.Spec != nil {
+		if _, err := utils.ParseSchedule(*req.Spec); err != nil {
+			return errBadRequest("spec: " + err.Error())
+		}
+		sc.Spec = *req.Spec
+	}
+	if req.Template != nil {
+		if *req.Template != "" && s.templateName(*req.Template) == "" {
+			return errBadRequest("no template " + *req.Template)
+		}
+		sc.Template = *req.Template
+	}
+	if req.Ranges != nil {
+		sc.Ranges = *req.Ranges
+	}
+	if req.MaxIPs != nil {
+		if *req.MaxIPs < 0 {
+			return errBadRequest("maxIPs must not be negative")
+		}
+		sc.MaxIPs = *req.MaxIPs
+	}
+	if req.HealthTopN != nil {
+		if *req.HealthTopN < 0 {
+			return errBadRequest("healthTopN must not be negative")
+		}
+		sc.HealthTopN = *req.HealthTopN
+	}
+	if req.Enabled != nil {
+		sc.Enabled = *req.Enabled
+	}
+	if req.Name != nil {
+		sc.Name = *req.Name
+	}
+	if sc.Name == "" {
+		// اسم job و session های این schedule
+		if sc.Name = s.templateName(sc.Template); sc.Name == "" {
+			sc.Name = "scheduled scan"
+		}
+	}
+	return nil
+}
+
+func (s *Server) templateName(id string) string {
+	s.state.mu.RLock()
+	defer s.state.mu.RUnlock()
+	for _, t := range s.state.Templates {
+		if t.ID == id {
+			return t.Name
+		}
+	}
+	return ""
+}
+
+// deleteSchedule — false یعنی پیدا نشد. IP هایی که به monitor اضافه کرده می‌مونن.
+func (s *Server) deleteSchedule(id string) bool {
+	s.state.mu.Lock()
+	found := false
+	out := s.state.Schedules[:0]
+	for _, sc := range s.state.Schedules {
+		if sc.ID != id {
+			out = append(out, sc)
+		} else {
+			found = true
+		}
+	}
+	s.state.Schedules = out
+	s.state.mu.Unlock()
+
+	go s.saveStateToDiskNow()
+	return found
+}
@@ -7,11 +7,13 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"piyazche/config"
+	"piyazche/history"
 	"piyazche/optimizer"
 	"piyazche/scanner"
 	"piyazche/shodan"
@@ -22,17 +24,50 @@ import (
 // Start شروع HTTP server
 func (s *Server) Start() error {
 	go s.hub.Run()
-	fmt.Printf("  Web UI (localhost): http://127.0.0.1:%d\n", s.port)
-	// نشون بده روی چه network interface هایی accessible هست
-	if addrs, err := net.InterfaceAddrs(); err == nil {
-		for _, addr := range addrs {
-			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
-				fmt.Printf("  Web UI (network):   http://%s:%d\n", ipNet.IP.String(), s.port)
+	go s.runScheduler()
+	s.state.mu.RLock()
+	rotate := s.state.HealthRotation.Enabled
+	s.state.mu.RUnlock()
+	if rotate {
+		// با rotation، monitor حتی با لیست خالی باید راه بیفته
+		s.startHealthMonitor()
+	}
+
+	scheme := "http"
+	if s.opts.TLS {
+		cfg, err := tlsConfig(s.opts)
+		if err != nil {
+			return err
+		}
+		s.srv.TLSConfig = cfg
+		scheme = "https"
+	}
+
+	bindIP := net.ParseIP(s.opts.Bind)
+	if s.opts.Bind == "" || (bindIP != nil && bindIP.IsUnspecified()) {
+		fmt.Printf("  Web UI (localhost): %s://127.0.0.1:%d\n", scheme, s.port)
+		// نشون بده روی چه network interface هایی accessible هست
+		if addrs, err := net.InterfaceAddrs(); err == nil {
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+					fmt.Printf("  Web UI (network):   %s://%s:%d\n", scheme, ipNet.IP.String(), s.port)
+				}
 			}
 		}
+		if !s.auth.enabled() {
+			fmt.Printf("  Warning: no --ui-password or --ui-token — anyone on the network can use the UI\n")
+		}
+	} else {
+		fmt.Printf("  Web UI: %s://%s\n", scheme, s.srv.Addr)
 	}
 	fmt.Printf("  Press Ctrl+C to stop\n\n")
-	err := s.srv.ListenAndServe()
+
+	var err error
+	if s.opts.TLS {
+		err = s.srv.ListenAndServeTLS("", "")
+	} else {
+		err = s.srv.ListenAndServe()
+	}
 	if err != nil && err.Error() != "http: Server closed" {
 		fmt.Printf("\n[ERROR] Web server failed: %v\n", err)
 		fmt.Printf("  Try: --ui-port 9091\n")
@@ -45,12 +80,15 @@ func (s *Server) Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	s.srv.Shutdown(ctx)
+	s.testers.Close()
 }
 
 // registerRoutes ثبت همه route ها
 func (s *Server) registerRoutes(mux *http.ServeMux) {
 	// Static files (embed)
 	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/login", s.handleLogin)
+	mux.HandleFunc("/logout", s.handleLogout)
 	mux.HandleFunc("/static/", s.handleStatic)
 
 	// WebSocket
@@ -61,12 +99,16 @@ func (s *Server) registerRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/scan/start", s.handleScanStart)
 	mux.HandleFunc("/api/scan/stop", s.handleScanStop)
 	mux.HandleFunc("/api/scan/pause", s.handleScanPause)
+	mux.HandleFunc("/api/scan/resume", s.handleScanResume)
 	mux.HandleFunc("/api/config/parse", s.handleConfigParse)
 	mux.HandleFunc("/api/config/build-link", s.handleBuildLink)
 	mux.HandleFunc("/api/config/multi-parse", s.handleMultiParse)
 	mux.HandleFunc("/api/results", s.handleResults)
 	mux.HandleFunc("/api/results/export", s.handleExport)
 	mux.HandleFunc("/api/sessions", s.handleSessions)
+	mux.HandleFunc("/api/history/best", s.handleHistoryBest)
+	mux.HandleFunc("/api/history/ip", s.handleHistoryIP)
+	mux.HandleFunc("/api/history/subnets", s.handleHistorySubnets)
 	mux.HandleFunc("/api/shodan/harvest", s.handleShodanHarvest)
 	mux.HandleFunc("/api/ips/expand", s.handleIPExpand)
 	mux.HandleFunc("/api/config/save", s.handleConfigSave)
@@ -83,6 +125,8 @@ func (s *Server) registerRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/tls/test", s.handleTLSTest)
 	// Subscription import
 	mux.HandleFunc("/api/subscription/fetch", s.handleSubscriptionFetch)
+	// Live subscriptions (subscriptions.go)
+	mux.HandleFunc("GET /sub/{token}", s.handleSubscription)
 	// Sessions persistence
 	mux.HandleFunc("/api/sessions/save", s.handleSessionsSave)
 	// Health monitor
@@ -98,48 +142,85 @@ func (s *Server) registerRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/quicktest", s.handleQuickTest)
 	// System info
 	mux.HandleFunc("/api/sysinfo", s.handleSysInfo)
+	// Prometheus
+	mux.HandleFunc("/metrics", s.handleMetrics)
 	// IP Ranges persistence
 	mux.HandleFunc("/api/ranges/save", s.handleRangesSave)
 	mux.HandleFunc("/api/ranges/load", s.handleRangesLoad)
+	// REST API v1 (apiv1.go)
+	s.registerV1(mux)
 }
 
 // --- API Handlers ---
 
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
-	s.state.mu.RLock()
-	defer s.state.mu.RUnlock()
+	status, phase := s.legacyStatus()
+	var progress ScanProgress
+	jobID := ""
+	if j, err := s.findJob(""); err == nil {
+		s.state.mu.RLock()
+		progress, jobID = j.Progress, j.ID
+		s.state.mu.RUnlock()
+	}
+	inUse, size := s.budget.Usage()
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":   s.state.ScanStatus,
-		"phase":    s.state.ScanPhase,
-		"progress": s.state.Progress,
+		"status":    status,
+		"phase":     phase,
+		"progress":  progress,
+		"job":       jobID,
+		"jobs":      s.jobStatuses(),
+		"workers":   map[string]int{"inUse": inUse, "size": size},
+		"resumable": s.scanResumable(),
+		"auth":      s.auth.enabled(),
 	})
 }
 
+// scanRequest پارامترهای شروع یه اسکن
+type scanRequest struct {
+	QuickSettings json.RawMessage `json:"quickSettings"` // فقط override های سریع
+	IPRanges      string          `json:"ipRanges"`
+	MaxIPs        int             `json:"maxIPs"`
+	Name          string          `json:"name"`     // اسم job — خالی یعنی اسم template یا "scan <id>"
+	Template      string          `json:"template"` // template ID — خالی یعنی config ذخیره‌شده
+
+	schedule    string         // schedule ID وقتی scheduler شروعش کرده
+	forcePhase2 bool           // نتیجه‌ها رتبه‌بندی میشن، پس فاز 2 لازمه
+	onDone      func(*ScanJob) // بعد از اجرای کامل (نه stop)
+	internal    bool           // mini-scan داخلی: نه session ذخیره میشه نه notify
+}
+
 func (s *Server) handleScanStart(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "POST only", 405)
 		return
 	}
 
-	var req struct {
-		QuickSettings json.RawMessage `json:"quickSettings"` // فقط override های سریع
-		IPRanges      string          `json:"ipRanges"`
-		MaxIPs        int             `json:"maxIPs"`
-	}
+	var req scanRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request: "+err.Error(), 400)
 		return
 	}
 
-	s.state.mu.Lock()
-	if s.state.ScanStatus == "scanning" {
-		s.state.mu.Unlock()
-		jsonError(w, "scan already running", 409)
+	job, totalCount, err := s.startScan(req)
+	if err != nil {
+		jsonAPIError(w, err)
 		return
 	}
-	s.state.mu.Unlock()
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":      true,
+		"message": "scan started",
+		"job":     job.ID,
+		"name":    job.Name,
+		"total":   totalCount,
+	})
+}
+
+// startScan یه job اسکن جدید با config ذخیره‌شده (یا template) + override های
+// quickSettings شروع می‌کنه و تعداد IP ها (اگه از قبل معلومه) رو برمی‌گردونه
+func (s *Server) startScan(req scanRequest) (*ScanJob, int, error) {
 	// تبدیل quickSettings به string برای buildMergedConfig
 	quickSettingsStr := ""
 	if len(req.QuickSettings) > 0 && string(req.QuickSettings) != "null" {
@@ -149,42 +230,165 @@ func (s *Server) handleScanStart(w http.ResponseWriter, r *http.Request) {
 	// بیلد config: saved config کامل + quick override
 	cfg, err := s.buildMergedConfig(quickSettingsStr)
 	if err != nil {
-		jsonError(w, "config parse error: "+err.Error(), 400)
-		return
+		return nil, 0, errBadRequest("config parse error: " + err.Error())
+	}
+
+	if req.forcePhase2 && cfg.Scan.StabilityRounds <= 0 {
+		cfg.Scan.StabilityRounds = 3
+	}
+
+	job := &ScanJob{Name: req.Name, Schedule: req.schedule, RawURL: s.savedRawURL(), onDone: req.onDone, internal: req.internal}
+	if req.Template != "" {
+		tmpl, err := s.applyTemplate(cfg, req.Template)
+		if err != nil {
+			return nil, 0, err
+		}
+		job.Template, job.RawURL = tmpl.ID, tmpl.RawURL
+		if job.Name == "" {
+			job.Name = tmpl.Name
+		}
 	}
 
 	// IP count رو برای پاسخ سریع به JS محاسبه کنیم
 	totalCount := 0
+	cp := newScanCheckpoint(cfg, req.IPRanges, req.MaxIPs)
 	if req.IPRanges != "" {
-		sampleSize := cfg.Scan.SampleSize
-		if sampleSize <= 0 { sampleSize = 1 }
-		ips := parseIPInputWithSample(req.IPRanges, sampleSize)
-		if req.MaxIPs > 0 && req.MaxIPs < len(ips) {
-			ips = ips[:req.MaxIPs]
+		if src, err := cp.OpenSource(); err == nil {
+			totalCount = src.Len()
+		}
+	}
+
+	job.cfg, job.cp = cfg, cp
+	s.addJob(job)
+	return job, totalCount, nil
+}
+
+// applyTemplate proxy یه template رو جای proxy ذخیره‌شده‌ی cfg میذاره
+func (s *Server) applyTemplate(cfg *config.Config, id string) (config.ConfigTemplate, error) {
+	s.state.mu.RLock()
+	var tmpl *config.ConfigTemplate
+	for i := range s.state.Templates {
+		if s.state.Templates[i].ID == id {
+			t := s.state.Templates[i]
+			tmpl = &t
 		}
-		totalCount = len(ips)
+	}
+	s.state.mu.RUnlock()
+	if tmpl == nil {
+		return config.ConfigTemplate{}, errNotFound("no template " + id)
+	}
+
+	var tcfg config.Config
+	if err := json.Unmarshal([]byte(tmpl.ConfigJSON), &tcfg); err != nil {
+		return config.ConfigTemplate{}, errBadRequest("template config error: " + err.Error())
+	}
+	cfg.Proxy = tcfg.Proxy
+	return *tmpl, nil
+}
+
+// handleScanResume اسکنی که نصفه مونده (stop/crash/reboot) رو از checkpoint ادامه میده
+func (s *Server) handleScanResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", 405)
+		return
 	}
 
-	go s.runScan(cfg, req.IPRanges, req.MaxIPs)
+	job, totalCount, done, err := s.resumeScan(r.URL.Query().Get("job"))
+	if err != nil {
+		jsonAPIError(w, err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"ok":      true,
-		"message": "scan started",
+		"message": "scan resumed",
+		"job":     job.ID,
+		"name":    job.Name,
 		"total":   totalCount,
+		"done":    done,
 	})
 }
 
+// resumeScan اسکن checkpoint شده رو به عنوان job ادامه میده — id خالی یعنی
+// جدیدترین checkpoint. total و تعداد IP های قبلاً تست‌شده رو برمی‌گردونه.
+func (s *Server) resumeScan(id string) (job *ScanJob, total, done int, err error) {
+	var path string
+	if id != "" {
+		if j, err := s.findJob(id); err == nil && j.active() {
+			return nil, 0, 0, errConflict("job is already running")
+		}
+		path = jobCheckpointPath(id)
+	} else if paths := s.resumableCheckpoints(); len(paths) > 0 {
+		path = paths[0]
+	} else {
+		return nil, 0, 0, errNotFound("no scan to resume")
+	}
+
+	cp, err := scanner.LoadCheckpoint(path)
+	if err != nil {
+		return nil, 0, 0, errNotFound("no scan to resume: " + err.Error())
+	}
+
+	// همون config اسکن اصلی — نه config فعلی UI
+	cfg := config.DefaultConfig()
+	if len(cp.Config) > 0 {
+		if err := json.Unmarshal(cp.Config, cfg); err != nil {
+			return nil, 0, 0, errBadRequest("checkpoint config error: " + err.Error())
+		}
+	} else if cfg, err = s.buildMergedConfig(""); err != nil {
+		return nil, 0, 0, errBadRequest("config parse error: " + err.Error())
+	}
+
+	// checkpoint قدیمی (یه اسکن در کل) به اسم job منتقل میشه
+	cpPath := jobCheckpointPath(cp.SessionID())
+	if path != cpPath {
+		if err := os.Rename(path, cpPath); err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to move checkpoint: %w", err)
+		}
+	}
+
+	if cp.SourceType == scanner.SourceText {
+		if src, err := cp.OpenSource(); err == nil {
+			total = src.Len()
+		}
+	}
+
+	job = &ScanJob{cfg: cfg, cp: cp, cpPath: cpPath, RawURL: s.savedRawURL()}
+	if old, err := s.findJob(cp.SessionID()); err == nil {
+		s.state.mu.RLock()
+		job.Name, job.Template, job.Schedule, job.RawURL = old.Name, old.Template, old.Schedule, old.RawURL
+		job.onDone, job.internal = old.onDone, old.internal
+		s.state.mu.RUnlock()
+	}
+
+	s.addJob(job)
+	return job, total, len(cp.Results), nil
+}
+
 func (s *Server) handleScanStop(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "POST only", 405)
 		return
 	}
+	if err := s.stopScan(r.URL.Query().Get("job")); err != nil {
+		jsonAPIError(w, err)
+		return
+	}
+	jsonOK(w, "stopped")
+}
 
+// stopScan یه job (فاز ۱ یا ۲) رو متوقف می‌کنه — id خالی یعنی آخرین job
+func (s *Server) stopScan(id string) error {
 	s.state.mu.Lock()
-	scnr := s.state.scannerRef
-	cancelFn := s.state.cancelFn
-	phase2Cancel := s.state.phase2CancelFn
+	j, err := s.findJobLocked(id)
+	if err != nil {
+		s.state.mu.Unlock()
+		return err
+	}
+	scnr := j.scannerRef
+	cancelFn := j.cancelFn
+	phase2Cancel := j.phase2CancelFn
 	s.state.mu.Unlock()
 
 	// اگه paused بود اول resume کن تا goroutineها آزاد بشن
@@ -202,14 +406,16 @@ func (s *Server) handleScanStop(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.state.mu.Lock()
-	s.state.ScanStatus = "idle"
-	s.state.ScanPhase = ""
-	s.state.scannerRef = nil
-	s.state.phase2CancelFn = nil
+	if j.active() {
+		j.Status = "stopped"
+	}
+	j.Phase = ""
+	j.scannerRef = nil
+	j.phase2CancelFn = nil
 	s.state.mu.Unlock()
 
-	s.hub.Broadcast("status", map[string]string{"status": "idle", "phase": ""})
-	jsonOK(w, "stopped")
+	s.jobBroadcast(j, "status", map[string]interface{}{"status": "idle", "phase": ""})
+	return nil
 }
 
 func (s *Server) handleScanPause(w http.ResponseWriter, r *http.Request) {
@@ -217,33 +423,46 @@ func (s *Server) handleScanPause(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "POST only", 405)
 		return
 	}
+	msg, err := s.togglePause(r.URL.Query().Get("job"))
+	if err != nil {
+		jsonAPIError(w, err)
+		return
+	}
+	jsonOK(w, msg)
+}
 
+// togglePause فاز ۱ یه job رو pause یا ادامه میده — "paused" یا "resumed" برمی‌گردونه
+func (s *Server) togglePause(id string) (string, error) {
 	s.state.mu.Lock()
-	status := s.state.ScanStatus
-	phase := s.state.ScanPhase
-	scnr := s.state.scannerRef
+	j, err := s.findJobLocked(id)
+	if err != nil {
+		s.state.mu.Unlock()
+		return "", err
+	}
+	status := j.Status
+	phase := j.Phase
+	scnr := j.scannerRef
 	s.state.mu.Unlock()
 
 	if status == "scanning" && phase == "phase1" && scnr != nil {
 		scnr.Pause()
 		s.state.mu.Lock()
-		s.state.ScanStatus = "paused"
+		j.Status = "paused"
 		s.state.mu.Unlock()
-		s.hub.Broadcast("status", map[string]string{"status": "paused", "phase": s.state.ScanPhase})
-		jsonOK(w, "paused")
+		s.jobBroadcast(j, "status", map[string]interface{}{"status": "paused", "phase": phase})
+		return "paused", nil
 	} else if status == "paused" && phase == "phase1" && scnr != nil {
 		scnr.Resume()
 		s.state.mu.Lock()
-		s.state.ScanStatus = "scanning"
+		j.Status = "scanning"
 		s.state.mu.Unlock()
-		s.hub.Broadcast("status", map[string]string{"status": "scanning", "phase": s.state.ScanPhase})
-		jsonOK(w, "resumed")
+		s.jobBroadcast(j, "status", map[string]interface{}{"status": "scanning", "phase": phase})
+		return "resumed", nil
 	} else if status == "scanning" && phase == "phase2" {
 		// فاز 2 pause ندارد — stop میشه
-		jsonError(w, "phase2 cannot be paused, use stop", 400)
-	} else {
-		jsonError(w, "not scanning", 400)
+		return "", errBadRequest("phase2 cannot be paused, use stop")
 	}
+	return "", errBadRequest("not scanning")
 }
 
 func (s *Server) handleConfigParse(w http.ResponseWriter, r *http.Request) {
@@ -268,7 +487,7 @@ func (s *Server) handleConfigParse(w http.ResponseWriter, r *http.Request) {
 
 	// rawURL رو ذخیره کن برای build-link بعداً
 	input := strings.TrimSpace(req.Input)
-	if strings.HasPrefix(input, "vless://") || strings.HasPrefix(input, "vmess://") || strings.HasPrefix(input, "trojan://") {
+	if IsProxyLink(input) {
 		s.state.mu.Lock()
 		s.state.SavedRawURL = input
 		rawURL := input
@@ -301,6 +520,7 @@ func (s *Server) handleConfigParse(w http.ResponseWriter, r *http.Request) {
 		"port":    cfg.Proxy.Port,
 		"type":    cfg.Proxy.Type,
 		"method":  cfg.Proxy.Method,
+		"protocol": cfg.Proxy.GetProtocol(),
 	}
 	if cfg.Proxy.TLS != nil {
 		parsed["sni"] = cfg.Proxy.TLS.SNI
@@ -346,7 +566,7 @@ func (s *Server) handleBuildLink(w http.ResponseWriter, r *http.Request) {
 	s.state.mu.RUnlock()
 
 	if rawURL == "" {
-		jsonError(w, "no raw proxy link saved — import a vless/vmess/trojan link first", 400)
+		jsonError(w, "no raw proxy link saved — import a vless/vmess/trojan/ss/hysteria2 link first", 400)
 		return
 	}
 
@@ -449,24 +669,38 @@ func (s *Server) handleGeoIP(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(geo)
 }
 
-
-
+// handleResults نتایج یه job — ?job= خالی یعنی آخرین job
 func (s *Server) handleResults(w http.ResponseWriter, r *http.Request) {
 	s.state.mu.RLock()
 	defer s.state.mu.RUnlock()
 
+	j, err := s.findJobLocked(r.URL.Query().Get("job"))
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"phase1": nil,
+			"phase2": nil,
+			"status": "idle",
+		})
+		return
+	}
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"phase1":  s.state.Results,
-		"phase2":  s.state.Phase2Results,
-		"status":  s.state.ScanStatus,
+		"phase1": j.Results,
+		"phase2": j.Phase2Results,
+		"status": j.Status,
+		"job":    j.ID,
 	})
 }
 
 func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
 	s.state.mu.RLock()
-	results := s.state.Phase2Results
+	var results []scanner.Phase2Result
 	rawURL := s.state.SavedRawURL
-	_ = rawURL
+	if j, err := s.findJobLocked(r.URL.Query().Get("job")); err == nil {
+		results = j.Phase2Results
+		if j.RawURL != "" {
+			rawURL = j.RawURL
+		}
+	}
 	s.state.mu.RUnlock()
 
 	format := r.URL.Query().Get("format")
@@ -538,9 +772,7 @@ func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
 	default:
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Content-Disposition", `attachment; filename="results.json"`)
-		s.state.mu.RLock()
-		json.NewEncoder(w).Encode(s.state.Phase2Results)
-		s.state.mu.RUnlock()
+		json.NewEncoder(w).Encode(results)
 	}
 }
 
@@ -550,6 +782,89 @@ func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(s.state.Sessions)
 }
 
+func (s *Server) recordPhase2(session, fp string, r scanner.Phase2Result) {
+	if s.hist == nil {
+		return
+	}
+	if err := s.hist.AddPhase2(session, fp, []scanner.Phase2Result{r}); err != nil {
+		s.tuiLog("⚠ ثبت تاریخچه: "+err.Error(), "warn")
+	}
+}
+
+// historyFilter فیلتر مشترک /api/history/* — ?days=7 (0 = همه) و ?fp=
+func historyFilter(r *http.Request) history.Filter {
+	var f history.Filter
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		fmt.Sscanf(v, "%d", &days)
+	}
+	if days > 0 {
+		f.Since = time.Now().AddDate(0, 0, -days)
+	}
+	f.ConfigFP = r.URL.Query().Get("fp")
+	return f
+}
+
+func queryLimit(r *http.Request, def int) int {
+	limit := def
+	if v := r.URL.Query().Get("limit"); v != "" {
+		fmt.Sscanf(v, "%d", &limit)
+	}
+	return limit
+}
+
+// handleHistoryBest بهترین IP ها برای config فعلی — ?fp=all یعنی همه‌ی config ها
+func (s *Server) handleHistoryBest(w http.ResponseWriter, r *http.Request) {
+	if s.hist == nil {
+		jsonError(w, "history unavailable", 503)
+		return
+	}
+	f := historyFilter(r)
+	fp := f.ConfigFP
+	switch f.ConfigFP {
+	case "all":
+		f.ConfigFP = ""
+	case "":
+		cfg, err := s.buildMergedConfig("")
+		if err != nil {
+			jsonError(w, "config parse error: "+err.Error(), 400)
+			return
+		}
+		f.ConfigFP = cfg.Fingerprint()
+		fp = f.ConfigFP
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"fp":      fp,
+		"results": s.hist.Best(f, queryLimit(r, 50)),
+	})
+}
+
+// handleHistoryIP تاریخچه‌ی latency یه IP — ?ip=
+func (s *Server) handleHistoryIP(w http.ResponseWriter, r *http.Request) {
+	if s.hist == nil {
+		jsonError(w, "history unavailable", 503)
+		return
+	}
+	ip := r.URL.Query().Get("ip")
+	if ip == "" {
+		jsonError(w, "ip is required", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.hist.IPHistory(ip, historyFilter(r)))
+}
+
+// handleHistorySubnets روند pass rate هر subnet بین session ها
+func (s *Server) handleHistorySubnets(w http.ResponseWriter, r *http.Request) {
+	if s.hist == nil {
+		jsonError(w, "history unavailable", 503)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.hist.SubnetTrends(historyFilter(r), queryLimit(r, 50)))
+}
+
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Write(indexHTML)
@@ -562,30 +877,30 @@ func (s *Server) handleStatic(w http.ResponseWriter, r *http.Request) {
 
 // --- Scan Runner ---
 
-func (s *Server) runScan(cfg *config.Config, ipRanges string, maxIPs int) {
+// runJob یه job اسکن رو اجرا می‌کنه — پیشرفت مدام توی checkpoint خود job ذخیره
+// میشه تا بعد از قطع شدن (stop/crash/reboot) با /api/scan/resume ادامه پیدا کنه
+func (s *Server) runJob(j *ScanJob) {
+	cfg, cp := j.cfg, j.cp
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	s.state.mu.Lock()
-	s.state.cancelFn = cancel
-	s.state.ScanStatus = "scanning"
-	s.state.ScanPhase = "phase1"
-	s.state.Progress = ScanProgress{StartTime: time.Now()}
-	s.state.Results = nil
-	s.state.Phase2Results = nil
+	j.cancelFn = cancel
 	s.state.mu.Unlock()
 
-	s.hub.Broadcast("status", map[string]string{"status": "scanning", "phase": "phase1"})
+	s.jobBroadcast(j, "status", map[string]interface{}{"status": "scanning", "phase": "phase1"})
 
 	scnr := scanner.NewScannerWithDebug(cfg, false)
-	s.tuiLog("▶ اسکن شروع شد — "+fmt.Sprintf("%d IP", scnr.IPCount()), "info")
+	scnr.Budget = s.budget
+	s.jobLog(j, "▶ اسکن شروع شد — "+fmt.Sprintf("%d IP", scnr.IPCount()), "info")
 
 	// Live IP tracking callback — fires when IP is dispatched to worker
 	var lastResultCount int64
 	scnr.OnIPStart = func(ip string) {
 		s.state.mu.Lock()
-		s.state.CurrentIP = ip
+		j.CurrentIP = ip
 		s.state.mu.Unlock()
-		s.hub.Broadcast("live_ip", map[string]string{"ip": ip})
+		s.jobBroadcast(j, "live_ip", map[string]interface{}{"ip": ip})
 
 		// also broadcast any newly completed results
 		results := scnr.GetResults()
@@ -594,7 +909,7 @@ func (s *Server) runScan(cfg *config.Config, ipRanges string, maxIPs int) {
 			all := results.All()
 			for i := lastResultCount; i < count && i < int64(len(all)); i++ {
 				r := all[i]
-				s.hub.Broadcast("ip_result", map[string]interface{}{
+				s.jobBroadcast(j, "ip_result", map[string]interface{}{
 					"ip":      r.IP,
 					"success": r.Success,
 					"latency": r.LatencyMs,
@@ -604,70 +919,97 @@ func (s *Server) runScan(cfg *config.Config, ipRanges string, maxIPs int) {
 		}
 	}
 
-	// Load IPs from input
-	if ipRanges != "" {
-		sampleSize := cfg.Scan.SampleSize
-		if sampleSize <= 0 { sampleSize = 1 }
-		ips := parseIPInputWithSample(ipRanges, sampleSize)
-		if maxIPs > 0 && maxIPs < len(ips) {
-			ips = ips[:maxIPs]
-		}
-		if cfg.Scan.Shuffle {
-			utils.ShuffleIPs(ips)
-		}
-		scnr.LoadIPsFromList(ips, 0, false)
-	} else {
-		// "" = embedded CF subnets (fallback اگه ipv4.txt نبود)
-		scnr.LoadIPs("ipv4.txt", maxIPs, cfg.Scan.Shuffle)
+	// Load IPs from checkpoint — IP ها lazy از source کشیده میشن و تست‌شده‌ها skip میشن
+	cpPath := j.cpPath
+	if err := scnr.UseCheckpoint(cp, cpPath); err != nil {
+		s.jobLog(j, "✗ خطا: "+err.Error(), "err")
 	}
 
 	s.state.mu.Lock()
-	s.state.scannerRef = scnr
-	s.state.Progress.Total = scnr.IPCount()
+	j.scannerRef = scnr
+	j.Progress.Total = scnr.IPCount()
+	stopped := j.Status == "stopped"
 	s.state.mu.Unlock()
+	if stopped {
+		// stop قبل از ساخته شدن scanner رسید
+		scnr.Stop()
+	}
 
 	// Progress broadcaster
-	go s.broadcastProgress(ctx, scnr)
+	go s.broadcastProgress(ctx, j, scnr)
 
 	_ = ctx // scanner uses its own context via Stop()
 
-	s.tuiLog(fmt.Sprintf("⚡ Phase 1 — %d IP در صف اسکن", scnr.IPCount()), "info")
-	if err := scnr.Run(); err != nil {
-		s.hub.Broadcast("error", map[string]string{"message": err.Error()})
-		s.tuiLog("✗ خطا: "+err.Error(), "err")
+	// هر نتیجه با session و fingerprint کانفیگ توی تاریخچه ثبت میشه
+	session, fp := cp.SessionID(), cfg.Fingerprint()
+
+	if cp.Phase1Done {
+		s.jobLog(j, fmt.Sprintf("⏭ Phase 1 قبلاً تموم شده — %d نتیجه از checkpoint", len(cp.Results)), "info")
+	} else {
+		if len(cp.Results) > 0 {
+			s.jobLog(j, fmt.Sprintf("↻ ادامه‌ی اسکن قبلی — %d IP قبلاً تست شده", len(cp.Results)), "info")
+		}
+		s.jobLog(j, fmt.Sprintf("⚡ Phase 1 — %d IP در صف اسکن", scnr.IPCount()), "info")
+		// هر نتیجه همون لحظه ثبت میشه تا crash نتایج run رو از بین نبره
+		rec := s.hist.Record(scnr.GetResults(), session, fp, func(err error) {
+			s.tuiLog("⚠ ثبت تاریخچه: "+err.Error(), "warn")
+		})
+		err := scnr.Run()
+		rec.Close()
+		if err != nil {
+			s.jobBroadcast(j, "error", map[string]interface{}{"message": err.Error()})
+			s.jobLog(j, "✗ خطا: "+err.Error(), "err")
+		}
 	}
+	interrupted := !cp.Phase1Done
 
 	// Collect phase1 results
 	results := scnr.GetResults().GetSuccessful()
 	s.state.mu.Lock()
-	s.state.ScanPhase = "phase2"
+	// job متوقف‌شده فاز 2 نمیره — checkpoint می‌مونه تا resume
+	stopped = j.Status == "stopped"
+	if !stopped {
+		j.Phase = "phase2"
+	}
 	for _, r := range scnr.GetResults().All() {
-		s.state.Results = append(s.state.Results, r)
+		j.Results = append(j.Results, r)
 	}
 	s.state.mu.Unlock()
 
-	s.hub.Broadcast("phase2_start", map[string]int{"count": len(results)})
-	s.tuiLog(fmt.Sprintf("🔬 Phase 2 شروع شد — %d IP", len(results)), "phase2")
+	if !stopped {
+		s.jobBroadcast(j, "phase2_start", map[string]interface{}{"count": len(results)})
+		s.jobLog(j, fmt.Sprintf("🔬 Phase 2 شروع شد — %d IP", len(results)), "phase2")
+	}
 
 	// Phase 2
-	if len(results) > 0 && cfg.Scan.StabilityRounds > 0 {
+	if !stopped && len(results) > 0 && cfg.Scan.StabilityRounds > 0 {
 		// context جداگانه برای فاز 2 — قابل کنسل مستقل
 		p2Ctx, p2Cancel := context.WithCancel(context.Background())
 		s.state.mu.Lock()
-		s.state.phase2CancelFn = p2Cancel
+		j.phase2CancelFn = p2Cancel
 		s.state.mu.Unlock()
 		defer p2Cancel()
 
+		// IP هایی که توی run قبلی phase 2 شدن دوباره تست نمیشن
+		pending := cp.Phase2Pending(results)
 		total2 := len(results)
-		var done2 int
+		done2 := total2 - len(pending)
 		p2StartTime := time.Now()
 
 		// P2 progress state init
 		s.state.mu.Lock()
-		s.state.P2Progress = P2ScanProgress{Total: total2, StartTime: p2StartTime}
+		j.P2Progress = P2ScanProgress{Total: total2, StartTime: p2StartTime}
 		s.state.mu.Unlock()
 
 		onP2Progress := func(r scanner.Phase2Result) {
+			if p2Ctx.Err() == nil {
+				// تست نصفه‌کاره ثبت نمیشه تا resume دوباره تستش کنه
+				cp.AddPhase2(r)
+				if err := cp.Save(cpPath); err != nil {
+					s.tuiLog("⚠ ذخیره checkpoint: "+err.Error(), "warn")
+				}
+				s.recordPhase2(session, fp, r)
+			}
 			done2++
 			dlStr := "—"
 			if r.DownloadMbps > 0 {
@@ -699,16 +1041,18 @@ func (s *Server) runScan(cfg *config.Config, ipRanges string, maxIPs int) {
 			grade := scoreToGrade(r.StabilityScore)
 
 			s.state.mu.Lock()
-			s.state.P2Progress.Done = done2
-			if r.Passed { s.state.P2Progress.Passed++ }
-			s.state.P2Progress.Rate = rate2
-			s.state.P2Progress.ETA = eta2
+			j.P2Progress.Done = done2
+			if r.Passed {
+				j.P2Progress.Passed++
+			}
+			j.P2Progress.Rate = rate2
+			j.P2Progress.ETA = eta2
 			s.state.mu.Unlock()
 
 			pct2 := 0
 			if total2 > 0 { pct2 = done2 * 100 / total2 }
 
-			s.hub.Broadcast("phase2_progress", map[string]interface{}{
+			s.jobBroadcast(j, "phase2_progress", map[string]interface{}{
 				"ip":         r.IP,
 				"done":       done2,
 				"total":      total2,
@@ -729,12 +1073,16 @@ func (s *Server) runScan(cfg *config.Config, ipRanges string, maxIPs int) {
 			if !r.Passed {
 				icon = "✗"
 			}
-			s.tuiLog(fmt.Sprintf("[%d/%d] %s %s  lat:%.0fms  loss:%.0f%%  score:%.0f(%s)  ↓%s  ↑%s",
+			s.jobLog(j, fmt.Sprintf("[%d/%d] %s %s  lat:%.0fms  loss:%.0f%%  score:%.0f(%s)  ↓%s  ↑%s",
 				done2, total2, icon, r.IP, r.AvgLatencyMs, r.PacketLossPct, r.StabilityScore, grade, dlStr, ulStr),
 				map[bool]string{true: "ok", false: "err"}[r.Passed])
 		}
 
-		p2results := scanner.RunPhase2WithCallback(p2Ctx, cfg, results, onP2Progress)
+		scanner.RunPhase2WithBudget(p2Ctx, cfg, pending, s.budget, onP2Progress)
+		p2results := cp.Phase2Results()
+		if p2Ctx.Err() != nil {
+			interrupted = true // phase 2 نصفه موند، checkpoint بمونه
+		}
 
 		// Subnet stats از نتایج phase1
 		subnetMap := map[string]*config.SubnetStat{}
@@ -767,11 +1115,11 @@ func (s *Server) runScan(cfg *config.Config, ipRanges string, maxIPs int) {
 		}
 
 		s.state.mu.Lock()
-		s.state.Phase2Results = p2results
+		j.Phase2Results = p2results
 		s.state.SubnetStats = subnetList
 		s.state.mu.Unlock()
 
-		s.hub.Broadcast("phase2_done", map[string]interface{}{
+		s.jobBroadcast(j, "phase2_done", map[string]interface{}{
 			"results": p2results,
 			"subnets": subnetList,
 		})
@@ -779,39 +1127,62 @@ func (s *Server) runScan(cfg *config.Config, ipRanges string, maxIPs int) {
 
 	// Save session
 	s.state.mu.Lock()
-	s.state.ScanStatus = "done"
-	duration := time.Since(s.state.Progress.StartTime)
+	if j.Status != "stopped" {
+		j.Status = "done"
+	}
+	completed := j.Status == "done" && !interrupted
+	j.Phase = ""
+	j.scannerRef = nil
+	j.phase2CancelFn = nil
+	duration := time.Since(j.Progress.StartTime)
 	passed := 0
-	for _, r := range s.state.Phase2Results {
+	for _, r := range j.Phase2Results {
 		if r.Passed {
 			passed++
 		}
 	}
-	session := ScanSession{
-		ID:        fmt.Sprintf("%d", time.Now().Unix()),
-		StartedAt: s.state.Progress.StartTime,
-		Duration:  duration.Round(time.Second).String(),
-		TotalIPs:  s.state.Progress.Total,
-		Passed:    passed,
-		Results:   s.state.Phase2Results,
-	}
-	s.state.Sessions = append([]ScanSession{session}, s.state.Sessions...)
-	if len(s.state.Sessions) > 50 {
-		s.state.Sessions = s.state.Sessions[:50]
+	// mini-scan های rotation جای session های واقعی رو توی سقف ۵۰ تا نمی‌گیرن
+	if !j.internal {
+		scanSession := ScanSession{
+			ID:        session,
+			StartedAt: j.Progress.StartTime,
+			Duration:  duration.Round(time.Second).String(),
+			TotalIPs:  j.Progress.Total,
+			Passed:    passed,
+			Config:    j.Name,
+			ConfigFP:  fp,
+			Results:   j.Phase2Results,
+		}
+		s.state.Sessions = append([]ScanSession{scanSession}, s.state.Sessions...)
+		if len(s.state.Sessions) > 50 {
+			s.state.Sessions = s.state.Sessions[:50]
+		}
 	}
 	s.state.mu.Unlock()
 
+	// اسکن کامل شد — checkpoint دیگه لازم نیست
+	if !interrupted {
+		os.Remove(cpPath)
+	}
+
 	// Persist sessions to disk
 	go s.saveStateToDiskNow()
 
-	s.hub.Broadcast("scan_done", map[string]interface{}{
+	s.jobBroadcast(j, "scan_done", map[string]interface{}{
 		"duration": duration.Round(time.Second).String(),
 		"passed":   passed,
 	})
-	s.tuiLog(fmt.Sprintf("✓ اسکن تموم شد — %d موفق — %s", passed, duration.Round(time.Second)), "ok")
+	s.jobLog(j, fmt.Sprintf("✓ اسکن تموم شد — %d موفق — %s", passed, duration.Round(time.Second)), "ok")
+
+	if completed && !j.internal {
+		s.notifyScanDone(j, passed, duration)
+	}
+	if j.onDone != nil && !interrupted {
+		j.onDone(j)
+	}
 }
 
-func (s *Server) broadcastProgress(ctx context.Context, scnr *scanner.Scanner) {
+func (s *Server) broadcastProgress(ctx context.Context, j *ScanJob, scnr *scanner.Scanner) {
 	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
 
@@ -821,12 +1192,12 @@ func (s *Server) broadcastProgress(ctx context.Context, scnr *scanner.Scanner) {
 			return
 		case <-ticker.C:
 			s.state.mu.RLock()
-			status := s.state.ScanStatus
-			phase := s.state.ScanPhase
+			active := j.active()
+			phase := j.Phase
 			s.state.mu.RUnlock()
 
 			// وقتی فاز 2 شروع شد، این goroutine کارش تموم شده
-			if status == "done" || status == "idle" || phase == "phase2" {
+			if !active || phase == "phase2" {
 				return
 			}
 
@@ -835,30 +1206,32 @@ func (s *Server) broadcastProgress(ctx context.Context, scnr *scanner.Scanner) {
 			succeeded := stats.SuccessCount()
 
 			s.state.mu.Lock()
-			s.state.Progress.Done = done
-			s.state.Progress.Succeeded = succeeded
-			s.state.Progress.Failed = done - succeeded
-			elapsed := time.Since(s.state.Progress.StartTime).Seconds()
+			j.Progress.Done = done
+			j.Progress.Succeeded = succeeded
+			j.Progress.Failed = done - succeeded
+			j.Progress.Failures = stats.FailureCounts()
+			elapsed := time.Since(j.Progress.StartTime).Seconds()
 			if elapsed > 0 {
-				s.state.Progress.Rate = float64(done) / elapsed
-				remaining := s.state.Progress.Total - done
-				if s.state.Progress.Rate > 0 {
-					eta := time.Duration(float64(remaining)/s.state.Progress.Rate) * time.Second
-					s.state.Progress.ETA = eta.Round(time.Second).String()
+				j.Progress.Rate = float64(done) / elapsed
+				remaining := j.Progress.Total - done
+				if j.Progress.Rate > 0 {
+					eta := time.Duration(float64(remaining)/j.Progress.Rate) * time.Second
+					j.Progress.ETA = eta.Round(time.Second).String()
 				}
 			}
-			progress := s.state.Progress
+			progress := j.Progress
 			s.state.mu.Unlock()
 
 			s.state.mu.RLock()
-			currentIP := s.state.CurrentIP
+			currentIP := j.CurrentIP
 			s.state.mu.RUnlock()
 
-			s.hub.Broadcast("progress", map[string]interface{}{
+			s.jobBroadcast(j, "progress", map[string]interface{}{
 				"total":     progress.Total,
 				"done":      progress.Done,
 				"succeeded": progress.Succeeded,
 				"failed":    progress.Failed,
+				"failures":  progress.Failures,
 				"rate":      progress.Rate,
 				"eta":       progress.ETA,
 				"currentIP": currentIP,
@@ -883,16 +1256,11 @@ func (s *Server) handleIPExpand(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "invalid request", 400)
 		return
 	}
-	ips := parseIPInput(req.IPRanges)
-	if req.MaxIPs > 0 && len(ips) > req.MaxIPs {
-		ips = ips[:req.MaxIPs]
-	}
+	src := utils.LimitSource(utils.NewTextSource(req.IPRanges, utils.SourceOptions{SampleSize: 1}), req.MaxIPs)
+	count := src.Len()
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"count": len(ips),
-		"preview": func() []string {
-			if len(ips) > 5 { return ips[:5] }
-			return ips
-		}(),
+		"count":   count,
+		"preview": utils.CollectIPs(utils.LimitSource(src, 5)),
 	})
 }
 
@@ -951,7 +1319,12 @@ func (s *Server) handleShodanHarvest(w http.ResponseWriter, r *http.Request) {
 			if err != nil {
 				cfg2 = config.DefaultConfig()
 			}
-			go s.runScan(cfg2, joinLines(result.IPs), 0)
+			s.addJob(&ScanJob{
+				Name:   "shodan",
+				RawURL: s.savedRawURL(),
+				cfg:    cfg2,
+				cp:     newScanCheckpoint(cfg2, strings.Join(result.IPs, "\n"), 0),
+			})
 		}
 	}()
 
@@ -965,14 +1338,6 @@ func min16(n int) int {
 	return 16
 }
 
-func joinLines(lines []string) string {
-	out := ""
-	for _, l := range lines {
-		out += l + "\n"
-	}
-	return out
-}
-
 // --- Helpers ---
 
 func jsonOK(w http.ResponseWriter, msg string) {
@@ -997,33 +1362,21 @@ func parseConfigFromJSON(raw string) (*config.Config, error) {
 	return cfg, nil
 }
 
-func parseIPInput(input string) []string {
-	return parseIPInputWithSample(input, 1)
-}
-
-func parseIPInputWithSample(input string, sampleSize int) []string {
-	var ips []string
-	if sampleSize <= 0 {
-		sampleSize = 1
-	}
-	for _, line := range strings.Split(input, "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		if strings.Contains(line, "/") {
-			// CIDR — expand with sampleSize
-			expanded, err := utils.ExpandCIDR(line, sampleSize)
-			if err != nil {
-				fmt.Printf("warning: invalid CIDR %s: %v\n", line, err)
-				continue
-			}
-			ips = append(ips, expanded...)
-		} else if net.ParseIP(line) != nil {
-			ips = append(ips, line)
+// newScanCheckpoint یه checkpoint تازه برای اسکن می‌سازه — ipRanges خالی یعنی لیست CF پیش‌فرض
+func newScanCheckpoint(cfg *config.Config, ipRanges string, maxIPs int) *scanner.Checkpoint {
+	var cp *scanner.Checkpoint
+	if ipRanges == "" {
+		cp = scanner.NewCheckpoint("ipv4.txt", scanner.SourcePath, cfg.Scan.SampleSize, cfg.Scan.Shuffle, maxIPs)
+	} else {
+		sampleSize := cfg.Scan.SampleSize
+		if sampleSize <= 0 {
+			sampleSize = 1
 		}
+		cp = scanner.NewCheckpoint(ipRanges, scanner.SourceText, sampleSize, cfg.Scan.Shuffle, maxIPs)
 	}
-	return ips
+	cp.Adaptive = cfg.Scan.Adaptive
+	cp.Config, _ = json.Marshal(cfg)
+	return cp
 }
 
 func splitLines(s string) []string {
@@ -1112,6 +1465,10 @@ func (s *Server) handleConfigActive(w http.ResponseWriter, r *http.Request) {
 		"threads":         cfg.Scan.Threads,
 		"timeout":         cfg.Scan.Timeout,
 		"maxLatency":      cfg.Scan.MaxLatency,
+		"maxTtfbMs":         cfg.Scan.MaxTTFBMs,
+		"preFilter":         cfg.Scan.PreFilter.Enabled,
+		"probes":            cfg.Scan.Probes,
+		"sustained":         cfg.Scan.Sustained.Enabled,
 		"stabilityRounds": cfg.Scan.StabilityRounds,
 		"stabilityInterval": cfg.Scan.StabilityInterval,
 		"packetLossCount": cfg.Scan.PacketLossCount,
@@ -1140,15 +1497,18 @@ func (s *Server) handleConfigLoad(w http.ResponseWriter, r *http.Request) {
 
 // buildMergedConfig — saved config کامل رو لود میکنه + quick override اعمال میکنه
 func (s *Server) buildMergedConfig(quickOverrideJSON string) (*config.Config, error) {
-	cfg := config.DefaultConfig()
-
 	s.state.mu.RLock()
 	proxyJSON := s.state.SavedProxyConfig
-	_ = proxyJSON
 	scanJSON := s.state.SavedScanConfig
-	_ = scanJSON
 	s.state.mu.RUnlock()
 
+	return mergeConfig(proxyJSON, scanJSON, quickOverrideJSON), nil
+}
+
+// mergeConfig بدنه‌ی buildMergedConfig بدون قفل، برای جاهایی که state از قبل قفل شده
+func mergeConfig(proxyJSON, scanJSON, quickOverrideJSON string) *config.Config {
+	cfg := config.DefaultConfig()
+
 	// ۱. proxy config از لینک parse شده
 	if proxyJSON != "" {
 		var proxyCfg config.Config
@@ -1211,6 +1571,7 @@ func (s *Server) buildMergedConfig(quickOverrideJSON string) (*config.Config, er
 			MaxLatency      *int  `json:"maxLatency"`
 			StabilityRounds *int  `json:"stabilityRounds"`
 			SampleSize      *int  `json:"sampleSize"`
+			Adaptive        *bool `json:"adaptive"`
 			JitterTest      *bool `json:"jitterTest"`
 			SpeedTest       *bool `json:"speedTest"`
 			PacketLossCount *int  `json:"packetLossCount"`
@@ -1221,6 +1582,7 @@ func (s *Server) buildMergedConfig(quickOverrideJSON string) (*config.Config, er
 			if q.MaxLatency != nil && *q.MaxLatency > 0   { cfg.Scan.MaxLatency = *q.MaxLatency }
 			if q.StabilityRounds != nil                   { cfg.Scan.StabilityRounds = *q.StabilityRounds }
 			if q.SampleSize != nil && *q.SampleSize > 0   { cfg.Scan.SampleSize = *q.SampleSize }
+			if q.Adaptive != nil                          { cfg.Scan.Adaptive = *q.Adaptive }
 			if q.JitterTest != nil                        { cfg.Scan.JitterTest = *q.JitterTest }
 			if q.SpeedTest != nil && *q.SpeedTest {
 				cfg.Scan.SpeedTest = true
@@ -1230,7 +1592,7 @@ func (s *Server) buildMergedConfig(quickOverrideJSON string) (*config.Config, er
 		}
 	}
 
-	return cfg, nil
+	return cfg
 }
 
 // --- TUI SSE Stream ---
@@ -1309,46 +1671,36 @@ func (s *Server) handleTemplateSave(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "name and rawUrl required", 400)
 		return
 	}
-	cfg, err := ParseProxyURL(req.RawURL)
+	tmpl, err := s.saveTemplate(req.Name, req.RawURL)
 	if err != nil {
-		jsonError(w, err.Error(), 400)
+		jsonAPIError(w, err)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tmpl)
+}
+
+// saveTemplate لینک رو parse و به عنوان template ذخیره می‌کنه
+func (s *Server) saveTemplate(name, rawURL string) (config.ConfigTemplate, error) {
+	cfg, err := ParseProxyURL(rawURL)
+	if err != nil {
+		return config.ConfigTemplate{}, errBadRequest(err.Error())
+	}
 	cfgJSON, _ := json.MarshalIndent(cfg, "", "  ")
 	tmpl := config.ConfigTemplate{
 		ID:         fmt.Sprintf("%d", time.Now().UnixMilli()),
-		Name:       req.Name,
-		RawURL:     req.RawURL,
+		Name:       name,
+		RawURL:     rawURL,
 		ConfigJSON: string(cfgJSON),
 		CreatedAt:  time.Now().Unix(),
 	}
 	s.state.mu.Lock()
 	s.state.Templates = append(s.state.Templates, tmpl)
-	proxyJSON := s.state.SavedProxyConfig
-	_ = proxyJSON
-	scanJSON := s.state.SavedScanConfig
-	_ = scanJSON
-	rawURL := s.state.SavedRawURL
-	_ = rawURL
-	templates := make([]config.ConfigTemplate, len(s.state.Templates))
-	copy(templates, s.state.Templates)
-	_ = templates
 	s.state.mu.Unlock()
 
 	// سیو روی دیسک
-	heCopyForSave := make(map[string]*config.HealthEntry, len(s.state.HealthEntries))
-	for k, v := range s.state.HealthEntries { cp := *v; heCopyForSave[k] = &cp }
-	_ = heCopyForSave
-	healthEnabled := s.state.HealthEnabled
-	healthIntervalMins := s.state.HealthIntervalMins
-	trafficDetect := s.state.TrafficDetectEnabled
-	_ = healthEnabled
-	_ = healthIntervalMins
-	_ = trafficDetect
 	go s.saveStateToDiskNow()
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(tmpl)
+	return tmpl, nil
 }
 
 func (s *Server) handleTemplateDelete(w http.ResponseWriter, r *http.Request) {
@@ -1361,38 +1713,28 @@ func (s *Server) handleTemplateDelete(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "invalid request", 400)
 		return
 	}
+	s.deleteTemplate(req.ID)
+	jsonOK(w, "deleted")
+}
+
+// deleteTemplate template رو حذف می‌کنه — false یعنی پیدا نشد
+func (s *Server) deleteTemplate(id string) bool {
 	s.state.mu.Lock()
+	found := false
 	out := s.state.Templates[:0]
 	for _, t := range s.state.Templates {
-		if t.ID != req.ID {
+		if t.ID != id {
 			out = append(out, t)
+		} else {
+			found = true
 		}
 	}
 	s.state.Templates = out
-	proxyJSON := s.state.SavedProxyConfig
-	_ = proxyJSON
-	scanJSON := s.state.SavedScanConfig
-	_ = scanJSON
-	rawURL2 := s.state.SavedRawURL
-	_ = rawURL2
-	templates2 := make([]config.ConfigTemplate, len(s.state.Templates))
-	copy(templates2, s.state.Templates)
-	_ = templates2
 	s.state.mu.Unlock()
 
 	// سیو روی دیسک
-	heCopyForSave2 := make(map[string]*config.HealthEntry, len(s.state.HealthEntries))
-	for k, v := range s.state.HealthEntries { cp := *v; heCopyForSave2[k] = &cp }
-	_ = heCopyForSave2
-	healthEnabled := s.state.HealthEnabled
-	healthIntervalMins := s.state.HealthIntervalMins
-	trafficDetect := s.state.TrafficDetectEnabled
-	_ = healthEnabled
-	_ = healthIntervalMins
-	_ = trafficDetect
 	go s.saveStateToDiskNow()
-
-	jsonOK(w, "deleted")
+	return found
 }
 
 // ── Subnet Intelligence ───────────────────────────────────────────────────────
@@ -1527,35 +1869,25 @@ func (s *Server) handleHealthAdd(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "ip required", 400)
 		return
 	}
+	s.addHealthEntry(req.IP, req.BaseLatencyMs, "")
+	jsonOK(w, "added")
+}
 
+// addHealthEntry یه IP به monitor اضافه می‌کنه و فوری یه بار چکش می‌کنه.
+// source فقط روی IP های جدید ثبت میشه — IP دستی مال schedule نمیشه.
+func (s *Server) addHealthEntry(ip string, baseLatencyMs float64, source string) {
 	s.state.mu.Lock()
-	if _, exists := s.state.HealthEntries[req.IP]; !exists {
-		s.state.HealthEntries[req.IP] = &config.HealthEntry{
-			IP:            req.IP,
+	if _, exists := s.state.HealthEntries[ip]; !exists {
+		s.state.HealthEntries[ip] = &config.HealthEntry{
+			IP:            ip,
 			Status:        config.HealthUnknown,
-			BaseLatencyMs: req.BaseLatencyMs,
+			BaseLatencyMs: baseLatencyMs,
 			LastCheck:     time.Now().UnixMilli(),
+			Source:        source,
 		}
 	}
-	proxyJSON := s.state.SavedProxyConfig
-	_ = proxyJSON
-	scanJSON := s.state.SavedScanConfig
-	_ = scanJSON
-	rawURL := s.state.SavedRawURL
-	_ = rawURL
-	templates := make([]config.ConfigTemplate, len(s.state.Templates))
-	copy(templates, s.state.Templates)
-	_ = templates
-	heCopy := make(map[string]*config.HealthEntry, len(s.state.HealthEntries))
-	for k, v := range s.state.HealthEntries { cp := *v; heCopy[k] = &cp }
 	s.state.mu.Unlock()
 
-	healthEnabled := s.state.HealthEnabled
-	healthIntervalMins := s.state.HealthIntervalMins
-	trafficDetect := s.state.TrafficDetectEnabled
-	_ = healthEnabled
-	_ = healthIntervalMins
-	_ = trafficDetect
 	go s.saveStateToDiskNow()
 
 	// Health monitor goroutine شروع کن (اگه قبلاً نبوده)
@@ -1564,9 +1896,9 @@ func (s *Server) handleHealthAdd(w http.ResponseWriter, r *http.Request) {
 	// بلافاصله یه چک اولیه بزن در background
 	go func() {
 		cfg, err := s.buildMergedConfig("")
-		if err != nil || cfg.Proxy.UUID == "" {
+		if err != nil || cfg.Proxy.CheckCredentials() != nil {
 			s.hub.Broadcast("health_update", map[string]interface{}{
-				"ip":     req.IP,
+				"ip":     ip,
 				"status": "unknown",
 				"error":  "no proxy config",
 			})
@@ -1576,10 +1908,8 @@ func (s *Server) handleHealthAdd(w http.ResponseWriter, r *http.Request) {
 		if testURL == "" {
 			testURL = "https://www.gstatic.com/generate_204"
 		}
-		s.checkOneIP(cfg, req.IP, testURL)
+		s.checkOneIP(cfg, ip, testURL)
 	}()
-
-	jsonOK(w, "added")
 }
 
 func (s *Server) handleHealthRemove(w http.ResponseWriter, r *http.Request) {
@@ -1592,28 +1922,18 @@ func (s *Server) handleHealthRemove(w http.ResponseWriter, r *http.Request) {
 		jsonError(w, "invalid request", 400)
 		return
 	}
+	s.removeHealthEntry(req.IP)
+	jsonOK(w, "removed")
+}
+
+// removeHealthEntry IP رو از monitor حذف می‌کنه — false یعنی نبود
+func (s *Server) removeHealthEntry(ip string) bool {
 	s.state.mu.Lock()
-	delete(s.state.HealthEntries, req.IP)
-	proxyJSON := s.state.SavedProxyConfig
-	_ = proxyJSON
-	scanJSON := s.state.SavedScanConfig
-	_ = scanJSON
-	rawURL := s.state.SavedRawURL
-	_ = rawURL
-	templates := make([]config.ConfigTemplate, len(s.state.Templates))
-	copy(templates, s.state.Templates)
-	_ = templates
-	heCopy := make(map[string]*config.HealthEntry, len(s.state.HealthEntries))
-	for k, v := range s.state.HealthEntries { cp := *v; heCopy[k] = &cp }
+	_, found := s.state.HealthEntries[ip]
+	delete(s.state.HealthEntries, ip)
 	s.state.mu.Unlock()
-	healthEnabled := s.state.HealthEnabled
-	healthIntervalMins := s.state.HealthIntervalMins
-	trafficDetect := s.state.TrafficDetectEnabled
-	_ = healthEnabled
-	_ = healthIntervalMins
-	_ = trafficDetect
 	go s.saveStateToDiskNow()
-	jsonOK(w, "removed")
+	return found
 }
 
 // startHealthMonitor یه goroutine شروع می‌کنه که هر N دقیقه IP ها رو ping می‌کنه
@@ -1658,6 +1978,7 @@ func (s *Server) handleHealthSettings(w http.ResponseWriter, r *http.Request) {
 			"enabled":           s.state.HealthEnabled,
 			"intervalMins":      s.state.HealthIntervalMins,
 			"trafficDetect":     s.state.TrafficDetectEnabled,
+			"rotation":      s.state.HealthRotation,
 		})
 		return
 	}
@@ -1665,15 +1986,43 @@ func (s *Server) handleHealthSettings(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "GET or POST only", 405)
 		return
 	}
-	var req struct {
-		Enabled       *bool `json:"enabled"`
-		IntervalMins  *int  `json:"intervalMins"`
-		TrafficDetect *bool `json:"trafficDetect"`
-	}
+	var req healthSettings
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonError(w, "invalid request", 400)
 		return
 	}
+	if err := req.validate(); err != nil {
+		jsonAPIError(w, err)
+		return
+	}
+	s.updateHealthSettings(req)
+	jsonOK(w, "settings updated")
+}
+
+// healthSettings تنظیمات مانیتور — فیلدهای nil دست نمی‌خورن
+type healthSettings struct {
+	Enabled       *bool                  `json:"enabled"`
+	IntervalMins  *int                   `json:"intervalMins"`
+	TrafficDetect *bool                  `json:"trafficDetect"`
+	Rotation      *config.HealthRotation `json:"rotation"` // کل سیاست با هم عوض میشه
+}
+
+func (req healthSettings) validate() error {
+	if req.IntervalMins != nil && *req.IntervalMins <= 0 {
+		return errBadRequest("intervalMins must be positive")
+	}
+	if rot := req.Rotation; rot != nil {
+		if rot.PoolSize < 0 || rot.MinChecks < 0 || rot.ScanMaxIPs < 0 {
+			return errBadRequest("rotation poolSize, minChecks and scanMaxIPs must not be negative")
+		}
+		if rot.MinUptimePct < 0 || rot.MinUptimePct > 100 {
+			return errBadRequest("rotation minUptimePct must be between 0 and 100")
+		}
+	}
+	return nil
+}
+
+func (s *Server) updateHealthSettings(req healthSettings) {
 	s.state.mu.Lock()
 	if req.Enabled != nil {
 		s.state.HealthEnabled = *req.Enabled
@@ -1688,10 +2037,15 @@ func (s *Server) handleHealthSettings(w http.ResponseWriter, r *http.Request) {
 	if req.TrafficDetect != nil {
 		s.state.TrafficDetectEnabled = *req.TrafficDetect
 	}
+	if req.Rotation != nil {
+		s.state.HealthRotation = *req.Rotation
+	}
 	s.state.mu.Unlock()
+	if req.Rotation != nil && req.Rotation.Enabled {
+		s.startHealthMonitor()
+	}
 	// تنظیمات رو روی دیسک ذخیره کن
 	go s.saveStateToDiskNow()
-	jsonOK(w, "settings updated")
 }
 
 // handlePhase3Run تست سرعت جداگانه (Phase 3) روی IP های موفق فاز 2 اجرا می‌کنه
@@ -1751,7 +2105,7 @@ func (s *Server) handlePhase3Run(w http.ResponseWriter, r *http.Request) {
 		s.hub.Broadcast("phase3_start", map[string]int{"count": len(req.IPs)})
 		var done3 int
 		total3 := len(req.IPs)
-		p3results := scanner.RunPhase2WithCallback(context.Background(), cfg, phase1Results, func(r scanner.Phase2Result) {
+		p3results := scanner.RunPhase2WithBudget(context.Background(), cfg, phase1Results, s.budget, func(r scanner.Phase2Result) {
 			done3++
 			pct := done3 * 100 / total3
 			s.hub.Broadcast("phase3_progress", map[string]interface{}{
@@ -1773,13 +2127,15 @@ func (s *Server) handlePhase3Run(w http.ResponseWriter, r *http.Request) {
 func (s *Server) runHealthChecks() {
 	// config رو از saved state بساز — CurrentConfig همیشه nil هست
 	cfg, err := s.buildMergedConfig("")
-	if err != nil || cfg.Proxy.UUID == "" {
+	if err != nil || cfg.Proxy.CheckCredentials() != nil {
 		// proxy config نداریم — health check ممکن نیست
 		s.hub.Broadcast("health_error", map[string]string{
 			"message": "no proxy config — import a proxy link first",
 		})
 		return
 	}
+	// بعد از هر دور، IP های ضعیف جایگزین میشن (rotation.go)
+	defer s.rotateHealthPool()
 
 	s.state.mu.RLock()
 	entries := make(map[string]*config.HealthEntry)
@@ -1816,29 +2172,18 @@ func (s *Server) runHealthChecks() {
 
 // checkOneIP یه IP رو با xray تست می‌کنه و نتیجه رو آپدیت می‌کنه
 func (s *Server) checkOneIP(cfg *config.Config, ip string, testURL string) {
-	port := utils.AcquirePort()
-	defer utils.ReleasePort(port)
-
 	cfgCopy := *cfg
 	cfgCopy.Xray.LogLevel = "none"
 
-	xrayCfg, err := config.GenerateXrayConfig(&cfgCopy, ip, port)
-	if err != nil {
-		s.updateHealthEntry(ip, false, 0, "config error: "+err.Error())
-		return
-	}
-
-	mgr := xray.NewManagerWithDebug(false)
-	if err := mgr.Start(xrayCfg, port); err != nil {
-		s.updateHealthEntry(ip, false, 0, "xray start error: "+err.Error())
-		return
-	}
-	defer mgr.Stop()
+	// xray instance از pool قرض گرفته میشه و فقط outbound اش عوض میشه
+	tester := s.testers.Get(&cfgCopy)
+	defer s.testers.Put(tester)
 
 	startCtx, startCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer startCancel()
-	if err := mgr.WaitForReadyWithContext(startCtx, 9*time.Second); err != nil {
-		s.updateHealthEntry(ip, false, 0, "xray not ready")
+	port, err := tester.Target(startCtx, ip)
+	if err != nil {
+		s.updateHealthEntry(ip, false, 0, err.Error())
 		return
 	}
 
@@ -1859,6 +2204,13 @@ func (s *Server) checkOneIP(cfg *config.Config, ip string, testURL string) {
 
 // updateHealthEntry نتیجه تست رو در state ذخیره می‌کنه و broadcast میکنه
 func (s *Server) updateHealthEntry(ip string, success bool, latencyMs float64, errMsg string) {
+	// هشدارها بعد از Unlock فرستاده میشن (defer ها برعکس اجرا میشن)
+	var events []notifyEvent
+	defer func() {
+		for _, ev := range events {
+			s.notify(ev)
+		}
+	}()
 	s.state.mu.Lock()
 	defer s.state.mu.Unlock()
 
@@ -1866,6 +2218,7 @@ func (s *Server) updateHealthEntry(ip string, success bool, latencyMs float64, e
 	if !ok {
 		return
 	}
+	prevStatus := he.Status
 
 	now := time.Now().UnixMilli()
 	he.TotalChecks++
@@ -1884,7 +2237,6 @@ func (s *Server) updateHealthEntry(ip string, success bool, latencyMs float64, e
 	}
 
 	if success {
-		prevStatus := he.Status
 		he.TotalAlive++
 		he.LatencyMs = latencyMs
 		he.LastSeen = now
@@ -1906,6 +2258,7 @@ func (s *Server) updateHealthEntry(ip string, success bool, latencyMs float64, e
 	if he.TotalChecks > 0 {
 		he.UptimePct = float64(he.TotalAlive) / float64(he.TotalChecks) * 100
 	}
+	events = s.healthTransitionEventsLocked(he, prevStatus, errMsg)
 
 	s.hub.Broadcast("health_update", map[string]interface{}{
 		"ip":             ip,
@@ -1936,7 +2289,7 @@ func (s *Server) handleFragmentAuto(w http.ResponseWriter, r *http.Request) {
 	json.NewDecoder(r.Body).Decode(&req)
 
 	cfg, err := s.buildMergedConfig("")
-	if err != nil || cfg.Proxy.UUID == "" {
+	if err != nil || cfg.Proxy.CheckCredentials() != nil {
 		jsonError(w, "no proxy config — import a link first", 400)
 		return
 	}
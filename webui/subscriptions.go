@@ -0,0 +1,374 @@
+package webui
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"piyazche/config"
+	"piyazche/history"
+)
+
+// ── Live Subscriptions ────────────────────────────────────────────────────────
+//
+// هر subscription یه لینک /sub/<token> ئه که client ها (v2rayN، Clash،
+// sing-box) دوره‌ای آپدیتش می‌کنن. هر بار که خونده میشه از IP های زنده‌ی health
+// monitor یا آخرین session که IP pass شده داره ساخته میشه، پس بعد از هر اسکن
+// یا rotation لینک دستی لازم نیست. token جای auth ئه و فقط همون subscription
+// رو باز می‌کنه.
+
+const (
+	subscriptionMaxIPs         = 10
+	subscriptionUpdateInterval = 6 // ساعت — Profile-Update-Interval برای client ها
+)
+
+// subscriptionEdit فیلدهای ساخت subscription
+type subscriptionEdit struct {
+	Name     string `json:"name"`
+	Template string `json:"template,omitempty"`
+	Source   string `json:"source,omitempty"`
+	MaxIPs   int    `json:"maxIPs,omitempty"`
+}
+
+// subscriptionView یه subscription با مسیرش و IP هایی که الان میده
+type subscriptionView struct {
+	config.Subscription
+	URL string   `json:"url"` // نسبی — ?format=clash یا singbox برای بقیه‌ی client ها
+	IPs []string `json:"ips"`
+}
+
+// subscriptionPick IP هایی که زیر قفل state انتخاب شدن؛ historyFP یعنی هنوز
+// خالیه و باید بعد از آزاد شدن قفل از تاریخچه‌ی همین fingerprint پر بشه
+type subscriptionPick struct {
+	ips       []string
+	historyFP string
+	max       int
+}
+
+// handleSubscription — GET /sub/{token}?format=v2ray|clash|singbox، بدون auth
+func (s *Server) handleSubscription(w http.ResponseWriter, r *http.Request) {
+	s.state.mu.RLock()
+	var sub *config.Subscription
+	for i := range s.state.Subscriptions {
+		if secretEqual(s.state.Subscriptions[i].Token, r.PathValue("token")) {
+			sub = &s.state.Subscriptions[i]
+			break
+		}
+	}
+	if sub == nil {
+		s.state.mu.RUnlock()
+		http.NotFound(w, r)
+		return
+	}
+	name := sub.Name
+	rawURL := s.subscriptionRawURLLocked(*sub)
+	pick := s.subscriptionPickLocked(*sub)
+	s.state.mu.RUnlock()
+
+	if rawURL == "" {
+		http.Error(w, "no proxy link saved for this subscription", http.StatusServiceUnavailable)
+		return
+	}
+	// لیست خالی برای Clash سند نامعتبره و client پروفایل رو دور می‌ریزه؛ با
+	// خطا client همون IP های قبلی رو نگه می‌داره
+	ips := s.subscriptionIPs(pick)
+	if len(ips) == 0 {
+		http.Error(w, "no alive or passing IPs for this subscription yet", http.StatusServiceUnavailable)
+		return
+	}
+	cfg, err := ParseProxyURL(rawURL)
+	if err != nil {
+		http.Error(w, "bad proxy link: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Profile-Update-Interval", fmt.Sprint(subscriptionUpdateInterval))
+	w.Header().Set("Cache-Control", "no-store")
+	filename := strings.Map(func(r rune) rune {
+		if r == '"' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	switch r.URL.Query().Get("format") {
+	case "clash":
+		w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.yaml"`, filename))
+		fmt.Fprint(w, BuildClashProxies(cfg, ips, rawURL))
+	case "singbox":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.json"`, filename))
+		fmt.Fprint(w, BuildSingboxOutbounds(cfg, ips))
+	case "", "v2ray":
+		var links []string
+		for i, ip := range ips {
+			link, err := BuildNamedProxyURL(cfg, ip, rawURL, fmt.Sprintf("%s %d", name, i+1))
+			if err == nil {
+				links = append(links, link)
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n"))))
+	default:
+		http.Error(w, "format must be v2ray, clash or singbox", http.StatusBadRequest)
+	}
+}
+
+// subscriptionRawURLLocked لینک template یا لینک ذخیره‌شده
+func (s *Server) subscriptionRawURLLocked(sub config.Subscription) string {
+	if sub.Template != "" {
+		for _, t := range s.state.Templates {
+			if t.ID == sub.Template {
+				return t.RawURL
+			}
+		}
+		return ""
+	}
+	return s.state.SavedRawURL
+}
+
+// subscriptionPickLocked بهترین IP های فعلی برای کانفیگ subscription: IP های
+// زنده‌ی monitor (بیشترین uptime، بعد کمترین latency)، یا بهترین نتایج آخرین
+// session همین کانفیگ که pass داشته، یا اگه session نیست از تاریخچه. IP ای که
+// با کانفیگ دیگه‌ای تست شده توی لینک‌ها نمیاد. تاریخچه اینجا خونده نمیشه تا
+// /sub/ که بدون auth ئه قفل state رو موقع query دیسک نگه نداره.
+func (s *Server) subscriptionPickLocked(sub config.Subscription) subscriptionPick {
+	p := subscriptionPick{max: sub.MaxIPs}
+	if p.max <= 0 {
+		p.max = subscriptionMaxIPs
+	}
+	fp := s.subscriptionFPLocked(sub)
+	if fp == "" {
+		return p
+	}
+	var ips []string
+	// monitor همیشه با کانفیگ ذخیره‌شده چک می‌کنه
+	if sub.Source != "session" && fp == s.savedConfigFPLocked() {
+		ips = aliveByUptime(s.state.HealthEntries)
+	}
+	if sub.Source == "session" || (sub.Source == "auto" && len(ips) == 0) {
+		for _, sess := range s.state.Sessions {
+			if sess.ConfigFP != fp {
+				continue
+			}
+			passed := rankPassed(sess.Results)
+			if len(passed) == 0 {
+				continue
+			}
+			for _, r := range passed {
+				ips = append(ips, r.IP)
+			}
+			break
+		}
+		if len(ips) == 0 {
+			p.historyFP = fp
+		}
+	}
+	p.ips = ips
+	return p
+}
+
+// subscriptionIPs IP های pick، با تاریخچه اگه state چیزی نداشت — بدون قفل state صداش بزن
+func (s *Server) subscriptionIPs(p subscriptionPick) []string {
+	ips := p.ips
+	if p.historyFP != "" && s.hist != nil {
+		f := history.Filter{ConfigFP: p.historyFP}
+		if f.Session = s.hist.LatestSession(f); f.Session != "" {
+			for _, r := range s.hist.Best(f, 0) {
+				if r.Passes > 0 {
+					ips = append(ips, r.IP)
+				}
+			}
+		}
+	}
+	if len(ips) > p.max {
+		ips = ips[:p.max]
+	}
+	return ips
+}
+
+// subscriptionFPLocked fingerprint کانفیگی که اسکن با template این subscription
+// (یا کانفیگ ذخیره‌شده) ثبت می‌کنه؛ "" اگه template دیگه نیست
+func (s *Server) subscriptionFPLocked(sub config.Subscription) string {
+	cfg := mergeConfig(s.state.SavedProxyConfig, s.state.SavedScanConfig, "")
+	if sub.Template != "" {
+		found := false
+		for _, t := range s.state.Templates {
+			if t.ID == sub.Template {
+				var tcfg config.Config
+				if err := json.Unmarshal([]byte(t.ConfigJSON), &tcfg); err != nil {
+					return ""
+				}
+				cfg.Proxy, found = tcfg.Proxy, true
+				break
+			}
+		}
+		if !found {
+			return ""
+		}
+	}
+	return cfg.Fingerprint()
+}
+
+// savedConfigFPLocked fingerprint کانفیگ ذخیره‌شده، همونی که health monitor باهاش چک می‌کنه
+func (s *Server) savedConfigFPLocked() string {
+	return mergeConfig(s.state.SavedProxyConfig, s.state.SavedScanConfig, "").Fingerprint()
+}
+
+// aliveByUptime IP های زنده، بیشترین uptime اول و بعد کمترین latency
+func aliveByUptime(entries map[string]*config.HealthEntry) []string {
+	var alive []*config.HealthEntry
+	for _, e := range entries {
+		if e.Status == config.HealthAlive || e.Status == config.HealthRecovered {
+			alive = append(alive, e)
+		}
+	}
+	sort.Slice(alive, func(a, b int) bool {
+		if alive[a].UptimePct != alive[b].UptimePct {
+			return alive[a].UptimePct > alive[b].UptimePct
+		}
+		return alive[a].LatencyMs < alive[b].LatencyMs
+	})
+	ips := make([]string, len(alive))
+	for i, e := range alive {
+		ips[i] = e.IP
+	}
+	return ips
+}
+
+// viewSubscription — pick رو subscriptionPickLocked زیر قفل ساخته، این بدون قفل صدا زده میشه
+func (s *Server) viewSubscription(sub config.Subscription, pick subscriptionPick) subscriptionView {
+	ips := s.subscriptionIPs(pick)
+	if ips == nil {
+		ips = []string{}
+	}
+	return subscriptionView{Subscription: sub, URL: "/sub/" + sub.Token, IPs: ips}
+}
+
+func (s *Server) subscriptionViews() []subscriptionView {
+	s.state.mu.RLock()
+	subs := append([]config.Subscription(nil), s.state.Subscriptions...)
+	picks := make([]subscriptionPick, len(subs))
+	for i, sub := range subs {
+		picks[i] = s.subscriptionPickLocked(sub)
+	}
+	s.state.mu.RUnlock()
+
+	out := make([]subscriptionView, 0, len(subs))
+	for i, sub := range subs {
+		out = append(out, s.viewSubscription(sub, picks[i]))
+	}
+	return out
+}
+
+// addSubscription یه subscription با token تازه می‌سازه
+func (s *Server) addSubscription(req subscriptionEdit) (subscriptionView, error) {
+	if req.Source == "" {
+		req.Source = "auto"
+	}
+	switch req.Source {
+	case "auto", "monitor", "session":
+	default:
+		return subscriptionView{}, errBadRequest("source must be auto, monitor or session")
+	}
+	if req.MaxIPs < 0 {
+		return subscriptionView{}, errBadRequest("maxIPs must not be negative")
+	}
+	if req.Template != "" && s.templateName(req.Template) == "" {
+		return subscriptionView{}, errBadRequest("no template " + req.Template)
+	}
+	if req.MaxIPs == 0 {
+		req.MaxIPs = subscriptionMaxIPs
+	}
+	if req.Name == "" {
+		req.Name = "piyazche"
+	}
+	token, err := newSubscriptionToken()
+	if err != nil {
+		return subscriptionView{}, err
+	}
+	sub := config.Subscription{
+		ID:        fmt.Sprintf("%d", time.Now().UnixMilli()),
+		Name:      req.Name,
+		Token:     token,
+		Template:  req.Template,
+		Source:    req.Source,
+		MaxIPs:    req.MaxIPs,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	s.state.mu.Lock()
+	for s.findSubscriptionLocked(sub.ID) != nil {
+		sub.ID += "0"
+	}
+	s.state.Subscriptions = append(s.state.Subscriptions, sub)
+	pick := s.subscriptionPickLocked(sub)
+	s.state.mu.Unlock()
+
+	go s.saveStateToDiskNow()
+	return s.viewSubscription(sub, pick), nil
+}
+
+// rotateSubscriptionToken لینک قبلی رو باطل می‌کنه
+func (s *Server) rotateSubscriptionToken(id string) (subscriptionView, error) {
+	token, err := newSubscriptionToken()
+	if err != nil {
+		return subscriptionView{}, err
+	}
+	s.state.mu.Lock()
+	sub := s.findSubscriptionLocked(id)
+	if sub == nil {
+		s.state.mu.Unlock()
+		return subscriptionView{}, errNotFound("no subscription " + id)
+	}
+	sub.Token = token
+	updated := *sub
+	pick := s.subscriptionPickLocked(updated)
+	s.state.mu.Unlock()
+
+	go s.saveStateToDiskNow()
+	return s.viewSubscription(updated, pick), nil
+}
+
+// deleteSubscription — false یعنی پیدا نشد
+func (s *Server) deleteSubscription(id string) bool {
+	s.state.mu.Lock()
+	found := false
+	out := s.state.Subscriptions[:0]
+	for _, sub := range s.state.Subscriptions {
+		if sub.ID != id {
+			out = append(out, sub)
+		} else {
+			found = true
+		}
+	}
+	s.state.Subscriptions = out
+	s.state.mu.Unlock()
+
+	go s.saveStateToDiskNow()
+	return found
+}
+
+func (s *Server) findSubscriptionLocked(id string) *config.Subscription {
+	for i := range s.state.Subscriptions {
+		if s.state.Subscriptions[i].ID == id {
+			return &s.state.Subscriptions[i]
+		}
+	}
+	return nil
+}
+
+func newSubscriptionToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to create token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
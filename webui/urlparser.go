@@ -9,9 +9,10 @@ import (
 	"strings"
 
 	"piyazche/config"
+	"piyazche/utils"
 )
 
-// ParseProxyURL تبدیل vless:// vmess:// trojan:// یا JSON به config.Config
+// ParseProxyURL تبدیل vless:// vmess:// trojan:// ss:// hysteria2:// یا JSON به config.Config
 func ParseProxyURL(input string) (*config.Config, error) {
 	input = strings.TrimSpace(input)
 
@@ -24,11 +25,23 @@ func ParseProxyURL(input string) (*config.Config, error) {
 	if strings.HasPrefix(input, "trojan://") {
 		return parseTrojan(input)
 	}
+	if strings.HasPrefix(input, "ss://") {
+		return parseShadowsocks(input)
+	}
+	if strings.HasPrefix(input, "hysteria2://") || strings.HasPrefix(input, "hy2://") {
+		return parseHysteria2(input)
+	}
 	if strings.HasPrefix(input, "{") {
 		return parseJSONConfig(input)
 	}
 
-	return nil, fmt.Errorf("فرمت شناخته‌شده نیست — vless:// vmess:// trojan:// یا JSON بفرست")
+	return nil, fmt.Errorf("فرمت شناخته‌شده نیست — vless:// vmess:// trojan:// ss:// hysteria2:// یا JSON بفرست")
+}
+
+// IsProxyLink بررسی می‌کنه input یه لینک پروکسی پشتیبانی‌شده هست یا نه
+func IsProxyLink(input string) bool {
+	proto, _ := detectProtoAndRemark(strings.TrimSpace(input))
+	return proto != ""
 }
 
 // --- VLESS parser ---
@@ -73,6 +86,7 @@ func parseVless(raw string) (*config.Config, error) {
 	alpn := parseALPN(alpnStr)
 
 	cfg := config.DefaultConfig()
+	cfg.Proxy.Protocol = "vless"
 	cfg.Proxy.UUID = uuid
 	cfg.Proxy.Address = host
 	cfg.Proxy.Port = port
@@ -170,6 +184,7 @@ func parseVmess(raw string) (*config.Config, error) {
 		Fp      string      `json:"fp"`
 		Alpn    string      `json:"alpn"`
 		Aid     interface{} `json:"aid"`
+		Scy     string      `json:"scy"`
 		// grpc
 		ServiceName string `json:"serviceName"`
 		// xhttp
@@ -196,6 +211,16 @@ func parseVmess(raw string) (*config.Config, error) {
 		}
 	}
 
+	alterID := 0
+	switch a := v.Aid.(type) {
+	case float64:
+		alterID = int(a)
+	case string:
+		if n, err := strconv.Atoi(a); err == nil {
+			alterID = n
+		}
+	}
+
 	transportType := firstNonEmpty(v.Net, "tcp")
 	if transportType == "h2" {
 		transportType = "xhttp"
@@ -206,18 +231,29 @@ func parseVmess(raw string) (*config.Config, error) {
 	alpn := parseALPN(v.Alpn)
 
 	cfg := config.DefaultConfig()
+	cfg.Proxy.Protocol = "vmess"
 	cfg.Proxy.UUID = v.ID
 	cfg.Proxy.Address = v.Add
 	cfg.Proxy.Port = port
 	cfg.Proxy.Type = transportType
-	cfg.Proxy.Method = "tls"
+	cfg.Proxy.Vmess = &config.VmessConfig{
+		AlterID:  alterID,
+		Security: firstNonEmpty(v.Scy, "auto"),
+	}
 
-	allowInsecure := v.AllowInsecure == "1" || v.AllowInsecure == "true" || v.SkipCertVerify
-	cfg.Proxy.TLS = &config.TlsConfig{
-		SNI:           sni,
-		Fingerprint:   fp,
-		ALPN:          alpn,
-		AllowInsecure: allowInsecure,
+	// tls فیلد خالی یعنی بدون TLS
+	if v.TLS == "tls" {
+		cfg.Proxy.Method = "tls"
+		allowInsecure := v.AllowInsecure == "1" || v.AllowInsecure == "true" || v.SkipCertVerify
+		cfg.Proxy.TLS = &config.TlsConfig{
+			SNI:           sni,
+			Fingerprint:   fp,
+			ALPN:          alpn,
+			AllowInsecure: allowInsecure,
+		}
+	} else {
+		cfg.Proxy.Method = "none"
+		cfg.Proxy.TLS = nil
 	}
 
 	switch transportType {
@@ -256,6 +292,9 @@ func parseTrojan(raw string) (*config.Config, error) {
 	}
 
 	password := u.User.Username()
+	if password == "" {
+		return nil, fmt.Errorf("trojan: password خالی است")
+	}
 	host := u.Hostname()
 	portStr := firstNonEmpty(u.Port(), "443")
 	port, _ := strconv.Atoi(portStr)
@@ -273,15 +312,36 @@ func parseTrojan(raw string) (*config.Config, error) {
 	alpn := parseALPN(q.Get("alpn"))
 
 	cfg := config.DefaultConfig()
-	cfg.Proxy.UUID = password // trojan uses password as uuid field
+	cfg.Proxy.Protocol = "trojan"
+	cfg.Proxy.UUID = password // kept for code paths that only check uuid
+	cfg.Proxy.Trojan = &config.TrojanConfig{Password: password}
 	cfg.Proxy.Address = host
 	cfg.Proxy.Port = port
 	cfg.Proxy.Type = transportType
-	cfg.Proxy.Method = "tls"
-	cfg.Proxy.TLS = &config.TlsConfig{
-		SNI:         sni,
-		Fingerprint: fp,
-		ALPN:        alpn,
+
+	switch q.Get("security") {
+	case "reality":
+		cfg.Proxy.Method = "reality"
+		cfg.Proxy.TLS = nil
+		cfg.Proxy.Reality = &config.RealityConfig{
+			PublicKey:   q.Get("pbk"),
+			ShortId:     firstNonEmpty(q.Get("sid"), q.Get("shortId")),
+			SpiderX:     q.Get("spx"),
+			Fingerprint: fp,
+			ServerName:  sni,
+		}
+	case "none":
+		cfg.Proxy.Method = "none"
+		cfg.Proxy.TLS = nil
+	default:
+		cfg.Proxy.Method = "tls"
+		allowInsecure := q.Get("allowInsecure") == "1" || q.Get("allowInsecure") == "true"
+		cfg.Proxy.TLS = &config.TlsConfig{
+			SNI:           sni,
+			Fingerprint:   fp,
+			ALPN:          alpn,
+			AllowInsecure: allowInsecure,
+		}
 	}
 
 	if transportType == "ws" {
@@ -299,6 +359,200 @@ func parseTrojan(raw string) (*config.Config, error) {
 	return cfg, nil
 }
 
+// --- Shadowsocks parser ---
+// SIP002: ss://base64(method:password)@host:port/?plugin=v2ray-plugin%3Bmode%3Dwebsocket#remark
+// plain userinfo (2022 ciphers): ss://method:password@host:port#remark
+// legacy: ss://base64(method:password@host:port)#remark
+func parseShadowsocks(raw string) (*config.Config, error) {
+	body := strings.TrimPrefix(raw, "ss://")
+	if idx := strings.Index(body, "#"); idx != -1 {
+		body = body[:idx]
+	}
+
+	// legacy format: همه چیز base64 شده
+	if !strings.Contains(body, "@") {
+		decoded := decodeBase64Loose(strings.TrimSuffix(body, "/"))
+		if decoded == "" || !strings.Contains(decoded, "@") {
+			return nil, fmt.Errorf("ss: لینک نامعتبر")
+		}
+		body = decoded
+	}
+
+	u, err := url.Parse("ss://" + body)
+	if err != nil {
+		return nil, fmt.Errorf("ss URL parse error: %w", err)
+	}
+
+	var cipher, password string
+	if pw, ok := u.User.Password(); ok {
+		cipher, password = u.User.Username(), pw
+	} else {
+		decoded := decodeBase64Loose(u.User.Username())
+		parts := strings.SplitN(decoded, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("ss: userinfo نامعتبر")
+		}
+		cipher, password = parts[0], parts[1]
+	}
+	if cipher == "" || password == "" {
+		return nil, fmt.Errorf("ss: method یا password خالی است")
+	}
+
+	host := u.Hostname()
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return nil, fmt.Errorf("ss: port نامعتبر: %s", u.Port())
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Proxy.Protocol = "shadowsocks"
+	cfg.Proxy.Address = host
+	cfg.Proxy.Port = port
+	cfg.Proxy.Type = "tcp"
+	cfg.Proxy.Method = "none"
+	cfg.Proxy.TLS = nil
+	cfg.Proxy.WS = nil
+	cfg.Proxy.Shadowsocks = &config.ShadowsocksConfig{
+		Cipher:   cipher,
+		Password: password,
+	}
+
+	// SIP003 plugin: "name;opt1=v1;opt2"
+	if plugin := u.Query().Get("plugin"); plugin != "" {
+		name, opts, _ := strings.Cut(plugin, ";")
+		cfg.Proxy.Shadowsocks.Plugin = name
+		cfg.Proxy.Shadowsocks.PluginOpts = opts
+
+		// v2ray-plugin در حالت websocket همون ws transport خود xray هست
+		if name == "v2ray-plugin" || name == "xray-plugin" {
+			po := parsePluginOpts(opts)
+			if mode, ok := po["mode"]; !ok || mode == "websocket" {
+				wsHost := firstNonEmpty(po["host"], host)
+				cfg.Proxy.Type = "ws"
+				cfg.Proxy.WS = &config.WsConfig{Host: wsHost, Path: firstNonEmpty(po["path"], "/")}
+				if _, tls := po["tls"]; tls {
+					cfg.Proxy.Method = "tls"
+					cfg.Proxy.TLS = &config.TlsConfig{
+						SNI:         wsHost,
+						Fingerprint: "chrome",
+						ALPN:        []string{"http/1.1"},
+					}
+				}
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// parsePluginOpts گزینه‌های SIP003 رو به map تبدیل می‌کنه — گزینه‌های بدون مقدار (مثل tls) کلید خالی دارن
+func parsePluginOpts(opts string) map[string]string {
+	out := map[string]string{}
+	for _, kv := range strings.Split(opts, ";") {
+		if kv == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(kv, "=")
+		out[k] = v
+	}
+	return out
+}
+
+// decodeBase64Loose همه‌ی انواع base64 (با/بدون padding، std/url) رو امتحان می‌کنه
+func decodeBase64Loose(s string) string {
+	if u, err := url.PathUnescape(s); err == nil {
+		s = u
+	}
+	s = strings.TrimRight(s, "=")
+	for _, enc := range []*base64.Encoding{base64.RawURLEncoding, base64.RawStdEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return string(b)
+		}
+	}
+	return ""
+}
+
+// --- Hysteria2 parser ---
+// hysteria2://auth@host:port/?sni=...&insecure=1&obfs=salamander&obfs-password=...&mport=20000-30000#remark
+func parseHysteria2(raw string) (*config.Config, error) {
+	raw = strings.Replace(raw, "hy2://", "hysteria2://", 1)
+
+	// پورت می‌تونه multi-port باشه (443,20000-30000) که url.Parse قبولش نمی‌کنه —
+	// قبل از parse جداش کن و اولی رو پورت اصلی بذار
+	portSpec := ""
+	rest := strings.TrimPrefix(raw, "hysteria2://")
+	authEnd := strings.IndexAny(rest, "/?#")
+	if authEnd == -1 {
+		authEnd = len(rest)
+	}
+	authority := rest[:authEnd]
+	if idx := strings.LastIndex(authority, ":"); idx != -1 && idx > strings.LastIndex(authority, "]") {
+		if spec := authority[idx+1:]; strings.ContainsAny(spec, ",-") {
+			portSpec = spec
+			raw = "hysteria2://" + authority[:idx] + rest[authEnd:]
+		}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("hysteria2 URL parse error: %w", err)
+	}
+
+	// auth می‌تونه user یا user:pass باشه
+	password := u.User.Username()
+	if pw, ok := u.User.Password(); ok {
+		password += ":" + pw
+	}
+	if password == "" {
+		return nil, fmt.Errorf("hysteria2: password خالی است")
+	}
+
+	host := u.Hostname()
+	q := u.Query()
+
+	portStr := firstNonEmpty(portSpec, u.Port(), "443")
+	ports := q.Get("mport")
+	if first, rest, multi := strings.Cut(portStr, ","); multi {
+		portStr = first
+		ports = firstNonEmpty(ports, rest)
+	}
+	if first, _, isRange := strings.Cut(portStr, "-"); isRange {
+		ports = firstNonEmpty(ports, portStr)
+		portStr = first
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("hysteria2: port نامعتبر: %s", portStr)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Proxy.Protocol = "hysteria2"
+	cfg.Proxy.Address = host
+	cfg.Proxy.Port = port
+	cfg.Proxy.Type = "quic"
+	cfg.Proxy.Method = "tls"
+	cfg.Proxy.WS = nil
+	cfg.Proxy.TLS = &config.TlsConfig{
+		SNI:           firstNonEmpty(q.Get("sni"), q.Get("peer"), host),
+		ALPN:          []string{"h3"},
+		AllowInsecure: q.Get("insecure") == "1" || q.Get("insecure") == "true",
+	}
+	if alpn := q.Get("alpn"); alpn != "" {
+		cfg.Proxy.TLS.ALPN = parseALPN(alpn)
+	}
+	cfg.Proxy.Hysteria2 = &config.Hysteria2Config{
+		Password:     password,
+		Obfs:         q.Get("obfs"),
+		ObfsPassword: q.Get("obfs-password"),
+		Ports:        ports,
+		PinSHA256:    q.Get("pinSHA256"),
+	}
+	cfg.Proxy.Hysteria2.UpMbps, _ = strconv.Atoi(q.Get("upmbps"))
+	cfg.Proxy.Hysteria2.DownMbps, _ = strconv.Atoi(q.Get("downmbps"))
+
+	return cfg, nil
+}
+
 func parseJSONConfig(raw string) (*config.Config, error) {
 	cfg := config.DefaultConfig()
 	if err := json.Unmarshal([]byte(raw), cfg); err != nil {
@@ -340,8 +594,14 @@ func parseALPN(s string) []string {
 
 // BuildProxyURL یه config رو با IP جدید به لینک برمیگردونه
 func BuildProxyURL(cfg *config.Config, newIP string, origRaw string) (string, error) {
-	origRaw = strings.TrimSpace(origRaw)
-	proto, remark := detectProtoAndRemark(origRaw)
+	_, remark := detectProtoAndRemark(strings.TrimSpace(origRaw))
+	return BuildNamedProxyURL(cfg, newIP, origRaw, remark)
+}
+
+// BuildNamedProxyURL مثل BuildProxyURL ولی با remark دلخواه — برای subscription
+// که هر IP اسم جدا لازم داره
+func BuildNamedProxyURL(cfg *config.Config, newIP, origRaw, remark string) (string, error) {
+	proto, _ := detectProtoAndRemark(strings.TrimSpace(origRaw))
 	switch proto {
 	case "vless":
 		return buildVless(cfg, newIP, remark)
@@ -349,6 +609,10 @@ func BuildProxyURL(cfg *config.Config, newIP string, origRaw string) (string, er
 		return buildVmess(cfg, newIP, remark)
 	case "trojan":
 		return buildTrojan(cfg, newIP, remark)
+	case "ss":
+		return buildShadowsocks(cfg, newIP, remark)
+	case "hysteria2":
+		return buildHysteria2(cfg, newIP, remark)
 	}
 	return "", fmt.Errorf("پروتکل شناخته‌شده نیست")
 }
@@ -360,13 +624,26 @@ func detectProtoAndRemark(raw string) (proto, remark string) {
 		proto = "vmess"
 	} else if strings.HasPrefix(raw, "trojan://") {
 		proto = "trojan"
+	} else if strings.HasPrefix(raw, "ss://") {
+		proto = "ss"
+	} else if strings.HasPrefix(raw, "hysteria2://") || strings.HasPrefix(raw, "hy2://") {
+		proto = "hysteria2"
 	} else {
 		return "", ""
 	}
-	if proto == "vmess" {
+	// ss legacy (base64 کامل) و hysteria2 multi-port با url.Parse خونده نمیشن، پس مثل vmess از # بخون
+	if proto == "vmess" || proto == "ss" || proto == "hysteria2" {
 		if idx := strings.LastIndex(raw, "#"); idx != -1 {
 			r, _ := url.PathUnescape(raw[idx+1:])
 			remark = r
+		} else if proto == "vmess" {
+			// vmess استاندارد # نداره و اسمش توی فیلد ps خود JSON هست
+			var v struct {
+				PS string `json:"ps"`
+			}
+			if json.Unmarshal([]byte(decodeBase64Loose(strings.TrimPrefix(raw, "vmess://"))), &v) == nil {
+				remark = v.PS
+			}
 		}
 	} else {
 		if u, err := url.Parse(raw); err == nil {
@@ -415,7 +692,7 @@ func buildVless(cfg *config.Config, newIP, remark string) (string, error) {
 		}
 	}
 
-	link := fmt.Sprintf("vless://%s@%s:%d?%s", p.UUID, newIP, p.Port, q.Encode())
+	link := fmt.Sprintf("vless://%s@%s:%d?%s", p.UUID, formatLinkHost(newIP), p.Port, q.Encode())
 	if remark != "" { link += "#" + url.PathEscape(remark) }
 	return link, nil
 }
@@ -443,8 +720,14 @@ func buildVmess(cfg *config.Config, newIP, remark string) (string, error) {
 		if p.Xhttp != nil { host_ = p.Xhttp.Host; path_ = p.Xhttp.Path }
 	}
 
+	aid, scy := 0, "auto"
+	if p.Vmess != nil {
+		aid = p.Vmess.AlterID
+		if p.Vmess.Security != "" { scy = p.Vmess.Security }
+	}
+
 	v := map[string]interface{}{
-		"v": "2", "ps": remark, "add": newIP, "port": p.Port, "id": p.UUID, "aid": 0,
+		"v": "2", "ps": remark, "add": utils.StripBrackets(newIP), "port": p.Port, "id": p.UUID, "aid": aid, "scy": scy,
 		"net": net_, "type": "none", "host": host_, "path": path_,
 		"tls": map[bool]string{true: "tls", false: ""}[p.Method == "tls"],
 		"sni": sni, "fp": fp, "alpn": alpn,
@@ -461,11 +744,22 @@ func buildTrojan(cfg *config.Config, newIP, remark string) (string, error) {
 	p := cfg.Proxy
 	q := url.Values{}
 	q.Set("type", p.Type)
-	q.Set("security", "tls")
-	if p.TLS != nil {
-		q.Set("sni", p.TLS.SNI)
-		if p.TLS.Fingerprint != "" { q.Set("fp", p.TLS.Fingerprint) }
-		if len(p.TLS.ALPN) > 0 { q.Set("alpn", strings.Join(p.TLS.ALPN, ",")) }
+	if p.Method == "reality" && p.Reality != nil {
+		q.Set("security", "reality")
+		q.Set("pbk", p.Reality.PublicKey)
+		if p.Reality.ShortId != "" { q.Set("sid", p.Reality.ShortId) }
+		if p.Reality.Fingerprint != "" { q.Set("fp", p.Reality.Fingerprint) }
+		if p.Reality.ServerName != "" { q.Set("sni", p.Reality.ServerName) }
+	} else if p.Method == "none" {
+		q.Set("security", "none")
+	} else {
+		q.Set("security", "tls")
+		if p.TLS != nil {
+			q.Set("sni", p.TLS.SNI)
+			if p.TLS.Fingerprint != "" { q.Set("fp", p.TLS.Fingerprint) }
+			if len(p.TLS.ALPN) > 0 { q.Set("alpn", strings.Join(p.TLS.ALPN, ",")) }
+			if p.TLS.AllowInsecure { q.Set("allowInsecure", "1") }
+		}
 	}
 	switch p.Type {
 	case "ws":
@@ -473,11 +767,67 @@ func buildTrojan(cfg *config.Config, newIP, remark string) (string, error) {
 	case "grpc":
 		if p.Grpc != nil { q.Set("serviceName", p.Grpc.ServiceName) }
 	}
-	link := fmt.Sprintf("trojan://%s@%s:%d?%s", p.UUID, newIP, p.Port, q.Encode())
+	password := p.UUID
+	if p.Trojan != nil && p.Trojan.Password != "" { password = p.Trojan.Password }
+	link := fmt.Sprintf("trojan://%s@%s:%d?%s", url.User(password).String(), formatLinkHost(newIP), p.Port, q.Encode())
 	if remark != "" { link += "#" + url.PathEscape(remark) }
 	return link, nil
 }
 
+func buildShadowsocks(cfg *config.Config, newIP, remark string) (string, error) {
+	p := cfg.Proxy
+	if p.Shadowsocks == nil {
+		return "", fmt.Errorf("ss: shadowsocks settings missing")
+	}
+	userInfo := base64.RawURLEncoding.EncodeToString([]byte(p.Shadowsocks.Cipher + ":" + p.Shadowsocks.Password))
+	// SIP002: رمزهای 2022 نباید base64 بشن
+	if strings.HasPrefix(p.Shadowsocks.Cipher, "2022-") {
+		userInfo = url.UserPassword(p.Shadowsocks.Cipher, p.Shadowsocks.Password).String()
+	}
+	link := fmt.Sprintf("ss://%s@%s:%d", userInfo, formatLinkHost(newIP), p.Port)
+	if p.Shadowsocks.Plugin != "" {
+		plugin := p.Shadowsocks.Plugin
+		if p.Shadowsocks.PluginOpts != "" { plugin += ";" + p.Shadowsocks.PluginOpts }
+		link += "/?plugin=" + url.QueryEscape(plugin)
+	}
+	if remark != "" { link += "#" + url.PathEscape(remark) }
+	return link, nil
+}
+
+func buildHysteria2(cfg *config.Config, newIP, remark string) (string, error) {
+	p := cfg.Proxy
+	if p.Hysteria2 == nil {
+		return "", fmt.Errorf("hysteria2: settings missing")
+	}
+	q := url.Values{}
+	if p.TLS != nil {
+		if p.TLS.SNI != "" { q.Set("sni", p.TLS.SNI) }
+		if p.TLS.AllowInsecure { q.Set("insecure", "1") }
+		if len(p.TLS.ALPN) > 0 && strings.Join(p.TLS.ALPN, ",") != "h3" { q.Set("alpn", strings.Join(p.TLS.ALPN, ",")) }
+	}
+	if p.Hysteria2.Obfs != "" {
+		q.Set("obfs", p.Hysteria2.Obfs)
+		q.Set("obfs-password", p.Hysteria2.ObfsPassword)
+	}
+	if p.Hysteria2.Ports != "" { q.Set("mport", p.Hysteria2.Ports) }
+	if p.Hysteria2.PinSHA256 != "" { q.Set("pinSHA256", p.Hysteria2.PinSHA256) }
+	if p.Hysteria2.UpMbps > 0 { q.Set("upmbps", strconv.Itoa(p.Hysteria2.UpMbps)) }
+	if p.Hysteria2.DownMbps > 0 { q.Set("downmbps", strconv.Itoa(p.Hysteria2.DownMbps)) }
+
+	link := fmt.Sprintf("hysteria2://%s@%s:%d/", url.User(p.Hysteria2.Password).String(), formatLinkHost(newIP), p.Port)
+	if len(q) > 0 { link += "?" + q.Encode() }
+	if remark != "" { link += "#" + url.PathEscape(remark) }
+	return link, nil
+}
+
+// formatLinkHost آدرس IPv6 رو داخل [] می‌ذاره
+func formatLinkHost(host string) string {
+	if utils.IsIPv6(host) {
+		return "[" + utils.StripBrackets(host) + "]"
+	}
+	return host
+}
+
 // ─── MULTI IMPORT ────────────────────────────────────────────────────────────
 
 type ParsedProxy struct {
@@ -537,18 +887,43 @@ func buildClashProxy(cfg *config.Config, ip, name, _ string) string {
 	sb.WriteString(fmt.Sprintf("  - name: %s\n", name))
 
 	// protocol
-	if strings.HasPrefix(cfg.Proxy.UUID, "") {
-		switch {
-		case p.Method == "reality":
-			sb.WriteString("    type: vless\n")
-		default:
-			sb.WriteString("    type: vless\n")
-		}
+	proto := p.GetProtocol()
+	if proto == "shadowsocks" {
+		sb.WriteString("    type: ss\n")
+	} else {
+		sb.WriteString("    type: " + proto + "\n")
 	}
-
-	sb.WriteString(fmt.Sprintf("    server: %s\n", ip))
+	sb.WriteString(fmt.Sprintf("    server: %s\n", utils.StripBrackets(ip)))
 	sb.WriteString(fmt.Sprintf("    port: %d\n", p.Port))
-	sb.WriteString(fmt.Sprintf("    uuid: %s\n", p.UUID))
+
+	switch proto {
+	case "shadowsocks":
+		// ss و hysteria2 فیلدهای TLS/transport خودشون رو دارن
+		if p.Shadowsocks != nil {
+			sb.WriteString(buildClashShadowsocks(p.Shadowsocks))
+		}
+		return sb.String()
+	case "hysteria2":
+		if p.Hysteria2 != nil {
+			sb.WriteString(buildClashHysteria2(p))
+		}
+		return sb.String()
+	case "vmess":
+		aid, scy := 0, "auto"
+		if p.Vmess != nil {
+			aid = p.Vmess.AlterID
+			if p.Vmess.Security != "" { scy = p.Vmess.Security }
+		}
+		sb.WriteString(fmt.Sprintf("    uuid: %s\n", p.UUID))
+		sb.WriteString(fmt.Sprintf("    alterId: %d\n", aid))
+		sb.WriteString("    cipher: " + scy + "\n")
+	case "trojan":
+		password := p.UUID
+		if p.Trojan != nil && p.Trojan.Password != "" { password = p.Trojan.Password }
+		sb.WriteString(fmt.Sprintf("    password: %s\n", password))
+	default:
+		sb.WriteString(fmt.Sprintf("    uuid: %s\n", p.UUID))
+	}
 
 	if p.Method == "reality" && p.Reality != nil {
 		sb.WriteString("    tls: true\n    servername: " + p.Reality.ServerName + "\n")
@@ -591,6 +966,51 @@ func buildClashProxy(cfg *config.Config, ip, name, _ string) string {
 	return sb.String()
 }
 
+func buildClashShadowsocks(ss *config.ShadowsocksConfig) string {
+	var sb strings.Builder
+	sb.WriteString("    cipher: " + ss.Cipher + "\n")
+	sb.WriteString(fmt.Sprintf("    password: %q\n", ss.Password))
+	if ss.Plugin == "" {
+		return sb.String()
+	}
+	opts := parsePluginOpts(ss.PluginOpts)
+	switch ss.Plugin {
+	case "v2ray-plugin", "xray-plugin":
+		sb.WriteString("    plugin: v2ray-plugin\n    plugin-opts:\n")
+		sb.WriteString("      mode: " + firstNonEmpty(opts["mode"], "websocket") + "\n")
+		if _, tls := opts["tls"]; tls { sb.WriteString("      tls: true\n") }
+		if opts["host"] != "" { sb.WriteString("      host: " + opts["host"] + "\n") }
+		if opts["path"] != "" { sb.WriteString("      path: " + opts["path"] + "\n") }
+	case "obfs-local", "simple-obfs":
+		sb.WriteString("    plugin: obfs\n    plugin-opts:\n")
+		sb.WriteString("      mode: " + firstNonEmpty(opts["obfs"], "http") + "\n")
+		if opts["obfs-host"] != "" { sb.WriteString("      host: " + opts["obfs-host"] + "\n") }
+	default:
+		sb.WriteString("    plugin: " + ss.Plugin + "\n")
+	}
+	return sb.String()
+}
+
+func buildClashHysteria2(p config.ProxyConfig) string {
+	var sb strings.Builder
+	hy := p.Hysteria2
+	sb.WriteString(fmt.Sprintf("    password: %q\n", hy.Password))
+	if hy.Ports != "" { sb.WriteString("    ports: " + hy.Ports + "\n") }
+	if hy.Obfs != "" {
+		sb.WriteString("    obfs: " + hy.Obfs + "\n")
+		sb.WriteString(fmt.Sprintf("    obfs-password: %q\n", hy.ObfsPassword))
+	}
+	if hy.UpMbps > 0 { sb.WriteString(fmt.Sprintf("    up: \"%d Mbps\"\n", hy.UpMbps)) }
+	if hy.DownMbps > 0 { sb.WriteString(fmt.Sprintf("    down: \"%d Mbps\"\n", hy.DownMbps)) }
+	if p.TLS != nil {
+		if p.TLS.SNI != "" { sb.WriteString("    sni: " + p.TLS.SNI + "\n") }
+		if p.TLS.AllowInsecure { sb.WriteString("    skip-cert-verify: true\n") }
+		if len(p.TLS.ALPN) > 0 { sb.WriteString("    alpn: [" + strings.Join(p.TLS.ALPN, ", ") + "]\n") }
+	}
+	if hy.PinSHA256 != "" { sb.WriteString("    fingerprint: " + hy.PinSHA256 + "\n") }
+	return sb.String()
+}
+
 // BuildSingboxOutbounds خروجی Sing-box JSON
 func BuildSingboxOutbounds(cfg *config.Config, ips []string) string {
 	p := cfg.Proxy
@@ -603,10 +1023,47 @@ func BuildSingboxOutbounds(cfg *config.Config, ips []string) string {
 
 		ob := map[string]interface{}{
 			"tag":        tag,
-			"type":       "vless",
-			"server":     ip,
+			"type":       p.GetProtocol(),
+			"server":     utils.StripBrackets(ip),
 			"server_port": p.Port,
-			"uuid":       p.UUID,
+		}
+		switch p.GetProtocol() {
+		case "vmess":
+			ob["uuid"] = p.UUID
+			ob["security"] = "auto"
+			if p.Vmess != nil {
+				ob["alter_id"] = p.Vmess.AlterID
+				if p.Vmess.Security != "" { ob["security"] = p.Vmess.Security }
+			}
+		case "trojan":
+			ob["password"] = p.UUID
+			if p.Trojan != nil && p.Trojan.Password != "" { ob["password"] = p.Trojan.Password }
+		case "shadowsocks":
+			// TLS و ws برای ss از طریق plugin تنظیم میشه، نه فیلدهای tls/transport
+			if p.Shadowsocks != nil {
+				ob["method"] = p.Shadowsocks.Cipher
+				ob["password"] = p.Shadowsocks.Password
+				if p.Shadowsocks.Plugin != "" {
+					ob["plugin"] = p.Shadowsocks.Plugin
+					ob["plugin_opts"] = p.Shadowsocks.PluginOpts
+				}
+			}
+			outbounds = append(outbounds, ob)
+			continue
+		case "hysteria2":
+			if p.Hysteria2 != nil {
+				ob["password"] = p.Hysteria2.Password
+				if p.Hysteria2.Obfs != "" {
+					ob["obfs"] = map[string]string{"type": p.Hysteria2.Obfs, "password": p.Hysteria2.ObfsPassword}
+				}
+				if p.Hysteria2.Ports != "" {
+					ob["server_ports"] = []string{strings.ReplaceAll(p.Hysteria2.Ports, "-", ":")}
+				}
+				if p.Hysteria2.UpMbps > 0 { ob["up_mbps"] = p.Hysteria2.UpMbps }
+				if p.Hysteria2.DownMbps > 0 { ob["down_mbps"] = p.Hysteria2.DownMbps }
+			}
+		default:
+			ob["uuid"] = p.UUID
 		}
 
 		// TLS
@@ -627,7 +1084,11 @@ func BuildSingboxOutbounds(cfg *config.Config, ips []string) string {
 			if p.TLS.AllowInsecure { tlsObj["insecure"] = true }
 			if len(p.TLS.ALPN) > 0 { tlsObj["alpn"] = p.TLS.ALPN }
 		}
-		ob["tls"] = tlsObj
+		if p.Method != "none" { ob["tls"] = tlsObj }
+		if p.GetProtocol() == "hysteria2" {
+			outbounds = append(outbounds, ob)
+			continue
+		}
 
 		// Transport
 		switch p.Type {
@@ -0,0 +1,58 @@
+package webui
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// links of every protocol; the vmess one is base64 JSON with ps "vm node"
+var roundTripLinks = []string{
+	"vless://b831381d-6324-4d53-ad4f-8cda48b30811@example.com:443?type=ws&security=tls&sni=sni.example.com&host=h.example.com&path=%2Fws&fp=chrome#vless%20node",
+	"vless://b831381d-6324-4d53-ad4f-8cda48b30811@example.com:443?type=grpc&security=reality&pbk=pubkey&sid=ab12&sni=www.example.com&fp=firefox&serviceName=svc#reality",
+	"vless://b831381d-6324-4d53-ad4f-8cda48b30811@[2606:4700::1]:8443?type=xhttp&security=tls&sni=sni.example.com&host=h.example.com&path=%2Fx&mode=auto#v6",
+	"vmess://eyJ2IjoiMiIsInBzIjoidm0gbm9kZSIsImFkZCI6ImV4YW1wbGUuY29tIiwicG9ydCI6IjQ0MyIsImlkIjoiYjgzMTM4MWQtNjMyNC00ZDUzLWFkNGYtOGNkYTQ4YjMwODExIiwiYWlkIjoiMCIsInNjeSI6ImFlcy0xMjgtZ2NtIiwibmV0Ijoid3MiLCJ0eXBlIjoibm9uZSIsImhvc3QiOiJoLmV4YW1wbGUuY29tIiwicGF0aCI6Ii92bSIsInRscyI6InRscyIsInNuaSI6InNuaS5leGFtcGxlLmNvbSIsImZwIjoiY2hyb21lIn0=",
+	"trojan://p%40ss@example.com:443?type=ws&security=tls&sni=sni.example.com&host=h.example.com&path=%2Ft#trojan",
+	"ss://YWVzLTI1Ni1nY206czNjcmV0@example.com:8388#ss%20node",
+	"ss://YWVzLTI1Ni1nY206czNjcmV0@example.com:443?plugin=v2ray-plugin%3Bmode%3Dwebsocket%3Bhost%3Dh.example.com%3Bpath%3D%2Fss%3Btls#ss-ws",
+	"hysteria2://pw@example.com:443/?sni=sni.example.com&obfs=salamander&obfs-password=ob&insecure=1#hy2",
+	"hy2://pw@example.com:443,20000-30000/?sni=sni.example.com#hop",
+}
+
+func TestProxyLinkRoundTrip(t *testing.T) {
+	const ip = "104.16.1.2"
+	for _, link := range roundTripLinks {
+		orig, err := ParseProxyURL(link)
+		if err != nil {
+			t.Errorf("parse %s: %v", link, err)
+			continue
+		}
+		// the health monitor and fragment optimizer refuse configs without one
+		if err := orig.Proxy.CheckCredentials(); err != nil {
+			t.Errorf("%s: %v", link, err)
+		}
+		built, err := BuildProxyURL(orig, ip, link)
+		if err != nil {
+			t.Errorf("build %s: %v", link, err)
+			continue
+		}
+		again, err := ParseProxyURL(built)
+		if err != nil {
+			t.Errorf("parse rebuilt %s: %v", built, err)
+			continue
+		}
+
+		want := orig.Proxy
+		want.Address = ip
+		if !reflect.DeepEqual(again.Proxy, want) {
+			got, _ := json.Marshal(again.Proxy)
+			exp, _ := json.Marshal(want)
+			t.Errorf("%s\nrebuilt as %s\n got %s\nwant %s", link, built, got, exp)
+		}
+
+		_, remark := detectProtoAndRemark(link)
+		if _, rebuilt := detectProtoAndRemark(built); remark == "" || rebuilt != remark {
+			t.Errorf("%s: remark %q became %q", link, remark, rebuilt)
+		}
+	}
+}
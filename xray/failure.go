@@ -0,0 +1,106 @@
+package xray
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// Failure classifies why a test failed.
+//
+// Through xray the scanner only sees the loopback SOCKS connection: an edge
+// RST, an edge TLS alert and an SNI block all arrive there as EOF or reset,
+// and tls/cert describe the origin behind the tunnel. Only the prefilter's
+// direct dial tells edge refusals and TLS/SNI failures apart.
+type Failure string
+
+const (
+	FailTimeout Failure = "timeout" // nothing answered in time
+	FailRefused Failure = "refused" // the edge refused the connect (direct dial or SOCKS reply)
+	FailReset   Failure = "reset"   // reset or closed mid-exchange: DPI, or any edge failure seen through xray
+	FailTLS     Failure = "tls"     // TLS handshake failed or got an alert; the origin's when through xray
+	FailCert    Failure = "cert"    // certificate does not match the SNI or is not trusted
+	FailAuth    Failure = "auth"    // the proxy rejected us: SOCKS refusal, HTTP 401/407
+	FailHTTP    Failure = "http"    // the origin answered with an error status
+	FailXray    Failure = "xray"    // xray failed to start, get ready or accept on its SOCKS port
+	FailLimit   Failure = "limit"   // worked but over a configured limit (maxLatency, filters)
+	FailOther   Failure = "other"
+)
+
+// Failures lists every class in the order summaries print them
+var Failures = []Failure{FailTimeout, FailRefused, FailReset, FailTLS, FailCert, FailAuth, FailHTTP, FailXray, FailLimit, FailOther}
+
+// ClassifyError maps a dial, TLS or HTTP client error to its Failure class
+func ClassifyError(err error) Failure {
+	if err == nil {
+		return ""
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var hostErr x509.HostnameError
+	var authErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &certErr) || errors.As(err, &hostErr) || errors.As(err, &authErr) || errors.As(err, &invalidErr) {
+		return FailCert
+	}
+
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FailRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return FailReset
+	}
+
+	var alert tls.AlertError
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &alert) || errors.As(err, &recordErr) {
+		return FailTLS
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return FailTimeout
+	}
+
+	// errors passed on as text, such as a scan's "xray not ready: ..." or a
+	// SOCKS reply from x/net ("unknown error connection refused")
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "xray not ready") || strings.Contains(msg, "failed to start xray"):
+		return FailXray
+	case strings.Contains(msg, "connection refused"):
+		return FailRefused
+	case strings.Contains(msg, "socks") && (strings.Contains(msg, "not allowed") || strings.Contains(msg, "auth")):
+		return FailAuth
+	case strings.Contains(msg, "tls:") || strings.Contains(msg, "handshake"):
+		return FailTLS
+	case strings.Contains(msg, "connection reset") || strings.Contains(msg, "forcibly closed"):
+		return FailReset
+	}
+	return FailOther
+}
+
+// ClassifyTunnelError is ClassifyError for requests through xray's SOCKS
+// port. The only TCP connect there is to the local port, so a refused
+// connect means xray is gone; an edge refusal comes back as a SOCKS reply,
+// which ClassifyError reads as refused.
+func ClassifyTunnelError(err error) Failure {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return FailXray
+	}
+	return ClassifyError(err)
+}
+
+// statusFailure classifies an HTTP status that does not count as success
+func statusFailure(code int) Failure {
+	if code == 401 || code == 407 {
+		return FailAuth
+	}
+	return FailHTTP
+}
@@ -0,0 +1,51 @@
+package xray
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	dial := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: err}}
+	}
+	get := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://www.gstatic.com/generate_204", Err: err}
+	}
+	tests := []struct {
+		name   string
+		err    error
+		direct Failure // ClassifyError
+		tunnel Failure // ClassifyTunnelError
+	}{
+		{"nil", nil, "", ""},
+		{"context deadline", get(context.DeadlineExceeded), FailTimeout, FailTimeout},
+		{"i/o timeout", &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, FailTimeout, FailTimeout},
+		{"econnrefused", dial(syscall.ECONNREFUSED), FailRefused, FailXray},
+		{"econnreset", get(&net.OpError{Op: "read", Net: "tcp", Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}}), FailReset, FailReset},
+		{"tls alert", get(&net.OpError{Op: "remote error", Err: tls.AlertError(40)}), FailTLS, FailTLS},
+		{"hostname mismatch", get(&tls.CertificateVerificationError{
+			Err: x509.HostnameError{Certificate: &x509.Certificate{DNSNames: []string{"example.com"}}, Host: "104.16.1.2"},
+		}), FailCert, FailCert},
+		{"socks refused", get(errors.New("socks connect tcp 127.0.0.1:10808->www.gstatic.com:443: unknown error connection refused")), FailRefused, FailRefused},
+		{"socks ruleset", get(errors.New("socks connect tcp 127.0.0.1:10808->www.gstatic.com:443: connection not allowed by ruleset")), FailAuth, FailAuth},
+		{"xray not ready", fmt.Errorf("%w: %v", errors.New("xray not ready"), errors.New("xray did not become ready within 10s")), FailXray, FailXray},
+		{"unknown", errors.New("something else"), FailOther, FailOther},
+	}
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.direct {
+			t.Errorf("%s: ClassifyError = %q, want %q", tt.name, got, tt.direct)
+		}
+		if got := ClassifyTunnelError(tt.err); got != tt.tunnel {
+			t.Errorf("%s: ClassifyTunnelError = %q, want %q", tt.name, got, tt.tunnel)
+		}
+	}
+}
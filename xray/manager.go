@@ -2,16 +2,23 @@ package xray
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"piyazche/utils"
 
 	// Core xray imports
+	"github.com/xtls/xray-core/app/observatory"
+	"github.com/xtls/xray-core/common"
 	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/extension"
+	"github.com/xtls/xray-core/features/outbound"
+	"github.com/xtls/xray-core/infra/conf"
 	"github.com/xtls/xray-core/infra/conf/serial"
 
 	// Required handlers - register in init functions
@@ -37,7 +44,10 @@ import (
 	_ "github.com/xtls/xray-core/proxy/freedom"
 	_ "github.com/xtls/xray-core/proxy/http"
 	_ "github.com/xtls/xray-core/proxy/loopback"
+	_ "github.com/xtls/xray-core/proxy/shadowsocks"
+	_ "github.com/xtls/xray-core/proxy/shadowsocks_2022"
 	_ "github.com/xtls/xray-core/proxy/socks"
+	_ "github.com/xtls/xray-core/proxy/trojan"
 	_ "github.com/xtls/xray-core/proxy/vless/inbound"
 	_ "github.com/xtls/xray-core/proxy/vless/outbound"
 	_ "github.com/xtls/xray-core/proxy/vmess/inbound"
@@ -62,6 +72,17 @@ import (
 	_ "github.com/xtls/xray-core/main/json"
 )
 
+var (
+	starts        atomic.Int64
+	startFailures atomic.Int64
+)
+
+// StartStats returns how many xray instances were started and how many of
+// those starts failed, since the process began
+func StartStats() (total, failed int64) {
+	return starts.Load(), startFailures.Load()
+}
+
 // Manager handles xray instance lifecycle using embedded xray-core
 type Manager struct {
 	instance  *core.Instance
@@ -69,6 +90,8 @@ type Manager struct {
 	mu        sync.Mutex
 	running   bool
 	debug     bool
+
+	batchPorts map[string]int // target IP -> SOCKS port, set by StartBatch
 }
 
 // NewManager creates a new xray manager
@@ -87,6 +110,15 @@ func NewManagerWithDebug(debug bool) *Manager {
 
 // Start starts the xray instance with the given configuration
 func (m *Manager) Start(configData []byte, socksPort int) error {
+	err := m.start(configData, socksPort)
+	starts.Add(1)
+	if err != nil {
+		startFailures.Add(1)
+	}
+	return err
+}
+
+func (m *Manager) start(configData []byte, socksPort int) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -141,6 +173,109 @@ func (m *Manager) Start(configData []byte, socksPort int) error {
 	return nil
 }
 
+// StartBatch starts one instance serving several targets, each behind its
+// own SOCKS port; ports maps every target IP to the port the config gives it
+// (see config.GenerateBatchXrayConfig)
+func (m *Manager) StartBatch(configData []byte, ports map[string]int) error {
+	if len(ports) == 0 {
+		return fmt.Errorf("empty batch")
+	}
+	first := 0
+	for _, port := range ports {
+		if first == 0 || port < first {
+			first = port
+		}
+	}
+	if err := m.Start(configData, first); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batchPorts = make(map[string]int, len(ports))
+	for ip, port := range ports {
+		m.batchPorts[ip] = port
+	}
+	return nil
+}
+
+// TargetPort returns the SOCKS port that reaches ip in a batch instance
+func (m *Manager) TargetPort(ip string) (int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	port, ok := m.batchPorts[ip]
+	return port, ok
+}
+
+// SetOutbound replaces the outbound with the same tag on the running
+// instance, so it can be pointed at another server without a restart
+func (m *Manager) SetOutbound(outboundJSON []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.running {
+		return fmt.Errorf("xray is not running")
+	}
+
+	var detour conf.OutboundDetourConfig
+	if err := json.Unmarshal(outboundJSON, &detour); err != nil {
+		return fmt.Errorf("failed to decode outbound: %w", err)
+	}
+	handlerConfig, err := detour.Build()
+	if err != nil {
+		return fmt.Errorf("failed to build outbound: %w", err)
+	}
+
+	ohm := m.instance.GetFeature(outbound.ManagerType()).(outbound.Manager)
+	if old := ohm.GetHandler(detour.Tag); old != nil {
+		if err := ohm.RemoveHandler(context.Background(), detour.Tag); err != nil {
+			return fmt.Errorf("failed to remove outbound: %w", err)
+		}
+		// RemoveHandler only unregisters it; this tears down its mux sessions
+		common.Close(old)
+	}
+	if err := core.AddOutboundHandler(m.instance, handlerConfig); err != nil {
+		return fmt.Errorf("failed to add outbound: %w", err)
+	}
+	return nil
+}
+
+// OutboundStatus is the observatory's last probe of one outbound
+type OutboundStatus struct {
+	Tag     string
+	Alive   bool
+	DelayMs int64
+	Error   string
+}
+
+// Observation returns the latest probe results of a config with an
+// observatory, such as config.GenerateGatewayXrayConfig
+func (m *Manager) Observation() ([]OutboundStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.running {
+		return nil, fmt.Errorf("xray is not running")
+	}
+	obs, ok := m.instance.GetFeature(extension.ObservatoryType()).(extension.Observatory)
+	if !ok {
+		return nil, fmt.Errorf("no observatory in this config")
+	}
+	msg, err := obs.GetObservation(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	result, ok := msg.(*observatory.ObservationResult)
+	if !ok {
+		return nil, fmt.Errorf("unexpected observation %T", msg)
+	}
+	out := make([]OutboundStatus, 0, len(result.Status))
+	for _, st := range result.Status {
+		out = append(out, OutboundStatus{Tag: st.OutboundTag, Alive: st.Alive, DelayMs: st.Delay, Error: st.LastErrorReason})
+	}
+	return out, nil
+}
+
 // Stop stops the xray instance
 func (m *Manager) Stop() error {
 	m.mu.Lock()
@@ -158,6 +293,7 @@ func (m *Manager) Stop() error {
 	}
 
 	m.running = false
+	m.batchPorts = nil
 
 	return nil
 }
@@ -179,10 +315,21 @@ func (m *Manager) WaitForReady(timeout time.Duration) error {
 	return m.WaitForReadyWithContext(nil, timeout)
 }
 
-// WaitForReadyWithContext waits for xray to be ready with context support for cancellation
+// WaitForReadyWithContext waits for xray to be ready with context support for cancellation.
+// A batch instance is ready once every one of its SOCKS ports accepts connections.
 func (m *Manager) WaitForReadyWithContext(ctx interface{ Done() <-chan struct{} }, timeout time.Duration) error {
 	deadline := time.Now().Add(timeout)
 
+	m.mu.Lock()
+	pending := []int{m.socksPort}
+	if len(m.batchPorts) > 0 {
+		pending = pending[:0]
+		for _, port := range m.batchPorts {
+			pending = append(pending, port)
+		}
+	}
+	m.mu.Unlock()
+
 	for time.Now().Before(deadline) {
 		if ctx != nil {
 			select {
@@ -196,8 +343,11 @@ func (m *Manager) WaitForReadyWithContext(ctx interface{ Done() <-chan struct{}
 			return fmt.Errorf("xray instance terminated unexpectedly")
 		}
 
-		// Poll until the SOCKS proxy port accepts connections
-		if IsPortOpen("127.0.0.1", m.socksPort) {
+		// Poll until the SOCKS proxy ports accept connections
+		for len(pending) > 0 && IsPortOpen("127.0.0.1", pending[0]) {
+			pending = pending[1:]
+		}
+		if len(pending) == 0 {
 			// Brief delay for xray to complete initialization after binding
 			time.Sleep(30 * time.Millisecond)
 			return nil
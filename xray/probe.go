@@ -0,0 +1,479 @@
+package xray
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/proxy"
+)
+
+// Probe is one check run through the SOCKS port of an xray instance
+type Probe interface {
+	// Name describes the probe in results, e.g. "ws wss://echo.example.com"
+	Name() string
+	Run(ctx context.Context, socksPort int, timeout time.Duration) *TestResult
+}
+
+// ProbeOutcome is how one probe of a plan went
+type ProbeOutcome struct {
+	Probe     string  `json:"probe"`
+	Success   bool    `json:"success"`
+	LatencyMs int64   `json:"latency_ms,omitempty"`
+	Bytes     int64   `json:"bytes,omitempty"`
+	Failure   Failure `json:"failure,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ProbePlan runs its probes in order and passes when every one of them does
+type ProbePlan []Probe
+
+// Run runs the plan until a probe fails. The result is the first probe's,
+// which gives the IP its latency, or the failed probe's; outcomes has one
+// entry per probe that ran.
+func (p ProbePlan) Run(ctx context.Context, socksPort int, timeout time.Duration) (*TestResult, []ProbeOutcome) {
+	var first *TestResult
+	outcomes := make([]ProbeOutcome, 0, len(p))
+	for _, probe := range p {
+		r := probe.Run(ctx, socksPort, timeout)
+		o := ProbeOutcome{Probe: probe.Name(), Success: r.Success, LatencyMs: r.Latency.Milliseconds(), Bytes: r.BytesRead, Failure: r.Failure}
+		if r.Error != nil {
+			o.Error = r.Error.Error()
+		}
+		outcomes = append(outcomes, o)
+		if !r.Success {
+			if len(p) > 1 {
+				r.Error = fmt.Errorf("%s: %w", probe.Name(), r.Error)
+			}
+			return r, outcomes
+		}
+		if first == nil {
+			first = r
+		}
+	}
+	if first == nil {
+		return &TestResult{Error: errors.New("empty probe plan"), Failure: FailOther}, outcomes
+	}
+	return first, outcomes
+}
+
+// classify fills in result.Failure from result.Error when a probe failed
+func classify(result *TestResult) {
+	if !result.Success && result.Failure == "" {
+		result.Failure = ClassifyTunnelError(result.Error)
+	}
+}
+
+// requestError prefers the context's error, which says why a request was cut
+func requestError(ctx context.Context, what string, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("%s: %w", what, err)
+}
+
+// socksDialer dials through the SOCKS inbound on socksPort
+func socksDialer(socksPort int) (proxy.ContextDialer, error) {
+	d, err := proxy.SOCKS5("tcp", fmt.Sprintf("127.0.0.1:%d", socksPort), nil, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SOCKS5 dialer: %w", err)
+	}
+	cd, ok := d.(proxy.ContextDialer)
+	if !ok {
+		return nil, errors.New("SOCKS5 dialer does not support contexts")
+	}
+	return cd, nil
+}
+
+// HTTPProbe requests URL and expects Status (any 2xx/3xx when 0) and, when
+// BodySHA256 is set, a body with that hex SHA-256
+type HTTPProbe struct {
+	URL        string
+	Method     string // GET (default) or HEAD
+	Status     int
+	BodySHA256 string
+}
+
+func (p HTTPProbe) Name() string {
+	if p.Method != "" && p.Method != http.MethodGet {
+		return strings.ToLower(p.Method) + " " + p.URL
+	}
+	return "http " + p.URL
+}
+
+func (p HTTPProbe) Run(ctx context.Context, socksPort int, timeout time.Duration) *TestResult {
+	result := &TestResult{}
+	defer classify(result)
+
+	parsedURL, err := url.Parse(p.URL)
+	if err != nil {
+		result.Error = fmt.Errorf("invalid test URL: %w", err)
+		return result
+	}
+
+	client, err := makeSOCKSClient(socksPort, parsedURL.Hostname(), timeout, false)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	method := p.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	start := time.Now()
+	req, err := http.NewRequestWithContext(traceTimings(ctx, start, result), method, p.URL, nil)
+	if err != nil {
+		result.Error = fmt.Errorf("failed to create request: %w", err)
+		return result
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = requestError(ctx, "request failed", err)
+		return result
+	}
+	defer resp.Body.Close()
+
+	// the hash needs the whole body, a plain check only the first bytes
+	limit := int64(10 * 1024)
+	if p.BodySHA256 != "" {
+		limit = 8 << 20
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	if err != nil {
+		result.Error = requestError(ctx, "failed to read response", err)
+		return result
+	}
+
+	result.Latency = time.Since(start)
+	result.StatusCode = resp.StatusCode
+	result.BytesRead = int64(len(body))
+
+	if p.Status != 0 {
+		result.Success = resp.StatusCode == p.Status
+	} else {
+		result.Success = resp.StatusCode >= 200 && resp.StatusCode < 400
+	}
+	if !result.Success {
+		result.Failure = statusFailure(resp.StatusCode)
+		result.Error = fmt.Errorf("HTTP %d", resp.StatusCode)
+		return result
+	}
+
+	if p.BodySHA256 != "" {
+		sum := sha256.Sum256(body)
+		if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, p.BodySHA256) {
+			// a block page or a tampering middlebox answered instead of the origin
+			result.Success, result.Failure = false, FailHTTP
+			result.Error = fmt.Errorf("body sha256 %s, want %s", got[:12], strings.ToLower(p.BodySHA256))
+		}
+	}
+	return result
+}
+
+// WebSocketProbe opens a ws:// or wss:// URL, sends Message and expects it
+// back unchanged
+type WebSocketProbe struct {
+	URL     string
+	Message string // default "piyazche"
+}
+
+func (p WebSocketProbe) Name() string { return "ws " + p.URL }
+
+func (p WebSocketProbe) Run(ctx context.Context, socksPort int, timeout time.Duration) *TestResult {
+	result := &TestResult{}
+	defer classify(result)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	parsedURL, err := url.Parse(p.URL)
+	if err != nil {
+		result.Error = fmt.Errorf("invalid websocket URL: %w", err)
+		return result
+	}
+	dialer, err := socksDialer(socksPort)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	start := time.Now()
+	d := websocket.Dialer{
+		NetDialContext:   dialer.DialContext,
+		TLSClientConfig:  &tls.Config{ServerName: parsedURL.Hostname()},
+		HandshakeTimeout: timeout,
+	}
+	conn, resp, err := d.DialContext(ctx, p.URL, nil)
+	if err != nil {
+		if resp != nil {
+			result.StatusCode = resp.StatusCode
+			result.Failure = statusFailure(resp.StatusCode)
+		}
+		result.Error = requestError(ctx, "websocket handshake failed", err)
+		return result
+	}
+	defer conn.Close()
+	result.Tunnel = time.Since(start)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+		conn.SetWriteDeadline(deadline)
+	}
+
+	msg := p.Message
+	if msg == "" {
+		msg = "piyazche"
+	}
+	sent := time.Now()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		result.Error = requestError(ctx, "websocket write failed", err)
+		return result
+	}
+	_, echo, err := conn.ReadMessage()
+	if err != nil {
+		result.Error = requestError(ctx, "websocket read failed", err)
+		return result
+	}
+	result.TTFB = time.Since(sent)
+	result.Latency = time.Since(start)
+	result.BytesRead = int64(len(echo))
+	if string(echo) != msg {
+		result.Failure = FailOther
+		result.Error = fmt.Errorf("websocket echo mismatch: got %d bytes", len(echo))
+		return result
+	}
+	result.Success = true
+	return result
+}
+
+// DoHProbe resolves Domain's A record with a DNS-over-HTTPS (RFC 8484) GET
+// to URL and expects at least one answer
+type DoHProbe struct {
+	URL    string // e.g. https://cloudflare-dns.com/dns-query
+	Domain string // default "www.google.com"
+}
+
+func (p DoHProbe) Name() string { return "doh " + p.URL }
+
+func (p DoHProbe) Run(ctx context.Context, socksPort int, timeout time.Duration) *TestResult {
+	result := &TestResult{}
+	defer classify(result)
+
+	domain := p.Domain
+	if domain == "" {
+		domain = "www.google.com"
+	}
+	name, err := dnsmessage.NewName(strings.TrimSuffix(domain, ".") + ".")
+	if err != nil {
+		result.Error = fmt.Errorf("invalid domain: %w", err)
+		return result
+	}
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		result.Error = fmt.Errorf("failed to build query: %w", err)
+		return result
+	}
+
+	parsedURL, err := url.Parse(p.URL)
+	if err != nil {
+		result.Error = fmt.Errorf("invalid DoH URL: %w", err)
+		return result
+	}
+	q := parsedURL.Query()
+	q.Set("dns", base64.RawURLEncoding.EncodeToString(query))
+	parsedURL.RawQuery = q.Encode()
+
+	client, err := makeSOCKSClient(socksPort, parsedURL.Hostname(), timeout, false)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	start := time.Now()
+	req, err := http.NewRequestWithContext(traceTimings(ctx, start, result), http.MethodGet, parsedURL.String(), nil)
+	if err != nil {
+		result.Error = fmt.Errorf("failed to create request: %w", err)
+		return result
+	}
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = requestError(ctx, "request failed", err)
+		return result
+	}
+	defer resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode != http.StatusOK {
+		result.Failure = statusFailure(resp.StatusCode)
+		result.Error = fmt.Errorf("HTTP %d", resp.StatusCode)
+		return result
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		result.Error = requestError(ctx, "failed to read response", err)
+		return result
+	}
+	result.Latency = time.Since(start)
+	result.BytesRead = int64(len(body))
+
+	var answer dnsmessage.Message
+	if err := answer.Unpack(body); err != nil {
+		result.Failure = FailOther
+		result.Error = fmt.Errorf("bad DNS answer: %w", err)
+		return result
+	}
+	if answer.RCode != dnsmessage.RCodeSuccess || len(answer.Answers) == 0 {
+		result.Failure = FailOther
+		result.Error = fmt.Errorf("no answer for %s (%s)", domain, answer.RCode)
+		return result
+	}
+	result.Success = true
+	return result
+}
+
+// DownloadProbe reads Bytes of URL's body, to catch paths that work for small
+// responses but stall or get cut after some KB
+type DownloadProbe struct {
+	URL   string
+	Bytes int64 // default 1 MB; a shorter body that ends cleanly also passes
+}
+
+func (p DownloadProbe) Name() string { return "download " + p.URL }
+
+func (p DownloadProbe) Run(ctx context.Context, socksPort int, timeout time.Duration) *TestResult {
+	result := &TestResult{}
+	defer classify(result)
+
+	want := p.Bytes
+	if want <= 0 {
+		want = 1 << 20
+	}
+	parsedURL, err := url.Parse(p.URL)
+	if err != nil {
+		result.Error = fmt.Errorf("invalid download URL: %w", err)
+		return result
+	}
+	client, err := makeSOCKSClient(socksPort, parsedURL.Hostname(), timeout, false)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(traceTimings(ctx, start, result), http.MethodGet, p.URL, nil)
+	if err != nil {
+		result.Error = fmt.Errorf("failed to create request: %w", err)
+		return result
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = requestError(ctx, "request failed", err)
+		return result
+	}
+	defer resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result.Failure = statusFailure(resp.StatusCode)
+		result.Error = fmt.Errorf("HTTP %d", resp.StatusCode)
+		return result
+	}
+
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, want))
+	result.BytesRead = n
+	result.Latency = time.Since(start)
+	if err != nil {
+		result.Error = requestError(ctx, fmt.Sprintf("stalled after %d KB", n/1024), err)
+		return result
+	}
+	result.Success = true
+	return result
+}
+
+// TCPProbe connects to Addr (host:port) through the tunnel, writes Send and
+// waits until the reply contains Expect. xray accepts the SOCKS connect
+// before it reaches Addr, so without Send or Expect only the tunnel is checked.
+type TCPProbe struct {
+	Addr   string
+	Send   string
+	Expect string
+}
+
+func (p TCPProbe) Name() string { return "tcp " + p.Addr }
+
+func (p TCPProbe) Run(ctx context.Context, socksPort int, timeout time.Duration) *TestResult {
+	result := &TestResult{}
+	defer classify(result)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	dialer, err := socksDialer(socksPort)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		result.Error = requestError(ctx, "connect failed", err)
+		return result
+	}
+	defer conn.Close()
+	result.Tunnel = time.Since(start)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if p.Send != "" {
+		if _, err := io.WriteString(conn, p.Send); err != nil {
+			result.Error = requestError(ctx, "write failed", err)
+			return result
+		}
+	}
+	if p.Expect != "" || p.Send != "" {
+		sent := time.Now()
+		var reply []byte
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 && result.TTFB == 0 {
+				result.TTFB = time.Since(sent)
+			}
+			reply = append(reply, buf[:n]...)
+			if bytes.Contains(reply, []byte(p.Expect)) && (p.Expect != "" || len(reply) > 0) {
+				break
+			}
+			if err != nil {
+				result.BytesRead = int64(len(reply))
+				result.Error = requestError(ctx, fmt.Sprintf("no %q in reply", p.Expect), err)
+				return result
+			}
+			if len(reply) > 64*1024 {
+				result.BytesRead = int64(len(reply))
+				result.Failure = FailOther
+				result.Error = fmt.Errorf("no %q in the first %d bytes", p.Expect, len(reply))
+				return result
+			}
+		}
+		result.BytesRead = int64(len(reply))
+	}
+	result.Latency = time.Since(start)
+	result.Success = true
+	return result
+}
@@ -8,7 +8,9 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"strconv"
 	"time"
 
 	"golang.org/x/net/proxy"
@@ -22,6 +24,33 @@ type TestResult struct {
 	Error      error
 	StatusCode int
 	BytesRead  int64
+	Failure    Failure // why it failed, empty on success
+
+	// Phases of Latency, from httptrace. Tunnel is everything up to a usable
+	// connection to the test URL's host: the local SOCKS handshake, xray's
+	// connect and TLS to the edge, the proxy handshake and the TLS with the
+	// origin all happen inside it and can't be told apart from here. TTFB is
+	// the wait from the written request to the first response byte
+	Tunnel time.Duration
+	TTFB   time.Duration
+}
+
+// traceTimings records the phase timestamps of one request into result
+func traceTimings(ctx context.Context, start time.Time, result *TestResult) context.Context {
+	var wrote time.Time
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			result.Tunnel = time.Since(start)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			wrote = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			if !wrote.IsZero() {
+				result.TTFB = time.Since(wrote)
+			}
+		},
+	})
 }
 
 // makeSOCKSClient یه http.Client با SOCKS5 proxy می‌سازه
@@ -63,53 +92,7 @@ func TestConnectivity(socksPort int, testURL string, timeout time.Duration) *Tes
 
 // TestConnectivityWithContext tests connectivity with context support for cancellation
 func TestConnectivityWithContext(ctx context.Context, socksPort int, testURL string, timeout time.Duration) *TestResult {
-	result := &TestResult{}
-
-	parsedURL, err := url.Parse(testURL)
-	if err != nil {
-		result.Error = fmt.Errorf("invalid test URL: %w", err)
-		return result
-	}
-
-	client, err := makeSOCKSClient(socksPort, parsedURL.Hostname(), timeout, false)
-	if err != nil {
-		result.Error = err
-		return result
-	}
-
-	start := time.Now()
-	req, err := http.NewRequestWithContext(ctx, "GET", testURL, nil)
-	if err != nil {
-		result.Error = fmt.Errorf("failed to create request: %w", err)
-		return result
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		if ctx.Err() != nil {
-			result.Error = ctx.Err()
-		} else {
-			result.Error = fmt.Errorf("request failed: %w", err)
-		}
-		return result
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(io.LimitReader(resp.Body, 10*1024))
-	if err != nil {
-		if ctx.Err() != nil {
-			result.Error = ctx.Err()
-		} else {
-			result.Error = fmt.Errorf("failed to read response: %w", err)
-		}
-		return result
-	}
-
-	result.Latency = time.Since(start)
-	result.StatusCode = resp.StatusCode
-	result.BytesRead = int64(len(body))
-	result.Success = resp.StatusCode >= 200 && resp.StatusCode < 400
-	return result
+	return HTTPProbe{URL: testURL}.Run(ctx, socksPort, timeout)
 }
 
 // TestSpeed performs a speed test by downloading a file
@@ -425,7 +408,7 @@ func EstimateBandwidth(ctx context.Context, socksPort int, testURL string, timeo
 
 // IsPortOpen checks if a TCP port is accepting connections
 func IsPortOpen(host string, port int) bool {
-	addr := fmt.Sprintf("%s:%d", host, port)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
 	conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
 	if err != nil {
 		return false
@@ -0,0 +1,170 @@
+package xray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// SustainedResult is a throughput curve of one long download. Throttling
+// shows up as a tail much slower than the head, as buckets with no data
+// (stalls) or as a reset after the first seconds went fine.
+type SustainedResult struct {
+	Curve     []float64 // Mbps per bucket, from the first body byte on
+	Bucket    time.Duration
+	Bytes     int64
+	AvgMbps   float64
+	HeadMbps  float64 // mean of the first third of the curve
+	TailMbps  float64 // mean of the last third of the curve
+	DropPct   float64 // how far the tail fell below the head, 0 if it did not
+	Stalls    int     // buckets in which nothing arrived
+	Reset     bool    // the transfer broke before the window ended
+	Responded bool    // a 2xx response came back, even if no body byte did
+	Failure   Failure // class of Error
+	Error     error   // set when the transfer failed or broke
+}
+
+// TestSustainedDownload downloads downloadURL for window and samples the
+// bytes read every bucket. downloadURL must be large enough to last the
+// window; a body that ends early just gives a shorter curve.
+func TestSustainedDownload(ctx context.Context, socksPort int, downloadURL string, window, bucket time.Duration) *SustainedResult {
+	result := &SustainedResult{Bucket: bucket}
+	defer func() {
+		if result.Error != nil && result.Failure == "" {
+			result.Failure = ClassifyTunnelError(result.Error)
+		}
+	}()
+
+	parsedURL, err := url.Parse(downloadURL)
+	if err != nil {
+		result.Error = fmt.Errorf("invalid URL: %w", err)
+		return result
+	}
+	// the client timeout would cut the body, so the window bounds it instead
+	client, err := makeSOCKSClient(socksPort, parsedURL.Hostname(), 0, false)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	headerTimer := time.AfterFunc(10*time.Second, cancel)
+	resp, err := client.Do(req)
+	if !headerTimer.Stop() {
+		result.Error = fmt.Errorf("no response after 10s: %w", context.DeadlineExceeded)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return result
+	}
+	if err != nil {
+		result.Error = requestError(ctx, "request failed", err)
+		return result
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result.Failure = statusFailure(resp.StatusCode)
+		result.Error = fmt.Errorf("HTTP %d", resp.StatusCode)
+		return result
+	}
+	result.Responded = true
+
+	// the body is read in the background so a stalled read still ends buckets
+	var read atomic.Int64
+	readDone := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := resp.Body.Read(buf)
+			read.Add(int64(n))
+			if err != nil {
+				readDone <- err
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(bucket)
+	defer ticker.Stop()
+	start := time.Now()
+	deadline := time.After(window)
+	var last int64
+	addBucket := func(elapsed time.Duration) {
+		n := read.Load()
+		delta := n - last
+		last = n
+		if delta == 0 {
+			result.Stalls++
+		}
+		result.Curve = append(result.Curve, float64(delta)/1024/1024*8/elapsed.Seconds())
+	}
+
+	lastTick := start
+loop:
+	for {
+		select {
+		case now := <-ticker.C:
+			addBucket(now.Sub(lastTick))
+			lastTick = now
+		case err := <-readDone:
+			// a partial bucket is only worth keeping if it is not tiny
+			if elapsed := time.Since(lastTick); elapsed > bucket/4 {
+				addBucket(elapsed)
+			}
+			switch {
+			case errors.Is(err, io.EOF):
+			case ctx.Err() != nil:
+				result.Error = ctx.Err()
+			default:
+				result.Reset = true
+				result.Error = fmt.Errorf("broke after %d KB: %w", read.Load()/1024, err)
+			}
+			break loop
+		case <-deadline:
+			break loop
+		case <-ctx.Done():
+			result.Error = ctx.Err()
+			break loop
+		}
+	}
+	cancel()
+
+	result.Bytes = read.Load()
+	if secs := time.Since(start).Seconds(); secs > 0 {
+		result.AvgMbps = float64(result.Bytes) / 1024 / 1024 * 8 / secs
+	}
+	if len(result.Curve) > 0 {
+		third := (len(result.Curve) + 2) / 3
+		result.HeadMbps = mean(result.Curve[:third])
+		result.TailMbps = mean(result.Curve[len(result.Curve)-third:])
+		if result.HeadMbps > 0 && result.TailMbps < result.HeadMbps {
+			result.DropPct = (1 - result.TailMbps/result.HeadMbps) * 100
+		}
+	}
+	if result.Bytes == 0 && result.Error == nil {
+		result.Error = fmt.Errorf("no data received")
+	}
+	return result
+}
+
+func mean(v []float64) float64 {
+	if len(v) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, x := range v {
+		sum += x
+	}
+	return sum / float64(len(v))
+}
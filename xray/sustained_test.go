@@ -0,0 +1,150 @@
+package xray
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// socksServer stands in for xray's SOCKS inbound: no auth, CONNECT only
+func socksServer(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS(conn)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func serveSOCKS(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	hello := make([]byte, 2)
+	if _, err := io.ReadFull(r, hello); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(r, make([]byte, hello[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return
+	}
+	var host string
+	switch head[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case 3:
+		n, _ := r.ReadByte()
+		name := make([]byte, n)
+		io.ReadFull(r, name)
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return
+	}
+	up, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer up.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(up, r)
+	io.Copy(conn, up)
+}
+
+func TestSustainedDownloadThrottling(t *testing.T) {
+	const window, bucket = 600 * time.Millisecond, 100 * time.Millisecond
+	chunk := make([]byte, 32*1024)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		check   func(t *testing.T, r *SustainedResult)
+	}{
+		{"reset before the first byte", func(w http.ResponseWriter, r *http.Request) {
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 10000000\r\n\r\n")
+			buf.Flush()
+			conn.Close()
+		}, func(t *testing.T, r *SustainedResult) {
+			if !r.Responded || !r.Reset || r.Bytes != 0 || r.Failure != FailReset {
+				t.Errorf("Responded=%v Reset=%v Bytes=%d Failure=%q; want a reset with no bytes", r.Responded, r.Reset, r.Bytes, r.Failure)
+			}
+		}},
+		{"stall for the whole window", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "10000000")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(2 * window):
+			}
+		}, func(t *testing.T, r *SustainedResult) {
+			if !r.Responded || r.Reset || r.Bytes != 0 || r.Stalls < 4 {
+				t.Errorf("Responded=%v Reset=%v Bytes=%d Stalls=%d; want a stalled window", r.Responded, r.Reset, r.Bytes, r.Stalls)
+			}
+		}},
+		{"throughput drop", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "100000000")
+			start := time.Now()
+			for time.Since(start) < 2*window {
+				if _, err := w.Write(chunk); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+				if time.Since(start) < window/2 {
+					time.Sleep(2 * time.Millisecond)
+				} else {
+					time.Sleep(bucket / 2)
+				}
+			}
+		}, func(t *testing.T, r *SustainedResult) {
+			if !r.Responded || r.Reset || r.DropPct < 50 {
+				t.Errorf("Responded=%v Reset=%v DropPct=%.0f (%.1f -> %.1f Mbps); want a drop over 50%%", r.Responded, r.Reset, r.DropPct, r.HeadMbps, r.TailMbps)
+			}
+		}},
+		{"error status", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}, func(t *testing.T, r *SustainedResult) {
+			if r.Responded || r.Failure != FailHTTP {
+				t.Errorf("Responded=%v Failure=%q; want no response and an http failure", r.Responded, r.Failure)
+			}
+		}},
+	}
+
+	port := socksServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			defer srv.CloseClientConnections()
+			tt.check(t, TestSustainedDownload(context.Background(), port, srv.URL, window, bucket))
+		})
+	}
+}